@@ -52,13 +52,6 @@ func main() {
 	}
 	commands.AddKubeCommands(cmds)
 
-	// Also add the auth group from crane to facilitate logging into a
-	// registry.
-	authCmd := cranecmd.NewCmdAuth("ko", "auth")
-	// That was a mistake, but just set it to Hidden so we don't break people.
-	authCmd.Hidden = true
-	cmds.AddCommand(authCmd)
-
 	// Just add a `ko login` command:
 	cmds.AddCommand(cranecmd.NewCmdAuthLogin())
 
@@ -4,7 +4,7 @@ go 1.14
 
 require (
 	github.com/containerd/stargz-snapshotter/estargz v0.0.0-20201223015020-a9a0c2d64694
-	github.com/docker/cli v0.0.0-20200303162255-7d407207c304 // indirect
+	github.com/docker/cli v0.0.0-20200303162255-7d407207c304
 	github.com/docker/docker v1.4.2-0.20190924003213-a8608b5b67c7
 	github.com/dprotaso/go-yit v0.0.0-20191028211022-135eb7262960
 	github.com/fsnotify/fsnotify v1.4.9
@@ -31,6 +31,7 @@ require (
 	gotest.tools/v3 v3.0.2 // indirect
 	k8s.io/apimachinery v0.19.6
 	k8s.io/cli-runtime v0.18.8
+	k8s.io/client-go v0.18.8
 	k8s.io/kube-openapi v0.0.0-20200410145947-bcb3869e6f29 // indirect
 	sigs.k8s.io/kind v0.8.1
 )
@@ -0,0 +1,219 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// SymbolSize is how many bytes of a binary a symbol, or all of the symbols
+// of a package, take up.
+type SymbolSize struct {
+	Name string
+	Size int64
+}
+
+// symbol is an entry of a binary's symbol table. Size is zero for formats
+// that don't record it.
+type symbol struct {
+	name string
+	addr uint64
+	size uint64
+	// end is the end of the symbol's section, which bounds its size.
+	end uint64
+}
+
+// analyzeBinary returns the top largest packages and symbols of the Go binary
+// at path, biggest first. It reads the symbol table, like go tool nm -size
+// does, or else (for binaries linked with -s) the sizes of the functions in
+// the pclntab.
+func analyzeBinary(path string, top int) (packages, symbols []SymbolSize, err error) {
+	syms, err := readSymbols(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(syms, func(i, j int) bool { return syms[i].addr < syms[j].addr })
+	byPackage := map[string]int64{}
+	for i, s := range syms {
+		size := s.size
+		if size == 0 {
+			// Assume the symbol runs until the next one, or the end of its
+			// section.
+			next := s.end
+			if i+1 < len(syms) && syms[i+1].addr < next {
+				next = syms[i+1].addr
+			}
+			if next > s.addr {
+				size = next - s.addr
+			}
+		}
+		if size == 0 {
+			continue
+		}
+		symbols = append(symbols, SymbolSize{Name: s.name, Size: int64(size)})
+		byPackage[symbolPackage(s.name)] += int64(size)
+	}
+	for name, size := range byPackage {
+		packages = append(packages, SymbolSize{Name: name, Size: size})
+	}
+	return largest(packages, top), largest(symbols, top), nil
+}
+
+// largest sorts sizes biggest first (then by name) and keeps the first top.
+func largest(sizes []SymbolSize, top int) []SymbolSize {
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].Size != sizes[j].Size {
+			return sizes[i].Size > sizes[j].Size
+		}
+		return sizes[i].Name < sizes[j].Name
+	})
+	if len(sizes) > top {
+		sizes = sizes[:top]
+	}
+	return sizes
+}
+
+// symbolPackage returns the import path of the package that defines the Go
+// symbol name, e.g. "net/http" for "net/http.(*Server).Serve". Symbols that
+// the compiler and linker generate are attributed to "go", "type" and the
+// like, and non-Go symbols to "(other)".
+func symbolPackage(name string) string {
+	// Newer linkers name generated symbols go:itab.*, type:*, etc.
+	if i := strings.IndexByte(name, ':'); i > 0 && !strings.ContainsAny(name[:i], "./") {
+		return name[:i]
+	}
+	// Only the last element of an import path can be followed by a dot, and
+	// receivers and type arguments can contain slashes of their own.
+	prefix := name
+	if i := strings.IndexAny(prefix, "(["); i >= 0 {
+		prefix = prefix[:i]
+	}
+	start := strings.LastIndex(prefix, "/") + 1
+	dot := strings.Index(name[start:], ".")
+	if dot <= 0 {
+		return "(other)"
+	}
+	return name[:start+dot]
+}
+
+// readSymbols reads the symbols of the ELF, Mach-O or PE binary at path.
+func readSymbols(path string) ([]symbol, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if ef, err := elf.NewFile(f); err == nil {
+		return elfSymbols(ef)
+	}
+	if mf, err := macho.NewFile(f); err == nil {
+		return machoSymbols(mf)
+	}
+	if pf, err := pe.NewFile(f); err == nil {
+		return peSymbols(pf)
+	}
+	return nil, fmt.Errorf("%s is not an ELF, Mach-O or PE binary", path)
+}
+
+func elfSymbols(f *elf.File) ([]symbol, error) {
+	syms, err := f.Symbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		text, pclntab := f.Section(".text"), f.Section(".gopclntab")
+		if text == nil || pclntab == nil {
+			return nil, errors.New("binary has neither symbols nor a pclntab")
+		}
+		data, err := pclntab.Data()
+		if err != nil {
+			return nil, err
+		}
+		return pclntabSymbols(data, text.Addr)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var out []symbol
+	for _, s := range syms {
+		switch elf.ST_TYPE(s.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT:
+		default:
+			continue
+		}
+		if s.Section == elf.SHN_UNDEF || int(s.Section) >= len(f.Sections) {
+			continue
+		}
+		sect := f.Sections[s.Section]
+		out = append(out, symbol{name: s.Name, addr: s.Value, size: s.Size, end: sect.Addr + sect.Size})
+	}
+	return out, nil
+}
+
+// pclntabSymbols returns the functions in a pclntab, which even stripped Go
+// binaries have.
+func pclntabSymbols(data []byte, textAddr uint64) ([]symbol, error) {
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, textAddr))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]symbol, 0, len(table.Funcs))
+	for _, fn := range table.Funcs {
+		out = append(out, symbol{name: fn.Name, addr: fn.Entry, size: fn.End - fn.Entry, end: fn.End})
+	}
+	return out, nil
+}
+
+func machoSymbols(f *macho.File) ([]symbol, error) {
+	if f.Symtab == nil {
+		return nil, errors.New("binary has no symbols")
+	}
+	var out []symbol
+	for _, s := range f.Symtab.Syms {
+		// Symbols without a section are undefined (or debugging entries).
+		if s.Sect == 0 || int(s.Sect) > len(f.Sections) || s.Type&0xe0 != 0 {
+			continue
+		}
+		sect := f.Sections[s.Sect-1]
+		out = append(out, symbol{name: s.Name, addr: s.Value, end: sect.Addr + sect.Size})
+	}
+	return out, nil
+}
+
+func peSymbols(f *pe.File) ([]symbol, error) {
+	if len(f.Symbols) == 0 {
+		return nil, errors.New("binary has no symbols")
+	}
+	var out []symbol
+	for _, s := range f.Symbols {
+		if s.SectionNumber <= 0 || int(s.SectionNumber) > len(f.Sections) {
+			continue
+		}
+		sect := f.Sections[s.SectionNumber-1]
+		out = append(out, symbol{
+			name: s.Name,
+			addr: uint64(sect.VirtualAddress) + uint64(s.Value),
+			end:  uint64(sect.VirtualAddress) + uint64(sect.VirtualSize),
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"debug/elf"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestSymbolPackage(t *testing.T) {
+	for name, want := range map[string]string{
+		"runtime.main":                            "runtime",
+		"net/http.(*Server).Serve":                "net/http",
+		"github.com/google/ko/pkg/build.NewGo":    "github.com/google/ko/pkg/build",
+		"github.com/a/b.F.func1":                  "github.com/a/b",
+		"github.com/a/b.(*T[github.com/c/d.U]).M": "github.com/a/b",
+		"go.itab.*os.File,io.Reader":              "go",
+		"go:itab.*os.File,io.Reader":              "go",
+		"type:.eq.[2]string":                      "type",
+		"type..eq.[2]string":                      "type",
+		"x_cgo_init":                              "(other)",
+	} {
+		if got := symbolPackage(name); got != want {
+			t.Errorf("symbolPackage(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAnalyzeBinary(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the test binary is only known to be ELF on linux")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("os.Executable() = %v", err)
+	}
+	packages, symbols, err := analyzeBinary(exe, 5)
+	if err != nil {
+		t.Fatalf("analyzeBinary() = %v", err)
+	}
+	if len(packages) != 5 || len(symbols) != 5 {
+		t.Fatalf("analyzeBinary() = %d packages, %d symbols, want 5 of each", len(packages), len(symbols))
+	}
+	for _, sizes := range [][]SymbolSize{packages, symbols} {
+		for i := 1; i < len(sizes); i++ {
+			if sizes[i].Size > sizes[i-1].Size {
+				t.Errorf("%v isn't sorted biggest first", sizes)
+			}
+		}
+	}
+	found := false
+	for _, p := range packages {
+		found = found || p.Name == "runtime"
+	}
+	if !found {
+		t.Errorf("analyzeBinary() = %v, want runtime among the largest packages", packages)
+	}
+
+	// Stripped binaries are analyzed from their pclntab.
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatalf("elf.Open() = %v", err)
+	}
+	defer f.Close()
+	data, err := f.Section(".gopclntab").Data()
+	if err != nil {
+		t.Fatalf("Data() = %v", err)
+	}
+	syms, err := pclntabSymbols(data, f.Section(".text").Addr)
+	if err != nil {
+		t.Fatalf("pclntabSymbols() = %v", err)
+	}
+	found = false
+	for _, s := range syms {
+		found = found || (s.name == "github.com/google/ko/pkg/build.analyzeBinary" && s.size > 0)
+	}
+	if !found {
+		t.Error("pclntabSymbols() doesn't have analyzeBinary")
+	}
+}
+
+func TestAnalyzeBinaryNotABinary(t *testing.T) {
+	if _, _, err := analyzeBinary("analysis_test.go", 5); err == nil {
+		t.Error("analyzeBinary(analysis_test.go) = nil, want error")
+	}
+}
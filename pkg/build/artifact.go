@@ -0,0 +1,178 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Blob is a piece of content in an artifact, e.g. a wasm module or a
+// packaged Helm chart, and the media type that describes it.
+type Blob struct {
+	MediaType   types.MediaType
+	Data        []byte
+	Annotations map[string]string
+}
+
+// NewArtifact returns a Result for a non-image OCI artifact whose config has
+// the given media type and contents, and whose layers are the given blobs.
+// Artifacts are pushed like images, but publishers that need a container
+// image (e.g. the daemon) reject them.
+func NewArtifact(configType types.MediaType, config []byte, blobs ...Blob) (v1.Image, error) {
+	if configType == "" {
+		return nil, fmt.Errorf("artifact config media type must be set")
+	}
+	if config == nil {
+		config = []byte("{}")
+	}
+	cd, err := blobDescriptor(Blob{MediaType: configType, Data: config})
+	if err != nil {
+		return nil, err
+	}
+	a := &artifact{
+		config: config,
+		layers: make(map[v1.Hash]*blobLayer, len(blobs)),
+	}
+	m := v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        cd,
+	}
+	for _, b := range blobs {
+		if b.MediaType == "" {
+			return nil, fmt.Errorf("artifact blob media type must be set")
+		}
+		desc, err := blobDescriptor(b)
+		if err != nil {
+			return nil, err
+		}
+		m.Layers = append(m.Layers, desc)
+		a.layers[desc.Digest] = &blobLayer{blob: b, digest: desc.Digest}
+	}
+	if a.manifest, err = json.Marshal(m); err != nil {
+		return nil, err
+	}
+	return partial.CompressedToImage(a)
+}
+
+// IsArtifact reports whether r is an image whose config isn't a container
+// image config, i.e. one made by NewArtifact or pulled from elsewhere.
+func IsArtifact(r Result) (bool, error) {
+	img, ok := r.(v1.Image)
+	if !ok {
+		return false, nil
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return false, err
+	}
+	switch m.Config.MediaType {
+	case types.OCIConfigJSON, types.DockerConfigJSON, "":
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func blobDescriptor(b Blob) (v1.Descriptor, error) {
+	h, size, err := v1.SHA256(bytes.NewReader(b.Data))
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{
+		MediaType:   b.MediaType,
+		Size:        size,
+		Digest:      h,
+		Annotations: b.Annotations,
+	}, nil
+}
+
+// artifact implements partial.CompressedImageCore.
+type artifact struct {
+	manifest []byte
+	config   []byte
+	layers   map[v1.Hash]*blobLayer
+}
+
+// MediaType implements partial.CompressedImageCore
+func (a *artifact) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+// RawManifest implements partial.CompressedImageCore
+func (a *artifact) RawManifest() ([]byte, error) {
+	return a.manifest, nil
+}
+
+// RawConfigFile implements partial.CompressedImageCore
+func (a *artifact) RawConfigFile() ([]byte, error) {
+	return a.config, nil
+}
+
+// LayerByDigest implements partial.CompressedImageCore
+func (a *artifact) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	l, ok := a.layers[h]
+	if !ok {
+		return nil, fmt.Errorf("artifact has no blob %v", h)
+	}
+	return l, nil
+}
+
+// blobLayer is an opaque layer: blobs aren't compressed tarballs, so the
+// digest doubles as the diffid.
+type blobLayer struct {
+	blob   Blob
+	digest v1.Hash
+}
+
+var _ partial.WithDiffID = (*blobLayer)(nil)
+
+// Digest implements partial.CompressedLayer
+func (l *blobLayer) Digest() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// DiffID implements partial.WithDiffID
+func (l *blobLayer) DiffID() (v1.Hash, error) {
+	return l.digest, nil
+}
+
+// Size implements partial.CompressedLayer
+func (l *blobLayer) Size() (int64, error) {
+	return int64(len(l.blob.Data)), nil
+}
+
+// MediaType implements partial.CompressedLayer
+func (l *blobLayer) MediaType() (types.MediaType, error) {
+	return l.blob.MediaType, nil
+}
+
+// Compressed implements partial.CompressedLayer
+func (l *blobLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.blob.Data)), nil
+}
+
+// Uncompressed returns the blob as-is.
+func (l *blobLayer) Uncompressed() (io.ReadCloser, error) {
+	return l.Compressed()
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestArtifact(t *testing.T) {
+	const (
+		configType types.MediaType = "application/vnd.wasm.config.v1+json"
+		wasmType   types.MediaType = "application/vnd.wasm.content.layer.v1+wasm"
+	)
+	module := []byte("\x00asm\x01\x00\x00\x00")
+
+	art, err := NewArtifact(configType, nil, Blob{MediaType: wasmType, Data: module})
+	if err != nil {
+		t.Fatalf("NewArtifact() = %v", err)
+	}
+	if artifact, err := IsArtifact(art); err != nil || !artifact {
+		t.Errorf("IsArtifact() = %v, %v, wanted true", artifact, err)
+	}
+	if _, err := ImageFor(art, v1.Platform{OS: "linux", Architecture: "amd64"}); err == nil {
+		t.Error("ImageFor() = nil, wanted error for an artifact")
+	}
+	if platforms, err := Platforms(art); err != nil || len(platforms) != 0 {
+		t.Errorf("Platforms() = %v, %v, wanted none", platforms, err)
+	}
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	ref, err := name.ParseReference(fmt.Sprintf("%s/wasm:latest", u.Host))
+	if err != nil {
+		t.Fatalf("ParseReference() = %v", err)
+	}
+	if err := remote.Write(ref, art); err != nil {
+		t.Fatalf("remote.Write() = %v", err)
+	}
+
+	got, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("remote.Image() = %v", err)
+	}
+	m, err := got.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() = %v", err)
+	}
+	if m.Config.MediaType != configType {
+		t.Errorf("config media type = %v, wanted %v", m.Config.MediaType, configType)
+	}
+	if len(m.Layers) != 1 || m.Layers[0].MediaType != wasmType {
+		t.Fatalf("layers = %v, wanted one %v", m.Layers, wasmType)
+	}
+	l, err := got.LayerByDigest(m.Layers[0].Digest)
+	if err != nil {
+		t.Fatalf("LayerByDigest() = %v", err)
+	}
+	rc, err := l.Compressed()
+	if err != nil {
+		t.Fatalf("Compressed() = %v", err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll() = %v", err)
+	}
+	if string(b) != string(module) {
+		t.Errorf("blob = %q, wanted %q", b, module)
+	}
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	if artifact, err := IsArtifact(img); err != nil || artifact {
+		t.Errorf("IsArtifact(image) = %v, %v, wanted false", artifact, err)
+	}
+}
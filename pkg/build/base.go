@@ -0,0 +1,58 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// IsMultiPlatform reports whether the --platform spec selects more than one
+// platform of a multi-platform base ("all", or a comma-separated list), so
+// that the base is its whole index rather than one of its images.
+func IsMultiPlatform(spec string) bool {
+	return spec == "all" || strings.Contains(spec, ",")
+}
+
+// FetchBase fetches ref as the base of builds for the --platform spec: its
+// index if the spec IsMultiPlatform and ref is an index, and otherwise its
+// image for the one platform the spec names (or the one that remote picks,
+// if the spec is empty). For a single platform, only the index manifest and
+// the matching child are fetched, never the other children of the index.
+func FetchBase(ref name.Reference, spec string, opts ...remote.Option) (Result, error) {
+	multiplatform := IsMultiPlatform(spec)
+	if spec != "" && !multiplatform {
+		p, err := ParsePlatform(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, remote.WithPlatform(p))
+	}
+
+	desc, err := remote.Get(ref, opts...)
+	if err != nil {
+		return nil, err
+	}
+	switch desc.MediaType {
+	case types.OCIImageIndex, types.DockerManifestList:
+		if multiplatform {
+			return desc.ImageIndex()
+		}
+	}
+	return desc.Image()
+}
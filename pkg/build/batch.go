@@ -0,0 +1,253 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// buildMany builds pkgs with one go build, which compiles the packages that
+// they share once, and returns the binary of each package.
+type buildMany func(ctx context.Context, pkgs []string, dir string, platform v1.Platform, config Config, disableOptimizations bool) (map[string]string, error)
+
+func goBuildMany(ctx context.Context, pkgs []string, dir string, platform v1.Platform, config Config, disableOptimizations bool) (map[string]string, error) {
+	log.Printf("Building %s for %s", strings.Join(pkgs, ", "), platformToString(platform))
+	// A trailing slash makes go build write a binary per package, even
+	// if there is only one.
+	out := filepath.Join(dir, "bin") + string(filepath.Separator)
+	if err := goBuild(ctx, dir, out, platform, config, disableOptimizations, pkgs...); err != nil {
+		return nil, err
+	}
+	files := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		files[pkg] = filepath.Join(out, execName(pkg, platform))
+	}
+	return files, nil
+}
+
+// execName is the name that go build gives the binary of pkg.
+func execName(pkg string, platform v1.Platform) string {
+	elem := path.Base(pkg)
+	if elem != pkg && isVersionElement(elem) {
+		// Major versions aren't part of the name, e.g. foo/v2 is foo.
+		elem = path.Base(path.Dir(pkg))
+	}
+	if platform.OS == "windows" {
+		elem += ".exe"
+	}
+	return elem
+}
+
+// isVersionElement reports whether s is a major version suffix, like v2.
+func isVersionElement(s string) bool {
+	if len(s) < 2 || s[0] != 'v' || s[1] == '0' || s[1] == '1' && len(s) == 2 {
+		return false
+	}
+	for _, r := range s[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// batcher coalesces the builds that start within a window of each other for
+// the same platform and configuration into one go build, so that the module
+// graph is loaded and their shared packages are compiled once.
+type batcher struct {
+	window  time.Duration
+	workDir string
+	single  builder
+	many    buildMany
+
+	mu      sync.Mutex
+	pending map[string]*batch
+}
+
+// batch is the builds that will share a go build.
+type batch struct {
+	platform             v1.Platform
+	config               Config
+	disableOptimizations bool
+	members              []*batchMember
+	// names are the binaries the members will build, which can't collide.
+	names map[string]bool
+}
+
+// batchMember is a build that is waiting for its batch.
+type batchMember struct {
+	ctx  context.Context
+	ip   string
+	dir  string
+	done chan batchResult
+}
+
+type batchResult struct {
+	file string
+	err  error
+}
+
+func newBatcher(window time.Duration, workDir string, single builder, many buildMany) *batcher {
+	return &batcher{
+		window:  window,
+		workDir: workDir,
+		single:  single,
+		many:    many,
+		pending: map[string]*batch{},
+	}
+}
+
+// build implements builder, by waiting to build ip along with any other
+// import paths that are built for the same platform and configuration in
+// the meantime.
+func (b *batcher) build(ctx context.Context, ip, dir string, platform v1.Platform, config Config, disableOptimizations bool) (string, error) {
+	if config.Main != "" || config.library() {
+		// Main may be a relative path, which go build would name after
+		// the directory it is run in, and go build can't write more
+		// than one shared library at once.
+		return b.single(ctx, ip, dir, platform, config, disableOptimizations)
+	}
+	key, err := json.Marshal(struct {
+		Platform             string
+		Dir                  string
+		BuildMode            string
+		Env, Flags, Ldflags  []string
+		Gcflags, Asmflags    []string
+		Tags                 []string
+		DisableOptimizations bool
+	}{platformToString(platform), config.Dir, config.BuildMode, config.Env, config.Flags, config.Ldflags, config.Gcflags, config.Asmflags, config.Tags, disableOptimizations})
+	if err != nil {
+		return "", err
+	}
+	name := execName(ip, platform)
+	m := &batchMember{ctx: ctx, ip: ip, dir: dir, done: make(chan batchResult, 1)}
+
+	b.mu.Lock()
+	bt, ok := b.pending[string(key)]
+	if ok && bt.names[name] {
+		// Its binary would overwrite another's, so build it by itself.
+		b.mu.Unlock()
+		return b.single(ctx, ip, dir, platform, config, disableOptimizations)
+	}
+	if !ok {
+		bt = &batch{
+			platform:             platform,
+			config:               config,
+			disableOptimizations: disableOptimizations,
+			names:                map[string]bool{},
+		}
+		b.pending[string(key)] = bt
+		time.AfterFunc(b.window, func() { b.run(string(key), bt) })
+	}
+	bt.members = append(bt.members, m)
+	bt.names[name] = true
+	b.mu.Unlock()
+
+	select {
+	case r := <-m.done:
+		return r.file, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// run builds the members of bt, once its window has passed.
+func (b *batcher) run(key string, bt *batch) {
+	b.mu.Lock()
+	delete(b.pending, key)
+	b.mu.Unlock()
+
+	if len(bt.members) == 1 {
+		b.runSingle(bt, bt.members[0])
+		return
+	}
+
+	files, err := b.runMany(bt)
+	var wg sync.WaitGroup
+	for _, m := range bt.members {
+		if file, ok := files[m.ip]; err == nil && ok {
+			m.done <- batchResult{file: file}
+			continue
+		}
+		// If the batch failed, build the members separately, so that
+		// the ones that can be built are, and each failure is attributed
+		// to the import path that caused it.
+		wg.Add(1)
+		go func(m *batchMember) {
+			defer wg.Done()
+			b.runSingle(bt, m)
+		}(m)
+	}
+	wg.Wait()
+}
+
+func (b *batcher) runSingle(bt *batch, m *batchMember) {
+	file, err := b.single(m.ctx, m.ip, m.dir, bt.platform, bt.config, bt.disableOptimizations)
+	m.done <- batchResult{file: file, err: err}
+}
+
+func (b *batcher) runMany(bt *batch) (map[string]string, error) {
+	dir, err := ioutil.TempDir(b.workDir, "ko-batch")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	pkgs := make([]string, 0, len(bt.members))
+	for _, m := range bt.members {
+		pkgs = append(pkgs, m.ip)
+	}
+	// The build is shared, so only stop it once every member has given up.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for _, m := range bt.members {
+			select {
+			case <-m.ctx.Done():
+			case <-ctx.Done():
+				return
+			}
+		}
+		cancel()
+	}()
+	files, err := b.many(ctx, pkgs, dir, bt.platform, bt.config, bt.disableOptimizations)
+	if err != nil {
+		return nil, err
+	}
+	// Move each binary into the directory of its member (which is cleaned
+	// up along with it) before this one is removed. Members whose binary
+	// is missing are built by themselves.
+	out := make(map[string]string, len(bt.members))
+	for _, m := range bt.members {
+		file := filepath.Join(m.dir, "out")
+		if err := os.Rename(files[m.ip], file); err != nil {
+			log.Printf("Unable to find the binary of %s in its batch, so building it by itself: %v", m.ip, err)
+			continue
+		}
+		out[m.ip] = file
+	}
+	return out, nil
+}
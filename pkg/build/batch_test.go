@@ -0,0 +1,233 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestExecName(t *testing.T) {
+	linux := v1.Platform{OS: "linux", Architecture: "amd64"}
+	windows := v1.Platform{OS: "windows", Architecture: "amd64"}
+	for _, c := range []struct {
+		pkg      string
+		platform v1.Platform
+		want     string
+	}{
+		{"github.com/foo/bar/cmd/app", linux, "app"},
+		{"github.com/foo/bar/v2", linux, "bar"},
+		{"github.com/foo/bar/v1", linux, "v1"},
+		{"v2", linux, "v2"},
+		{"github.com/foo/bar/cmd/app", windows, "app.exe"},
+	} {
+		if got := execName(c.pkg, c.platform); got != c.want {
+			t.Errorf("execName(%q, %v) = %q, want %q", c.pkg, c.platform, got, c.want)
+		}
+	}
+}
+
+// fakeBuilds records the builds that a batcher runs, writing the name of each
+// package to its binary.
+type fakeBuilds struct {
+	mu     sync.Mutex
+	single []string
+	many   [][]string
+	fail   bool
+}
+
+func (f *fakeBuilds) build(_ context.Context, ip, dir string, _ v1.Platform, _ Config, _ bool) (string, error) {
+	f.mu.Lock()
+	f.single = append(f.single, ip)
+	f.mu.Unlock()
+	file := filepath.Join(dir, "out")
+	return file, ioutil.WriteFile(file, []byte(ip), 0644)
+}
+
+func (f *fakeBuilds) buildMany(_ context.Context, pkgs []string, dir string, platform v1.Platform, _ Config, _ bool) (map[string]string, error) {
+	f.mu.Lock()
+	sorted := append([]string{}, pkgs...)
+	sort.Strings(sorted)
+	f.many = append(f.many, sorted)
+	f.mu.Unlock()
+	if f.fail {
+		return nil, errors.New("one of them is broken")
+	}
+	files := map[string]string{}
+	for _, pkg := range pkgs {
+		file := filepath.Join(dir, execName(pkg, platform))
+		if err := ioutil.WriteFile(file, []byte(pkg), 0644); err != nil {
+			return nil, err
+		}
+		files[pkg] = file
+	}
+	return files, nil
+}
+
+// buildAll builds the import paths concurrently and checks that each binary
+// is its own.
+func buildAll(t *testing.T, b *batcher, platform v1.Platform, ips ...string) {
+	t.Helper()
+	var wg sync.WaitGroup
+	errs := make([]error, len(ips))
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			dir, err := ioutil.TempDir("", "ko-batch-test")
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer os.RemoveAll(dir)
+			file, err := b.build(context.Background(), ip, dir, platform, Config{}, false)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			got, err := ioutil.ReadFile(file)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if string(got) != ip {
+				errs[i] = errors.New("got the binary of " + string(got))
+			}
+		}(i, ip)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("build(%s) = %v", ips[i], err)
+		}
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	linux := v1.Platform{OS: "linux", Architecture: "amd64"}
+	for _, c := range []struct {
+		name       string
+		ips        []string
+		fail       bool
+		wantMany   [][]string
+		wantSingle []string
+	}{{
+		name:     "batched",
+		ips:      []string{"example.com/cmd/a", "example.com/cmd/b", "example.com/cmd/c"},
+		wantMany: [][]string{{"example.com/cmd/a", "example.com/cmd/b", "example.com/cmd/c"}},
+	}, {
+		name:       "alone",
+		ips:        []string{"example.com/cmd/a"},
+		wantSingle: []string{"example.com/cmd/a"},
+	}, {
+		name:       "failed",
+		ips:        []string{"example.com/cmd/a", "example.com/cmd/b"},
+		fail:       true,
+		wantMany:   [][]string{{"example.com/cmd/a", "example.com/cmd/b"}},
+		wantSingle: []string{"example.com/cmd/a", "example.com/cmd/b"},
+	}} {
+		t.Run(c.name, func(t *testing.T) {
+			f := &fakeBuilds{fail: c.fail}
+			b := newBatcher(100*time.Millisecond, "", f.build, f.buildMany)
+			buildAll(t, b, linux, c.ips...)
+			sort.Strings(f.single)
+			if diff := cmp.Diff(c.wantMany, f.many); diff != "" {
+				t.Errorf("batched builds (-want +got) = %s", diff)
+			}
+			if diff := cmp.Diff(c.wantSingle, f.single); diff != "" {
+				t.Errorf("single builds (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestBatcherSameName(t *testing.T) {
+	linux := v1.Platform{OS: "linux", Architecture: "amd64"}
+	f := &fakeBuilds{}
+	b := newBatcher(time.Second, "", f.build, f.buildMany)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buildAll(t, b, linux, "example.com/a/cmd/app", "example.com/cmd/c")
+	}()
+	// Wait for the batch to form, then build another app, whose binary
+	// would collide with the first.
+	time.Sleep(100 * time.Millisecond)
+	buildAll(t, b, linux, "example.com/b/cmd/app")
+	<-done
+
+	if diff := cmp.Diff([][]string{{"example.com/a/cmd/app", "example.com/cmd/c"}}, f.many); diff != "" {
+		t.Errorf("batched builds (-want +got) = %s", diff)
+	}
+	if diff := cmp.Diff([]string{"example.com/b/cmd/app"}, f.single); diff != "" {
+		t.Errorf("single builds (-want +got) = %s", diff)
+	}
+}
+
+func TestBatcherPlatforms(t *testing.T) {
+	f := &fakeBuilds{}
+	b := newBatcher(100*time.Millisecond, "", f.build, f.buildMany)
+	var wg sync.WaitGroup
+	for _, p := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		wg.Add(1)
+		go func(p v1.Platform) {
+			defer wg.Done()
+			buildAll(t, b, p, "example.com/cmd/a", "example.com/cmd/b")
+		}(p)
+	}
+	wg.Wait()
+	if len(f.many) != 2 || len(f.single) != 0 {
+		t.Errorf("batched %v and built %v alone, want a batch per platform", f.many, f.single)
+	}
+}
+
+func TestBatcherBuildModes(t *testing.T) {
+	f := &fakeBuilds{}
+	b := newBatcher(100*time.Millisecond, "", f.build, f.buildMany)
+	platform := v1.Platform{OS: "linux", Architecture: "amd64"}
+	var wg sync.WaitGroup
+	for ip, config := range map[string]Config{
+		"example.com/cmd/a": {},
+		"example.com/cmd/b": {BuildMode: "pie"},
+	} {
+		wg.Add(1)
+		go func(ip string, config Config) {
+			defer wg.Done()
+			dir, err := ioutil.TempDir("", "ko-batch-test")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer os.RemoveAll(dir)
+			if _, err := b.build(context.Background(), ip, dir, platform, config, false); err != nil {
+				t.Errorf("build(%s) = %v", ip, err)
+			}
+		}(ip, config)
+	}
+	wg.Wait()
+	if len(f.many) != 0 || len(f.single) != 2 {
+		t.Errorf("batched %v and built %v alone, want each build mode built by itself", f.many, f.single)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/internal/trace"
+)
+
+const (
+	// BinaryConfigMediaType is the media type of the config of binary
+	// artifacts (see WithBinaryArtifacts), which describes the binary.
+	BinaryConfigMediaType types.MediaType = "application/vnd.dev.ko.binary.config.v1+json"
+
+	// BinaryLayerMediaType is the media type of the binary in binary
+	// artifacts: the go build output as-is, not a tarball.
+	BinaryLayerMediaType types.MediaType = "application/vnd.dev.ko.binary.layer.v1"
+
+	// titleAnnotation names the file that oras pull writes a blob to.
+	titleAnnotation = "org.opencontainers.image.title"
+)
+
+// binaryConfig is the config of a binary artifact.
+type binaryConfig struct {
+	ImportPath   string     `json:"importPath"`
+	OS           string     `json:"os"`
+	Architecture string     `json:"architecture"`
+	Variant      string     `json:"variant,omitempty"`
+	Created      *time.Time `json:"created,omitempty"`
+}
+
+// artifactFor returns whether importpath is published as a binary artifact
+// instead of an image.
+func (g *gobuild) artifactFor(importpath string) bool {
+	if a := g.configFor(importpath).Artifact; a != nil {
+		return *a
+	}
+	return g.binaryArtifacts
+}
+
+// binaryFilename is the name the binary of importpath is fetched as.
+func binaryFilename(importpath string, c Config, platform v1.Platform) string {
+	if c.library() {
+		return path.Base(outputPath(importpath, c))
+	}
+	name := appFilename(importpath)
+	if platform.OS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// explicitPlatforms returns the platforms of the platform spec of s, if it
+// names them fully, or else nil. Binaries are built for those without a base
+// image to pick them from.
+func (g *gobuild) explicitPlatforms(s string) []v1.Platform {
+	matcher := g.platformMatcher
+	if pm := g.configFor(newRef(s).Path()).platformMatcher; pm != nil {
+		matcher = pm
+	}
+	if matcher.spec == "all" {
+		return nil
+	}
+	for _, p := range matcher.platforms {
+		if p.OS == "" || p.Architecture == "" {
+			return nil
+		}
+	}
+	return matcher.platforms
+}
+
+// basePlatforms returns the platforms of base that the platform spec of s
+// matches.
+func (g *gobuild) basePlatforms(s string, base Result) ([]v1.Platform, error) {
+	platforms, err := Platforms(base)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := base.(v1.Image); ok {
+		// Like images, single-platform bases are built for regardless.
+		return platforms, nil
+	}
+	matcher := g.platformMatcher
+	if pm := g.configFor(newRef(s).Path()).platformMatcher; pm != nil {
+		matcher = pm
+	}
+	var matched []v1.Platform
+	for _, p := range platforms {
+		p := p
+		if matcher.Matches(&p) {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+// buildArtifacts builds s as a binary artifact for each of platforms, in an
+// index if there is more than one.
+func (g *gobuild) buildArtifacts(ctx context.Context, s string, platforms []v1.Platform) (Result, error) {
+	switch len(platforms) {
+	case 0:
+		return nil, fmt.Errorf("no platforms to build %s for", s)
+	case 1:
+		return g.buildArtifact(ctx, s, platforms[0])
+	}
+	adds := make([]mutate.IndexAddendum, 0, len(platforms))
+	for _, p := range platforms {
+		p := p
+		art, err := g.buildArtifact(ctx, s, p)
+		if err != nil {
+			return nil, err
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: art,
+			Descriptor: v1.Descriptor{
+				MediaType: types.OCIManifestSchema1,
+				Platform:  &p,
+			},
+		})
+	}
+	return mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), types.OCIImageIndex), nil
+}
+
+// buildArtifact builds s for platform and returns an artifact with just the
+// binary, which --image-label annotates.
+func (g *gobuild) buildArtifact(ctx context.Context, s string, platform v1.Platform) (_ v1.Image, err error) {
+	ref := newRef(s)
+
+	dir, err := ioutil.TempDir(g.workDir, "ko")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { g.cleanup(dir, err) }()
+	phaseHooks(ctx, g.hooks, BuildPhase{ImportPath: ref.Path(), Platform: platformToString(platform), Phase: PhaseCompile})
+	buildCtx, span := trace.Start(ctx, "go build")
+	span.SetAttribute("ko.importpath", ref.Path())
+	span.SetAttribute("ko.platform", platformToString(platform))
+	file, err := g.build(buildCtx, ref.Path(), dir, platform, g.goConfig(ref.Path()), g.disableOptimizations)
+	span.End(err)
+	if err != nil {
+		return nil, err
+	}
+	phaseHooks(ctx, g.hooks, BuildPhase{ImportPath: ref.Path(), Platform: platformToString(platform), Phase: PhaseLayers})
+	binary, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := binaryConfig{
+		ImportPath:   ref.Path(),
+		OS:           platform.OS,
+		Architecture: platform.Architecture,
+		Variant:      platform.Variant,
+	}
+	if g.creationTime != (v1.Time{}) {
+		created := g.creationTime.Time.UTC()
+		bc.Created = &created
+	}
+	config, err := json.Marshal(bc)
+	if err != nil {
+		return nil, err
+	}
+	art, err := NewArtifact(BinaryConfigMediaType, config, Blob{
+		MediaType:   BinaryLayerMediaType,
+		Data:        binary,
+		Annotations: map[string]string{titleAnnotation: binaryFilename(ref.Path(), g.configFor(ref.Path()).Config, platform)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(g.labels) != 0 {
+		return withImageAnnotations(art, g.labels)
+	}
+	return art, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestGoBuildBinaryArtifacts(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, p := range []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "windows", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+	} {
+		p := p
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{MediaType: types.OCIManifestSchema1, Platform: &p},
+		})
+	}
+	base := mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), types.OCIImageIndex)
+
+	no := false
+	fetched := 0
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) {
+			fetched++
+			return base, nil
+		}),
+		// darwin/arm64 isn't in the base, but binaries don't need it.
+		WithPlatforms("linux/amd64,windows/amd64,darwin/arm64"),
+		WithBinaryArtifacts(),
+		WithLabels(map[string]string{"org.opencontainers.image.source": "https://github.com/google/ko"}),
+		WithConfigs([]Config{{ID: "github.com/google/ko/cmd/*", Artifact: &no}}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	idx, ok := result.(v1.ImageIndex)
+	if !ok {
+		t.Fatalf("Build() = %T, want an index", result)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	var titles []string
+	for _, desc := range im.Manifests {
+		art, err := idx.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("Image() = %v", err)
+		}
+		if artifact, err := IsArtifact(art); err != nil || !artifact {
+			t.Errorf("IsArtifact(%s) = %v, %v, want true", platformToString(*desc.Platform), artifact, err)
+		}
+		m, err := art.Manifest()
+		if err != nil {
+			t.Fatalf("Manifest() = %v", err)
+		}
+		if m.Config.MediaType != BinaryConfigMediaType {
+			t.Errorf("config media type = %v, want %v", m.Config.MediaType, BinaryConfigMediaType)
+		}
+		if got := m.Annotations["org.opencontainers.image.source"]; got != "https://github.com/google/ko" {
+			t.Errorf("source annotation = %q, want the label", got)
+		}
+		if len(m.Layers) != 1 || m.Layers[0].MediaType != BinaryLayerMediaType {
+			t.Fatalf("layers = %v, want one %v", m.Layers, BinaryLayerMediaType)
+		}
+		titles = append(titles, m.Layers[0].Annotations[titleAnnotation])
+
+		layer, err := art.LayerByDigest(m.Layers[0].Digest)
+		if err != nil {
+			t.Fatalf("LayerByDigest() = %v", err)
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			t.Fatalf("Compressed() = %v", err)
+		}
+		got, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("ReadAll() = %v", err)
+		}
+		if string(got) != "github.com/google/ko" {
+			t.Errorf("binary = %q, want the go build output", got)
+		}
+	}
+	if diff := cmp.Diff([]string{"ko", "ko.exe", "ko"}, titles); diff != "" {
+		t.Errorf("titles (-want +got) = %v", diff)
+	}
+	// The platforms are spelled out, so the base isn't needed.
+	if fetched != 0 {
+		t.Errorf("fetched the base %d times, want none", fetched)
+	}
+
+	// Import paths can still opt out.
+	result, err = ng.Build(context.Background(), StrictScheme+"github.com/google/ko/cmd/ko")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, err := ImageFor(result, v1.Platform{OS: "linux", Architecture: "amd64"})
+	if err != nil {
+		t.Fatalf("ImageFor() = %v", err)
+	}
+	if artifact, err := IsArtifact(img); err != nil || artifact {
+		t.Errorf("IsArtifact() = %v, %v, want false", artifact, err)
+	}
+}
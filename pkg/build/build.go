@@ -35,7 +35,9 @@ type Interface interface {
 	Build(context.Context, string) (Result, error)
 }
 
-// Result represents the product of a Build. This is usually a v1.Image or v1.ImageIndex.
+// Result represents the product of a Build. This is usually a v1.Image or v1.ImageIndex,
+// or an artifact from NewArtifact.
+// Platforms, TotalSize and AddedLayers describe a Result further.
 type Result interface {
 	MediaType() (types.MediaType, error)
 	Size() (int64, error)
@@ -0,0 +1,34 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+)
+
+type buildEnvKey struct{}
+
+// WithBuildEnv returns a copy of ctx whose builds run go build with env
+// appended to its environment (after that of .ko.yaml, so env wins), e.g. to
+// build with another GOCACHE.
+func WithBuildEnv(ctx context.Context, env ...string) context.Context {
+	return context.WithValue(ctx, buildEnvKey{}, append(buildEnv(ctx), env...))
+}
+
+// buildEnv returns the environment that WithBuildEnv added to ctx.
+func buildEnv(ctx context.Context) []string {
+	env, _ := ctx.Value(buildEnvKey{}).([]string)
+	return append([]string(nil), env...)
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"path"
+)
+
+// Build modes of Config.BuildMode that ko knows how to package.
+const (
+	buildModeExe     = "exe"
+	buildModePIE     = "pie"
+	buildModeCShared = "c-shared"
+	buildModePlugin  = "plugin"
+)
+
+func validateBuildMode(c Config) error {
+	switch c.BuildMode {
+	case "", buildModeExe, buildModePIE:
+		if c.LibraryPath != "" {
+			return fmt.Errorf("libraryPath is only for the %s and %s build modes", buildModeCShared, buildModePlugin)
+		}
+		return nil
+	case buildModeCShared, buildModePlugin:
+		if c.LibraryPath != "" && !path.IsAbs(c.LibraryPath) {
+			return fmt.Errorf("libraryPath %q is not absolute", c.LibraryPath)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported build mode %q, expected %s, %s, %s or %s", c.BuildMode, buildModeExe, buildModePIE, buildModeCShared, buildModePlugin)
+	}
+}
+
+// library returns whether c builds a shared library (e.g. a Go plugin, or an
+// NGINX module), which images ship instead of running.
+func (c Config) library() bool {
+	return c.BuildMode == buildModeCShared || c.BuildMode == buildModePlugin
+}
+
+// outputPath returns where the go build output of importpath goes in images.
+func outputPath(importpath string, c Config) string {
+	if !c.library() {
+		return path.Join(appDir, appFilename(importpath))
+	}
+	if c.LibraryPath != "" {
+		return c.LibraryPath
+	}
+	return path.Join(appDir, appFilename(importpath)+".so")
+}
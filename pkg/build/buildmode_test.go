@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestGoBuildLibrary(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	base, err := mutate.Config(img, v1.Config{Entrypoint: []string{"nginx", "-g", "daemon off;"}})
+	if err != nil {
+		t.Fatalf("mutate.Config() = %v", err)
+	}
+
+	var modes []string
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithInit(Init{
+			Binary: func(context.Context, v1.Platform) (string, error) { return "", nil },
+			All:    true,
+		}),
+		WithConfigs([]Config{{
+			ID:          "github.com/google/ko/cmd/ko",
+			BuildMode:   "c-shared",
+			LibraryPath: "/usr/lib/nginx/modules/ngx_ko_module.so",
+		}, {
+			ID:        "github.com/google/ko",
+			BuildMode: "plugin",
+		}}),
+		withBuilder(func(ctx context.Context, s, dir string, p v1.Platform, c Config, disableOptimizations bool) (string, error) {
+			modes = append(modes, c.BuildMode)
+			return writeTempFile(ctx, s, dir, p, c, disableOptimizations)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	for importpath, want := range map[string]string{
+		"github.com/google/ko/cmd/ko": "/usr/lib/nginx/modules/ngx_ko_module.so",
+		"github.com/google/ko":        "/ko-app/ko.so",
+	} {
+		result, err := ng.Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		img := result.(v1.Image)
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		// The init isn't added, and whatever loads the library still runs.
+		if diff := cmp.Diff([]string{"nginx", "-g", "daemon off;"}, cfg.Config.Entrypoint); diff != "" {
+			t.Errorf("Entrypoint of %s (-want +got) = %v", importpath, diff)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		if got := layerFiles(t, layers[len(layers)-1]); !cmp.Equal(got, []string{want}) {
+			t.Errorf("files of %s = %v, want %v", importpath, got, want)
+		}
+	}
+	if len(modes) != 2 || modes[0] == "" || modes[1] == "" {
+		t.Errorf("built with modes %q, want the configured ones", modes)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompileError is a failed go build of an import path, with the problems
+// that go build reported parsed out of its output.
+type CompileError struct {
+	ImportPath string
+	Platform   string
+
+	// Output is everything that go build printed.
+	Output string
+	// Diagnostics are the problems in Output that have a position, which is
+	// usually all of them.
+	Diagnostics []Diagnostic
+
+	Err error
+}
+
+// Error implements error
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("building %s for %s: %v\n%s", e.ImportPath, e.Platform, e.Err, strings.TrimSpace(e.Output))
+}
+
+// Unwrap returns the error from running go build.
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnostic is a problem that go build reported at a position in a file.
+type Diagnostic struct {
+	// Package is the package that was being built, if go build said.
+	Package string `json:"package,omitempty"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	// Column is 0 if go build only reported the line.
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// Position returns file:line[:column].
+func (d Diagnostic) Position() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+// String implements fmt.Stringer, like go build prints it.
+func (d Diagnostic) String() string {
+	return d.Position() + ": " + d.Message
+}
+
+// diagnosticRE matches the first line of a diagnostic, e.g. "./main.go:12:2:
+// undefined: foo".
+var diagnosticRE = regexp.MustCompile(`^(\S[^:]*\.(?:go|s|c|h|cc|cpp|m|syso|mod|sum)):(\d+)(?::(\d+))?: (.*)$`)
+
+// ParseDiagnostics parses the problems that go build reported in output.
+// Each belongs to the package named by the closest preceding "# package"
+// line, and continues on the indented lines that follow it.
+func ParseDiagnostics(output string) []Diagnostic {
+	var (
+		diags []Diagnostic
+		pkg   string
+		last  *Diagnostic
+	)
+	s := bufio.NewScanner(strings.NewReader(output))
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.HasPrefix(line, "# ") {
+			pkg = strings.TrimPrefix(line, "# ")
+			last = nil
+			continue
+		}
+		if last != nil && strings.HasPrefix(line, "\t") {
+			last.Message += "\n" + line
+			continue
+		}
+		m := diagnosticRE.FindStringSubmatch(line)
+		if m == nil {
+			last = nil
+			continue
+		}
+		d := Diagnostic{
+			Package: pkg,
+			File:    strings.TrimPrefix(m[1], "./"),
+			Message: m[4],
+		}
+		d.Line, _ = strconv.Atoi(m[2])
+		d.Column, _ = strconv.Atoi(m[3])
+		diags = append(diags, d)
+		last = &diags[len(diags)-1]
+	}
+	return diags
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	output := `go: downloading github.com/foo/qux v1.0.0
+# github.com/foo/bar/pkg/baz
+pkg/baz/baz.go:3:2: undefined: qux
+pkg/baz/baz.go:7: missing return
+# github.com/foo/bar/cmd/app
+./main.go:10:5: cannot use x (type int) as type string in argument to f:
+	int does not implement fmt.Stringer
+note: module requires Go 1.99
+`
+	want := []Diagnostic{{
+		Package: "github.com/foo/bar/pkg/baz",
+		File:    "pkg/baz/baz.go",
+		Line:    3,
+		Column:  2,
+		Message: "undefined: qux",
+	}, {
+		Package: "github.com/foo/bar/pkg/baz",
+		File:    "pkg/baz/baz.go",
+		Line:    7,
+		Message: "missing return",
+	}, {
+		Package: "github.com/foo/bar/cmd/app",
+		File:    "main.go",
+		Line:    10,
+		Column:  5,
+		Message: "cannot use x (type int) as type string in argument to f:\n\tint does not implement fmt.Stringer",
+	}}
+	if diff := cmp.Diff(want, ParseDiagnostics(output)); diff != "" {
+		t.Errorf("ParseDiagnostics() (-want +got) = %s", diff)
+	}
+
+	if got, want := want[1].String(), "pkg/baz/baz.go:7: missing return"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := ParseDiagnostics("go: cannot find main module"); len(got) != 0 {
+		t.Errorf("ParseDiagnostics() = %v, want none", got)
+	}
+}
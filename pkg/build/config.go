@@ -0,0 +1,189 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Config is the build configuration of an import path, from the builds
+// section of .ko.yaml.
+type Config struct {
+	// ID is the import path this configures, or a path.Match pattern
+	// of import paths, e.g. github.com/foo/bar/cmd/*.
+	ID string `mapstructure:"id"`
+
+	// Main, if set, is the package that is built (an import path, or a
+	// path relative to Dir) instead of the import path.
+	Main string `mapstructure:"main"`
+
+	// Dir, if set, is the directory that go build runs in, instead of the
+	// root of the module that contains the import path (or the working
+	// directory, for the main module).
+	Dir string `mapstructure:"dir"`
+
+	// Env is added to the environment of go build, after ko's defaults.
+	Env []string `mapstructure:"env"`
+
+	// Flags are passed to go build, e.g. -tags=netgo.
+	Flags []string `mapstructure:"flags"`
+
+	// Ldflags are joined with spaces and passed to go build as -ldflags.
+	Ldflags []string `mapstructure:"ldflags"`
+
+	// Gcflags and Asmflags are joined with spaces and passed to go build
+	// as -gcflags and -asmflags.
+	Gcflags  []string `mapstructure:"gcflags"`
+	Asmflags []string `mapstructure:"asmflags"`
+
+	// Tags are joined with commas and passed to go build as -tags.
+	Tags []string `mapstructure:"tags"`
+
+	// BuildMode, if set, is passed to go build as -buildmode. The
+	// c-shared and plugin modes build a shared library, which is added to
+	// the image at LibraryPath instead of becoming its entrypoint.
+	BuildMode string `mapstructure:"buildmode"`
+
+	// LibraryPath is where the shared library of the c-shared and plugin
+	// build modes goes, instead of /ko-app/<name>.so.
+	LibraryPath string `mapstructure:"libraryPath"`
+
+	// Platforms, if set, replaces the platforms that are built for the
+	// import path, in the same format as WithPlatforms.
+	Platforms []string `mapstructure:"platforms"`
+
+	// User, if set, is the user the image runs as, instead of the default
+	// user (see WithDefaultUser) or the base image's user, e.g. root to
+	// opt out of a non-root default.
+	User string `mapstructure:"user"`
+
+	// Layers are added to the image between the base image and kodata,
+	// e.g. to ship a CA bundle without maintaining a custom base image.
+	Layers []LayerConfig `mapstructure:"layers"`
+
+	// Init, if set, says whether to wrap the entrypoint in the init (see
+	// WithInit), instead of leaving that to the Init itself.
+	Init *bool `mapstructure:"init"`
+
+	// Artifact, if set, says whether to publish just the binary as an OCI
+	// artifact (see WithBinaryArtifacts), instead of leaving that to the
+	// default.
+	Artifact *bool `mapstructure:"artifact"`
+}
+
+// buildConfig is a Config with its platforms parsed.
+type buildConfig struct {
+	Config
+	platformMatcher *PlatformMatcher
+}
+
+func parseConfigs(configs []Config) ([]buildConfig, error) {
+	bcs := make([]buildConfig, 0, len(configs))
+	for _, c := range configs {
+		if c.ID == "" {
+			return nil, fmt.Errorf("build config is missing an id")
+		}
+		if _, err := path.Match(c.ID, ""); err != nil {
+			return nil, fmt.Errorf("build config %q: %v", c.ID, err)
+		}
+		if err := validateBuildMode(c); err != nil {
+			return nil, fmt.Errorf("build config %q: %v", c.ID, err)
+		}
+		for _, lc := range c.Layers {
+			if err := lc.validate(); err != nil {
+				return nil, fmt.Errorf("build config %q: %v", c.ID, err)
+			}
+		}
+		if err := c.expand(); err != nil {
+			return nil, fmt.Errorf("build config %q: %v", c.ID, err)
+		}
+		bc := buildConfig{Config: c}
+		if len(c.Platforms) != 0 {
+			pm, err := ParsePlatformSpec(strings.Join(c.Platforms, ","))
+			if err != nil {
+				return nil, fmt.Errorf("build config %q: %v", c.ID, err)
+			}
+			bc.platformMatcher = pm
+		}
+		bcs = append(bcs, bc)
+	}
+	return bcs, nil
+}
+
+// goConfig returns the Config that importpath is built with, which runs go
+// build in the module that contains importpath unless it says otherwise.
+func (g *gobuild) goConfig(importpath string) Config {
+	c := g.configFor(importpath).Config
+	if c.Dir == "" && g.mod != nil {
+		if m := g.mod.owner(importpath); m != g.mod.main {
+			c.Dir = m.Dir
+		}
+	}
+	return c
+}
+
+// configFor returns the first config whose ID matches importpath.
+func (g *gobuild) configFor(importpath string) buildConfig {
+	for _, c := range g.configs {
+		if c.ID == importpath {
+			return c
+		}
+		if ok, _ := path.Match(c.ID, importpath); ok {
+			return c
+		}
+	}
+	return buildConfig{}
+}
+
+// expand expands the templates in the flags of c, e.g.
+// -X main.version={{.Env.VERSION}}, so that what is injected at build time
+// can come from the environment rather than .ko.yaml itself.
+func (c *Config) expand() error {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	data := struct{ Env map[string]string }{Env: env}
+	for _, flags := range []*[]string{&c.Flags, &c.Ldflags, &c.Gcflags, &c.Asmflags, &c.Tags} {
+		if len(*flags) == 0 {
+			continue
+		}
+		expanded := make([]string, 0, len(*flags))
+		for _, f := range *flags {
+			if !strings.Contains(f, "{{") {
+				expanded = append(expanded, f)
+				continue
+			}
+			t, err := template.New("flag").Option("missingkey=error").Parse(f)
+			if err != nil {
+				return fmt.Errorf("parsing %q: %v", f, err)
+			}
+			var b bytes.Buffer
+			if err := t.Execute(&b, data); err != nil {
+				return fmt.Errorf("expanding %q: %v", f, err)
+			}
+			expanded = append(expanded, b.String())
+		}
+		*flags = expanded
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestConfigFor(t *testing.T) {
+	configs, err := parseConfigs([]Config{{
+		ID:      "github.com/google/ko/cmd/ko",
+		Ldflags: []string{"-s"},
+	}, {
+		ID:    "github.com/google/ko/cmd/*",
+		Flags: []string{"-tags=netgo"},
+	}})
+	if err != nil {
+		t.Fatalf("parseConfigs() = %v", err)
+	}
+	g := &gobuild{configs: configs}
+
+	for importpath, want := range map[string]Config{
+		"github.com/google/ko/cmd/ko":    {ID: "github.com/google/ko/cmd/ko", Ldflags: []string{"-s"}},
+		"github.com/google/ko/cmd/other": {ID: "github.com/google/ko/cmd/*", Flags: []string{"-tags=netgo"}},
+		"github.com/google/ko/test":      {},
+	} {
+		if diff := cmp.Diff(want, g.configFor(importpath).Config); diff != "" {
+			t.Errorf("configFor(%q) (-want +got) = %s", importpath, diff)
+		}
+	}
+
+	for _, bad := range []Config{{}, {ID: "["}, {ID: "x", Platforms: []string{"linux/arm/v7/what"}},
+		{ID: "x", BuildMode: "archive"}, {ID: "x", LibraryPath: "/x.so"},
+		{ID: "x", BuildMode: "plugin", LibraryPath: "x.so"},
+	} {
+		if _, err := parseConfigs([]Config{bad}); err == nil {
+			t.Errorf("parseConfigs(%+v) = nil, wanted an error", bad)
+		}
+	}
+}
+
+func TestGoBuildConfig(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		adds = append(adds, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				Platform: &v1.Platform{OS: "linux", Architecture: arch},
+			},
+		})
+	}
+	base := mutate.AppendManifests(empty.Index, adds...)
+
+	var m sync.Mutex
+	var built []Config
+	config := Config{
+		ID:        "github.com/google/ko/*",
+		Main:      "./cmd/ko",
+		Ldflags:   []string{"-s", "-w"},
+		Platforms: []string{"linux/arm64"},
+	}
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithPlatforms("all"),
+		WithConfigs([]Config{config}),
+		withBuilder(func(ctx context.Context, s, dir string, p v1.Platform, c Config, disableOptimizations bool) (string, error) {
+			m.Lock()
+			built = append(built, c)
+			m.Unlock()
+			return writeTempFile(ctx, s, dir, p, c, disableOptimizations)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	im, err := result.(v1.ImageIndex).IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if len(im.Manifests) != 1 || im.Manifests[0].Platform.Architecture != "arm64" {
+		t.Errorf("Build() = %+v, wanted only linux/arm64", im.Manifests)
+	}
+	if diff := cmp.Diff([]Config{config}, built); diff != "" {
+		t.Errorf("built with (-want +got) = %s", diff)
+	}
+}
+
+func TestConfigExpand(t *testing.T) {
+	defer os.Setenv("KO_TEST_VERSION", os.Getenv("KO_TEST_VERSION"))
+	os.Setenv("KO_TEST_VERSION", "v1.2.3")
+
+	configs, err := parseConfigs([]Config{{
+		ID:       "github.com/google/ko/cmd/ko",
+		Ldflags:  []string{"-s", "-X main.version={{.Env.KO_TEST_VERSION}}"},
+		Gcflags:  []string{"-trimpath={{.Env.KO_TEST_VERSION}}"},
+		Asmflags: []string{"-D=V{{.Env.KO_TEST_VERSION}}"},
+		Tags:     []string{"netgo", "{{.Env.KO_TEST_VERSION}}"},
+		Flags:    []string{"-v"},
+	}})
+	if err != nil {
+		t.Fatalf("parseConfigs() = %v", err)
+	}
+	want := Config{
+		ID:       "github.com/google/ko/cmd/ko",
+		Ldflags:  []string{"-s", "-X main.version=v1.2.3"},
+		Gcflags:  []string{"-trimpath=v1.2.3"},
+		Asmflags: []string{"-D=Vv1.2.3"},
+		Tags:     []string{"netgo", "v1.2.3"},
+		Flags:    []string{"-v"},
+	}
+	if diff := cmp.Diff(want, configs[0].Config); diff != "" {
+		t.Errorf("parseConfigs() (-want +got) = %s", diff)
+	}
+
+	for _, bad := range []string{"{{.Env.KO_TEST_UNSET}}", "{{.Env"} {
+		if _, err := parseConfigs([]Config{{ID: "x", Ldflags: []string{bad}}}); err == nil {
+			t.Errorf("parseConfigs(%q) = nil, wanted an error", bad)
+		}
+	}
+}
+
+func TestGoBuildArgs(t *testing.T) {
+	config := Config{
+		BuildMode: "pie",
+		Flags:     []string{"-v"},
+		Ldflags:   []string{"-s", "-w"},
+		Gcflags:   []string{"-m"},
+		Asmflags:  []string{"-D=X"},
+		Tags:      []string{"netgo", "osusergo"},
+	}
+	for _, tc := range []struct {
+		name                 string
+		gcflags              []string
+		disableOptimizations bool
+		want                 []string
+		wantErr              bool
+	}{{
+		name:    "gcflags",
+		gcflags: []string{"-m"},
+		want:    []string{"-gcflags", "-m"},
+	}, {
+		name:                 "disable optimizations",
+		disableOptimizations: true,
+		want:                 []string{"-gcflags", "all=-N -l"},
+	}, {
+		// go build only keeps the last -gcflags for a package, so
+		// both have to be in one.
+		name:                 "gcflags and disable optimizations",
+		gcflags:              []string{"-m", "-B"},
+		disableOptimizations: true,
+		want:                 []string{"-gcflags", "all=-N -l -m -B"},
+	}, {
+		name:                 "all gcflags and disable optimizations",
+		gcflags:              []string{"all=-m"},
+		disableOptimizations: true,
+		want:                 []string{"-gcflags", "all=-N -l -m"},
+	}, {
+		name:                 "pattern gcflags and disable optimizations",
+		gcflags:              []string{"./cmd/...=-m"},
+		disableOptimizations: true,
+		wantErr:              true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := config
+			config.Gcflags = tc.gcflags
+			got, err := goBuildArgs("/tmp/out", config, tc.disableOptimizations, "./cmd/ko")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("goBuildArgs() = %v, wanted an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("goBuildArgs() = %v", err)
+			}
+			want := append([]string{"build"}, tc.want...)
+			want = append(want, "-buildmode=pie", "-v", "-ldflags", "-s -w", "-asmflags", "-D=X", "-tags", "netgo,osusergo", "-o", "/tmp/out")
+			want = append(addGo113TrimPathFlag(want), "./cmd/ko")
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("goBuildArgs() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// baseDirectivePrefix starts a comment, before the package clause of a file
+// in a main package, that names the base image to build the package on.
+//
+//	//ko:base gcr.io/distroless/base:nonroot
+//	package main
+const baseDirectivePrefix = "//ko:base "
+
+type baseDirectiveKey struct{}
+
+// BaseDirective returns the base image named by the //ko:base directive of
+// the import path that the GetBase it is passed to was called for, if any.
+func BaseDirective(ctx context.Context) string {
+	s, _ := ctx.Value(baseDirectiveKey{}).(string)
+	return s
+}
+
+// baseDirective returns the base image named by a //ko:base directive in
+// ref's package, or "" if there is none (or the package can't be found, which
+// the build itself will report).
+func (g *gobuild) baseDirective(ref reference) (string, error) {
+	p, err := g.importPackage(ref)
+	if err != nil {
+		return "", nil
+	}
+	var base, from string
+	for _, f := range p.GoFiles {
+		path := filepath.Join(p.Dir, f)
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly|parser.ParseComments)
+		if err != nil {
+			return "", err
+		}
+		for _, cg := range file.Comments {
+			for _, c := range cg.List {
+				if c.Pos() > file.Package || !strings.HasPrefix(c.Text, baseDirectivePrefix) {
+					continue
+				}
+				b := strings.TrimSpace(strings.TrimPrefix(c.Text, baseDirectivePrefix))
+				if base != "" && b != base {
+					return "", fmt.Errorf("conflicting //ko:base directives in %s (%s) and %s (%s)", from, base, f, b)
+				}
+				base, from = b, f
+			}
+		}
+	}
+	return base, nil
+}
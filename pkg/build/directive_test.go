@@ -0,0 +1,94 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	gb "go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestBaseDirective(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-directive")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for f, content := range map[string]string{
+		"main.go": `// Copyright header.
+
+//ko:base gcr.io/distroless/base:nonroot
+
+// Command main does things.
+package main
+
+//ko:base ignored/after/the/package:clause
+func main() {}
+`,
+		"other.go": "package main\n",
+		"conflict.go": `//ko:base gcr.io/distroless/static:nonroot
+package main
+`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile() = %v", err)
+		}
+	}
+
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	var got string
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(ctx context.Context, _ string) (Result, error) {
+			got = BaseDirective(ctx)
+			return base, nil
+		}),
+		withBuilder(writeTempFile),
+		withModuleInfo(&modules{main: &modInfo{Path: "github.com/google/ko", Dir: dir}}),
+		withBuildContext(stubBuildContext{
+			"github.com/google/ko/directive": &gb.Package{Name: "main", Dir: dir, GoFiles: []string{"main.go", "other.go"}},
+			"github.com/google/ko/none":      &gb.Package{Name: "main", Dir: dir, GoFiles: []string{"other.go"}},
+			"github.com/google/ko/conflict":  &gb.Package{Name: "main", Dir: dir, GoFiles: []string{"main.go", "conflict.go"}},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	for importpath, want := range map[string]string{
+		"github.com/google/ko/directive": "gcr.io/distroless/base:nonroot",
+		"github.com/google/ko/none":      "",
+	} {
+		got = "unset"
+		if _, err := ng.Build(context.Background(), StrictScheme+importpath); err != nil {
+			t.Fatalf("Build(%s) = %v", importpath, err)
+		}
+		if got != want {
+			t.Errorf("Build(%s): BaseDirective() = %q, want %q", importpath, got, want)
+		}
+	}
+
+	if _, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/conflict"); err == nil {
+		t.Error("Build() = nil, wanted an error for conflicting directives")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnsupportedReference means that a reference isn't to an import
+	// path that ko can build, e.g. because it lacks the ko:// prefix or the
+	// package can't be found.
+	ErrUnsupportedReference = errors.New("unsupported reference")
+
+	// ErrNotMainPackage means that a reference is to an import path that
+	// isn't `package main`, so it has no binary to build.
+	ErrNotMainPackage = errors.New("importpath is not `package main`")
+
+	// ErrBaseImageUnavailable means that the base image for an import path
+	// couldn't be fetched.
+	ErrBaseImageUnavailable = errors.New("base image unavailable")
+
+	// ErrKoDataSecret means that the kodata of an import path looks like it
+	// holds secrets, and the secrets policy is to fail.
+	ErrKoDataSecret = errors.New("kodata may hold secrets")
+)
+
+// Error attributes an underlying error to one of the errors above, so that
+// errors.Is matches Kind while errors.As still finds the underlying error.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+// Is reports whether target is the Kind of this error.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
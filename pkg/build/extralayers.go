@@ -0,0 +1,103 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"fmt"
+	"hash"
+	"os"
+	"path"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// LayerConfig is an extra layer to add to the images of an import path: a
+// tarball (optionally gzipped), whose contents are added as they are, or a
+// directory, which is copied to Target.
+type LayerConfig struct {
+	// Path is the tarball or directory, relative to the working directory.
+	Path string `mapstructure:"path"`
+
+	// Target is where in the image the contents of a directory go, e.g.
+	// /etc/ssl/certs. It must be unset for tarballs.
+	Target string `mapstructure:"target"`
+}
+
+func (lc LayerConfig) validate() error {
+	if lc.Path == "" {
+		return fmt.Errorf("layer is missing a path")
+	}
+	if lc.Target != "" && !path.IsAbs(lc.Target) {
+		return fmt.Errorf("layer %q: target %q is not an absolute path", lc.Path, lc.Target)
+	}
+	return nil
+}
+
+// extraLayer returns the layer that lc describes.
+func (g *gobuild) extraLayer(lc LayerConfig) (mutate.Addendum, error) {
+	fi, err := os.Stat(lc.Path)
+	if err != nil {
+		return mutate.Addendum{}, fmt.Errorf("extra layer: %v", err)
+	}
+	history := v1.History{
+		Author:  "ko",
+		Comment: "extra layer from " + lc.Path,
+	}
+	if !fi.IsDir() {
+		if lc.Target != "" {
+			return mutate.Addendum{}, fmt.Errorf("extra layer %q: target is only for directories, tarballs are added as they are", lc.Path)
+		}
+		layer, err := tarball.LayerFromFile(lc.Path, tarball.WithCompressionLevel(g.compressionLevel))
+		if err != nil {
+			return mutate.Addendum{}, fmt.Errorf("extra layer %q: %v", lc.Path, err)
+		}
+		return mutate.Addendum{Layer: layer, History: history}, nil
+	}
+	if lc.Target == "" {
+		return mutate.Addendum{}, fmt.Errorf("extra layer %q: directories need a target", lc.Path)
+	}
+	history.Comment += ", at " + lc.Target
+	// Parent directories are left out, so that those the base has (like
+	// /etc) keep their permissions.
+	layer, _, err := stageLayer(g.workDir, g.compressionLevel, func(tw *tar.Writer) error {
+		return walkRecursive(tw, lc.Path, lc.Target, nil)
+	})
+	if err != nil {
+		return mutate.Addendum{}, fmt.Errorf("extra layer %q: %v", lc.Path, err)
+	}
+	return mutate.Addendum{Layer: layer, History: history}, nil
+}
+
+// hashLayers hashes the contents of the extra layers, for inputsDigest.
+func hashLayers(h hash.Hash, layers []LayerConfig) error {
+	for _, lc := range layers {
+		fi, err := os.Stat(lc.Path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = hashTree(h, lc.Path, "layer "+lc.Target, nil)
+		} else {
+			err = hashFile(h, "layer "+lc.Path, lc.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
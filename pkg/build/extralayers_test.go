@@ -0,0 +1,139 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+// layerFiles returns the names of the files in layer.
+func layerFiles(t *testing.T, layer v1.Layer) []string {
+	t.Helper()
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatalf("Uncompressed() = %v", err)
+	}
+	defer rc.Close()
+	var names []string
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			names = append(names, hdr.Name)
+		}
+	}
+	return names
+}
+
+func TestGoBuildExtraLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-layers")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	certs := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certs, os.ModePerm); err != nil {
+		t.Fatalf("Mkdir() = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(certs, "ca.crt"), []byte("cert"), 0644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	fonts := filepath.Join(dir, "fonts.tar")
+	f, err := os.Create(fonts)
+	if err != nil {
+		t.Fatalf("Create() = %v", err)
+	}
+	tw := tar.NewWriter(f)
+	if err := tw.WriteHeader(&tar.Header{Name: "usr/share/fonts/font.ttf", Size: 4, Mode: 0644, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatalf("WriteHeader() = %v", err)
+	}
+	if _, err := tw.Write([]byte("font")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithConfigs([]Config{{
+			ID: "github.com/google/ko",
+			Layers: []LayerConfig{
+				{Path: certs, Target: "/etc/ssl/certs"},
+				{Path: fonts},
+			},
+		}}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	layers, err := result.(v1.Image).Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	// The base layer, the two extra layers, kodata and the binary.
+	if got, want := len(layers), 5; got != want {
+		t.Fatalf("len(Layers()) = %d, want %d", got, want)
+	}
+	if diff := cmp.Diff([]string{"/etc/ssl/certs/ca.crt"}, layerFiles(t, layers[1])); diff != "" {
+		t.Errorf("certs layer (-want +got) = %v", diff)
+	}
+	if diff := cmp.Diff([]string{"usr/share/fonts/font.ttf"}, layerFiles(t, layers[2])); diff != "" {
+		t.Errorf("fonts layer (-want +got) = %v", diff)
+	}
+	if got, want := len(AddedLayers(result)), 4; got != want {
+		t.Errorf("len(AddedLayers()) = %d, want %d", got, want)
+	}
+}
+
+func TestLayerConfigValidate(t *testing.T) {
+	for _, lc := range []LayerConfig{
+		{Target: "/etc"},
+		{Path: "certs", Target: "etc/ssl"},
+	} {
+		if _, err := parseConfigs([]Config{{ID: "foo", Layers: []LayerConfig{lc}}}); err == nil {
+			t.Errorf("parseConfigs(%+v) = nil, want error", lc)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package build
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -31,6 +32,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/containerd/stargz-snapshotter/estargz"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -38,6 +41,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/internal/trace"
 )
 
 const (
@@ -61,16 +65,22 @@ For more information see:
 `
 )
 
-// GetBase takes an importpath and returns a base image.
+// GetBase takes an importpath and returns a base image. Builds only read the
+// manifest and config file of the base, so its layers should be fetched
+// lazily: that way publishers only read them if the registry they publish to
+// doesn't already have them, or can't mount them from the base's repository.
 type GetBase func(context.Context, string) (Result, error)
 
-type builder func(context.Context, string, v1.Platform, bool) (string, error)
+type builder func(context.Context, string, string, v1.Platform, Config, bool) (string, error)
 
 type buildContext interface {
 	Import(path string, srcDir string, mode gb.ImportMode) (*gb.Package, error)
 }
 
-type platformMatcher struct {
+// PlatformMatcher matches the platforms of a --platform spec: "all", or
+// comma-separated os[/arch[/variant]] platforms, any part of which may be
+// left out to match every value of it.
+type PlatformMatcher struct {
 	spec      string
 	platforms []v1.Platform
 }
@@ -82,7 +92,26 @@ type gobuild struct {
 	disableOptimizations bool
 	mod                  *modules
 	buildContext         buildContext
-	platformMatcher      *platformMatcher
+	platformMatcher      *PlatformMatcher
+	sizeReporter         func(SizeReport)
+	sizeAnalysis         int
+	configs              []buildConfig
+	labels               map[string]string
+	workDir              string
+	cleanupPolicy        CleanupPolicy
+	ignored              func(string, bool) bool
+	hooks                []Hooks
+	compressionLevel     int
+	lookup               Lookup
+	secretsPolicy        SecretsPolicy
+	defaultUser          string
+	baseMetadata         BaseMetadataPolicy
+	init                 *Init
+	binaryArtifacts      bool
+	sourceAnnotations    bool
+
+	// revisions caches the commit checked out in each module directory.
+	revisions sync.Map
 }
 
 // Option is a functional option for NewGo.
@@ -96,24 +125,93 @@ type gobuildOpener struct {
 	mod                  *modules
 	buildContext         buildContext
 	platform             string
+	sizeReporter         func(SizeReport)
+	sizeAnalysis         int
+	configs              []Config
+	labels               map[string]string
+	workDir              string
+	cleanupPolicy        CleanupPolicy
+	ignored              func(string, bool) bool
+	hooks                []Hooks
+	compressionLevel     int
+	lookup               Lookup
+	batchWindow          time.Duration
+	secretsPolicy        SecretsPolicy
+	defaultUser          string
+	baseMetadata         BaseMetadataPolicy
+	init                 *Init
+	binaryArtifacts      bool
+	sourceAnnotations    bool
 }
 
 func (gbo *gobuildOpener) Open() (Interface, error) {
 	if gbo.getBase == nil {
 		return nil, errors.New("a way of providing base images must be specified, see build.WithBaseImages")
 	}
-	matcher, err := parseSpec(gbo.platform)
+	matcher, err := ParsePlatformSpec(gbo.platform)
 	if err != nil {
 		return nil, err
 	}
+	configs, err := parseConfigs(gbo.configs)
+	if err != nil {
+		return nil, err
+	}
+	// If we can't list the ports the toolchain supports, go build will
+	// complain about the platforms it doesn't.
+	if known, err := ports.get(); err == nil {
+		matchers := []*PlatformMatcher{matcher}
+		for _, c := range configs {
+			matchers = append(matchers, c.platformMatcher)
+		}
+		if err := checkPlatforms(known, matchers...); err != nil {
+			return nil, err
+		}
+	}
+	cleanup, err := parseCleanupPolicy(gbo.cleanupPolicy)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := parseSecretsPolicy(gbo.secretsPolicy)
+	if err != nil {
+		return nil, err
+	}
+	baseMetadata, err := parseBaseMetadataPolicy(gbo.baseMetadata)
+	if err != nil {
+		return nil, err
+	}
+	if gbo.workDir != "" {
+		if err := os.MkdirAll(gbo.workDir, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	b := gbo.build
+	if gbo.batchWindow > 0 {
+		b = newBatcher(gbo.batchWindow, gbo.workDir, gbo.build, goBuildMany).build
+	}
 	return &gobuild{
 		getBase:              gbo.getBase,
 		creationTime:         gbo.creationTime,
-		build:                gbo.build,
+		build:                b,
 		disableOptimizations: gbo.disableOptimizations,
 		mod:                  gbo.mod,
 		buildContext:         gbo.buildContext,
 		platformMatcher:      matcher,
+		sizeReporter:         gbo.sizeReporter,
+		sizeAnalysis:         gbo.sizeAnalysis,
+		configs:              configs,
+		labels:               gbo.labels,
+		workDir:              gbo.workDir,
+		cleanupPolicy:        cleanup,
+		ignored:              gbo.ignored,
+		hooks:                gbo.hooks,
+		compressionLevel:     gbo.compressionLevel,
+		lookup:               gbo.lookup,
+		secretsPolicy:        secrets,
+		defaultUser:          gbo.defaultUser,
+		baseMetadata:         baseMetadata,
+		init:                 gbo.init,
+		binaryArtifacts:      gbo.binaryArtifacts,
+		sourceAnnotations:    gbo.sourceAnnotations,
 	}, nil
 }
 
@@ -121,57 +219,179 @@ func (gbo *gobuildOpener) Open() (Interface, error) {
 type modules struct {
 	main *modInfo
 	deps map[string]*modInfo
+	// local are the other modules in the directory tree of main (each with
+	// its own go.mod) that main doesn't depend on.
+	local []*modInfo
+}
+
+// owner returns the module that contains importpath: the local module with
+// the longest matching path, or else main.
+func (m *modules) owner(importpath string) *modInfo {
+	owner, matched := m.main, 0
+	if inModule(importpath, m.main.Path) {
+		matched = len(m.main.Path)
+	}
+	for _, l := range m.local {
+		if len(l.Path) > matched && inModule(importpath, l.Path) {
+			owner, matched = l, len(l.Path)
+		}
+	}
+	return owner
+}
+
+// inModule reports whether importpath is in the module with path mod.
+func inModule(importpath, mod string) bool {
+	return importpath == mod || strings.HasPrefix(importpath, mod+"/")
 }
 
 type modInfo struct {
-	Path string
-	Dir  string
-	Main bool
+	Path    string
+	Version string
+	Dir     string
+	Main    bool
+	Error   *modError
+}
+
+type modError struct {
+	Err string
 }
 
 // moduleInfo returns the module path and module root directory for a project
 // using go modules, otherwise returns nil.
 //
+// Modules that can't be loaded (e.g. because they aren't in the module cache
+// and we're offline) are reported, but don't stop us from using the rest.
+//
 // Related: https://github.com/golang/go/issues/26504
 func moduleInfo(ctx context.Context) (*modules, error) {
-	modules := modules{
-		deps: make(map[string]*modInfo),
-	}
-
-	// TODO we read all the output as a single byte array - it may
-	// be possible & more efficient to stream it
-	output, err := exec.CommandContext(ctx, "go", "list", "-mod=readonly", "-json", "-m", "all").Output()
+	cmd := exec.CommandContext(ctx, "go", "list", "-mod=readonly", "-json", "-m", "-e", "all")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, nil
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("running go list -m: %w", err)
+	}
+	modules, decodeErr := decodeModules(stdout)
+	// Drain anything left, so that go list can exit.
+	io.Copy(ioutil.Discard, stdout)
+
+	if err := cmd.Wait(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if notUsingModules(msg) {
+			return nil, nil
+		}
+		if decodeErr != nil || modules.main == nil {
+			return nil, fmt.Errorf("go list -m: %v: %s", err, msg)
+		}
+		log.Printf("Warning: go list -m failed, continuing with partial module data: %s", msg)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if modules.main == nil {
+		return nil, fmt.Errorf("couldn't find main module")
 	}
+	for _, l := range findModules(modules.main.Dir) {
+		if _, ok := modules.deps[l.Path]; !ok {
+			modules.local = append(modules.local, l)
+		}
+	}
+	return modules, nil
+}
 
-	dec := json.NewDecoder(bytes.NewReader(output))
+// findModules returns the modules nested in the directory tree of root,
+// skipping the directories that the go command ignores.
+func findModules(root string) []*modInfo {
+	var mods []*modInfo
+	filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// Skip what we can't read.
+			if fi != nil && fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.IsDir() || path == root {
+			return nil
+		}
+		switch name := fi.Name(); {
+		case strings.HasPrefix(name, "."), strings.HasPrefix(name, "_"), name == "testdata", name == "vendor", name == "node_modules":
+			return filepath.SkipDir
+		}
+		b, err := ioutil.ReadFile(filepath.Join(path, "go.mod"))
+		if err != nil {
+			return nil
+		}
+		if mod := modulePath(b); mod != "" {
+			mods = append(mods, &modInfo{Path: mod, Dir: path})
+		}
+		return nil
+	})
+	return mods
+}
 
+// modulePath returns the path of the module directive of a go.mod, or "".
+func modulePath(gomod []byte) string {
+	for _, line := range strings.Split(string(gomod), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "module" {
+			continue
+		}
+		mod := fields[1]
+		if unquoted, err := strconv.Unquote(mod); err == nil {
+			mod = unquoted
+		}
+		return mod
+	}
+	return ""
+}
+
+// decodeModules decodes the stream of modules that go list -json -m writes.
+func decodeModules(r io.Reader) (*modules, error) {
+	modules := &modules{
+		deps: make(map[string]*modInfo),
+	}
+	dec := json.NewDecoder(r)
+	var broken []string
 	for {
 		var info modInfo
-
-		err := dec.Decode(&info)
-		if err == io.EOF {
-			// all done
+		if err := dec.Decode(&info); err == io.EOF {
 			break
+		} else if err != nil {
+			return modules, fmt.Errorf("error reading module data: %w", err)
 		}
 
 		modules.deps[info.Path] = &info
-
 		if info.Main {
 			modules.main = &info
 		}
-
-		if err != nil {
-			return nil, fmt.Errorf("error reading module data %w", err)
+		if info.Error != nil {
+			broken = append(broken, fmt.Sprintf("%s (%s)", info.Path, info.Error.Err))
 		}
 	}
-
-	if modules.main == nil {
-		return nil, fmt.Errorf("couldn't find main module")
+	if len(broken) != 0 {
+		log.Printf("Warning: couldn't load modules %s; import paths in them may fail to build", strings.Join(broken, ", "))
 	}
+	return modules, nil
+}
 
-	return &modules, nil
+// notUsingModules reports whether the stderr of go list -m means that we're
+// not in module mode, rather than that something went wrong.
+func notUsingModules(stderr string) bool {
+	for _, s := range []string{
+		"go.mod file not found",
+		"cannot find main module",
+		"not using modules",
+		"GO111MODULE=off",
+	} {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
 }
 
 // getGoroot shells out to `go env GOROOT` to determine
@@ -216,9 +436,10 @@ func NewGo(ctx context.Context, options ...Option) (Interface, error) {
 	}
 
 	gbo := &gobuildOpener{
-		build:        build,
-		mod:          module,
-		buildContext: &bc,
+		build:            build,
+		mod:              module,
+		buildContext:     &bc,
+		compressionLevel: gzip.BestSpeed,
 	}
 
 	for _, option := range options {
@@ -237,14 +458,14 @@ func NewGo(ctx context.Context, options ...Option) (Interface, error) {
 func (g *gobuild) IsSupportedReference(s string) error {
 	ref := newRef(s)
 	if !ref.IsStrict() {
-		return errors.New("importpath does not start with ko://")
+		return &Error{Kind: ErrUnsupportedReference, Err: errors.New("importpath does not start with ko://")}
 	}
 	p, err := g.importPackage(ref)
 	if err != nil {
-		return err
+		return &Error{Kind: ErrUnsupportedReference, Err: err}
 	}
 	if !p.IsCommand() {
-		return errors.New("importpath is not `package main`")
+		return ErrNotMainPackage
 	}
 	return nil
 }
@@ -263,10 +484,14 @@ func (g *gobuild) importPackage(ref reference) (*gb.Package, error) {
 	// * paths that match module path prefix (they should be in this project)
 	// * relative paths (they should also be in this project)
 	// * path is a module
+	//
+	// Import paths in other modules of the project are imported from
+	// their own module's directory.
 
+	owner := g.mod.owner(ref.Path())
 	_, isDep := g.mod.deps[ref.Path()]
-	if ref.IsStrict() || strings.HasPrefix(ref.Path(), g.mod.main.Path) || gb.IsLocalImport(ref.Path()) || isDep {
-		return g.buildContext.Import(ref.Path(), g.mod.main.Dir, gb.ImportComment)
+	if ref.IsStrict() || strings.HasPrefix(ref.Path(), g.mod.main.Path) || gb.IsLocalImport(ref.Path()) || isDep || owner != g.mod.main {
+		return g.buildContext.Import(ref.Path(), owner.Dir, gb.ImportComment)
 	}
 
 	return nil, fmt.Errorf("unmatched importPackage %q with gomodules", ref.String())
@@ -300,27 +525,74 @@ func platformToString(p v1.Platform) string {
 	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
 }
 
-func build(ctx context.Context, ip string, platform v1.Platform, disableOptimizations bool) (string, error) {
-	tmpDir, err := ioutil.TempDir("", "ko")
-	if err != nil {
+func build(ctx context.Context, ip, dir string, platform v1.Platform, config Config, disableOptimizations bool) (string, error) {
+	file := filepath.Join(dir, "out")
+	pkg := ip
+	if config.Main != "" {
+		pkg = config.Main
+	}
+
+	log.Printf("Building %s for %s", ip, platformToString(platform))
+	if err := goBuild(ctx, dir, file, platform, config, disableOptimizations, pkg); err != nil {
+		if ce, ok := err.(*CompileError); ok {
+			ce.ImportPath = ip
+		}
 		return "", err
 	}
-	file := filepath.Join(tmpDir, "out")
+	return file, nil
+}
 
-	args := make([]string, 0, 7)
+// goBuildArgs returns the arguments of the go command that builds pkgs into
+// out with config.
+func goBuildArgs(out string, config Config, disableOptimizations bool, pkgs ...string) ([]string, error) {
+	args := make([]string, 0, 14+len(config.Flags)+len(pkgs))
 	args = append(args, "build")
+	gcflags := strings.Join(config.Gcflags, " ")
 	if disableOptimizations {
-		// Disable optimizations (-N) and inlining (-l).
-		args = append(args, "-gcflags", "all=-N -l")
+		// Disable optimizations (-N) and inlining (-l). go build only
+		// keeps the last -gcflags that matches a package, so the config's
+		// gcflags go in the same flag, for every package.
+		if i := strings.Index(gcflags, "="); i > 0 && !strings.HasPrefix(gcflags, "-") {
+			if pattern := gcflags[:i]; pattern != "all" {
+				return nil, fmt.Errorf("gcflags for %s can't be combined with --disable-optimizations, which applies to all packages", pattern)
+			}
+			gcflags = gcflags[i+1:]
+		}
+		gcflags = strings.TrimSpace("all=-N -l " + gcflags)
+	}
+	if gcflags != "" {
+		args = append(args, "-gcflags", gcflags)
+	}
+	if config.BuildMode != "" {
+		args = append(args, "-buildmode="+config.BuildMode)
+	}
+	args = append(args, config.Flags...)
+	if len(config.Ldflags) != 0 {
+		args = append(args, "-ldflags", strings.Join(config.Ldflags, " "))
+	}
+	if len(config.Asmflags) != 0 {
+		args = append(args, "-asmflags", strings.Join(config.Asmflags, " "))
+	}
+	if len(config.Tags) != 0 {
+		args = append(args, "-tags", strings.Join(config.Tags, ","))
 	}
-	args = append(args, "-o", file)
+	args = append(args, "-o", out)
 	args = addGo113TrimPathFlag(args)
-	args = append(args, ip)
-	cmd := exec.CommandContext(ctx, "go", args...)
+	return append(args, pkgs...), nil
+}
+
+// goEnv returns the environment of the go command when it builds for
+// platform with config.
+func goEnv(platform v1.Platform, config Config) ([]string, error) {
+	// Shared libraries can only be linked with cgo.
+	cgo := "CGO_ENABLED=0"
+	if config.library() {
+		cgo = "CGO_ENABLED=1"
+	}
 
 	// Last one wins
 	defaultEnv := []string{
-		"CGO_ENABLED=0",
+		cgo,
 		"GOOS=" + platform.OS,
 		"GOARCH=" + platform.Architecture,
 	}
@@ -328,26 +600,51 @@ func build(ctx context.Context, ip string, platform v1.Platform, disableOptimiza
 	if strings.HasPrefix(platform.Architecture, "arm") && platform.Variant != "" {
 		goarm, err := getGoarm(platform)
 		if err != nil {
-			return "", fmt.Errorf("goarm failure for %s: %v", ip, err)
+			return nil, err
 		}
 		if goarm != "" {
 			defaultEnv = append(defaultEnv, "GOARM="+goarm)
 		}
 	}
 
-	cmd.Env = append(defaultEnv, os.Environ()...)
+	env := append(defaultEnv, os.Environ()...)
+	return append(env, config.Env...), nil
+}
+
+// goBuild runs go build for pkgs, writing its scratch files to dir and the
+// binary to out (or, with more than one package, the binaries to the
+// directory out).
+func goBuild(ctx context.Context, dir, out string, platform v1.Platform, config Config, disableOptimizations bool, pkgs ...string) error {
+	args, err := goBuildArgs(out, config, disableOptimizations, pkgs...)
+	if err != nil {
+		return err
+	}
+	env, err := goEnv(platform, config)
+	if err != nil {
+		return fmt.Errorf("goarm failure for %s: %v", strings.Join(pkgs, " "), err)
+	}
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = config.Dir
+	// Keep the go command's own scratch files in the working directory.
+	cmd.Env = append([]string{"GOTMPDIR=" + dir}, env...)
 
 	var output bytes.Buffer
 	cmd.Stderr = &output
 	cmd.Stdout = &output
 
-	log.Printf("Building %s for %s", ip, platformToString(platform))
 	if err := cmd.Run(); err != nil {
-		os.RemoveAll(tmpDir)
-		log.Printf("Unexpected error running \"go build\": %v\n%v", err, output.String())
-		return "", err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &CompileError{
+			ImportPath:  strings.Join(pkgs, " "),
+			Platform:    platformToString(platform),
+			Output:      output.String(),
+			Diagnostics: ParseDiagnostics(output.String()),
+			Err:         err,
+		}
 	}
-	return file, nil
+	return nil
 }
 
 func appFilename(importpath string) string {
@@ -387,24 +684,21 @@ func tarAddDirectories(tw *tar.Writer, dir string) error {
 	return nil
 }
 
-func tarBinary(name, binary string) (*bytes.Buffer, error) {
-	buf := bytes.NewBuffer(nil)
-	tw := tar.NewWriter(buf)
-	defer tw.Close()
-
+// writeBinary writes the binary, as name, to tw.
+func writeBinary(tw *tar.Writer, name, binary string) error {
 	// write the parent directories to the tarball archive
 	if err := tarAddDirectories(tw, path.Dir(name)); err != nil {
-		return nil, err
+		return err
 	}
 
 	file, err := os.Open(binary)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, err
+		return err
 	}
 	header := &tar.Header{
 		Name:     name,
@@ -417,14 +711,11 @@ func tarBinary(name, binary string) (*bytes.Buffer, error) {
 	}
 	// write the header to the tarball archive
 	if err := tw.WriteHeader(header); err != nil {
-		return nil, err
+		return err
 	}
 	// copy the file data to the tarball
-	if _, err := io.Copy(tw, file); err != nil {
-		return nil, err
-	}
-
-	return buf, nil
+	_, err = io.Copy(tw, file)
+	return err
 }
 
 func (g *gobuild) kodataPath(ref reference) (string, error) {
@@ -438,30 +729,38 @@ func (g *gobuild) kodataPath(ref reference) (string, error) {
 // Where kodata lives in the image.
 const kodataRoot = "/var/run/ko"
 
-// walkRecursive performs a filepath.Walk of the given root directory adding it
-// to the provided tar.Writer with root -> chroot.  All symlinks are dereferenced,
-// which is what leads to recursion when we encounter a directory symlink.
-func walkRecursive(tw *tar.Writer, root, chroot string) error {
+// walkTree walks the files under root as if they were under prefix, except
+// for those that ignored reports as ignored. Symlinks are followed, which is
+// what leads to recursion when we encounter a directory symlink. fn is called
+// with the slash-separated path of each file and the host path it resolves
+// to, and with those of each directory symlink before it is followed. A
+// missing root has no files.
+func walkTree(root, prefix string, ignored func(string, bool) bool, fn func(rel, hostPath string, info os.FileInfo) error) error {
 	return filepath.Walk(root, func(hostPath string, info os.FileInfo, err error) error {
-		if hostPath == root {
-			// Add an entry for the root directory of our walk.
-			return tw.WriteHeader(&tar.Header{
-				Name:     chroot,
-				Typeflag: tar.TypeDir,
-				// Use a fixed Mode, so that this isn't sensitive to the directory and umask
-				// under which it was created. Additionally, windows can only set 0222,
-				// 0444, or 0666, none of which are executable.
-				Mode: 0555,
-			})
+		if os.IsNotExist(err) && hostPath == root {
+			return nil
 		}
 		if err != nil {
 			return err
 		}
+		if hostPath == root {
+			return nil
+		}
+		if ignored != nil && ignored(hostPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		// Skip other directories.
-		if info.Mode().IsDir() {
+		if info.IsDir() {
 			return nil
 		}
-		newPath := path.Join(chroot, filepath.ToSlash(hostPath[len(root):]))
+		rel, err := filepath.Rel(root, hostPath)
+		if err != nil {
+			return err
+		}
+		rel = path.Join(prefix, filepath.ToSlash(rel))
 
 		hostPath, err = filepath.EvalSymlinks(hostPath)
 		if err != nil {
@@ -473,9 +772,36 @@ func walkRecursive(tw *tar.Writer, root, chroot string) error {
 		if err != nil {
 			return err
 		}
-		// Skip other directories.
-		if info.Mode().IsDir() {
-			return walkRecursive(tw, hostPath, newPath)
+		if err := fn(rel, hostPath, info); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return walkTree(hostPath, rel, ignored, fn)
+		}
+		return nil
+	})
+}
+
+// walkRecursive adds the files under root to the provided tar.Writer with
+// root -> chroot, as walkTree finds them.
+func walkRecursive(tw *tar.Writer, root, chroot string, ignored func(string, bool) bool) error {
+	dir := func(name string) error {
+		return tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeDir,
+			// Use a fixed Mode, so that this isn't sensitive to the directory and umask
+			// under which it was created. Additionally, windows can only set 0222,
+			// 0444, or 0666, none of which are executable.
+			Mode: 0555,
+		})
+	}
+	// Add an entry for the root directory of our walk.
+	if err := dir(chroot); err != nil {
+		return err
+	}
+	return walkTree(root, chroot, ignored, func(newPath, hostPath string, info os.FileInfo) error {
+		if info.IsDir() {
+			return dir(newPath)
 		}
 
 		// Open the file to copy it into the tarball.
@@ -502,20 +828,16 @@ func walkRecursive(tw *tar.Writer, root, chroot string) error {
 	})
 }
 
-func (g *gobuild) tarKoData(ref reference) (*bytes.Buffer, error) {
-	buf := bytes.NewBuffer(nil)
-	tw := tar.NewWriter(buf)
-	defer tw.Close()
-
+// writeKoData writes the kodata directory of ref to tw.
+func (g *gobuild) writeKoData(tw *tar.Writer, ref reference) error {
 	root, err := g.kodataPath(ref)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return buf, walkRecursive(tw, root, kodataRoot)
+	return walkRecursive(tw, root, kodataRoot, g.ignored)
 }
 
-func (g *gobuild) buildOne(ctx context.Context, s string, base v1.Image, platform *v1.Platform) (v1.Image, error) {
+func (g *gobuild) buildOne(ctx context.Context, s string, base v1.Image, platform *v1.Platform) (_ v1.Image, err error) {
 	ref := newRef(s)
 
 	cf, err := base.ConfigFile()
@@ -530,23 +852,54 @@ func (g *gobuild) buildOne(ctx context.Context, s string, base v1.Image, platfor
 		}
 	}
 
-	// Do the build into a temporary file.
-	file, err := g.build(ctx, ref.Path(), *platform, g.disableOptimizations)
+	// Do the build into a temporary directory.
+	dir, err := ioutil.TempDir(g.workDir, "ko")
 	if err != nil {
 		return nil, err
 	}
-	defer os.RemoveAll(filepath.Dir(file))
-
-	var layers []mutate.Addendum
-	// Create a layer from the kodata directory under this import path.
-	dataLayerBuf, err := g.tarKoData(ref)
+	defer func() { g.cleanup(dir, err) }()
+	phaseHooks(ctx, g.hooks, BuildPhase{ImportPath: ref.Path(), Platform: platformToString(*platform), Phase: PhaseCompile})
+	buildCtx, span := trace.Start(ctx, "go build")
+	span.SetAttribute("ko.importpath", ref.Path())
+	span.SetAttribute("ko.platform", platformToString(*platform))
+	config := g.goConfig(ref.Path())
+	if env := buildEnv(ctx); len(env) != 0 {
+		config.Env = append(append([]string{}, config.Env...), env...)
+	}
+	file, err := g.build(buildCtx, ref.Path(), dir, *platform, config, g.disableOptimizations)
+	span.End(err)
 	if err != nil {
 		return nil, err
 	}
-	dataLayerBytes := dataLayerBuf.Bytes()
-	dataLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
-		return ioutil.NopCloser(bytes.NewBuffer(dataLayerBytes)), nil
-	}, tarball.WithCompressedCaching)
+
+	phaseHooks(ctx, g.hooks, BuildPhase{ImportPath: ref.Path(), Platform: platformToString(*platform), Phase: PhaseLayers})
+	_, span = trace.Start(ctx, "build layers")
+	span.SetAttribute("ko.importpath", ref.Path())
+	defer func() { span.End(err) }()
+
+	var layers []mutate.Addendum
+	for _, lc := range g.configFor(ref.Path()).Layers {
+		add, err := g.extraLayer(lc)
+		if err != nil {
+			return nil, err
+		}
+		add.History.CreatedBy = "ko publish " + ref.String()
+		layers = append(layers, add)
+	}
+
+	useInit := g.initFor(ref.Path())
+	if useInit {
+		add, err := g.initLayer(ctx, ref, *platform)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, add)
+	}
+
+	// Create a layer from the kodata directory under this import path.
+	dataLayer, dataSize, err := stageLayer(g.workDir, g.compressionLevel, func(tw *tar.Writer) error {
+		return g.writeKoData(tw, ref)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -559,17 +912,13 @@ func (g *gobuild) buildOne(ctx context.Context, s string, base v1.Image, platfor
 		},
 	})
 
-	appPath := path.Join(appDir, appFilename(ref.Path()))
+	bc := g.configFor(ref.Path())
+	appPath := outputPath(ref.Path(), bc.Config)
 
 	// Construct a tarball with the binary and produce a layer.
-	binaryLayerBuf, err := tarBinary(appPath, file)
-	if err != nil {
-		return nil, err
-	}
-	binaryLayerBytes := binaryLayerBuf.Bytes()
-	binaryLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
-		return ioutil.NopCloser(bytes.NewBuffer(binaryLayerBytes)), nil
-	}, tarball.WithCompressedCaching, tarball.WithEstargzOptions(estargz.WithPrioritizedFiles([]string{
+	binaryLayer, binarySize, err := stageLayer(g.workDir, g.compressionLevel, func(tw *tar.Writer) error {
+		return writeBinary(tw, appPath, file)
+	}, tarball.WithEstargzOptions(estargz.WithPrioritizedFiles([]string{
 		// When using estargz, prioritize downloading the binary entrypoint.
 		appPath,
 	})))
@@ -599,21 +948,83 @@ func (g *gobuild) buildOne(ctx context.Context, s string, base v1.Image, platfor
 	}
 
 	cfg = cfg.DeepCopy()
-	cfg.Config.Entrypoint = []string{appPath}
-	updatePath(cfg)
+	if !bc.library() {
+		cfg.Config.Entrypoint = []string{appPath}
+		if useInit {
+			cfg.Config.Entrypoint = append(append([]string{initPath}, g.init.Args...), appPath)
+		}
+		updatePath(cfg)
+	}
+	// Otherwise, the image runs whatever loads the library (e.g. the
+	// nginx of the base image), so its entrypoint is left alone.
 	cfg.Config.Env = append(cfg.Config.Env, "KO_DATA_PATH="+kodataRoot)
 	cfg.Author = "github.com/google/ko"
+	if user := g.userFor(ref.Path()); user != "" {
+		cfg.Config.User = user
+	}
+	// The source of the image is ours, not the base's, but --image-label
+	// can still say otherwise.
+	source := g.sourceMetadata(ref.Path())
+	if labels := mergeLabels(BaseMetadataMerge, source, g.labels); len(labels) != 0 || g.baseMetadata != BaseMetadataMerge {
+		cfg.Config.Labels = mergeLabels(g.baseMetadata, cfg.Config.Labels, labels)
+	}
 
 	image, err := mutate.ConfigFile(withApp, cfg)
 	if err != nil {
 		return nil, err
 	}
+	if len(source) != 0 || g.baseMetadata == BaseMetadataDrop {
+		// The manifest is a copy of the base's, annotations and all.
+		m, err := image.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		if image, err = withImageAnnotations(image, mergeLabels(g.baseMetadata, m.Annotations, source)); err != nil {
+			return nil, err
+		}
+	}
+
+	if g.sizeReporter != nil || sizeHooks(g.hooks) {
+		report, err := g.sizeReport(ref, *platform, base, dataLayer, dataSize, binaryLayer, binarySize)
+		if err != nil {
+			return nil, err
+		}
+		if g.sizeAnalysis > 0 {
+			// The report is still useful without the analysis.
+			if report.Packages, report.Symbols, err = analyzeBinary(file, g.sizeAnalysis); err != nil {
+				log.Printf("Analyzing the size of %s: %v", ref.Path(), err)
+			}
+		}
+		if g.sizeReporter != nil {
+			g.sizeReporter(report)
+		}
+		sizeReportHooks(ctx, g.hooks, report)
+	}
 
 	empty := v1.Time{}
 	if g.creationTime != empty {
-		return mutate.CreatedAt(image, g.creationTime)
+		if image, err = mutate.CreatedAt(image, g.creationTime); err != nil {
+			return nil, err
+		}
+	}
+	baseDigest, err := base.Digest()
+	if err != nil {
+		return nil, err
+	}
+	added := make([]v1.Layer, 0, len(layers))
+	for _, add := range layers {
+		added = append(added, add.Layer)
 	}
-	return image, nil
+	return &builtImage{Image: image, base: baseDigest, added: added}, nil
+}
+
+// userFor returns the user that images of importpath run as, or "" to keep
+// the base image's.
+func (g *gobuild) userFor(importpath string) string {
+	if user := g.configFor(importpath).User; user != "" {
+		return user
+	}
+	return g.defaultUser
 }
 
 // Append appDir to the PATH environment variable, if it exists. Otherwise,
@@ -639,11 +1050,75 @@ func updatePath(cf *v1.ConfigFile) {
 
 // Build implements build.Interface
 func (g *gobuild) Build(ctx context.Context, s string) (Result, error) {
+	return withHooks(ctx, g.hooks, s, func() (Result, error) {
+		return g.buildResult(ctx, s)
+	})
+}
+
+func (g *gobuild) buildResult(ctx context.Context, s string) (Result, error) {
+	// Let getBase see any //ko:base directive of the import path.
+	directive, err := g.baseDirective(newRef(s))
+	if err != nil {
+		return nil, err
+	}
+	if directive != "" {
+		ctx = context.WithValue(ctx, baseDirectiveKey{}, directive)
+	}
+
+	// Check kodata before fetching the base, so that we fail fast.
+	if err := g.checkKoData(newRef(s)); err != nil {
+		return nil, err
+	}
+
+	if g.artifactFor(newRef(s).Path()) {
+		if platforms := g.explicitPlatforms(s); len(platforms) != 0 {
+			// There's no need for a base image (or a registry) to
+			// build binaries for platforms that are spelled out.
+			return g.buildArtifacts(ctx, s, platforms)
+		}
+	}
+
 	// Determine the appropriate base image for this import path.
-	base, err := g.getBase(ctx, s)
+	fetchCtx, span := trace.Start(ctx, "fetch base")
+	span.SetAttribute("ko.importpath", s)
+	base, err := g.getBase(fetchCtx, s)
+	span.End(err)
+	if err != nil {
+		return nil, &Error{Kind: ErrBaseImageUnavailable, Err: err}
+	}
+
+	if g.lookup == nil {
+		return g.buildBase(ctx, s, base)
+	}
+	ref := newRef(s)
+	inputs, err := g.inputsDigest(ctx, ref, base)
+	if err != nil {
+		log.Printf("Unable to compute the inputs of %s, so building it: %v", ref.Path(), err)
+		return g.buildBase(ctx, s, base)
+	}
+	if res, err := g.lookup(ctx, s, inputs); err != nil {
+		log.Printf("Unable to look up %s by its inputs, so building it: %v", ref.Path(), err)
+	} else if res != nil {
+		log.Printf("Skipping build of %s, whose inputs are unchanged", ref.Path())
+		return withInputs(res, inputs)
+	}
+	res, err := g.buildBase(ctx, s, base)
 	if err != nil {
 		return nil, err
 	}
+	return withInputs(res, inputs)
+}
+
+// buildBase builds s on base, which is an image or an index.
+func (g *gobuild) buildBase(ctx context.Context, s string, base Result) (Result, error) {
+	if g.artifactFor(newRef(s).Path()) {
+		// Binaries have no base; at most it decides the platforms.
+		platforms, err := g.basePlatforms(s, base)
+		if err != nil {
+			return nil, err
+		}
+		return g.buildArtifacts(ctx, s, platforms)
+	}
 
 	// Determine what kind of base we have and if we should publish an image or an index.
 	mt, err := base.MediaType()
@@ -676,15 +1151,21 @@ func (g *gobuild) buildAll(ctx context.Context, s string, base v1.ImageIndex) (v
 		return nil, err
 	}
 
+	matcher := g.platformMatcher
+	if pm := g.configFor(newRef(s).Path()).platformMatcher; pm != nil {
+		matcher = pm
+	}
+
 	// Build an image for each child from the base and append it to a new index to produce the result.
 	adds := []mutate.IndexAddendum{}
+	var added []v1.Layer
 	for _, desc := range im.Manifests {
 		// Nested index is pretty rare. We could support this in theory, but return an error for now.
 		if desc.MediaType != types.OCIManifestSchema1 && desc.MediaType != types.DockerManifestSchema2 {
 			return nil, fmt.Errorf("%q has unexpected mediaType %q in base for %q", desc.Digest, desc.MediaType, s)
 		}
 
-		if !g.platformMatcher.matches(desc.Platform) {
+		if !matcher.Matches(desc.Platform) {
 			continue
 		}
 
@@ -696,54 +1177,133 @@ func (g *gobuild) buildAll(ctx context.Context, s string, base v1.ImageIndex) (v
 		if err != nil {
 			return nil, err
 		}
+		added = append(added, AddedLayers(img)...)
+		annotations := desc.Annotations
+		if g.baseMetadata == BaseMetadataDrop {
+			annotations = nil
+		}
 		adds = append(adds, mutate.IndexAddendum{
 			Add: img,
 			Descriptor: v1.Descriptor{
 				URLs:        desc.URLs,
 				MediaType:   desc.MediaType,
-				Annotations: desc.Annotations,
+				Annotations: annotations,
 				Platform:    desc.Platform,
 			},
 		})
 	}
 
+	if len(adds) == 0 {
+		return nil, fmt.Errorf("base image for %s has no image for --platform=%s, only for %s", s, matcher.spec, indexPlatforms(im))
+	}
+	for _, p := range matcher.missing(im) {
+		log.Printf("Base image for %s has no image for %s, so %s won't have one either", s, path.Join(p.OS, p.Architecture, p.Variant), s)
+	}
+
 	baseType, err := base.MediaType()
 	if err != nil {
 		return nil, err
 	}
 
-	return mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), baseType), nil
+	baseDigest, err := base.Digest()
+	if err != nil {
+		return nil, err
+	}
+	idx := mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), baseType)
+	// Like images, indexes keep the annotations of their base unless
+	// they're dropped, and say where their source is.
+	if annotations := mergeLabels(g.baseMetadata, im.Annotations, g.sourceMetadata(newRef(s).Path())); len(annotations) != 0 {
+		if idx, err = withIndexAnnotations(idx, annotations); err != nil {
+			return nil, err
+		}
+	}
+	return &builtIndex{inner: idx, base: baseDigest, added: added}, nil
+}
+
+// sizeReport breaks down the size of an image built from base, dataLayer
+// and binaryLayer.
+func (g *gobuild) sizeReport(ref reference, platform v1.Platform, base v1.Image, dataLayer v1.Layer, dataSize int64, binaryLayer v1.Layer, binarySize int64) (SizeReport, error) {
+	report := SizeReport{
+		ImportPath:         ref.Path(),
+		Platform:           path.Join(platform.OS, platform.Architecture, platform.Variant),
+		KoDataUncompressed: dataSize,
+		BinaryUncompressed: binarySize,
+	}
+	m, err := base.Manifest()
+	if err != nil {
+		return report, err
+	}
+	for _, l := range m.Layers {
+		report.Base += l.Size
+	}
+	// These are staged compressed, so this doesn't compress the layers again.
+	if report.KoDataCompressed, err = dataLayer.Size(); err != nil {
+		return report, err
+	}
+	if report.BinaryCompressed, err = binaryLayer.Size(); err != nil {
+		return report, err
+	}
+	return report, nil
 }
 
-func parseSpec(spec string) (*platformMatcher, error) {
+// ParsePlatformSpec parses a --platform spec.
+func ParsePlatformSpec(spec string) (*PlatformMatcher, error) {
 	// Don't bother parsing "all".
 	// "" should never happen because we default to linux/amd64.
 	platforms := []v1.Platform{}
 	if spec == "all" || spec == "" {
-		return &platformMatcher{spec: spec}, nil
+		return &PlatformMatcher{spec: spec}, nil
 	}
 
 	for _, platform := range strings.Split(spec, ",") {
-		var p v1.Platform
-		parts := strings.Split(strings.TrimSpace(platform), "/")
-		if len(parts) > 0 {
-			p.OS = parts[0]
+		p, err := ParsePlatform(platform)
+		if err != nil {
+			return nil, err
 		}
-		if len(parts) > 1 {
-			p.Architecture = parts[1]
+		platforms = append(platforms, p)
+	}
+	return &PlatformMatcher{spec: spec, platforms: platforms}, nil
+}
+
+// missing returns the platforms that pm names but that none of the children
+// of im match.
+func (pm *PlatformMatcher) missing(im *v1.IndexManifest) []v1.Platform {
+	var missing []v1.Platform
+	for _, p := range pm.platforms {
+		only := &PlatformMatcher{spec: path.Join(p.OS, p.Architecture, p.Variant), platforms: []v1.Platform{p}}
+		found := false
+		for _, desc := range im.Manifests {
+			if only.Matches(desc.Platform) {
+				found = true
+				break
+			}
 		}
-		if len(parts) > 2 {
-			p.Variant = parts[2]
+		if !found {
+			missing = append(missing, p)
 		}
-		if len(parts) > 3 {
-			return nil, fmt.Errorf("too many slashes in platform spec: %s", platform)
+	}
+	return missing
+}
+
+// indexPlatforms lists the platforms of the children of im, for errors.
+func indexPlatforms(im *v1.IndexManifest) string {
+	var platforms []string
+	for _, desc := range im.Manifests {
+		if desc.Platform == nil {
+			platforms = append(platforms, "unknown")
+			continue
 		}
-		platforms = append(platforms, p)
+		platforms = append(platforms, platformToString(*desc.Platform))
+	}
+	if len(platforms) == 0 {
+		return "nothing"
 	}
-	return &platformMatcher{spec: spec, platforms: platforms}, nil
+	return strings.Join(platforms, ", ")
 }
 
-func (pm *platformMatcher) matches(base *v1.Platform) bool {
+// Matches reports whether base is one of the platforms of pm. Nothing but
+// "all" matches a nil platform.
+func (pm *PlatformMatcher) Matches(base *v1.Platform) bool {
 	if pm.spec == "all" {
 		return true
 	}
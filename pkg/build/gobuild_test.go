@@ -16,17 +16,22 @@ package build
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	gb "go/build"
 	"io"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
@@ -56,18 +61,40 @@ func TestGoBuildIsSupportedRef(t *testing.T) {
 	}
 
 	// Unsupported import paths.
-	for _, importpath := range []string{
-		"ko://github.com/google/ko/pkg/build",       // not a command.
-		"ko://github.com/google/ko/pkg/nonexistent", // does not exist.
+	for importpath, want := range map[string]error{
+		"ko://github.com/google/ko/pkg/build":       ErrNotMainPackage,       // not a command.
+		"ko://github.com/google/ko/pkg/nonexistent": ErrUnsupportedReference, // does not exist.
+		"github.com/google/ko":                      ErrUnsupportedReference, // not strict.
 	} {
+		importpath, want := importpath, want
 		t.Run(importpath, func(t *testing.T) {
-			if err := ng.IsSupportedReference(importpath); err == nil {
-				t.Errorf("IsSupportedReference(%v) = nil, want error", importpath)
+			if err := ng.IsSupportedReference(importpath); !errors.Is(err, want) {
+				t.Errorf("IsSupportedReference(%v) = %v, want %v", importpath, err, want)
 			}
 		})
 	}
 }
 
+func TestGoBuildBaseImageUnavailable(t *testing.T) {
+	denied := errors.New("denied")
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return nil, denied }),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	_, err = ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+	if !errors.Is(err, ErrBaseImageUnavailable) {
+		t.Errorf("Build() = %v, wanted %v", err, ErrBaseImageUnavailable)
+	}
+	if !errors.Is(err, denied) {
+		t.Errorf("Build() = %v, wanted it to wrap %v", err, denied)
+	}
+}
+
 func TestGoBuildIsSupportedRefWithModules(t *testing.T) {
 	base, err := random.Image(1024, 3)
 	if err != nil {
@@ -131,13 +158,8 @@ func TestGoBuildIsSupportedRefWithModules(t *testing.T) {
 }
 
 // A helper method we use to substitute for the default "build" method.
-func writeTempFile(_ context.Context, s string, _ v1.Platform, _ bool) (string, error) {
-	tmpDir, err := ioutil.TempDir("", "ko")
-	if err != nil {
-		return "", err
-	}
-
-	file, err := ioutil.TempFile(tmpDir, "out")
+func writeTempFile(_ context.Context, s, dir string, _ v1.Platform, _ Config, _ bool) (string, error) {
+	file, err := ioutil.TempFile(dir, "out")
 	if err != nil {
 		return "", err
 	}
@@ -390,6 +412,123 @@ func (s stubBuildContext) Import(path string, srcDir string, mode gb.ImportMode)
 	return nil, fmt.Errorf("not found: %s", path)
 }
 
+func TestGoBuildSizeReport(t *testing.T) {
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	var reports, events []SizeReport
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithSizeReporter(func(r SizeReport) { reports = append(reports, r) }),
+		WithHooks(Hooks{OnSizeReport: func(_ context.Context, r SizeReport) { events = append(events, r) }}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+importpath)
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	r := reports[0]
+	if r.ImportPath != importpath {
+		t.Errorf("ImportPath = %q, want %q", r.ImportPath, importpath)
+	}
+	if r.BinaryUncompressed == 0 || r.BinaryCompressed == 0 {
+		t.Errorf("binary sizes = %d, %d, want non-zero", r.BinaryCompressed, r.BinaryUncompressed)
+	}
+	// Hooks get the same reports.
+	if diff := cmp.Diff(reports, events); diff != "" {
+		t.Errorf("OnSizeReport (-want +got) = %s", diff)
+	}
+
+	// The layers add up to the total.
+	m, err := result.(v1.Image).Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() = %v", err)
+	}
+	var total int64
+	for _, l := range m.Layers {
+		total += l.Size
+	}
+	if got := r.Total(); got != total {
+		t.Errorf("Total() = %d, want %d", got, total)
+	}
+}
+
+func TestGoBuildLabels(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithLabels(map[string]string{"build-url": "https://ci.example.com/123"}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	cfg, err := result.(v1.Image).ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	if got, want := cfg.Config.Labels["build-url"], "https://ci.example.com/123"; got != want {
+		t.Errorf("Labels[build-url] = %q, want %q", got, want)
+	}
+}
+
+func TestGoBuildDefaultUser(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithDefaultUser("65532"),
+		WithConfigs([]Config{{ID: "github.com/google/ko/cmd/*", User: "root"}}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	for _, c := range []struct {
+		importpath string
+		want       string
+	}{
+		{"github.com/google/ko", "65532"},
+		{"github.com/google/ko/cmd/ko", "root"},
+	} {
+		result, err := ng.Build(context.Background(), StrictScheme+c.importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		cfg, err := result.(v1.Image).ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		if got := cfg.Config.User; got != c.want {
+			t.Errorf("User of %s = %q, want %q", c.importpath, got, c.want)
+		}
+	}
+}
+
 func TestGoBuild(t *testing.T) {
 	baseLayers := int64(3)
 	base, err := random.Image(1024, baseLayers)
@@ -673,19 +812,301 @@ func TestMatchesPlatformSpec(t *testing.T) {
 		},
 		result: false,
 	}} {
-		pm, err := parseSpec(tc.spec)
+		pm, err := ParsePlatformSpec(tc.spec)
 		if tc.err {
 			if err == nil {
-				t.Errorf("parseSpec(%v, %q) expected err", tc.platform, tc.spec)
+				t.Errorf("ParsePlatformSpec(%v, %q) expected err", tc.platform, tc.spec)
 			}
 			continue
 		}
 		if err != nil {
 			t.Fatalf("parseSpec failed for %v %q: %v", tc.platform, tc.spec, err)
 		}
-		matches := pm.matches(tc.platform)
+		matches := pm.Matches(tc.platform)
 		if got, want := matches, tc.result; got != want {
 			t.Errorf("wrong result for %v %q: want %t got %t", tc.platform, tc.spec, want, got)
 		}
 	}
 }
+
+func TestWalkRecursiveIgnore(t *testing.T) {
+	root, err := ioutil.TempDir("", "ko-kodata")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(root)
+	for _, f := range []string{"index.html", ".index.html.swp", "fixtures/huge.bin", "static/app.js"} {
+		p := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			t.Fatalf("MkdirAll() = %v", err)
+		}
+		if err := ioutil.WriteFile(p, []byte(f), 0644); err != nil {
+			t.Fatalf("WriteFile() = %v", err)
+		}
+	}
+	// Symlinked directories are followed.
+	if err := os.Symlink(filepath.Join(root, "static"), filepath.Join(root, "assets")); err != nil {
+		t.Fatalf("Symlink() = %v", err)
+	}
+	ignored := func(p string, isDir bool) bool {
+		return strings.HasSuffix(p, ".swp") || (isDir && filepath.Base(p) == "fixtures")
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := walkRecursive(tw, root, kodataRoot, ignored); err != nil {
+		t.Fatalf("walkRecursive() = %v", err)
+	}
+	tw.Close()
+
+	var got []string
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		got = append(got, header.Name)
+	}
+	want := []string{
+		kodataRoot,
+		kodataRoot + "/assets",
+		kodataRoot + "/assets/app.js",
+		kodataRoot + "/index.html",
+		kodataRoot + "/static/app.js",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("walkRecursive() (-want +got) = %s", diff)
+	}
+}
+
+func TestDecodeModules(t *testing.T) {
+	// go list -e -json -m all writes a stream of JSON objects, including
+	// modules that couldn't be loaded.
+	stream := `{
+	"Path": "github.com/google/ko",
+	"Main": true,
+	"Dir": "/src/ko"
+}
+{
+	"Path": "github.com/google/go-containerregistry",
+	"Dir": "/go/pkg/mod/github.com/google/go-containerregistry@v0.4.0"
+}
+{
+	"Path": "example.com/offline",
+	"Error": {
+		"Err": "module lookup disabled by GOPROXY=off"
+	}
+}
+`
+	got, err := decodeModules(strings.NewReader(stream))
+	if err != nil {
+		t.Fatalf("decodeModules() = %v", err)
+	}
+	if got.main == nil || got.main.Path != "github.com/google/ko" || got.main.Dir != "/src/ko" {
+		t.Errorf("main = %+v, wanted github.com/google/ko in /src/ko", got.main)
+	}
+	for _, dep := range []string{"github.com/google/go-containerregistry", "example.com/offline"} {
+		if _, ok := got.deps[dep]; !ok {
+			t.Errorf("deps is missing %s", dep)
+		}
+	}
+
+	if _, err := decodeModules(strings.NewReader(stream + "{")); err == nil {
+		t.Error("decodeModules() = nil, wanted an error for truncated output")
+	}
+}
+
+func TestFindModules(t *testing.T) {
+	root, err := ioutil.TempDir("", "ko-modules")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(root)
+	for dir, gomod := range map[string]string{
+		".":                    "module example.com/repo\n",
+		"tools":                "// Tools.\nmodule \"example.com/repo/tools\"\n\ngo 1.14\n",
+		"services/api":         "module example.com/api // not under the repo\n",
+		"vendor/example.com/x": "module example.com/x\n",
+		".github/actions":      "module example.com/actions\n",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, dir, "go.mod"), []byte(gomod), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mods := &modules{main: &modInfo{Path: "example.com/repo", Dir: root}}
+	mods.local = findModules(root)
+	if len(mods.local) != 2 {
+		t.Fatalf("findModules() = %d modules, want 2", len(mods.local))
+	}
+
+	for importpath, want := range map[string]string{
+		"example.com/repo/cmd/app":    root,
+		"example.com/repo/tools/cmd":  filepath.Join(root, "tools"),
+		"example.com/repo/toolsmith":  root,
+		"example.com/api/cmd/server":  filepath.Join(root, "services", "api"),
+		"github.com/google/ko/cmd/ko": root,
+	} {
+		if got := mods.owner(importpath).Dir; got != want {
+			t.Errorf("owner(%s) = %s, want %s", importpath, got, want)
+		}
+	}
+}
+
+func TestGoBuildNestedModules(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	var mu sync.Mutex
+	dirs := map[string]string{}
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		withModuleInfo(&modules{
+			main:  &modInfo{Path: "example.com/repo", Dir: "/src/repo"},
+			local: []*modInfo{{Path: "example.com/repo/tools", Dir: "/src/repo/tools"}},
+		}),
+		withBuildContext(stubBuildContext{
+			"example.com/repo/cmd/app":          &gb.Package{Name: "main"},
+			"example.com/repo/tools/cmd/gen":    &gb.Package{Name: "main"},
+			"example.com/repo/tools/cmd/pinned": &gb.Package{Name: "main"},
+		}),
+		WithConfigs([]Config{{ID: "example.com/repo/tools/cmd/pinned", Dir: "/elsewhere"}}),
+		withBuilder(func(ctx context.Context, ip, dir string, platform v1.Platform, config Config, disableOptimizations bool) (string, error) {
+			mu.Lock()
+			dirs[ip] = config.Dir
+			mu.Unlock()
+			return writeTempFile(ctx, ip, dir, platform, config, disableOptimizations)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	want := map[string]string{
+		"example.com/repo/cmd/app":          "",
+		"example.com/repo/tools/cmd/gen":    "/src/repo/tools",
+		"example.com/repo/tools/cmd/pinned": "/elsewhere",
+	}
+	for importpath := range want {
+		if _, err := ng.Build(context.Background(), StrictScheme+importpath); err != nil {
+			t.Fatalf("Build(%s) = %v", importpath, err)
+		}
+	}
+	if diff := cmp.Diff(want, dirs); diff != "" {
+		t.Errorf("go build directories (-want +got) = %s", diff)
+	}
+}
+
+func TestNotUsingModules(t *testing.T) {
+	for stderr, want := range map[string]bool{
+		`go: cannot match "all": go.mod file not found in current directory or any parent directory; see 'go help modules'`: true,
+		"go: cannot find main module; see 'go help modules'":                                                                true,
+		"go list -m: not using modules":                           true,
+		"go: list -m cannot be used with GO111MODULE=off":         true,
+		"go: updates to go.sum needed, disabled by -mod=readonly": false,
+	} {
+		if got := notUsingModules(stderr); got != want {
+			t.Errorf("notUsingModules(%q) = %v, wanted %v", stderr, got, want)
+		}
+	}
+}
+
+func TestGoBuildWithBuildEnv(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	var got []string
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithConfigs([]Config{{ID: "github.com/google/ko", Env: []string{"GOCACHE=/from/config", "CGO_ENABLED=1"}}}),
+		withBuilder(func(ctx context.Context, s, dir string, p v1.Platform, c Config, opt bool) (string, error) {
+			got = c.Env
+			return writeTempFile(ctx, s, dir, p, c, opt)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+	ctx := WithBuildEnv(context.Background(), "GOCACHE=/fresh")
+	if _, err := ng.Build(ctx, StrictScheme+"github.com/google/ko"); err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	if diff := cmp.Diff([]string{"GOCACHE=/from/config", "CGO_ENABLED=1", "GOCACHE=/fresh"}, got); diff != "" {
+		t.Errorf("Env (-want +got) = %s", diff)
+	}
+}
+
+func TestGoBuildIndexPlatforms(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, p := range []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		p := p
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &p}})
+	}
+	base := mutate.AppendManifests(empty.Index, adds...)
+
+	for _, tc := range []struct {
+		platforms string
+		want      []string
+		wantErr   bool
+	}{{
+		platforms: "linux/arm64,linux/s390x",
+		want:      []string{"linux/arm64"},
+	}, {
+		platforms: "linux",
+		want:      []string{"linux/amd64", "linux/arm64"},
+	}, {
+		platforms: "linux/s390x,windows/amd64",
+		wantErr:   true,
+	}} {
+		t.Run(tc.platforms, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+				WithPlatforms(tc.platforms),
+				withBuilder(writeTempFile),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "only for linux/amd64, linux/arm64") {
+					t.Fatalf("Build() = %v, want an error listing the base's platforms", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			idx, ok := result.(v1.ImageIndex)
+			if !ok {
+				t.Fatalf("Build() = %T, want an index", result)
+			}
+			im, err := idx.IndexManifest()
+			if err != nil {
+				t.Fatalf("IndexManifest() = %v", err)
+			}
+			var got []string
+			for _, desc := range im.Manifests {
+				got = append(got, platformToString(*desc.Platform))
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("platforms (-want +got) = %s", diff)
+			}
+		})
+	}
+}
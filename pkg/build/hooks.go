@@ -0,0 +1,119 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"time"
+)
+
+// Hooks are called as builds start and end, so that tools embedding ko can
+// report progress or collect metrics. Any hook may be nil. Builds run
+// concurrently, so hooks must be safe to call concurrently.
+type Hooks struct {
+	OnBuildStart func(context.Context, BuildStart)
+	OnBuildEnd   func(context.Context, BuildEnd)
+	// OnBuildPhase is called as the image for each platform moves from
+	// compiling to building its layers.
+	OnBuildPhase func(context.Context, BuildPhase)
+	// OnSizeReport is called with the breakdown of the size of the image
+	// for each platform, once it is built.
+	OnSizeReport func(context.Context, SizeReport)
+}
+
+// The phases of building the image for a platform.
+const (
+	// PhaseCompile is when go build runs.
+	PhaseCompile = "compile"
+	// PhaseLayers is when the layers are written and compressed.
+	PhaseLayers = "layers"
+)
+
+// BuildPhase describes a phase of a build that is starting.
+type BuildPhase struct {
+	ImportPath string
+	Platform   string
+	Phase      string
+}
+
+// BuildStart describes a build that is starting.
+type BuildStart struct {
+	ImportPath string
+	Started    time.Time
+}
+
+// BuildEnd describes a build that has finished. If Err is nil, the
+// BuildRecord describes what was built, otherwise only its ImportPath,
+// Started and Seconds are set.
+type BuildEnd struct {
+	BuildRecord
+	Err error
+}
+
+// withHooks calls the start hooks, runs build, and then calls the end hooks
+// with its outcome.
+func withHooks(ctx context.Context, hooks []Hooks, ip string, build func() (Result, error)) (Result, error) {
+	if len(hooks) == 0 {
+		return build()
+	}
+	start := time.Now()
+	for _, h := range hooks {
+		if h.OnBuildStart != nil {
+			h.OnBuildStart(ctx, BuildStart{ImportPath: ip, Started: start})
+		}
+	}
+	res, err := build()
+	end := BuildEnd{Err: err}
+	if err == nil {
+		end.BuildRecord, end.Err = describe(ip, res)
+	}
+	end.ImportPath = ip
+	end.Started = start
+	end.Seconds = time.Since(start).Seconds()
+	for _, h := range hooks {
+		if h.OnBuildEnd != nil {
+			h.OnBuildEnd(ctx, end)
+		}
+	}
+	return res, err
+}
+
+// sizeHooks reports whether any of hooks wants size reports.
+func sizeHooks(hooks []Hooks) bool {
+	for _, h := range hooks {
+		if h.OnSizeReport != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeReportHooks calls the size report hooks.
+func sizeReportHooks(ctx context.Context, hooks []Hooks, report SizeReport) {
+	for _, h := range hooks {
+		if h.OnSizeReport != nil {
+			h.OnSizeReport(ctx, report)
+		}
+	}
+}
+
+// phaseHooks calls the phase hooks.
+func phaseHooks(ctx context.Context, hooks []Hooks, phase BuildPhase) {
+	for _, h := range hooks {
+		if h.OnBuildPhase != nil {
+			h.OnBuildPhase(ctx, phase)
+		}
+	}
+}
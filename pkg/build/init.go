@@ -0,0 +1,80 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// initPath is where the init binary goes in images.
+const initPath = "/ko-init/init"
+
+// Init is an init process (e.g. a static tini) that the entrypoint of images
+// is wrapped in, to forward signals and reap zombies.
+type Init struct {
+	// Binary returns the path of the init binary for platform.
+	Binary func(ctx context.Context, platform v1.Platform) (string, error)
+
+	// Args go between the init binary and the app in the entrypoint, e.g.
+	// -- for tini.
+	Args []string
+
+	// All wraps the entrypoint of every import path whose Config doesn't
+	// opt out. Otherwise, only those whose Config opts in are wrapped.
+	All bool
+
+	// ID identifies the binaries, e.g. by their checksums, so that changing
+	// them changes the inputs digest (see WithLookup).
+	ID string
+}
+
+// initFor returns whether importpath is wrapped in the init. Shared
+// libraries aren't entrypoints, so they never are.
+func (g *gobuild) initFor(importpath string) bool {
+	if g.init == nil || g.configFor(importpath).library() {
+		return false
+	}
+	if use := g.configFor(importpath).Init; use != nil {
+		return *use
+	}
+	return g.init.All
+}
+
+// initLayer returns the layer with the init binary for platform.
+func (g *gobuild) initLayer(ctx context.Context, ref reference, platform v1.Platform) (mutate.Addendum, error) {
+	binary, err := g.init.Binary(ctx, platform)
+	if err != nil {
+		return mutate.Addendum{}, fmt.Errorf("init for %s: %v", platformToString(platform), err)
+	}
+	layer, _, err := stageLayer(g.workDir, g.compressionLevel, func(tw *tar.Writer) error {
+		return writeBinary(tw, initPath, binary)
+	})
+	if err != nil {
+		return mutate.Addendum{}, err
+	}
+	return mutate.Addendum{
+		Layer: layer,
+		History: v1.History{
+			Author:    "ko",
+			CreatedBy: "ko publish " + ref.String(),
+			Comment:   "init, at " + initPath,
+		},
+	}, nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestGoBuildInit(t *testing.T) {
+	f, err := ioutil.TempFile("", "tini")
+	if err != nil {
+		t.Fatalf("TempFile() = %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	var platforms []v1.Platform
+	no := false
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithInit(Init{
+			Binary: func(_ context.Context, p v1.Platform) (string, error) {
+				platforms = append(platforms, p)
+				return f.Name(), nil
+			},
+			Args: []string{"--"},
+			All:  true,
+		}),
+		WithConfigs([]Config{{ID: "github.com/google/ko/cmd/*", Init: &no}}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	for _, c := range []struct {
+		importpath string
+		want       []string
+		layers     int
+	}{
+		{"github.com/google/ko", []string{initPath, "--", "/ko-app/ko"}, 4},
+		{"github.com/google/ko/cmd/ko", []string{"/ko-app/ko"}, 3},
+	} {
+		result, err := ng.Build(context.Background(), StrictScheme+c.importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		img := result.(v1.Image)
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		if diff := cmp.Diff(c.want, cfg.Config.Entrypoint); diff != "" {
+			t.Errorf("Entrypoint of %s (-want +got) = %v", c.importpath, diff)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		if len(layers) != c.layers {
+			t.Errorf("len(Layers()) of %s = %d, want %d", c.importpath, len(layers), c.layers)
+		}
+	}
+	if len(platforms) != 1 {
+		t.Errorf("init binary fetched for %v, want once", platforms)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Lookup finds a result that was already built from the inputs with the
+// given digest (as computed by WithLookup), e.g. an image in the target
+// repository tagged with it. It returns nil if there is none.
+type Lookup func(ctx context.Context, importpath, inputs string) (Result, error)
+
+// inputsVersion changes whenever what goes into the digest of inputs does,
+// so that old digests don't match.
+const inputsVersion = "ko-inputs/v2"
+
+// InputsDigest returns the digest of the inputs r was built from, if r was
+// built by NewGo with WithLookup.
+func InputsDigest(r Result) (string, bool) {
+	if i, ok := r.(interface{ InputsDigest() string }); ok && i.InputsDigest() != "" {
+		return i.InputsDigest(), true
+	}
+	return "", false
+}
+
+// withInputs records the digest of the inputs res was built from.
+func withInputs(res Result, inputs string) (Result, error) {
+	switch r := res.(type) {
+	case *builtImage:
+		r.inputs = inputs
+		return r, nil
+	case *builtIndex:
+		r.inputs = inputs
+		return r, nil
+	case v1.ImageIndex:
+		return &builtIndex{inner: r, inputs: inputs}, nil
+	case v1.Image:
+		return &builtImage{Image: r, inputs: inputs}, nil
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", res)
+	}
+}
+
+// inputsDigest returns a digest of everything that goes into building ref on
+// base: the configuration of the build, the module graph, the files of the
+// packages it depends on outside of the module cache, and its kodata.
+func (g *gobuild) inputsDigest(ctx context.Context, ref reference, base Result) (string, error) {
+	h := sha256.New()
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(h, format+"\n", args...)
+	}
+	line(inputsVersion)
+	line("importpath %s", ref.Path())
+
+	baseDigest, err := base.Digest()
+	if err != nil {
+		return "", err
+	}
+	line("base %s", baseDigest)
+
+	bc := g.configFor(ref.Path())
+	config, err := json.Marshal(bc.Config)
+	if err != nil {
+		return "", err
+	}
+	line("config %s", config)
+	matcher := g.platformMatcher
+	if bc.platformMatcher != nil {
+		matcher = bc.platformMatcher
+	}
+	line("platforms %s", matcher.spec)
+	line("disable-optimizations %t", g.disableOptimizations)
+	line("creation-time %d", g.creationTime.Unix())
+	line("compression-level %d", g.compressionLevel)
+	line("default-user %s", g.defaultUser)
+	line("base-metadata %s", g.baseMetadata)
+	line("artifact %t", g.artifactFor(ref.Path()))
+	if g.initFor(ref.Path()) {
+		line("init %s %q", g.init.ID, g.init.Args)
+	}
+	var labels []string
+	for k, v := range g.labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	line("labels %q", labels)
+	var source []string
+	for k, v := range g.sourceMetadata(ref.Path()) {
+		source = append(source, k+"="+v)
+	}
+	sort.Strings(source)
+	line("source %q", source)
+	for _, env := range []string{"GOFLAGS", "CGO_ENABLED", "GOEXPERIMENT"} {
+		line("env %s=%s", env, os.Getenv(env))
+	}
+
+	version, err := exec.CommandContext(ctx, "go", "version").Output()
+	if err != nil {
+		return "", fmt.Errorf("go version: %w", err)
+	}
+	line("go %s", strings.TrimSpace(string(version)))
+
+	if g.mod != nil {
+		mod := g.mod.owner(ref.Path())
+		for _, f := range []string{"go.mod", "go.sum"} {
+			if err := hashFile(h, "module "+f, filepath.Join(mod.Dir, f)); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	pkg := ref.Path()
+	if bc.Main != "" {
+		pkg = bc.Main
+	}
+	platforms, err := g.basePlatforms(ref.String(), base)
+	if err != nil {
+		return "", err
+	}
+	goConfig := g.goConfig(ref.Path())
+	if env := buildEnv(ctx); len(env) != 0 {
+		goConfig.Env = append(append([]string{}, goConfig.Env...), env...)
+	}
+	if err := hashPackages(ctx, h, goConfig, platforms, pkg); err != nil {
+		return "", err
+	}
+
+	if err := hashLayers(h, bc.Layers); err != nil {
+		return "", err
+	}
+
+	root, err := g.kodataPath(ref)
+	if err != nil {
+		return "", err
+	}
+	if err := hashTree(h, root, "", g.ignored); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listedPackage is the subset of the output of go list -json that
+// hashPackages needs.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *struct {
+		Path    string
+		Version string
+		Replace *struct {
+			Path    string
+			Version string
+		}
+	}
+}
+
+// hashPackages hashes the packages that pkg depends on when it is built
+// with config for any of platforms. Packages from the module cache are
+// identified by their module's version; the rest are hashed by the contents
+// of every file in their directory, so that files for other platforms count
+// too.
+func hashPackages(ctx context.Context, h hash.Hash, config Config, platforms []v1.Platform, pkg string) error {
+	deps := map[string]listedPackage{}
+	for _, platform := range platforms {
+		if err := listDeps(ctx, config, platform, pkg, deps); err != nil {
+			return err
+		}
+	}
+	paths := make([]string, 0, len(deps))
+	for path := range deps {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		p := deps[path]
+		if m := p.Module; m != nil {
+			if m.Replace != nil {
+				if m.Replace.Version != "" {
+					fmt.Fprintf(h, "package %s from %s@%s\n", p.ImportPath, m.Replace.Path, m.Replace.Version)
+					continue
+				}
+			} else if m.Version != "" {
+				fmt.Fprintf(h, "package %s from %s@%s\n", p.ImportPath, m.Path, m.Version)
+				continue
+			}
+		}
+		if err := hashDir(h, "package "+p.ImportPath, p.Dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listDeps adds the packages outside of the standard library that pkg
+// depends on when it is built with config for platform to deps, listing them
+// with the same environment, flags and tags as go build.
+func listDeps(ctx context.Context, config Config, platform v1.Platform, pkg string, deps map[string]listedPackage) error {
+	env, err := goEnv(platform, config)
+	if err != nil {
+		return err
+	}
+	args := []string{"list", "-deps", "-json"}
+	if config.BuildMode != "" {
+		args = append(args, "-buildmode="+config.BuildMode)
+	}
+	args = append(args, config.Flags...)
+	if len(config.Tags) != 0 {
+		args = append(args, "-tags", strings.Join(config.Tags, ","))
+	}
+	cmd := exec.CommandContext(ctx, "go", append(args, pkg)...)
+	cmd.Dir = config.Dir
+	cmd.Env = env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("go list -deps: %w", err)
+	}
+	dec := json.NewDecoder(stdout)
+	var decodeErr error
+	for {
+		var p listedPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			decodeErr = fmt.Errorf("reading go list -deps output: %w", err)
+			break
+		}
+		if !p.Standard {
+			deps[p.ImportPath] = p
+		}
+	}
+	io.Copy(ioutil.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("go list -deps %s for %s: %v: %s", pkg, platformToString(platform), err, strings.TrimSpace(stderr.String()))
+	}
+	return decodeErr
+}
+
+// hashDir hashes the regular files directly in dir.
+func hashDir(h hash.Hash, label, dir string) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if err := hashFile(h, label+" "+fi.Name(), filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashTree hashes the files under root (following symlinks, like kodata),
+// as if they were under prefix, except for those that are ignored. A missing
+// root hashes as nothing.
+func hashTree(h hash.Hash, root, prefix string, ignored func(string, bool) bool) error {
+	return walkTree(root, prefix, ignored, func(rel, hostPath string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		return hashFile(h, "kodata "+rel, hostPath)
+	})
+}
+
+// hashFile hashes the label and the digest of the contents of the file.
+func hashFile(h hash.Hash, label, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fh := sha256.New()
+	if _, err := io.Copy(fh, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%s %x\n", label, fh.Sum(nil))
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestLookup(t *testing.T) {
+	base, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	previous, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	importpath := "github.com/google/ko"
+
+	// published maps the digests of inputs to what was built from them.
+	published := map[string]Result{}
+	lookup := func(_ context.Context, _, inputs string) (Result, error) {
+		return published[inputs], nil
+	}
+	build := func(labels map[string]string) Result {
+		t.Helper()
+		ng, err := NewGo(
+			context.Background(),
+			WithCreationTime(v1.Time{Time: time.Unix(5000, 0)}),
+			WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+			WithLabels(labels),
+			WithLookup(lookup),
+			withBuilder(writeTempFile),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		result, err := ng.Build(context.Background(), StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		return result
+	}
+
+	first := build(map[string]string{"foo": "bar"})
+	inputs, ok := InputsDigest(first)
+	if !ok {
+		t.Fatal("InputsDigest() = false, want the digest of the inputs")
+	}
+	if _, ok := BaseDigest(first); !ok {
+		t.Error("BaseDigest() = false for a result that was built")
+	}
+
+	// Pretend that something else was published from the same inputs.
+	published[inputs] = previous
+	second := build(map[string]string{"foo": "bar"})
+	if got, ok := InputsDigest(second); !ok || got != inputs {
+		t.Errorf("InputsDigest() = %q, %t, want %q", got, ok, inputs)
+	}
+	got, err := second.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	want, err := previous.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got != want {
+		t.Errorf("Build() = %v, want the published image %v", got, want)
+	}
+
+	// Changing the configuration of the build changes its inputs.
+	third := build(map[string]string{"foo": "baz"})
+	if got, ok := InputsDigest(third); !ok || got == inputs {
+		t.Errorf("InputsDigest() = %q, %t, want a digest other than %q", got, ok, inputs)
+	}
+	if got, err := third.Digest(); err != nil {
+		t.Fatalf("Digest() = %v", err)
+	} else if got == want {
+		t.Error("Build() returned the published image, even though the inputs changed")
+	}
+}
+
+func TestHashPackagesPlatforms(t *testing.T) {
+	root, err := ioutil.TempDir("", "ko-inputs")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(root)
+	write := func(name, contents string) {
+		t.Helper()
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/app\n")
+	write("main.go", "package main\n\nimport _ \"example.com/app/dep\"\n\nfunc main() {}\n")
+	write("dep/dep.go", "package dep\n")
+	write("dep/dep_linux.go", "package dep\n\nimport _ \"example.com/app/linuxonly\"\n")
+	write("dep/dep_tagged.go", "// +build tagged\n\npackage dep\n\nimport _ \"example.com/app/taggedonly\"\n")
+	write("linuxonly/linuxonly.go", "package linuxonly\n")
+	write("taggedonly/taggedonly.go", "package taggedonly\n")
+
+	linux := v1.Platform{OS: "linux", Architecture: "amd64"}
+	windows := v1.Platform{OS: "windows", Architecture: "amd64"}
+	config := Config{Dir: root, Env: []string{"GOFLAGS=-mod=mod"}}
+	tagged := config
+	tagged.Tags = []string{"tagged"}
+
+	hashes := func(changed string) map[string]string {
+		t.Helper()
+		if changed != "" {
+			write(changed, "package "+filepath.Base(filepath.Dir(changed))+"\n\n// Changed.\n")
+		}
+		got := map[string]string{}
+		for name, tc := range map[string]struct {
+			config    Config
+			platforms []v1.Platform
+		}{
+			"linux":   {config, []v1.Platform{linux}},
+			"windows": {config, []v1.Platform{windows}},
+			"tagged":  {tagged, []v1.Platform{windows}},
+		} {
+			h := sha256.New()
+			if err := hashPackages(context.Background(), h, tc.config, tc.platforms, "."); err != nil {
+				t.Fatalf("hashPackages(%s) = %v", name, err)
+			}
+			got[name] = fmt.Sprintf("%x", h.Sum(nil))
+		}
+		return got
+	}
+
+	before := hashes("")
+	for changed, want := range map[string]map[string]bool{
+		"linuxonly/linuxonly.go":   {"linux": true},
+		"taggedonly/taggedonly.go": {"tagged": true},
+	} {
+		after := hashes(changed)
+		for name := range before {
+			if got := after[name] != before[name]; got != want[name] {
+				t.Errorf("changing %s changed the %s hash: %t, want %t", changed, name, got, want[name])
+			}
+		}
+		before = after
+	}
+}
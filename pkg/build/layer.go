@@ -0,0 +1,111 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// stageLayer writes the tarball that write produces, gzipped at the given
+// level, to a file in dir (or the default directory for temporary files),
+// and returns a layer
+// that reads it from there along with the uncompressed size of the tarball.
+// Staging layers on disk keeps memory flat however big binaries and kodata
+// are, and compressing them once up front saves gzipping them again to
+// compute digests and to push them.
+//
+// The file is removed once the layer is garbage collected.
+func stageLayer(dir string, level int, write func(*tar.Writer) error, opts ...tarball.LayerOption) (v1.Layer, int64, error) {
+	f, err := ioutil.TempFile(dir, "ko-layer-*.tar.gz")
+	if err != nil {
+		return nil, 0, err
+	}
+	staged := &stagedFile{path: f.Name()}
+	runtime.SetFinalizer(staged, (*stagedFile).remove)
+
+	size, err := writeLayer(f, level, write)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		staged.remove()
+		return nil, 0, err
+	}
+
+	if os.Getenv("GGCR_EXPERIMENT_ESTARGZ") == "1" {
+		// The layer is recompressed as estargz, so cache that instead.
+		opts = append(opts, tarball.WithCompressionLevel(level), tarball.WithCompressedCaching)
+	}
+	layer, err := tarball.LayerFromOpener(staged.open, opts...)
+	if err != nil {
+		staged.remove()
+		return nil, 0, err
+	}
+	return layer, size, nil
+}
+
+// writeLayer writes the tarball that write produces to w, gzipped at the
+// given level, and returns its uncompressed size.
+func writeLayer(w io.Writer, level int, write func(*tar.Writer) error) (int64, error) {
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return 0, err
+	}
+	cw := &countingWriter{w: zw}
+	tw := tar.NewWriter(cw)
+	if err := write(tw); err != nil {
+		return 0, err
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return cw.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// stagedFile is a layer staged on disk, which the layer's opener refers to
+// so that it is removed along with the layer.
+type stagedFile struct {
+	path string
+}
+
+func (s *stagedFile) open() (io.ReadCloser, error) {
+	return os.Open(s.path)
+}
+
+func (s *stagedFile) remove() {
+	os.Remove(s.path)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func TestStageLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-stage")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("kodata"), 1<<16)
+	write := func(tw *tar.Writer) error {
+		if err := tw.WriteHeader(&tar.Header{Name: "/var/run/ko/data", Size: int64(len(content)), Typeflag: tar.TypeReg, Mode: 0555}); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	staged, size, err := stageLayer(dir, gzip.BestSpeed, write)
+	if err != nil {
+		t.Fatalf("stageLayer() = %v", err)
+	}
+
+	// The staged layer should be the same as one built in memory.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := write(tw); err != nil {
+		t.Fatalf("write() = %v", err)
+	}
+	tw.Close()
+	if got, want := size, int64(buf.Len()); got != want {
+		t.Errorf("stageLayer() size = %d, wanted %d", got, want)
+	}
+	inMemory, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("LayerFromOpener() = %v", err)
+	}
+	gotDigest, err := staged.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	wantDigest, err := inMemory.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if gotDigest != wantDigest {
+		t.Errorf("Digest() = %v, wanted %v", gotDigest, wantDigest)
+	}
+	gotDiffID, err := staged.DiffID()
+	if err != nil {
+		t.Fatalf("DiffID() = %v", err)
+	}
+	wantDiffID, err := inMemory.DiffID()
+	if err != nil {
+		t.Fatalf("DiffID() = %v", err)
+	}
+	if gotDiffID != wantDiffID {
+		t.Errorf("DiffID() = %v, wanted %v", gotDiffID, wantDiffID)
+	}
+
+	// Compressing harder changes the digest and size, but not the diffid.
+	smaller, _, err := stageLayer(dir, gzip.BestCompression, write)
+	if err != nil {
+		t.Fatalf("stageLayer() = %v", err)
+	}
+	if h, err := smaller.Digest(); err != nil || h == gotDigest {
+		t.Errorf("Digest() = %v, %v, wanted a different digest", h, err)
+	}
+	if h, err := smaller.DiffID(); err != nil || h != gotDiffID {
+		t.Errorf("DiffID() = %v, %v, wanted %v", h, err, gotDiffID)
+	}
+	fast, err := staged.Size()
+	if err != nil {
+		t.Fatalf("Size() = %v", err)
+	}
+	if small, err := smaller.Size(); err != nil || small >= fast {
+		t.Errorf("Size() = %d, %v, wanted less than %d", small, err, fast)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("len(ReadDir()) = %d, wanted the two staged layers", len(entries))
+	}
+}
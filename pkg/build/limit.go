@@ -16,18 +16,51 @@ package build
 
 import (
 	"context"
-
-	"golang.org/x/sync/semaphore"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// backoff is how long a build waits before checking for pressure again.
+var backoff = time.Second
+
+// Prioritizer is implemented by builders that can schedule some import paths
+// ahead of others, e.g. the ones a watch has just seen change.
+type Prioritizer interface {
+	Prioritize(ips ...string)
+}
+
 // Limiter composes with another Interface to limit the number of concurrent builds.
+// Builds of import paths passed to Prioritize jump ahead of queued builds.
 type Limiter struct {
-	Builder   Interface
-	semaphore *semaphore.Weighted
+	Builder Interface
+
+	mu      sync.Mutex
+	free    int
+	waiters []*waiter
+	urgent  map[string]bool
+
+	// pressure, if set, reports when the machine is overloaded, in which
+	// case builds wait for the running ones to finish before starting.
+	pressure func() bool
+	running  int64
+}
+
+// waiter is a build queued for a slot; ready is closed once it has one.
+type waiter struct {
+	ip     string
+	urgent bool
+	ready  chan struct{}
 }
 
-// Limiter implements Interface
-var _ Interface = (*Recorder)(nil)
+// Limiter implements Interface and Prioritizer
+var (
+	_ Interface   = (*Limiter)(nil)
+	_ Prioritizer = (*Limiter)(nil)
+)
 
 // IsSupportedReference implements Interface
 func (l *Limiter) IsSupportedReference(ip string) error {
@@ -36,18 +69,137 @@ func (l *Limiter) IsSupportedReference(ip string) error {
 
 // Build implements Interface
 func (l *Limiter) Build(ctx context.Context, ip string) (Result, error) {
-	if err := l.semaphore.Acquire(ctx, 1); err != nil {
+	if err := l.acquire(ctx, ip); err != nil {
 		return nil, err
 	}
-	defer l.semaphore.Release(1)
+	defer l.release()
+
+	if err := l.start(ctx, ip); err != nil {
+		return nil, err
+	}
+	defer atomic.AddInt64(&l.running, -1)
 
 	return l.Builder.Build(ctx, ip)
 }
 
+// Prioritize implements Prioritizer. The next build of each of ips, queued or
+// not, goes ahead of any build that was not prioritized.
+func (l *Limiter) Prioritize(ips ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, ip := range ips {
+		l.urgent[strings.TrimPrefix(ip, StrictScheme)] = true
+	}
+	for _, w := range l.waiters {
+		if l.urgent[w.ip] {
+			w.urgent = true
+		}
+	}
+	sort.SliceStable(l.waiters, func(i, j int) bool {
+		return l.waiters[i].urgent && !l.waiters[j].urgent
+	})
+}
+
+// acquire waits for a slot to build ip, queueing prioritized import paths
+// after the other prioritized ones but before everything else.
+func (l *Limiter) acquire(ctx context.Context, ip string) error {
+	ip = strings.TrimPrefix(ip, StrictScheme)
+
+	l.mu.Lock()
+	if l.free > 0 && len(l.waiters) == 0 {
+		l.free--
+		delete(l.urgent, ip)
+		l.mu.Unlock()
+		return nil
+	}
+	w := &waiter{ip: ip, urgent: l.urgent[ip], ready: make(chan struct{})}
+	i := len(l.waiters)
+	if w.urgent {
+		i = sort.Search(len(l.waiters), func(i int) bool { return !l.waiters[i].urgent })
+	}
+	l.waiters = append(l.waiters, nil)
+	copy(l.waiters[i+1:], l.waiters[i:])
+	l.waiters[i] = w
+	l.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		select {
+		case <-w.ready:
+			// We were handed a slot as we gave up, so pass it on.
+			l.releaseLocked()
+		default:
+			for i, o := range l.waiters {
+				if o == w {
+					l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+					break
+				}
+			}
+		}
+		return ctx.Err()
+	}
+}
+
+// release hands our slot to the first queued build, if any.
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+func (l *Limiter) releaseLocked() {
+	if len(l.waiters) == 0 {
+		l.free++
+		return
+	}
+	w := l.waiters[0]
+	l.waiters = l.waiters[1:]
+	delete(l.urgent, w.ip)
+	close(w.ready)
+}
+
+// start counts a build as running once there is no pressure, or once nothing
+// else is building, so that at least one build always makes progress.
+func (l *Limiter) start(ctx context.Context, ip string) error {
+	logged := false
+	for {
+		if l.pressure == nil || !l.pressure() {
+			atomic.AddInt64(&l.running, 1)
+			return nil
+		}
+		if atomic.CompareAndSwapInt64(&l.running, 0, 1) {
+			return nil
+		}
+		if !logged {
+			log.Printf("Low on memory, waiting to build %s", ip)
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
 // NewLimiter returns a new builder that only allows n concurrent builds of b.
 func NewLimiter(b Interface, n int) *Limiter {
 	return &Limiter{
-		Builder:   b,
-		semaphore: semaphore.NewWeighted(int64(n)),
+		Builder: b,
+		free:    n,
+		urgent:  map[string]bool{},
 	}
 }
+
+// NewAdaptiveLimiter returns a new builder that allows up to n concurrent
+// builds of b, but fewer while pressure returns true.
+func NewAdaptiveLimiter(b Interface, n int, pressure func() bool) *Limiter {
+	l := NewLimiter(b, n)
+	l.pressure = pressure
+	return l
+}
@@ -16,9 +16,11 @@ package build
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -55,3 +57,126 @@ func TestLimiter(t *testing.T) {
 		t.Fatal("Too many builds")
 	}
 }
+
+func TestAdaptiveLimiter(t *testing.T) {
+	defer func(d time.Duration) { backoff = d }(backoff)
+	backoff = time.Millisecond
+
+	// Under constant pressure, builds run one at a time.
+	b := NewAdaptiveLimiter(&sleeper{}, 10, func() bool { return true })
+
+	start := time.Now()
+	g, _ := errgroup.WithContext(context.TODO())
+	for i := 0; i < 4; i++ {
+		g.Go(func() error {
+			_, _ = b.Build(context.Background(), "whatever")
+			return nil
+		})
+	}
+	g.Wait()
+
+	// 50 ms * 4 builds / 1 concurrency = ~200ms
+	if time.Now().Before(start.Add(200 * time.Millisecond)) {
+		t.Fatal("Too many builds under pressure")
+	}
+}
+
+// orderRecorder records the order builds start in, holding each until released.
+type orderRecorder struct {
+	mu      sync.Mutex
+	order   []string
+	release chan struct{}
+}
+
+// IsSupportedReference implements Interface
+func (r *orderRecorder) IsSupportedReference(ip string) error {
+	return nil
+}
+
+// Build implements Interface
+func (r *orderRecorder) Build(_ context.Context, ip string) (Result, error) {
+	r.mu.Lock()
+	r.order = append(r.order, ip)
+	r.mu.Unlock()
+	<-r.release
+	return nil, nil
+}
+
+// waitFor polls until cond, called with l locked, is true.
+func waitFor(l *Limiter, cond func() bool) {
+	for {
+		l.mu.Lock()
+		ok := cond()
+		l.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLimiterPrioritize(t *testing.T) {
+	r := &orderRecorder{release: make(chan struct{})}
+	b := NewLimiter(r, 1)
+
+	var wg sync.WaitGroup
+	build := func(ip string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = b.Build(context.Background(), ip)
+		}()
+	}
+	queued := func(n int) {
+		waitFor(b, func() bool { return b.free == 0 && len(b.waiters) == n })
+	}
+
+	// Occupy the only slot, then queue cold builds behind it.
+	build("ko://running")
+	for i, ip := range []string{"cold1", "cold2", "changed1"} {
+		queued(i)
+		build("ko://" + ip)
+	}
+	queued(3)
+
+	// A build that is already queued moves up, and so does one queued later.
+	b.Prioritize("changed1", "changed2")
+	build("ko://changed2")
+	queued(4)
+
+	for i := 0; i < 5; i++ {
+		r.release <- struct{}{}
+	}
+	wg.Wait()
+
+	want := []string{"ko://running", "ko://changed1", "ko://changed2", "ko://cold1", "ko://cold2"}
+	if diff := cmp.Diff(want, r.order); diff != "" {
+		t.Errorf("build order (-want +got): %s", diff)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	r := &orderRecorder{release: make(chan struct{})}
+	b := NewLimiter(r, 1)
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = b.Build(context.Background(), "running")
+		close(done)
+	}()
+	waitFor(b, func() bool { return b.free == 0 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.Build(ctx, "canceled"); err != context.DeadlineExceeded {
+		t.Fatalf("Build() = %v, wanted %v", err, context.DeadlineExceeded)
+	}
+	r.release <- struct{}{}
+	<-done
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.free != 1 || len(b.waiters) != 0 {
+		t.Errorf("after cancel: free = %d, waiters = %d; wanted 1, 0", b.free, len(b.waiters))
+	}
+}
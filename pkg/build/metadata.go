@@ -0,0 +1,155 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// BaseMetadataPolicy controls what happens to the labels and annotations of
+// base images.
+type BaseMetadataPolicy string
+
+const (
+	// BaseMetadataMerge keeps the labels and annotations of the base, and
+	// adds ko's, which win when both set the same key. This is the default.
+	BaseMetadataMerge BaseMetadataPolicy = "merge"
+	// BaseMetadataPreserve keeps the labels and annotations of the base,
+	// and adds the labels ko would that the base doesn't set.
+	BaseMetadataPreserve BaseMetadataPolicy = "preserve"
+	// BaseMetadataDrop drops the labels and annotations of the base, so
+	// that images only have the labels ko adds.
+	BaseMetadataDrop BaseMetadataPolicy = "drop"
+)
+
+func parseBaseMetadataPolicy(p BaseMetadataPolicy) (BaseMetadataPolicy, error) {
+	switch p {
+	case "":
+		return BaseMetadataMerge, nil
+	case BaseMetadataMerge, BaseMetadataPreserve, BaseMetadataDrop:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown base metadata policy %q, expected one of %q, %q or %q", p, BaseMetadataMerge, BaseMetadataPreserve, BaseMetadataDrop)
+}
+
+// mergeLabels combines the labels of the base with the ones ko adds, as the
+// policy says.
+func mergeLabels(p BaseMetadataPolicy, base, added map[string]string) map[string]string {
+	labels := make(map[string]string, len(base)+len(added))
+	first, second := base, added
+	switch p {
+	case BaseMetadataDrop:
+		first = nil
+	case BaseMetadataPreserve:
+		first, second = added, base
+	}
+	for k, v := range first {
+		labels[k] = v
+	}
+	for k, v := range second {
+		labels[k] = v
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// reannotatedImage replaces the manifest of an image with one with different
+// annotations.
+type reannotatedImage struct {
+	v1.Image
+	manifest []byte
+}
+
+// withImageAnnotations returns img with its manifest's annotations replaced.
+func withImageAnnotations(img v1.Image, annotations map[string]string) (v1.Image, error) {
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	mc := *m
+	mc.Annotations = annotations
+	b, err := json.Marshal(mc)
+	if err != nil {
+		return nil, err
+	}
+	return &reannotatedImage{Image: img, manifest: b}, nil
+}
+
+// RawManifest implements v1.Image
+func (i *reannotatedImage) RawManifest() ([]byte, error) { return i.manifest, nil }
+
+// Manifest implements v1.Image
+func (i *reannotatedImage) Manifest() (*v1.Manifest, error) {
+	return v1.ParseManifest(bytes.NewReader(i.manifest))
+}
+
+// Digest implements v1.Image
+func (i *reannotatedImage) Digest() (v1.Hash, error) { return partial.Digest(i) }
+
+// Size implements v1.Image
+func (i *reannotatedImage) Size() (int64, error) { return partial.Size(i) }
+
+// reannotatedIndex replaces the manifest of an index with one with different
+// annotations.
+type reannotatedIndex struct {
+	inner    v1.ImageIndex
+	manifest []byte
+}
+
+// withIndexAnnotations returns idx with its manifest's annotations replaced.
+func withIndexAnnotations(idx v1.ImageIndex, annotations map[string]string) (v1.ImageIndex, error) {
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	imc := *im
+	imc.Annotations = annotations
+	b, err := json.Marshal(imc)
+	if err != nil {
+		return nil, err
+	}
+	return &reannotatedIndex{inner: idx, manifest: b}, nil
+}
+
+// MediaType implements v1.ImageIndex
+func (i *reannotatedIndex) MediaType() (types.MediaType, error) { return i.inner.MediaType() }
+
+// Image implements v1.ImageIndex
+func (i *reannotatedIndex) Image(h v1.Hash) (v1.Image, error) { return i.inner.Image(h) }
+
+// ImageIndex implements v1.ImageIndex
+func (i *reannotatedIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) { return i.inner.ImageIndex(h) }
+
+// RawManifest implements v1.ImageIndex
+func (i *reannotatedIndex) RawManifest() ([]byte, error) { return i.manifest, nil }
+
+// IndexManifest implements v1.ImageIndex
+func (i *reannotatedIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return v1.ParseIndexManifest(bytes.NewReader(i.manifest))
+}
+
+// Digest implements v1.ImageIndex
+func (i *reannotatedIndex) Digest() (v1.Hash, error) { return partial.Digest(i) }
+
+// Size implements v1.ImageIndex
+func (i *reannotatedIndex) Size() (int64, error) { return partial.Size(i) }
@@ -0,0 +1,156 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestMergeLabels(t *testing.T) {
+	base := map[string]string{"vendor": "distroless", "version": "base"}
+	added := map[string]string{"version": "ko", "build-url": "https://ci"}
+	for _, c := range []struct {
+		policy BaseMetadataPolicy
+		want   map[string]string
+	}{{
+		policy: BaseMetadataMerge,
+		want:   map[string]string{"vendor": "distroless", "version": "ko", "build-url": "https://ci"},
+	}, {
+		policy: BaseMetadataPreserve,
+		want:   map[string]string{"vendor": "distroless", "version": "base", "build-url": "https://ci"},
+	}, {
+		policy: BaseMetadataDrop,
+		want:   map[string]string{"version": "ko", "build-url": "https://ci"},
+	}} {
+		if diff := cmp.Diff(c.want, mergeLabels(c.policy, base, added)); diff != "" {
+			t.Errorf("mergeLabels(%s) (-want +got) = %v", c.policy, diff)
+		}
+	}
+	if got := mergeLabels(BaseMetadataDrop, base, nil); got != nil {
+		t.Errorf("mergeLabels(drop) = %v, want nil", got)
+	}
+}
+
+func TestGoBuildBaseMetadata(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels = map[string]string{"vendor": "base"}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	base, err := withImageAnnotations(img, map[string]string{"org.opencontainers.image.vendor": "base"})
+	if err != nil {
+		t.Fatalf("withImageAnnotations() = %v", err)
+	}
+
+	for _, c := range []struct {
+		policy          BaseMetadataPolicy
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+	}{{
+		policy:          BaseMetadataMerge,
+		wantLabels:      map[string]string{"vendor": "ko"},
+		wantAnnotations: map[string]string{"org.opencontainers.image.vendor": "base"},
+	}, {
+		policy:          BaseMetadataPreserve,
+		wantLabels:      map[string]string{"vendor": "base"},
+		wantAnnotations: map[string]string{"org.opencontainers.image.vendor": "base"},
+	}, {
+		policy:     BaseMetadataDrop,
+		wantLabels: map[string]string{"vendor": "ko"},
+	}} {
+		t.Run(string(c.policy), func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+				WithLabels(map[string]string{"vendor": "ko"}),
+				WithBaseMetadata(c.policy),
+				withBuilder(writeTempFile),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			cfg, err := result.(v1.Image).ConfigFile()
+			if err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+			if diff := cmp.Diff(c.wantLabels, cfg.Config.Labels); diff != "" {
+				t.Errorf("Labels (-want +got) = %v", diff)
+			}
+			annotations, err := Annotations(result)
+			if err != nil {
+				t.Fatalf("Annotations() = %v", err)
+			}
+			if diff := cmp.Diff(c.wantAnnotations, annotations); diff != "" {
+				t.Errorf("Annotations() (-want +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestWithIndexAnnotations(t *testing.T) {
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	want := map[string]string{"foo": "bar"}
+	got, err := withIndexAnnotations(idx, want)
+	if err != nil {
+		t.Fatalf("withIndexAnnotations() = %v", err)
+	}
+	annotations, err := Annotations(got)
+	if err != nil {
+		t.Fatalf("Annotations() = %v", err)
+	}
+	if diff := cmp.Diff(want, annotations); diff != "" {
+		t.Errorf("Annotations() (-want +got) = %v", diff)
+	}
+	raw, err := got.RawManifest()
+	if err != nil {
+		t.Fatalf("RawManifest() = %v", err)
+	}
+	h, _, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("SHA256() = %v", err)
+	}
+	if d, err := got.Digest(); err != nil || d != h {
+		t.Errorf("Digest() = %v, %v, want %v", d, err, h)
+	}
+	im, err := got.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if _, err := got.Image(im.Manifests[0].Digest); err != nil {
+		t.Errorf("Image() = %v", err)
+	}
+}
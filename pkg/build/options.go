@@ -15,6 +15,11 @@
 package build
 
 import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"time"
+
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
@@ -58,6 +63,182 @@ func WithPlatforms(platforms string) Option {
 	}
 }
 
+// WithSizeReporter is a functional option for receiving a breakdown of the
+// size of each image that is built.
+func WithSizeReporter(f func(SizeReport)) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.sizeReporter = f
+		return nil
+	}
+}
+
+// WithSizeAnalysis is a functional option for adding the top largest
+// packages and symbols of each binary to the reports of WithSizeReporter
+// and Hooks.OnSizeReport.
+func WithSizeAnalysis(top int) Option {
+	return func(gbo *gobuildOpener) error {
+		if top <= 0 {
+			return fmt.Errorf("size analysis must report at least one symbol, got %d", top)
+		}
+		gbo.sizeAnalysis = top
+		return nil
+	}
+}
+
+// WithConfigs is a functional option for configuring how particular import
+// paths are built. The first config that matches an import path applies.
+func WithConfigs(configs []Config) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.configs = configs
+		return nil
+	}
+}
+
+// WithLabels is a functional option for adding labels to the config of
+// every image that is built.
+func WithLabels(labels map[string]string) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.labels = labels
+		return nil
+	}
+}
+
+// WithSourceAnnotations is a functional option for labelling and annotating
+// images and indexes with org.opencontainers.image.source and
+// org.opencontainers.image.revision, derived from the module of each import
+// path, so that registries can link them back to their code.
+func WithSourceAnnotations() Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.sourceAnnotations = true
+		return nil
+	}
+}
+
+// WithWorkDir is a functional option for building in temporary directories
+// under dir instead of the default directory for temporary files, and for
+// choosing which of them to remove afterwards.
+func WithWorkDir(dir string, cleanup CleanupPolicy) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.workDir = dir
+		gbo.cleanupPolicy = cleanup
+		return nil
+	}
+}
+
+// WithIgnore is a functional option for leaving the files (or directories,
+// if isDir) for which ignored returns true out of the kodata of images.
+func WithIgnore(ignored func(path string, isDir bool) bool) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.ignored = ignored
+		return nil
+	}
+}
+
+// WithSecretsPolicy is a functional option for scanning the kodata of each
+// import path for what look like secrets (private keys, AWS credentials and
+// .env files) before it is packaged, and warning about them or failing the
+// build.
+func WithSecretsPolicy(p SecretsPolicy) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.secretsPolicy = p
+		return nil
+	}
+}
+
+// WithDefaultUser is a functional option for setting the user that images
+// run as (e.g. 65532, to run unprivileged), unless the Config of their import
+// path sets one.
+func WithDefaultUser(user string) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.defaultUser = user
+		return nil
+	}
+}
+
+// WithBaseMetadata is a functional option for choosing whether the labels and
+// annotations of base images are merged with the labels ko adds (the
+// default), preserved over them, or dropped.
+func WithBaseMetadata(p BaseMetadataPolicy) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.baseMetadata = p
+		return nil
+	}
+}
+
+// WithInit is a functional option for wrapping the entrypoint of images in an
+// init process, e.g. a static tini, which is added in a layer of its own.
+func WithInit(init Init) Option {
+	return func(gbo *gobuildOpener) error {
+		if init.Binary == nil {
+			return errors.New("init has no way of providing its binary")
+		}
+		gbo.init = &init
+		return nil
+	}
+}
+
+// WithBinaryArtifacts is a functional option for publishing just the binary
+// of each import path whose Config doesn't opt out, as an OCI artifact that
+// oras or crane can fetch, instead of an image. Binaries are built for every
+// platform that WithPlatforms names, whether or not the base image has it.
+func WithBinaryArtifacts() Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.binaryArtifacts = true
+		return nil
+	}
+}
+
+// WithCompressionLevel is a functional option for overriding how hard the
+// layers that are built are gzipped, from gzip.BestSpeed (the default) to
+// gzip.BestCompression, trading build speed for registry storage.
+func WithCompressionLevel(level int) Option {
+	return func(gbo *gobuildOpener) error {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return fmt.Errorf("invalid compression level %d, expected %d to %d", level, gzip.HuffmanOnly, gzip.BestCompression)
+		}
+		gbo.compressionLevel = level
+		return nil
+	}
+}
+
+// WithLookup is a functional option for skipping builds whose inputs haven't
+// changed. Before building an import path, the builder computes a digest of
+// its inputs (the module graph, the source of the packages it depends on,
+// its kodata, its configuration and its base image) and returns the result
+// of lookup for it, if any. Results report their digest via InputsDigest, so
+// that publishers can record it for later lookups.
+func WithLookup(lookup Lookup) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.lookup = lookup
+		return nil
+	}
+}
+
+// WithBatching is a functional option for building the import paths that
+// start building within window of each other, for the same platform and with
+// the same flags, in one go build. That loads the module graph and compiles
+// the packages they share once, instead of once per import path. If that
+// go build fails, they are built one at a time, so that each failure is
+// attributed to its import path.
+func WithBatching(window time.Duration) Option {
+	return func(gbo *gobuildOpener) error {
+		if window < 0 {
+			return fmt.Errorf("invalid batching window %v", window)
+		}
+		gbo.batchWindow = window
+		return nil
+	}
+}
+
+// WithHooks is a functional option for calling hooks as each build starts
+// and ends. It may be given more than once.
+func WithHooks(hooks Hooks) Option {
+	return func(gbo *gobuildOpener) error {
+		gbo.hooks = append(gbo.hooks, hooks)
+		return nil
+	}
+}
+
 // withBuilder is a functional option for overriding the way go binaries
 // are built.
 func withBuilder(b builder) Option {
@@ -0,0 +1,200 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ports are the OS/architecture pairs that the go toolchain can build for.
+var ports = &portList{list: goToolDistList}
+
+// portList lists ports once, and remembers them.
+type portList struct {
+	list func() ([]string, error)
+
+	once  sync.Once
+	ports []string
+	err   error
+}
+
+func (l *portList) get() ([]string, error) {
+	l.once.Do(func() {
+		l.ports, l.err = l.list()
+	})
+	return l.ports, l.err
+}
+
+func goToolDistList() ([]string, error) {
+	out, err := exec.Command("go", "tool", "dist", "list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("go tool dist list: %w", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// archAliases are the names that other tools give architectures, by the
+// name that go gives them.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"x64":     "amd64",
+	"aarch64": "arm64",
+	"armv8":   "arm64",
+	"armhf":   "arm",
+	"armv7":   "arm",
+	"armv6":   "arm",
+	"i386":    "386",
+	"i686":    "386",
+	"x86":     "386",
+}
+
+// osAliases are the names that people give operating systems, by the name
+// that go gives them.
+var osAliases = map[string]string{
+	"macos": "darwin",
+	"osx":   "darwin",
+	"win":   "windows",
+}
+
+// ParsePlatform parses one os[/arch[/variant]] platform of a --platform
+// spec.
+func ParsePlatform(s string) (v1.Platform, error) {
+	var p v1.Platform
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) > 3 {
+		return p, fmt.Errorf("too many slashes in platform spec: %s", s)
+	}
+	p.OS = parts[0]
+	if len(parts) > 1 {
+		p.Architecture = parts[1]
+	}
+	if len(parts) > 2 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// checkPlatforms returns an error for the first platform that matchers name
+// which isn't one of ports, suggesting what it might have meant.
+func checkPlatforms(ports []string, matchers ...*PlatformMatcher) error {
+	known := make(map[string]bool, len(ports))
+	oses := map[string]bool{}
+	for _, p := range ports {
+		known[p] = true
+		oses[strings.SplitN(p, "/", 2)[0]] = true
+	}
+	for _, pm := range matchers {
+		if pm == nil {
+			continue
+		}
+		for _, p := range pm.platforms {
+			if err := checkPlatform(known, oses, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkPlatform(known, oses map[string]bool, p v1.Platform) error {
+	if p.OS != "" && !oses[p.OS] {
+		want := osAliases[strings.ToLower(p.OS)]
+		if want == "" {
+			want = closest(p.OS, keys(oses))
+		}
+		return fmt.Errorf("unknown OS %q in platform %q (see go tool dist list)%s", p.OS, platformToString(p), didYouMean(want))
+	}
+	if p.OS == "" || p.Architecture == "" || known[p.OS+"/"+p.Architecture] {
+		return nil
+	}
+	arch, ok := archAliases[strings.ToLower(p.Architecture)]
+	if !ok || !known[p.OS+"/"+arch] {
+		var archs []string
+		for _, k := range keys(known) {
+			if strings.HasPrefix(k, p.OS+"/") {
+				archs = append(archs, strings.TrimPrefix(k, p.OS+"/"))
+			}
+		}
+		arch = closest(p.Architecture, archs)
+	}
+	var want string
+	if arch != "" {
+		want = p.OS + "/" + arch
+	}
+	return fmt.Errorf("unknown platform %q (see go tool dist list)%s", platformToString(p), didYouMean(want))
+}
+
+func didYouMean(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("; did you mean %q?", s)
+}
+
+func keys(m map[string]bool) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// closest returns the candidate that is the fewest edits from s, if it is
+// close enough to be a plausible typo, or "".
+func closest(s string, candidates []string) string {
+	best, bestDistance := "", len(s)/3+2
+	for _, c := range candidates {
+		if d := editDistance(s, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
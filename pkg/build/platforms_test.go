@@ -0,0 +1,142 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestCheckPlatforms(t *testing.T) {
+	known := []string{"darwin/arm64", "linux/amd64", "linux/arm", "linux/arm64", "linux/loong64", "windows/amd64"}
+	for spec, want := range map[string]string{
+		"all":                       "",
+		"linux":                     "",
+		"linux/amd64,linux/arm/v7":  "",
+		"linux/loong64":             "",
+		"linux/amd46":               `unknown platform "linux/amd46" (see go tool dist list); did you mean "linux/amd64"?`,
+		"linux/x86_64":              `did you mean "linux/amd64"?`,
+		"linux/aarch64":             `did you mean "linux/arm64"?`,
+		"lnux/amd64":                `unknown OS "lnux" in platform "lnux/amd64" (see go tool dist list); did you mean "linux"?`,
+		"macos/arm64":               `did you mean "darwin"?`,
+		"linux/amd64,plan9/sparc64": `unknown OS "plan9"`,
+		"windows/sparc64":           `unknown platform "windows/sparc64" (see go tool dist list)`,
+	} {
+		pm, err := ParsePlatformSpec(spec)
+		if err != nil {
+			t.Fatalf("ParsePlatformSpec(%q) = %v", spec, err)
+		}
+		err = checkPlatforms(known, pm, nil)
+		switch {
+		case want == "" && err != nil:
+			t.Errorf("checkPlatforms(%q) = %v", spec, err)
+		case want != "" && (err == nil || !strings.Contains(err.Error(), want)):
+			t.Errorf("checkPlatforms(%q) = %v, want %s", spec, err, want)
+		}
+		if strings.HasPrefix(spec, "windows/") && err != nil && strings.Contains(err.Error(), "did you mean") {
+			t.Errorf("checkPlatforms(%q) = %v, want no suggestion", spec, err)
+		}
+	}
+}
+
+func TestPlatformMatcherMatches(t *testing.T) {
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	armv7 := v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	for _, c := range []struct {
+		p    v1.Platform
+		spec string
+		want bool
+	}{
+		{amd64, "linux/amd64", true},
+		{amd64, "linux", true},
+		{amd64, "linux/arm64,linux/amd64", true},
+		{amd64, "linux/arm64", false},
+		{amd64, "all", true},
+		{armv7, "linux/arm", true},
+		{armv7, "linux/arm/v6", false},
+	} {
+		pm, err := ParsePlatformSpec(c.spec)
+		if err != nil {
+			t.Fatalf("ParsePlatformSpec(%q) = %v", c.spec, err)
+		}
+		if got := pm.Matches(&c.p); got != c.want {
+			t.Errorf("ParsePlatformSpec(%q).Matches(%v) = %v, want %v", c.spec, c.p, got, c.want)
+		}
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, tc := range []struct {
+		spec          string
+		want          v1.Platform
+		wantErr       bool
+		multiplatform bool
+	}{{
+		spec: "linux",
+		want: v1.Platform{OS: "linux"},
+	}, {
+		spec: " linux/arm/v7",
+		want: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"},
+	}, {
+		spec:    "linux/arm/v7/x",
+		wantErr: true,
+	}, {
+		spec:          "linux/amd64,linux/arm64",
+		multiplatform: true,
+	}, {
+		spec:          "all",
+		multiplatform: true,
+	}} {
+		if got := IsMultiPlatform(tc.spec); got != tc.multiplatform {
+			t.Errorf("IsMultiPlatform(%q) = %t, want %t", tc.spec, got, tc.multiplatform)
+		}
+		if tc.multiplatform {
+			continue
+		}
+		got, err := ParsePlatform(tc.spec)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParsePlatform(%q) = %v, want error", tc.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ParsePlatform(%q) = %v", tc.spec, err)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("ParsePlatform(%q) (-want +got) = %s", tc.spec, diff)
+		}
+	}
+}
+
+func TestPortList(t *testing.T) {
+	calls := 0
+	l := &portList{list: func() ([]string, error) {
+		calls++
+		return nil, errors.New("no go")
+	}}
+	for i := 0; i < 2; i++ {
+		if _, err := l.get(); err == nil {
+			t.Error("get() = nil, want error")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("listed %d times, want once", calls)
+	}
+}
@@ -17,13 +17,30 @@ package build
 import (
 	"context"
 	"sync"
+	"time"
 )
 
-// Recorder composes with another Interface to record the built import paths.
+// Recorder composes with another Interface to record the built import paths,
+// and what was built for them.
 type Recorder struct {
 	m           sync.Mutex
 	ImportPaths []string
-	Builder     Interface
+	// Builds describes each successful build, in the order they finished.
+	Builds  []BuildRecord
+	Builder Interface
+}
+
+// BuildRecord describes what was built for an import path, for provenance.
+type BuildRecord struct {
+	ImportPath string   `json:"importPath"`
+	Digest     string   `json:"digest"`
+	Platforms  []string `json:"platforms,omitempty"`
+	// BaseDigest is the digest of the base image (or index), if known.
+	BaseDigest string `json:"baseDigest,omitempty"`
+	// Started is when the build was requested, and Seconds is how long it
+	// took from then, including any wait to start it.
+	Started time.Time `json:"started"`
+	Seconds float64   `json:"seconds"`
 }
 
 // Recorder implements Interface
@@ -41,5 +58,41 @@ func (r *Recorder) Build(ctx context.Context, ip string) (Result, error) {
 		defer r.m.Unlock()
 		r.ImportPaths = append(r.ImportPaths, ip)
 	}()
-	return r.Builder.Build(ctx, ip)
+	start := time.Now()
+	res, err := r.Builder.Build(ctx, ip)
+	if err != nil || res == nil {
+		return res, err
+	}
+	rec, err := describe(ip, res)
+	if err != nil {
+		return nil, err
+	}
+	rec.Started = start
+	rec.Seconds = time.Since(start).Seconds()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.Builds = append(r.Builds, rec)
+	return res, nil
+}
+
+// describe records the digest, platforms and base of res.
+func describe(ip string, res Result) (BuildRecord, error) {
+	rec := BuildRecord{ImportPath: ip}
+	h, err := res.Digest()
+	if err != nil {
+		return rec, err
+	}
+	rec.Digest = h.String()
+	platforms, err := Platforms(res)
+	if err != nil {
+		return rec, err
+	}
+	for _, p := range platforms {
+		rec.Platforms = append(rec.Platforms, platformToString(p))
+	}
+	if h, ok := BaseDigest(res); ok {
+		rec.BaseDigest = h.String()
+	}
+	return rec, nil
 }
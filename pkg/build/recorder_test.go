@@ -16,9 +16,12 @@ package build
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 )
 
 type fake struct {
@@ -114,3 +117,41 @@ func TestBuildRecording(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildRecords(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	rec := &Recorder{
+		Builder: &fake{
+			b: func(ip string) (Result, error) {
+				if ip == "github.com/foo/broken" {
+					return nil, errors.New("broken")
+				}
+				return img, nil
+			},
+		},
+	}
+	before := time.Now()
+	rec.Build(context.Background(), "github.com/foo/bar")
+	rec.Build(context.Background(), "github.com/foo/broken")
+
+	if got, want := len(rec.Builds), 1; got != want {
+		t.Fatalf("len(Builds) = %d, wanted %d", got, want)
+	}
+	got := rec.Builds[0]
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got.ImportPath != "github.com/foo/bar" || got.Digest != h.String() {
+		t.Errorf("Builds[0] = %+v, wanted github.com/foo/bar@%s", got, h)
+	}
+	if got.Started.Before(before) || got.Seconds < 0 {
+		t.Errorf("Builds[0] = %+v, wanted it to start after %v", got, before)
+	}
+	if got.BaseDigest != "" {
+		t.Errorf("BaseDigest = %s, wanted none for a result not built by NewGo", got.BaseDigest)
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Platforms returns the platform of r if it is an image, or those of the
+// images in r if it is an index. Images without a platform are skipped.
+func Platforms(r Result) ([]v1.Platform, error) {
+	switch r := r.(type) {
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var platforms []v1.Platform
+		for _, desc := range im.Manifests {
+			if desc.Platform != nil {
+				platforms = append(platforms, *desc.Platform)
+			}
+		}
+		return platforms, nil
+	case v1.Image:
+		// Artifacts have no platform, and maybe not even a JSON config.
+		if artifact, err := IsArtifact(r); err != nil || artifact {
+			return nil, err
+		}
+		cf, err := r.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		if cf.OS == "" {
+			return nil, nil
+		}
+		return []v1.Platform{{
+			OS:           cf.OS,
+			Architecture: cf.Architecture,
+			OSVersion:    cf.OSVersion,
+		}}, nil
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", r)
+	}
+}
+
+// Annotations returns the annotations of the manifest of r.
+func Annotations(r Result) (map[string]string, error) {
+	switch r := r.(type) {
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		return im.Annotations, nil
+	case v1.Image:
+		m, err := r.Manifest()
+		if err != nil {
+			return nil, err
+		}
+		return m.Annotations, nil
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", r)
+	}
+}
+
+// ImageFor returns r if it is an image, or else the first image in the index
+// r whose OS and architecture (and variant, if platform has one) match
+// platform.
+func ImageFor(r Result, platform v1.Platform) (v1.Image, error) {
+	switch r := r.(type) {
+	case v1.Image:
+		if artifact, err := IsArtifact(r); err != nil {
+			return nil, err
+		} else if artifact {
+			return nil, fmt.Errorf("result is an artifact, not a container image")
+		}
+		return r, nil
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range im.Manifests {
+			p := desc.Platform
+			if p == nil || p.OS != platform.OS || p.Architecture != platform.Architecture {
+				continue
+			}
+			if platform.Variant != "" && p.Variant != platform.Variant {
+				continue
+			}
+			img, err := r.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			return ImageFor(img, platform)
+		}
+		return nil, fmt.Errorf("no %s image in index", platformToString(platform))
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", r)
+	}
+}
+
+// TotalSize returns the size of everything that makes up r: its manifest,
+// and the configs and layers of its images. Layers shared by the images of
+// an index are counted once per image.
+func TotalSize(r Result) (int64, error) {
+	switch r := r.(type) {
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return 0, err
+		}
+		size, err := r.Size()
+		if err != nil {
+			return 0, err
+		}
+		for _, desc := range im.Manifests {
+			img, err := r.Image(desc.Digest)
+			if err != nil {
+				return 0, err
+			}
+			s, err := TotalSize(img)
+			if err != nil {
+				return 0, err
+			}
+			size += s
+		}
+		return size, nil
+	case v1.Image:
+		m, err := r.Manifest()
+		if err != nil {
+			return 0, err
+		}
+		size, err := r.Size()
+		if err != nil {
+			return 0, err
+		}
+		size += m.Config.Size
+		for _, l := range m.Layers {
+			size += l.Size
+		}
+		return size, nil
+	default:
+		return 0, fmt.Errorf("result of type %T is not an image or index", r)
+	}
+}
+
+// AddedLayers returns the layers that ko added on top of the base image of
+// r (for an index, those of each of its images in turn), or nil if r wasn't
+// built by NewGo.
+func AddedLayers(r Result) []v1.Layer {
+	if a, ok := r.(interface{ AddedLayers() []v1.Layer }); ok {
+		return a.AddedLayers()
+	}
+	return nil
+}
+
+// BaseDigest returns the digest of the base image (or index) that r was
+// built on, if r was built by NewGo.
+func BaseDigest(r Result) (v1.Hash, bool) {
+	if b, ok := r.(interface{ BaseDigest() v1.Hash }); ok && b.BaseDigest() != (v1.Hash{}) {
+		return b.BaseDigest(), true
+	}
+	return v1.Hash{}, false
+}
+
+// builtImage is an image built by gobuild, which remembers its base, the
+// layers it added to it, and the digest of its inputs.
+type builtImage struct {
+	v1.Image
+	base   v1.Hash
+	added  []v1.Layer
+	inputs string
+}
+
+// BaseDigest returns the digest of the base image.
+func (i *builtImage) BaseDigest() v1.Hash {
+	return i.base
+}
+
+// AddedLayers returns the layers added to the base image.
+func (i *builtImage) AddedLayers() []v1.Layer {
+	return i.added
+}
+
+// InputsDigest returns the digest of the inputs of the build, if known.
+func (i *builtImage) InputsDigest() string {
+	return i.inputs
+}
+
+// builtIndex is an index built by gobuild, which remembers its base, the
+// layers it added to each of the base's images, and the digest of its inputs.
+// (It can't embed the index, since the field would hide its ImageIndex
+// method.)
+type builtIndex struct {
+	inner  v1.ImageIndex
+	base   v1.Hash
+	added  []v1.Layer
+	inputs string
+}
+
+var _ v1.ImageIndex = (*builtIndex)(nil)
+
+// BaseDigest returns the digest of the base index.
+func (i *builtIndex) BaseDigest() v1.Hash {
+	return i.base
+}
+
+// InputsDigest returns the digest of the inputs of the build, if known.
+func (i *builtIndex) InputsDigest() string {
+	return i.inputs
+}
+
+// AddedLayers returns the layers added to the base images.
+func (i *builtIndex) AddedLayers() []v1.Layer {
+	return i.added
+}
+
+// MediaType implements v1.ImageIndex
+func (i *builtIndex) MediaType() (types.MediaType, error) { return i.inner.MediaType() }
+
+// Digest implements v1.ImageIndex
+func (i *builtIndex) Digest() (v1.Hash, error) { return i.inner.Digest() }
+
+// Size implements v1.ImageIndex
+func (i *builtIndex) Size() (int64, error) { return i.inner.Size() }
+
+// IndexManifest implements v1.ImageIndex
+func (i *builtIndex) IndexManifest() (*v1.IndexManifest, error) { return i.inner.IndexManifest() }
+
+// RawManifest implements v1.ImageIndex
+func (i *builtIndex) RawManifest() ([]byte, error) { return i.inner.RawManifest() }
+
+// Image implements v1.ImageIndex
+func (i *builtIndex) Image(h v1.Hash) (v1.Image, error) { return i.inner.Image(h) }
+
+// ImageIndex implements v1.ImageIndex
+func (i *builtIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) { return i.inner.ImageIndex(h) }
@@ -0,0 +1,167 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestResultDetails(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	cf = cf.DeepCopy()
+	cf.OS, cf.Architecture = "linux", "arm64"
+	base, err := mutate.ConfigFile(img, cf)
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	platforms := []v1.Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64"}}
+	var adds []mutate.IndexAddendum
+	for i := range platforms {
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        base,
+			Descriptor: v1.Descriptor{MediaType: types.DockerManifestSchema2, Platform: &platforms[i]},
+		})
+	}
+	baseIndex := mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), types.DockerManifestList)
+
+	for _, tc := range []struct {
+		name          string
+		base          Result
+		wantPlatforms []v1.Platform
+		wantLayers    int
+	}{
+		{name: "image", base: base, wantPlatforms: platforms[1:], wantLayers: 2},
+		{name: "index", base: baseIndex, wantPlatforms: platforms, wantLayers: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ng, err := NewGo(
+				context.Background(),
+				WithBaseImages(func(context.Context, string) (Result, error) { return tc.base, nil }),
+				WithPlatforms("all"),
+				withBuilder(writeTempFile),
+			)
+			if err != nil {
+				t.Fatalf("NewGo() = %v", err)
+			}
+			result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+
+			got, err := Platforms(result)
+			if err != nil {
+				t.Fatalf("Platforms() = %v", err)
+			}
+			if diff := cmp.Diff(tc.wantPlatforms, got); diff != "" {
+				t.Errorf("Platforms() (-want +got) = %s", diff)
+			}
+			if got := len(AddedLayers(result)); got != tc.wantLayers {
+				t.Errorf("len(AddedLayers()) = %d, wanted %d", got, tc.wantLayers)
+			}
+			if size, err := TotalSize(result); err != nil {
+				t.Errorf("TotalSize() = %v", err)
+			} else if manifestSize, _ := result.Size(); size <= manifestSize {
+				t.Errorf("TotalSize() = %d, wanted more than the manifest (%d)", size, manifestSize)
+			}
+		})
+	}
+
+	if got := AddedLayers(base); got != nil {
+		t.Errorf("AddedLayers(base) = %v, wanted nil", got)
+	}
+}
+
+func TestImageFor(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	images := map[string]v1.Image{}
+	for _, p := range []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v6"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	} {
+		p := p
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		images[platformToString(p)] = img
+		adds = append(adds, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{MediaType: types.DockerManifestSchema2, Platform: &p},
+		})
+	}
+	idx := mutate.AppendManifests(empty.Index, adds...)
+
+	for _, tc := range []struct {
+		platform v1.Platform
+		want     string
+	}{
+		{platform: v1.Platform{OS: "linux", Architecture: "amd64"}, want: "linux/amd64"},
+		{platform: v1.Platform{OS: "linux", Architecture: "arm"}, want: "linux/arm/v6"},
+		{platform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, want: "linux/arm/v7"},
+	} {
+		img, err := ImageFor(idx, tc.platform)
+		if err != nil {
+			t.Fatalf("ImageFor(%v) = %v", tc.platform, err)
+		}
+		if img != images[tc.want] {
+			t.Errorf("ImageFor(%v) is not the %s image", tc.platform, tc.want)
+		}
+	}
+	if _, err := ImageFor(idx, v1.Platform{OS: "windows", Architecture: "amd64"}); err == nil {
+		t.Error("ImageFor(windows/amd64) = nil, wanted an error")
+	}
+	if img, err := ImageFor(images["linux/amd64"], v1.Platform{OS: "windows"}); err != nil || img != images["linux/amd64"] {
+		t.Errorf("ImageFor(image) = %v, %v, wanted the image itself", img, err)
+	}
+
+	annotations, err := Annotations(annotatedImage{images["linux/amd64"]})
+	if err != nil {
+		t.Fatalf("Annotations() = %v", err)
+	}
+	if diff := cmp.Diff(map[string]string{"foo": "bar"}, annotations); diff != "" {
+		t.Errorf("Annotations() (-want +got) = %s", diff)
+	}
+}
+
+// annotatedImage adds an annotation to the manifest of an image.
+type annotatedImage struct {
+	v1.Image
+}
+
+func (i annotatedImage) Manifest() (*v1.Manifest, error) {
+	m, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+	m.Annotations = map[string]string{"foo": "bar"}
+	return m, nil
+}
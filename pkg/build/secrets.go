@@ -0,0 +1,153 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// SecretsPolicy controls what happens when the kodata of an import path looks
+// like it holds secrets.
+type SecretsPolicy string
+
+const (
+	// SecretsIgnore doesn't scan kodata. This is the default.
+	SecretsIgnore SecretsPolicy = "ignore"
+	// SecretsWarn logs what looks like a secret, and builds anyway.
+	SecretsWarn SecretsPolicy = "warn"
+	// SecretsFail fails the build with ErrKoDataSecret.
+	SecretsFail SecretsPolicy = "fail"
+)
+
+func parseSecretsPolicy(p SecretsPolicy) (SecretsPolicy, error) {
+	switch p {
+	case "":
+		return SecretsIgnore, nil
+	case SecretsIgnore, SecretsWarn, SecretsFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown secrets policy %q, expected one of %q, %q or %q", p, SecretsIgnore, SecretsWarn, SecretsFail)
+}
+
+// Secret is a file of kodata that looks like it holds a secret.
+type Secret struct {
+	// File is the path of the file under kodata.
+	File   string
+	Reason string
+}
+
+func (s Secret) String() string {
+	return s.File + ": " + s.Reason
+}
+
+// secretScanLimit is how much of each file is scanned for secrets.
+const secretScanLimit = 1 << 20
+
+var (
+	privateKeyPattern = regexp.MustCompile(`-----BEGIN ((RSA|DSA|EC|OPENSSH|ENCRYPTED|PGP) )?PRIVATE KEY( BLOCK)?-----`)
+	awsKeyIDPattern   = regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)
+	awsSecretPattern  = regexp.MustCompile(`(?i)aws_secret_access_key\s*[=:]`)
+)
+
+// secretFileNames are the names of files that hold secrets, whatever is in
+// them.
+var secretFileNames = map[string]string{
+	".env":       "environment files usually hold credentials",
+	".netrc":     "netrc files hold credentials",
+	"id_rsa":     "SSH private key",
+	"id_dsa":     "SSH private key",
+	"id_ecdsa":   "SSH private key",
+	"id_ed25519": "SSH private key",
+}
+
+// secretReason returns why the file name, with contents b, looks like a
+// secret, or "".
+func secretReason(name string, b []byte) string {
+	if reason, ok := secretFileNames[name]; ok {
+		return reason
+	}
+	// .env.production and the like, but not .env.example.
+	if strings.HasPrefix(name, ".env.") && !strings.HasSuffix(name, ".example") && !strings.HasSuffix(name, ".sample") {
+		return "environment files usually hold credentials"
+	}
+	switch {
+	case privateKeyPattern.Match(b):
+		return "contains a private key"
+	case awsKeyIDPattern.Match(b):
+		return "contains an AWS access key ID"
+	case awsSecretPattern.Match(b):
+		return "contains an AWS secret access key"
+	}
+	return ""
+}
+
+// findSecrets returns the files under root (following symlinks, like kodata)
+// that look like they hold secrets, except for those that are ignored. A
+// missing root has none.
+func findSecrets(root string, ignored func(string, bool) bool) ([]Secret, error) {
+	var secrets []Secret
+	err := walkTree(root, "", ignored, func(rel, hostPath string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(hostPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(f, secretScanLimit))
+		if err != nil {
+			return err
+		}
+		if reason := secretReason(path.Base(rel), b); reason != "" {
+			secrets = append(secrets, Secret{File: rel, Reason: reason})
+		}
+		return nil
+	})
+	return secrets, err
+}
+
+// checkKoData scans the kodata of ref for secrets, as the secrets policy says.
+func (g *gobuild) checkKoData(ref reference) error {
+	if g.secretsPolicy == SecretsIgnore || g.secretsPolicy == "" {
+		return nil
+	}
+	root, err := g.kodataPath(ref)
+	if err != nil {
+		return err
+	}
+	secrets, err := findSecrets(root, g.ignored)
+	if err != nil || len(secrets) == 0 {
+		return err
+	}
+	if g.secretsPolicy == SecretsWarn {
+		for _, s := range secrets {
+			log.Printf("WARNING: kodata of %s may hold a secret: %s", ref.Path(), s)
+		}
+		return nil
+	}
+	files := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		files = append(files, s.String())
+	}
+	return &Error{Kind: ErrKoDataSecret, Err: fmt.Errorf("%s: %s", ref.Path(), strings.Join(files, "; "))}
+}
@@ -15,30 +15,87 @@
 package build
 
 import (
+	"container/list"
 	"context"
 	"sync"
+	"time"
 )
 
 // Caching wraps a builder implementation in a layer that shares build results
 // for the same inputs using a simple "future" implementation.  Cached results
-// may be invalidated by calling Invalidate with the same input passed to Build.
+// may be invalidated by calling Invalidate with the same input passed to Build,
+// and are evicted once they are older than the TTL or there are more of them
+// than the maximum number of entries, if those are set.
 type Caching struct {
 	inner Interface
 
+	// OnLookup, if set, is called for each Build with whether it shared an
+	// existing result, e.g. to measure the hit rate of the cache.
+	OnLookup func(hit bool)
+
+	// OnEvict, if set, is called for each result that is evicted, with why:
+	// EvictedTTL or EvictedSize.
+	OnEvict func(reason string)
+
+	maxEntries int
+	ttl        time.Duration
+	now        func() time.Time
+
 	m       sync.Mutex
-	results map[string]*future
+	results map[string]*list.Element
+	// lru orders the results from most to least recently used.
+	lru *list.List
+}
+
+// cacheEntry is a result of Caching.
+type cacheEntry struct {
+	ip      string
+	f       *future
+	created time.Time
 }
 
+// The reasons that Caching evicts results.
+const (
+	EvictedTTL  = "ttl"
+	EvictedSize = "size"
+)
+
 // Caching implements Interface
 var _ Interface = (*Caching)(nil)
 
+// CachingOption is a functional option for NewCaching.
+type CachingOption func(*Caching)
+
+// WithCacheMaxEntries bounds the number of results that Caching keeps, by
+// evicting the least recently used. Zero means no limit.
+func WithCacheMaxEntries(n int) CachingOption {
+	return func(c *Caching) {
+		c.maxEntries = n
+	}
+}
+
+// WithCacheTTL evicts results once they are older than ttl, so that they are
+// built again. Zero means they never expire.
+func WithCacheTTL(ttl time.Duration) CachingOption {
+	return func(c *Caching) {
+		c.ttl = ttl
+	}
+}
+
 // NewCaching wraps the provided build.Interface in an implementation that
-// shares build results for a given path until the result has been invalidated.
-func NewCaching(inner Interface) (*Caching, error) {
-	return &Caching{
+// shares build results for a given path until the result has been invalidated
+// or evicted.
+func NewCaching(inner Interface, opts ...CachingOption) (*Caching, error) {
+	c := &Caching{
 		inner:   inner,
-		results: make(map[string]*future),
-	}, nil
+		now:     time.Now,
+		results: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
 // Build implements Interface
@@ -48,22 +105,51 @@ func (c *Caching) Build(ctx context.Context, ip string) (Result, error) {
 		c.m.Lock()
 		defer c.m.Unlock()
 
-		// If a future for "ip" exists, then return it.
-		f, ok := c.results[ip]
+		// If a future for "ip" exists (and hasn't expired), then return it.
+		e, ok := c.results[ip]
+		if ok && c.ttl != 0 && c.now().Sub(e.Value.(*cacheEntry).created) > c.ttl {
+			c.evict(e, EvictedTTL)
+			ok = false
+		}
+		if c.OnLookup != nil {
+			c.OnLookup(ok)
+		}
 		if ok {
-			return f
+			c.lru.MoveToFront(e)
+			return e.Value.(*cacheEntry).f
 		}
 		// Otherwise create and record a future for a Build of "ip".
-		f = newFuture(func() (Result, error) {
+		f := newFuture(func() (Result, error) {
 			return c.inner.Build(ctx, ip)
 		})
-		c.results[ip] = f
+		c.results[ip] = c.lru.PushFront(&cacheEntry{ip: ip, f: f, created: c.now()})
+		for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+			c.evict(c.lru.Back(), EvictedSize)
+		}
 		return f
 	}()
 
 	return f.Get()
 }
 
+// evict removes e, with c.m held. Builds that are waiting for its result
+// still get it.
+func (c *Caching) evict(e *list.Element, reason string) {
+	c.lru.Remove(e)
+	delete(c.results, e.Value.(*cacheEntry).ip)
+	if c.OnEvict != nil {
+		c.OnEvict(reason)
+	}
+}
+
+// Prioritize implements Prioritizer by passing ips on to the inner builder,
+// if it schedules builds.
+func (c *Caching) Prioritize(ips ...string) {
+	if p, ok := c.inner.(Prioritizer); ok {
+		p.Prioritize(ips...)
+	}
+}
+
 // IsSupportedReference implements Interface
 func (c *Caching) IsSupportedReference(ip string) error {
 	return c.inner.IsSupportedReference(ip)
@@ -74,5 +160,8 @@ func (c *Caching) Invalidate(ip string) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	delete(c.results, ip)
+	if e, ok := c.results[ip]; ok {
+		c.lru.Remove(e)
+		delete(c.results, ip)
+	}
 }
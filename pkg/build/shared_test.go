@@ -16,9 +16,11 @@ package build
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 )
 
@@ -90,3 +92,75 @@ func TestCaching(t *testing.T) {
 		cb.Invalidate(ip)
 	}
 }
+
+// countingBuild counts the builds of each import path.
+type countingBuild struct {
+	m      sync.Mutex
+	builds map[string]int
+}
+
+func (cb *countingBuild) IsSupportedReference(string) error { return nil }
+
+func (cb *countingBuild) Build(_ context.Context, ip string) (Result, error) {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+	cb.builds[ip]++
+	return random.Image(256, 1)
+}
+
+func TestCachingMaxEntries(t *testing.T) {
+	cb := &countingBuild{builds: map[string]int{}}
+	c, _ := NewCaching(cb, WithCacheMaxEntries(2))
+	var evicted []string
+	c.OnEvict = func(reason string) { evicted = append(evicted, reason) }
+
+	// c is the third, so it evicts a (which b is more recently used than).
+	for _, ip := range []string{"a", "b", "b", "c", "b", "a"} {
+		if _, err := c.Build(context.Background(), ip); err != nil {
+			t.Fatalf("Build(%s) = %v", ip, err)
+		}
+	}
+	if diff := cmp.Diff(map[string]int{"a": 2, "b": 1, "c": 1}, cb.builds); diff != "" {
+		t.Errorf("builds (-want +got) = %s", diff)
+	}
+	if diff := cmp.Diff([]string{EvictedSize, EvictedSize}, evicted); diff != "" {
+		t.Errorf("evictions (-want +got) = %s", diff)
+	}
+}
+
+func TestCachingTTL(t *testing.T) {
+	cb := &countingBuild{builds: map[string]int{}}
+	c, _ := NewCaching(cb, WithCacheTTL(time.Minute))
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	var evicted []string
+	c.OnEvict = func(reason string) { evicted = append(evicted, reason) }
+
+	build := func() {
+		t.Helper()
+		if _, err := c.Build(context.Background(), "a"); err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+	}
+	build()
+	now = now.Add(30 * time.Second)
+	build()
+	if cb.builds["a"] != 1 {
+		t.Errorf("built %d times within the TTL, want 1", cb.builds["a"])
+	}
+	now = now.Add(time.Minute)
+	build()
+	if cb.builds["a"] != 2 {
+		t.Errorf("built %d times after the TTL, want 2", cb.builds["a"])
+	}
+	if diff := cmp.Diff([]string{EvictedTTL}, evicted); diff != "" {
+		t.Errorf("evictions (-want +got) = %s", diff)
+	}
+
+	// Invalidating works as before.
+	c.Invalidate("a")
+	build()
+	if cb.builds["a"] != 3 {
+		t.Errorf("built %d times after Invalidate, want 3", cb.builds["a"])
+	}
+}
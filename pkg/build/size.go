@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/ko/pkg/internal/humanize"
+)
+
+// SizeReport breaks down the size of an image built by ko. Compressed sizes
+// are what gets pushed and pulled; uncompressed sizes are what ends up on
+// disk.
+type SizeReport struct {
+	ImportPath string
+	Platform   string
+
+	// Base is the compressed size of the base image's layers.
+	Base int64
+
+	KoDataCompressed   int64
+	KoDataUncompressed int64
+
+	BinaryCompressed   int64
+	BinaryUncompressed int64
+
+	// Packages and Symbols are the largest packages and symbols of the
+	// binary (uncompressed), biggest first, when size analysis is enabled.
+	Packages []SymbolSize
+	Symbols  []SymbolSize
+}
+
+// Total is the compressed size of all of the image's layers.
+func (r SizeReport) Total() int64 {
+	return r.Base + r.KoDataCompressed + r.BinaryCompressed
+}
+
+// Write writes a human-readable breakdown of r to w.
+func (r SizeReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "Size of %s (%s): %s total\n", r.ImportPath, r.Platform, humanize.Bytes(r.Total()))
+	fmt.Fprintf(w, "  base:   %s\n", humanize.Bytes(r.Base))
+	fmt.Fprintf(w, "  kodata: %s (%s uncompressed)\n", humanize.Bytes(r.KoDataCompressed), humanize.Bytes(r.KoDataUncompressed))
+	fmt.Fprintf(w, "  binary: %s (%s uncompressed)\n", humanize.Bytes(r.BinaryCompressed), humanize.Bytes(r.BinaryUncompressed))
+	writeSizes(w, "largest packages", r.Packages)
+	writeSizes(w, "largest symbols", r.Symbols)
+}
+
+func writeSizes(w io.Writer, title string, sizes []SymbolSize) {
+	if len(sizes) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", title)
+	for _, s := range sizes {
+		fmt.Fprintf(w, "    %10s  %s\n", humanize.Bytes(s.Size), s.Name)
+	}
+}
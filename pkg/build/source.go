@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+const (
+	sourceAnnotation   = "org.opencontainers.image.source"
+	revisionAnnotation = "org.opencontainers.image.revision"
+)
+
+// sourceMetadata returns the org.opencontainers.image.source and
+// org.opencontainers.image.revision of importpath, as far as they can be
+// told from the module that contains it, or nil.
+func (g *gobuild) sourceMetadata(importpath string) map[string]string {
+	if !g.sourceAnnotations || g.mod == nil {
+		return nil
+	}
+	m := g.mod.source(importpath)
+	if m == nil {
+		return nil
+	}
+	url := sourceURL(m.Path)
+	if url == "" {
+		return nil
+	}
+	md := map[string]string{sourceAnnotation: url}
+	if rev := g.revision(m); rev != "" {
+		md[revisionAnnotation] = rev
+	}
+	return md
+}
+
+// source returns the module that importpath is built from: one of ours, or
+// else the dependency with the longest matching path.
+func (m *modules) source(importpath string) *modInfo {
+	if owner := m.owner(importpath); inModule(importpath, owner.Path) {
+		return owner
+	}
+	var source *modInfo
+	for path, dep := range m.deps {
+		if inModule(importpath, path) && (source == nil || len(path) > len(source.Path)) {
+			source = dep
+		}
+	}
+	return source
+}
+
+// sourceURL returns where the code of the module with path mod can be
+// browsed, for the hosts whose module paths spell that out, or else "".
+func sourceURL(mod string) string {
+	parts := strings.Split(mod, "/")
+	switch parts[0] {
+	case "github.com", "bitbucket.org", "codeberg.org":
+		if len(parts) < 3 {
+			return ""
+		}
+		return "https://" + strings.Join(parts[:3], "/")
+	case "gitlab.com":
+		// GitLab has subgroups, so the repository is the whole path,
+		// less any major version suffix.
+		if len(parts) < 3 {
+			return ""
+		}
+		if last := parts[len(parts)-1]; len(parts) > 3 && majorVersion(last) {
+			parts = parts[:len(parts)-1]
+		}
+		return "https://" + strings.Join(parts, "/")
+	case "golang.org":
+		if len(parts) < 3 || parts[1] != "x" {
+			return ""
+		}
+		return "https://go.googlesource.com/" + parts[2]
+	}
+	return ""
+}
+
+// majorVersion reports whether elem is a major version suffix, like v2.
+func majorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// revision returns the revision that m is built from: the commit checked
+// out in its directory for the modules we build from source, or else the
+// commit or tag that its version names.
+func (g *gobuild) revision(m *modInfo) string {
+	if m.Version != "" {
+		return versionRevision(m.Version)
+	}
+	if rev, ok := g.revisions.Load(m.Dir); ok {
+		return rev.(string)
+	}
+	out, err := exec.CommandContext(context.Background(), "git", "-C", m.Dir, "rev-parse", "HEAD").Output()
+	rev := ""
+	if err == nil {
+		rev = strings.TrimSpace(string(out))
+	}
+	g.revisions.Store(m.Dir, rev)
+	return rev
+}
+
+// versionRevision returns the commit of a pseudo-version like
+// v0.0.0-20210101000000-0123456789ab, or else the tag that version is.
+func versionRevision(version string) string {
+	version = strings.TrimSuffix(version, "+incompatible")
+	parts := strings.Split(version, "-")
+	if n := len(parts); n >= 3 && len(parts[n-1]) == 12 {
+		ts := parts[n-2]
+		if i := strings.LastIndex(ts, "."); i >= 0 {
+			ts = ts[i+1:]
+		}
+		if len(ts) == 14 && strings.Trim(ts, "0123456789") == "" {
+			return parts[n-1]
+		}
+	}
+	return version
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestSourceURL(t *testing.T) {
+	for _, c := range []struct {
+		mod  string
+		want string
+	}{
+		{"github.com/google/ko", "https://github.com/google/ko"},
+		{"github.com/google/go-containerregistry/pkg/authn/k8schain", "https://github.com/google/go-containerregistry"},
+		{"github.com/foo/bar/v2", "https://github.com/foo/bar"},
+		{"gitlab.com/group/subgroup/project", "https://gitlab.com/group/subgroup/project"},
+		{"gitlab.com/group/project/v3", "https://gitlab.com/group/project"},
+		{"bitbucket.org/foo/bar", "https://bitbucket.org/foo/bar"},
+		{"golang.org/x/tools/gopls", "https://go.googlesource.com/tools"},
+		{"github.com/google", ""},
+		{"example.com/vanity", ""},
+	} {
+		if got := sourceURL(c.mod); got != c.want {
+			t.Errorf("sourceURL(%q) = %q, want %q", c.mod, got, c.want)
+		}
+	}
+}
+
+func TestVersionRevision(t *testing.T) {
+	for _, c := range []struct {
+		version string
+		want    string
+	}{
+		{"v1.2.3", "v1.2.3"},
+		{"v2.0.0+incompatible", "v2.0.0"},
+		{"v1.0.0-rc.1", "v1.0.0-rc.1"},
+		{"v0.0.0-20210101000000-0123456789ab", "0123456789ab"},
+		{"v1.2.4-0.20210101000000-0123456789ab", "0123456789ab"},
+		{"v1.2.3-pre.0.20210101000000-0123456789ab", "0123456789ab"},
+	} {
+		if got := versionRevision(c.version); got != c.want {
+			t.Errorf("versionRevision(%q) = %q, want %q", c.version, got, c.want)
+		}
+	}
+}
+
+func TestGoBuildSourceAnnotations(t *testing.T) {
+	base, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatalf("Abs() = %v", err)
+	}
+	ng, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithPlatforms("all"),
+		WithSourceAnnotations(),
+		WithLabels(map[string]string{revisionAnnotation: "overridden"}),
+		withModuleInfo(&modules{
+			main: &modInfo{Path: "github.com/google/ko", Dir: root, Main: true},
+			deps: map[string]*modInfo{
+				"github.com/foo/bar": {Path: "github.com/foo/bar", Version: "v0.0.0-20210101000000-0123456789ab"},
+			},
+		}),
+		withBuilder(writeTempFile),
+	)
+	if err != nil {
+		t.Fatalf("NewGo() = %v", err)
+	}
+
+	// The commit isn't known outside of a git checkout.
+	commit := ""
+	if out, err := exec.Command("git", "-C", root, "rev-parse", "HEAD").Output(); err == nil {
+		commit = strings.TrimSpace(string(out))
+	}
+	g := ng.(*gobuild)
+	if got := g.sourceMetadata("github.com/google/ko/cmd/ko"); got[revisionAnnotation] != commit {
+		t.Errorf("revision of github.com/google/ko = %q, want %q", got[revisionAnnotation], commit)
+	}
+	want := map[string]string{
+		sourceAnnotation:   "https://github.com/foo/bar",
+		revisionAnnotation: "0123456789ab",
+	}
+	if diff := cmp.Diff(want, g.sourceMetadata("github.com/foo/bar/cmd/baz")); diff != "" {
+		t.Errorf("sourceMetadata(github.com/foo/bar/cmd/baz) (-want +got): %s", diff)
+	}
+	if got := g.sourceMetadata("example.com/vanity"); got != nil {
+		t.Errorf("sourceMetadata(example.com/vanity) = %v, want nil", got)
+	}
+
+	result, err := ng.Build(context.Background(), StrictScheme+"github.com/google/ko/test")
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	idx, ok := result.(v1.ImageIndex)
+	if !ok {
+		t.Fatalf("Build() = %T, want an index", result)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if got, want := im.Annotations[sourceAnnotation], "https://github.com/google/ko"; got != want {
+		t.Errorf("index annotation %s = %q, want %q", sourceAnnotation, got, want)
+	}
+	for _, desc := range im.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("Image(%s) = %v", desc.Digest, err)
+		}
+		m, err := img.Manifest()
+		if err != nil {
+			t.Fatalf("Manifest() = %v", err)
+		}
+		if got, want := m.Annotations[sourceAnnotation], "https://github.com/google/ko"; got != want {
+			t.Errorf("image annotation %s = %q, want %q", sourceAnnotation, got, want)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		// --image-label beats what ko derives.
+		if got, want := cfg.Config.Labels[revisionAnnotation], "overridden"; got != want {
+			t.Errorf("label %s = %q, want %q", revisionAnnotation, got, want)
+		}
+		if got, want := cfg.Config.Labels[sourceAnnotation], "https://github.com/google/ko"; got != want {
+			t.Errorf("label %s = %q, want %q", sourceAnnotation, got, want)
+		}
+	}
+}
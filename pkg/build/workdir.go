@@ -0,0 +1,57 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// CleanupPolicy controls which of the working directories of builds are
+// removed once the build is done with them.
+type CleanupPolicy string
+
+const (
+	// CleanupAlways removes every working directory. This is the default.
+	CleanupAlways CleanupPolicy = "always"
+	// CleanupOnSuccess keeps the working directories of failed builds, so
+	// they can be inspected.
+	CleanupOnSuccess CleanupPolicy = "on-success"
+	// CleanupNever keeps every working directory.
+	CleanupNever CleanupPolicy = "never"
+)
+
+func parseCleanupPolicy(p CleanupPolicy) (CleanupPolicy, error) {
+	switch p {
+	case "":
+		return CleanupAlways, nil
+	case CleanupAlways, CleanupOnSuccess, CleanupNever:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown cleanup policy %q, expected one of %q, %q or %q", p, CleanupAlways, CleanupOnSuccess, CleanupNever)
+}
+
+// cleanup removes the working directory of a build that finished with err,
+// unless the cleanup policy says to keep it.
+func (g *gobuild) cleanup(dir string, err error) {
+	switch {
+	case g.cleanupPolicy == CleanupNever,
+		g.cleanupPolicy == CleanupOnSuccess && err != nil:
+		log.Printf("Keeping working directory %s", dir)
+	default:
+		os.RemoveAll(dir)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestWorkDirCleanup(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	failing := func(context.Context, string, string, v1.Platform, Config, bool) (string, error) {
+		return "", errors.New("boom")
+	}
+
+	for _, tc := range []struct {
+		policy CleanupPolicy
+		b      builder
+		want   int
+	}{
+		{policy: "", b: writeTempFile, want: 0},
+		{policy: CleanupAlways, b: failing, want: 0},
+		{policy: CleanupOnSuccess, b: writeTempFile, want: 0},
+		{policy: CleanupOnSuccess, b: failing, want: 1},
+		{policy: CleanupNever, b: writeTempFile, want: 1},
+	} {
+		workDir, err := ioutil.TempDir("", "ko-workdir")
+		if err != nil {
+			t.Fatalf("TempDir() = %v", err)
+		}
+		defer os.RemoveAll(workDir)
+
+		ng, err := NewGo(
+			context.Background(),
+			WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+			WithWorkDir(workDir, tc.policy),
+			withBuilder(tc.b),
+		)
+		if err != nil {
+			t.Fatalf("NewGo() = %v", err)
+		}
+		ng.Build(context.Background(), StrictScheme+"github.com/google/ko")
+
+		entries, err := ioutil.ReadDir(workDir)
+		if err != nil {
+			t.Fatalf("ReadDir() = %v", err)
+		}
+		// Layers are staged alongside the build directories, so only
+		// count the directories.
+		dirs := 0
+		for _, e := range entries {
+			if e.IsDir() {
+				dirs++
+			}
+		}
+		if dirs != tc.want {
+			t.Errorf("%q: build directories = %d, wanted %d", tc.policy, dirs, tc.want)
+		}
+	}
+
+	if _, err := NewGo(
+		context.Background(),
+		WithBaseImages(func(context.Context, string) (Result, error) { return base, nil }),
+		WithWorkDir("", "sometimes"),
+	); err == nil {
+		t.Error("NewGo() = nil, wanted an error for an unknown cleanup policy")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+)
+
+const (
+	importPathAnnotation = "ko.build/import-path"
+	commitAnnotation     = "ko.build/commit"
+	baseDigestAnnotation = "ko.build/base-digest"
+)
+
+// podAnnotator returns the annotations to add to a pod template whose
+// containers reference the given import paths.
+type podAnnotator func(ctx context.Context, importPaths []string) (map[string]string, error)
+
+// baseRefs records the reference of the base image that each import path was
+// built on, as it was configured (by tag, say).
+var baseRefs sync.Map
+
+// buildAnnotator returns a podAnnotator that traces pods back to the import
+// paths, commit and base images they were built from. Pods with more than one
+// import path get comma-separated values, in container order.
+func buildAnnotator(builder build.Interface) podAnnotator {
+	var once sync.Once
+	var commit string
+	return func(ctx context.Context, refs []string) (map[string]string, error) {
+		once.Do(func() {
+			commit = gitCommit(ctx)
+		})
+
+		var ips, bases []string
+		for _, ref := range refs {
+			// The builder is caching, so this just waits for the
+			// build that resolving the reference already started.
+			br, err := builder.Build(ctx, ref)
+			if err != nil {
+				return nil, err
+			}
+			ip := strings.TrimPrefix(ref, build.StrictScheme)
+			ips = append(ips, ip)
+			if base, ok := baseDigest(br, ip); ok {
+				bases = append(bases, base)
+			}
+		}
+
+		annotations := map[string]string{
+			importPathAnnotation: strings.Join(ips, ","),
+		}
+		if commit != "" {
+			annotations[commitAnnotation] = commit
+		}
+		if len(bases) == len(ips) {
+			annotations[baseDigestAnnotation] = strings.Join(bases, ",")
+		}
+		return annotations, nil
+	}
+}
+
+// baseDigest returns the base image that br was built on, pinned by digest in
+// the repository it was configured from.
+func baseDigest(br build.Result, ip string) (string, bool) {
+	h, ok := build.BaseDigest(br)
+	if !ok {
+		return "", false
+	}
+	configured, ok := baseRefs.Load(ip)
+	if !ok {
+		return "", false
+	}
+	ref, err := name.ParseReference(configured.(string))
+	if err != nil {
+		return "", false
+	}
+	return ref.Context().Name() + "@" + h.String(), true
+}
+
+// gitCommit returns the commit checked out in the working directory, or ""
+// if it isn't a git repository.
+func gitCommit(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
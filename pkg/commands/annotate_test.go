@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestBuildAnnotator(t *testing.T) {
+	base := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	baseRefs.Store(fooRef, "gcr.io/distroless/static:nonroot")
+	defer baseRefs.Delete(fooRef)
+	baseRefs.Store(barRef, "gcr.io/distroless/static:nonroot")
+	defer baseRefs.Delete(barRef)
+
+	// Only foo knows the base it was built on.
+	builder := kotesting.NewFixedBuild(map[string]build.Result{
+		fooRef: &baseImage{Image: foo, base: base},
+		barRef: bar,
+	})
+	annotate := buildAnnotator(builder)
+	got, err := annotate(context.Background(), []string{build.StrictScheme + fooRef})
+	if err != nil {
+		t.Fatalf("annotate() = %v", err)
+	}
+	if got, want := got[importPathAnnotation], fooRef; got != want {
+		t.Errorf("%s = %q, want %q", importPathAnnotation, got, want)
+	}
+	if got, want := got[baseDigestAnnotation], "gcr.io/distroless/static@"+base.String(); got != want {
+		t.Errorf("%s = %q, want %q", baseDigestAnnotation, got, want)
+	}
+
+	// Without a known base for every import path, the base digests would
+	// be misaligned with the import paths, so they are left out.
+	got, err = annotate(context.Background(), []string{build.StrictScheme + fooRef, build.StrictScheme + barRef})
+	if err != nil {
+		t.Fatalf("annotate() = %v", err)
+	}
+	if got, want := got[importPathAnnotation], fooRef+","+barRef; got != want {
+		t.Errorf("%s = %q, want %q", importPathAnnotation, got, want)
+	}
+	if base, ok := got[baseDigestAnnotation]; ok {
+		t.Errorf("%s = %q, want unset", baseDigestAnnotation, base)
+	}
+}
+
+// baseImage is an image that knows the digest of its base.
+type baseImage struct {
+	v1.Image
+	base v1.Hash
+}
+
+func (i *baseImage) BaseDigest() v1.Hash {
+	return i.base
+}
@@ -15,10 +15,14 @@
 package commands
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/spf13/cobra"
@@ -33,7 +37,11 @@ func addApply(topLevel *cobra.Command) {
 	po := &options.PublishOptions{}
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
+	ao := &options.AnnotateOptions{}
 	bo := &options.BuildOptions{}
+	vo := &options.ValidateOptions{}
+	var wait bool
+	var waitTimeout time.Duration
 	apply := &cobra.Command{
 		Use:   "apply -f FILENAME",
 		Short: "Apply the input files with image references resolved to built/pushed image digests.",
@@ -60,7 +68,19 @@ func addApply(topLevel *cobra.Command) {
   ko apply --local -f config/
 
   # Apply from stdin:
-  cat config.yaml | ko apply -f -`,
+  cat config.yaml | ko apply -f -
+
+  # Apply, then wait for the Deployments, StatefulSets and DaemonSets
+  # that were applied to finish rolling out.
+  ko apply --wait --wait-timeout=10m -f config/
+
+  # Validate the resolved yaml against the cluster's schema, and
+  # only apply it if it is valid.
+  ko apply --validate=cluster -f config/
+
+  # Apply each file as soon as its images are built, rather than
+  # waiting on the files before it.
+  ko apply --stream -f config/`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			if !isKubectlAvailable() {
@@ -68,13 +88,27 @@ func addApply(topLevel *cobra.Command) {
 				return
 			}
 
+			if wait && fo.Watch {
+				log.Fatal("--wait cannot be used with --watch")
+			}
+			if err := validateMode(vo.Validate); err != nil {
+				log.Fatal(err)
+			}
+			if vo.Validate != "" && fo.Watch {
+				log.Fatal("--validate cannot be used with --watch")
+			}
+
 			// Cancel on signals.
 			ctx := createCancellableContext()
 
+			if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
 			builder, err := makeBuilder(ctx, bo)
 			if err != nil {
 				log.Fatalf("error creating builder: %v", err)
 			}
+			po.WorkDir = bo.WorkDir
 			publisher, err := makePublisher(po)
 			if err != nil {
 				log.Fatalf("error creating publisher: %v", err)
@@ -95,58 +129,39 @@ func addApply(topLevel *cobra.Command) {
 				}
 			})
 
-			// Issue a "kubectl apply" command reading from stdin,
-			// to which we will pipe the resolved files.
-			argv := []string{"apply", "-f", "-"}
-			argv = append(argv, kubectlFlags...)
-			kubectlCmd := exec.CommandContext(ctx, "kubectl", argv...)
-
-			// Pass through our environment
-			kubectlCmd.Env = os.Environ()
-			// Pass through our std{out,err} and make our resolved buffer stdin.
-			kubectlCmd.Stderr = os.Stderr
-			kubectlCmd.Stdout = os.Stdout
-
-			// Wire up kubectl stdin to resolveFilesToWriter.
-			stdin, err := kubectlCmd.StdinPipe()
-			if err != nil {
-				log.Fatalf("error piping to 'kubectl apply': %v", err)
+			// Keep a copy of what we apply so we know what to wait on.
+			applied := &bytes.Buffer{}
+			var tee io.Writer
+			if wait {
+				tee = applied
+			}
+			if err := kubectlApply(ctx, kubectlFlags, vo.Validate, tee, func(ctx context.Context, out io.WriteCloser) error {
+				return resolveFilesToWriter(ctx, builder, publisher, fo, so, ao, out, nil)
+			}); err != nil {
+				log.Fatal(withHint(err))
 			}
 
-			// Make sure builds are cancelled if kubectl apply fails.
-			g, ctx := errgroup.WithContext(ctx)
-			g.Go(func() error {
-				// kubectl buffers data before starting to apply it, which
-				// can lead to resources being created more slowly than desired.
-				// In the case of --watch, it can lead to resources not being
-				// applied at all until enough iteration has occurred.  To work
-				// around this, we prime the stream with a bunch of empty objects
-				// which kubectl will discard.
-				// See https://github.com/google/go-containerregistry/pull/348
-				for i := 0; i < 1000; i++ {
-					stdin.Write([]byte("---\n"))
+			if wait {
+				targets, err := rolloutTargets(applied)
+				if err != nil {
+					log.Fatalf("error reading applied resources: %v", err)
 				}
-				// Once primed kick things off.
-				return resolveFilesToWriter(ctx, builder, publisher, fo, so, stdin)
-			})
-
-			g.Go(func() error {
-				// Run it.
-				if err := kubectlCmd.Run(); err != nil {
-					return fmt.Errorf("error executing 'kubectl apply': %v", err)
+				if err := waitForRollouts(ctx, targets, kubectlFlags, waitTimeout); err != nil {
+					log.Fatal(err)
 				}
-				return nil
-			})
-
-			if err := g.Wait(); err != nil {
-				log.Fatal(err)
 			}
 		},
 	}
 	options.AddPublishArg(apply, po)
 	options.AddFileArg(apply, fo)
 	options.AddSelectorArg(apply, so)
+	options.AddAnnotateArg(apply, ao)
 	options.AddBuildOptions(apply, bo)
+	options.AddValidateArg(apply, vo)
+	apply.Flags().BoolVar(&wait, "wait", false,
+		"After applying, wait for applied Deployments, StatefulSets and DaemonSets to finish rolling out.")
+	apply.Flags().DurationVar(&waitTimeout, "wait-timeout", 5*time.Minute,
+		"How long to wait for each rollout with --wait.")
 
 	// Collect the ko-specific apply flags before registering the kubectl global
 	// flags so that we can ignore them when passing kubectl global flags through
@@ -161,3 +176,76 @@ func addApply(topLevel *cobra.Command) {
 
 	topLevel.AddCommand(apply)
 }
+
+// kubectlApply pipes what resolve writes into "kubectl apply" with
+// kubectlFlags, and into tee if it isn't nil. With a validate mode, nothing
+// is applied until all of it has been validated. If either resolving or
+// kubectl fails, the other is cancelled.
+func kubectlApply(ctx context.Context, kubectlFlags []string, validate string, tee io.Writer, resolve func(context.Context, io.WriteCloser) error) error {
+	// Make sure builds are cancelled if kubectl apply fails, and kubectl
+	// apply is cancelled if builds fail.
+	g, gctx := errgroup.WithContext(ctx)
+
+	// Issue a "kubectl apply" command reading from stdin,
+	// to which we will pipe the resolved files.
+	argv := []string{"apply", "-f", "-"}
+	argv = append(argv, kubectlFlags...)
+	kubectlCmd := exec.CommandContext(gctx, "kubectl", argv...)
+
+	// Pass through our environment
+	kubectlCmd.Env = os.Environ()
+	// Pass through our std{out,err} and make our resolved buffer stdin.
+	kubectlCmd.Stderr = os.Stderr
+	kubectlCmd.Stdout = os.Stdout
+
+	// Wire up kubectl stdin to resolveFilesToWriter.
+	stdin, err := kubectlCmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("error piping to 'kubectl apply': %v", err)
+	}
+	var out io.WriteCloser = stdin
+	if tee != nil {
+		out = teeWriteCloser(stdin, nopWriteCloser{tee})
+	}
+
+	g.Go(func() error {
+		// However this ends, kubectl has to see the end of its input.
+		defer out.Close()
+
+		// kubectl buffers data before starting to apply it, which
+		// can lead to resources being created more slowly than desired.
+		// In the case of --watch, it can lead to resources not being
+		// applied at all until enough iteration has occurred.  To work
+		// around this, we prime the stream with a bunch of empty objects
+		// which kubectl will discard.
+		// See https://github.com/google/go-containerregistry/pull/348
+		for i := 0; i < 1000; i++ {
+			stdin.Write([]byte("---\n"))
+		}
+		// Once primed kick things off.
+		if validate == "" {
+			return resolve(gctx, out)
+		}
+
+		// Don't apply anything until all of it has been validated.
+		resolved := &bytes.Buffer{}
+		if err := resolve(gctx, nopWriteCloser{resolved}); err != nil {
+			return err
+		}
+		if err := validateDocuments(gctx, validate, resolved.Bytes(), kubectlFlags); err != nil {
+			return err
+		}
+		_, err := io.Copy(out, resolved)
+		return err
+	})
+
+	g.Go(func() error {
+		// Run it.
+		if err := kubectlCmd.Run(); err != nil {
+			return fmt.Errorf("error executing 'kubectl apply': %v", err)
+		}
+		return nil
+	})
+
+	return g.Wait()
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKubectlApplyValidationFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake kubectl is a shell script")
+	}
+	invalid := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicass: 1
+`
+	for name, script := range map[string]string{
+		// Waits for the end of its input, like kubectl apply -f -.
+		"reads stdin": "#!/bin/sh\ncat >/dev/null\n",
+		// Only stops when it is cancelled.
+		"hangs": "#!/bin/sh\nexec sleep 60\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer scriptedKubectl(t, script)()
+
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- kubectlApply(context.Background(), nil, "builtin", nil, func(_ context.Context, out io.WriteCloser) error {
+					defer out.Close()
+					_, err := io.WriteString(out, invalid)
+					return err
+				})
+			}()
+			select {
+			case err := <-errCh:
+				if err == nil || !strings.Contains(err.Error(), "validation failed") {
+					t.Errorf("kubectlApply() = %v, wanted a validation error", err)
+				}
+			case <-time.After(30 * time.Second):
+				t.Fatal("kubectlApply() didn't return after validation failed")
+			}
+		})
+	}
+}
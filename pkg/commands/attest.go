@@ -0,0 +1,313 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v0.1"
+
+	// buildPredicateType identifies the predicate of the statements that
+	// ko writes.
+	buildPredicateType = "https://github.com/google/ko/attestation/build/v1"
+)
+
+// attestedEnv are the environment variables that affect builds, and are
+// recorded in attestations. The rest of the environment isn't, since it may
+// hold secrets.
+var attestedEnv = []string{
+	"CGO_ENABLED", "GOAMD64", "GOARCH", "GOARM", "GOEXPERIMENT", "GOFLAGS",
+	"GONOSUMDB", "GOOS", "GOPRIVATE", "GOPROXY",
+	"KO_DEFAULTBASEIMAGE", "KO_DOCKER_REPO", "SOURCE_DATE_EPOCH",
+}
+
+// inTotoStatement is an in-toto statement about the images in Subject.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     buildPredicate  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// buildPredicate describes how ko produced an image.
+type buildPredicate struct {
+	KoVersion  string   `json:"koVersion"`
+	GoVersion  string   `json:"goVersion"`
+	ImportPath string   `json:"importPath"`
+	Platforms  []string `json:"platforms,omitempty"`
+	BaseDigest string   `json:"baseDigest,omitempty"`
+	// Flags are the flags that were set, on the command line or from
+	// .ko.yaml.
+	Flags map[string]string `json:"flags,omitempty"`
+	// Env is the part of the environment in attestedEnv that was set.
+	Env map[string]string `json:"env,omitempty"`
+	// Build is the entry of the builds section of .ko.yaml that applied.
+	Build   *build.Config    `json:"build,omitempty"`
+	Modules []moduleChecksum `json:"modules,omitempty"`
+}
+
+// moduleChecksum is a module that an image's binary was built from, with its
+// checksum from go.sum.
+type moduleChecksum struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+// buildDescriber describes how images were built, for attestations and
+// provenance.
+type buildDescriber struct {
+	flags map[string]string
+
+	// modules returns the modules that the binary of an import path is
+	// built from.
+	modules func(ctx context.Context, importpath string) ([]moduleChecksum, error)
+
+	once      sync.Once
+	goVersion string
+	err       error
+}
+
+// attestor is a publish.Interface that writes an in-toto statement about each
+// image published through it to dir.
+type attestor struct {
+	buildDescriber
+	inner publish.Interface
+	dir   string
+}
+
+func newAttestor(inner publish.Interface, dir string, flags map[string]string) (*attestor, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &attestor{
+		buildDescriber: buildDescriber{flags: flags, modules: listModules},
+		inner:          inner,
+		dir:            dir,
+	}, nil
+}
+
+// Publish implements publish.Interface
+func (a *attestor) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	ref, err := a.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	st, err := a.statement(ctx, br, s, ref)
+	if err != nil {
+		return nil, fmt.Errorf("attesting %s: %v", s, err)
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// Named like the tags cosign attaches attestations to.
+	d := st.Subject[0].Digest
+	file := filepath.Join(a.dir, "sha256-"+d["sha256"]+".intoto.json")
+	if err := ioutil.WriteFile(file, append(b, '\n'), 0644); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// Close implements publish.Interface
+func (a *attestor) Close() error {
+	return a.inner.Close()
+}
+
+func (a *attestor) statement(ctx context.Context, br build.Result, s string, ref name.Reference) (*inTotoStatement, error) {
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+	p, err := a.describe(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	return &inTotoStatement{
+		Type: inTotoStatementType,
+		Subject: []inTotoSubject{{
+			Name:   ref.Context().Name(),
+			Digest: map[string]string{h.Algorithm: h.Hex},
+		}},
+		PredicateType: buildPredicateType,
+		Predicate:     *p,
+	}, nil
+}
+
+// describe describes how br was built for the import path s.
+func (d *buildDescriber) describe(ctx context.Context, br build.Result, s string) (*buildPredicate, error) {
+	d.once.Do(func() {
+		var out []byte
+		out, d.err = exec.CommandContext(ctx, "go", "version").Output()
+		d.goVersion = strings.TrimSpace(string(out))
+	})
+	if d.err != nil {
+		return nil, fmt.Errorf("go version: %v", d.err)
+	}
+
+	ip := strings.TrimPrefix(s, build.StrictScheme)
+	p := &buildPredicate{
+		KoVersion:  version(),
+		GoVersion:  d.goVersion,
+		ImportPath: ip,
+		Flags:      d.flags,
+	}
+	platforms, err := build.Platforms(br)
+	if err != nil {
+		return nil, err
+	}
+	for _, pl := range platforms {
+		p.Platforms = append(p.Platforms, platformString(pl))
+	}
+	if bh, ok := build.BaseDigest(br); ok {
+		p.BaseDigest = bh.String()
+	}
+	for _, env := range attestedEnv {
+		if v, ok := os.LookupEnv(env); ok {
+			if p.Env == nil {
+				p.Env = make(map[string]string)
+			}
+			p.Env[env] = v
+		}
+	}
+	for _, c := range buildConfigs {
+		if ok, _ := path.Match(c.ID, ip); ok {
+			c := c
+			p.Build = &c
+			break
+		}
+	}
+	if p.Modules, err = d.modules(ctx, ip); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// setFlags returns the flags of cmd that were set, on the command line or from
+// .ko.yaml, by name.
+func setFlags(cmd *cobra.Command) map[string]string {
+	flags := map[string]string{}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return flags
+}
+
+// listModules returns the modules that provide the packages importpath
+// depends on, with their checksums from the go.sum of the main module.
+func listModules(ctx context.Context, importpath string) ([]moduleChecksum, error) {
+	gomod, err := exec.CommandContext(ctx, "go", "env", "GOMOD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env GOMOD: %v", err)
+	}
+	sums := map[string]string{}
+	if f := strings.TrimSpace(string(gomod)); f != "" && f != os.DevNull {
+		b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(f), "go.sum"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		sums = parseGoSum(b)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "list", "-deps", "-json", importpath)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -deps %s: %v: %s", importpath, err, strings.TrimSpace(stderr.String()))
+	}
+	seen := map[string]bool{}
+	var mods []moduleChecksum
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var p struct {
+			Module *struct {
+				Path    string
+				Version string
+				Replace *struct {
+					Path    string
+					Version string
+				}
+			}
+		}
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading go list -deps output: %v", err)
+		}
+		m := p.Module
+		if m == nil {
+			continue
+		}
+		mc := moduleChecksum{Path: m.Path, Version: m.Version}
+		if m.Replace != nil {
+			mc = moduleChecksum{Path: m.Replace.Path, Version: m.Replace.Version}
+		}
+		// The main module and directory replacements have no version,
+		// and no checksum.
+		if mc.Version == "" || seen[mc.Path] {
+			continue
+		}
+		seen[mc.Path] = true
+		mc.Sum = sums[mc.Path+" "+mc.Version]
+		mods = append(mods, mc)
+	}
+	sort.Slice(mods, func(i, j int) bool {
+		return mods[i].Path < mods[j].Path
+	})
+	return mods, nil
+}
+
+// parseGoSum maps "<module> <version>" to the checksum of the module's
+// contents in the go.sum b.
+func parseGoSum(b []byte) map[string]string {
+	sums := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) != 3 || strings.HasSuffix(f[1], "/go.mod") {
+			continue
+		}
+		sums[f[0]+" "+f[1]] = f[2]
+	}
+	return sums
+}
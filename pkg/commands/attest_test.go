@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestParseGoSum(t *testing.T) {
+	got := parseGoSum([]byte(`github.com/google/go-cmp v0.5.4 h1:L8R9j+yAqZuZjsqh/z+F1NCffTKKLShY6zXTItVIZ8M=
+github.com/google/go-cmp v0.5.4/go.mod h1:v8dTdLbMG2kIc/vJvl+f65V22dbkXbowE6jgT/gNBxE=
+garbage
+`))
+	want := map[string]string{
+		"github.com/google/go-cmp v0.5.4": "h1:L8R9j+yAqZuZjsqh/z+F1NCffTKKLShY6zXTItVIZ8M=",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseGoSum() (-want +got) = %v", diff)
+	}
+}
+
+func TestAttestor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-attest")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(bc []build.Config) {
+		buildConfigs = bc
+	}(buildConfigs)
+	buildConfigs = []build.Config{{ID: "github.com/foo/*", Ldflags: []string{"-s"}}}
+
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("CGO_ENABLED", v)
+		} else {
+			os.Unsetenv("CGO_ENABLED")
+		}
+	}(os.LookupEnv("CGO_ENABLED"))
+	os.Setenv("CGO_ENABLED", "0")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	inner := nopPublisher{repoName: "gcr.io/project", namer: options.MakeNamer(&options.PublishOptions{Bare: true})}
+	a, err := newAttestor(inner, filepath.Join(dir, "attestations"), map[string]string{"platform": "linux/arm64"})
+	if err != nil {
+		t.Fatalf("newAttestor() = %v", err)
+	}
+	mods := []moduleChecksum{{Path: "github.com/google/go-cmp", Version: "v0.5.4", Sum: "h1:abc="}}
+	a.modules = func(_ context.Context, ip string) ([]moduleChecksum, error) {
+		if ip != "github.com/foo/bar" {
+			t.Errorf("modules(%q), want the import path without ko://", ip)
+		}
+		return mods, nil
+	}
+	if _, err := a.Publish(context.Background(), img, build.StrictScheme+"github.com/foo/bar"); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "attestations", "sha256-"+h.Hex+".intoto.json"))
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	var st inTotoStatement
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if st.Type != inTotoStatementType || st.PredicateType != buildPredicateType {
+		t.Errorf("statement types = %q, %q", st.Type, st.PredicateType)
+	}
+	wantSubject := []inTotoSubject{{Name: "gcr.io/project", Digest: map[string]string{"sha256": h.Hex}}}
+	if diff := cmp.Diff(wantSubject, st.Subject); diff != "" {
+		t.Errorf("subject (-want +got) = %v", diff)
+	}
+	p := st.Predicate
+	if p.ImportPath != "github.com/foo/bar" {
+		t.Errorf("importPath = %q", p.ImportPath)
+	}
+	if p.GoVersion == "" {
+		t.Error("goVersion is empty")
+	}
+	if got := p.Env["CGO_ENABLED"]; got != "0" {
+		t.Errorf("env[CGO_ENABLED] = %q, want 0", got)
+	}
+	if diff := cmp.Diff(map[string]string{"platform": "linux/arm64"}, p.Flags); diff != "" {
+		t.Errorf("flags (-want +got) = %v", diff)
+	}
+	if diff := cmp.Diff(&buildConfigs[0], p.Build); diff != "" {
+		t.Errorf("build (-want +got) = %v", diff)
+	}
+	if diff := cmp.Diff(mods, p.Modules); diff != "" {
+		t.Errorf("modules (-want +got) = %v", diff)
+	}
+}
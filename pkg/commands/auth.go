@@ -0,0 +1,252 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	cranecmd "github.com/google/go-containerregistry/cmd/crane/cmd"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+)
+
+// addAuth augments our CLI surface with auth.
+func addAuth(topLevel *cobra.Command) {
+	auth := &cobra.Command{
+		Use:   "auth",
+		Short: "Diagnose registry authentication.",
+		Args:  cobra.NoArgs,
+	}
+
+	auth.AddCommand(&cobra.Command{
+		Use:   "check",
+		Short: "Check that the current credentials can push to KO_DOCKER_REPO and pull base images.",
+		Long:  `This sub-command reports which registries the keychain (e.g. ~/.docker/config.json and its credential helpers) has credentials for, and checks that they can push to KO_DOCKER_REPO and pull the base images of .ko.yaml, so that auth failures are found before a long build.`,
+		Example: `
+  # Check credentials before building.
+  ko auth check`,
+		Args: cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			ctx := createCancellableContext()
+			ok := true
+			if creds, err := credentials(); err != nil {
+				ok = false
+				fmt.Printf("[FAIL] credentials: %v\n", err)
+			} else {
+				fmt.Println("[ OK ] credentials")
+				for _, c := range creds {
+					fmt.Printf("       %s\n", c)
+				}
+			}
+			if !runDoctor(ctx, os.Stdout, authChecks) || !ok {
+				os.Exit(1)
+			}
+		},
+	})
+
+	var push bool
+	token := &cobra.Command{
+		Use:   "token [REPOSITORY]",
+		Short: "Print a registry bearer token for debugging.",
+		Long:  `This sub-command exchanges the current credentials for a bearer token scoped to pull from (or, with --push, push to) a repository, which defaults to KO_DOCKER_REPO, and prints it. The token is a secret: don't paste it anywhere public.`,
+		Example: `
+  # Inspect the access that KO_DOCKER_REPO grants.
+  ko auth token --push | cut -d. -f2 | base64 -d
+
+  # Try the registry API by hand.
+  curl -H "Authorization: Bearer $(ko auth token gcr.io/my-project/app)" https://gcr.io/v2/my-project/app/tags/list`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			ctx := createCancellableContext()
+			repoName := os.Getenv("KO_DOCKER_REPO")
+			if len(args) == 1 {
+				repoName = args[0]
+			}
+			if repoName == "" {
+				log.Fatal("pass a repository, or set KO_DOCKER_REPO")
+			}
+			repo, err := name.NewRepository(repoName)
+			if err != nil {
+				log.Fatalf("error parsing %q as a repository: %v", repoName, err)
+			}
+			tok, err := registryToken(ctx, repo, keychain, push, http.DefaultTransport)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(tok)
+		},
+	}
+	token.Flags().BoolVar(&push, "push", push,
+		"Ask for a token that can push to the repository, not just pull from it.")
+	scopeFlag(token, "push")
+	auth.AddCommand(token)
+
+	// ko auth used to be crane's auth group, so keep its commands working
+	// (ko auth login, and ko auth get as a credential helper).
+	for _, cmd := range cranecmd.NewCmdAuth("ko", "auth").Commands() {
+		cmd.Hidden = true
+		auth.AddCommand(cmd)
+	}
+
+	topLevel.AddCommand(auth)
+}
+
+var authChecks = []doctorCheck{
+	{"KO_DOCKER_REPO", checkDockerRepo},
+	{"base images", checkBaseImages},
+}
+
+// authRegistries returns the registries that ko talks to: that of
+// KO_DOCKER_REPO, and those of the base images.
+func authRegistries() []name.Registry {
+	seen := map[string]bool{}
+	var regs []name.Registry
+	add := func(reg name.Registry) {
+		if !seen[reg.Name()] {
+			seen[reg.Name()] = true
+			regs = append(regs, reg)
+		}
+	}
+	switch repoName := os.Getenv("KO_DOCKER_REPO"); repoName {
+	case "", publish.LocalDomain, publish.KindDomain:
+	default:
+		if repo, err := name.NewRepository(repoName); err == nil {
+			add(repo.Registry)
+		} else if reg, err := name.NewRegistry(repoName); err == nil {
+			add(reg)
+		}
+	}
+	if configErr == nil && defaultBaseImage != nil {
+		for _, ref := range baseImages(defaultBaseImage) {
+			add(ref.Context().Registry)
+		}
+	}
+	return regs
+}
+
+// describeCredentials says what kind of credentials auth has, without giving
+// away any secrets.
+func describeCredentials(auth authn.Authenticator) (string, error) {
+	if auth == authn.Anonymous {
+		return "anonymous", nil
+	}
+	cfg, err := auth.Authorization()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case cfg.RegistryToken != "":
+		return "a registry token", nil
+	case cfg.IdentityToken != "":
+		return "an identity token", nil
+	case cfg.Username != "":
+		return fmt.Sprintf("username %q", cfg.Username), nil
+	case cfg.Auth != "":
+		return "basic auth", nil
+	default:
+		return "anonymous", nil
+	}
+}
+
+// credentials describes the credentials that the keychain has for each of
+// authRegistries.
+func credentials() ([]string, error) {
+	var lines, failed []string
+	for _, reg := range authRegistries() {
+		auth, err := keychain.Resolve(reg)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", reg, err))
+			continue
+		}
+		desc, err := describeCredentials(auth)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", reg, err))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", reg, desc))
+	}
+	if len(failed) != 0 {
+		return nil, fmt.Errorf("can't read credentials:\n  %s", strings.Join(failed, "\n  "))
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
+
+// bearerRecorder remembers the last bearer token sent to a registry.
+type bearerRecorder struct {
+	inner http.RoundTripper
+	host  string
+
+	mu    sync.Mutex
+	token string
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *bearerRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if h := req.Header.Get("Authorization"); req.URL.Host == r.host && strings.HasPrefix(h, "Bearer ") {
+		r.mu.Lock()
+		r.token = strings.TrimPrefix(h, "Bearer ")
+		r.mu.Unlock()
+	}
+	return r.inner.RoundTrip(req)
+}
+
+// registryToken returns the bearer token that the credentials of kc for repo
+// are exchanged for, scoped to pull from it (and push to it, if push).
+func registryToken(ctx context.Context, repo name.Repository, kc authn.Keychain, push bool, t http.RoundTripper) (string, error) {
+	auth, err := kc.Resolve(repo.Registry)
+	if err != nil {
+		return "", err
+	}
+	scope := repo.Scope(transport.PullScope)
+	if push {
+		scope = repo.Scope(transport.PushScope)
+	}
+	rec := &bearerRecorder{inner: t, host: repo.RegistryStr()}
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, rec, []string{scope})
+	if err != nil {
+		return "", fmt.Errorf("getting a token for %s: %v", repo, err)
+	}
+
+	// Send a request, to see the token that it's sent with.
+	u := fmt.Sprintf("%s://%s/v2/", repo.Registry.Scheme(), repo.RegistryStr())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.token == "" {
+		return "", errors.New(repo.RegistryStr() + " doesn't use bearer tokens")
+	}
+	return rec.token, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/spf13/cobra"
+)
+
+// staticKeychain resolves every registry to the same credentials.
+type staticKeychain struct{ auth authn.Authenticator }
+
+func (k staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) { return k.auth, nil }
+
+func TestRegistryToken(t *testing.T) {
+	var scopes []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			scopes = append(scopes, r.URL.Query().Get("scope"))
+			fmt.Fprint(w, `{"token": "sekrit"}`)
+		case "/v2/":
+			if r.Header.Get("Authorization") != "Bearer sekrit" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="test"`, r.Host))
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", s.URL, err)
+	}
+	repo, err := name.NewRepository(u.Host + "/my/app")
+	if err != nil {
+		t.Fatalf("NewRepository() = %v", err)
+	}
+
+	kc := staticKeychain{&authn.Basic{Username: "user", Password: "pass"}}
+	for _, push := range []bool{false, true} {
+		tok, err := registryToken(context.Background(), repo, kc, push, http.DefaultTransport)
+		if err != nil {
+			t.Fatalf("registryToken() = %v", err)
+		}
+		if tok != "sekrit" {
+			t.Errorf("registryToken() = %q, want sekrit", tok)
+		}
+	}
+	if len(scopes) != 2 || !strings.HasSuffix(scopes[0], ":pull") || !strings.HasSuffix(scopes[1], ":push,pull") {
+		t.Errorf("scopes = %q, want pull then push,pull", scopes)
+	}
+
+	if _, err := registryToken(context.Background(), repo, staticKeychain{&authn.Basic{Username: "user", Password: "wrong"}}, false, http.DefaultTransport); err == nil {
+		t.Error("registryToken() = nil, wanted an error with the wrong password")
+	}
+}
+
+func TestDescribeCredentials(t *testing.T) {
+	for _, c := range []struct {
+		auth authn.Authenticator
+		want string
+	}{
+		{authn.Anonymous, "anonymous"},
+		{&authn.Basic{Username: "oauth2accesstoken", Password: "secret"}, `username "oauth2accesstoken"`},
+		{&authn.Bearer{Token: "secret"}, "a registry token"},
+		{authn.FromConfig(authn.AuthConfig{IdentityToken: "secret"}), "an identity token"},
+	} {
+		got, err := describeCredentials(c.auth)
+		if err != nil {
+			t.Fatalf("describeCredentials() = %v", err)
+		}
+		if got != c.want {
+			t.Errorf("describeCredentials() = %q, want %q", got, c.want)
+		}
+		if strings.Contains(got, "secret") {
+			t.Errorf("describeCredentials() = %q, which gives away the secret", got)
+		}
+	}
+}
+
+func TestAuthKeepsCraneCommands(t *testing.T) {
+	topLevel := &cobra.Command{Use: "ko"}
+	addAuth(topLevel)
+	for _, args := range [][]string{{"auth", "login", "registry.example.com"}, {"auth", "get"}, {"auth", "check"}} {
+		cmd, _, err := topLevel.Find(args)
+		if err != nil {
+			t.Fatalf("Find(%v) = %v", args, err)
+		}
+		if cmd.Name() != args[1] {
+			t.Errorf("Find(%v) = %s, want %s", args, cmd.CommandPath(), args[1])
+		}
+	}
+}
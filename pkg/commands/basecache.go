@@ -0,0 +1,393 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+)
+
+// metadataCache stores the manifests and config files of base images on
+// disk, by digest, so that they are fetched from the registry once rather
+// than on every run.
+type metadataCache struct {
+	store metadataStore
+
+	// digests remembers what each tag resolved to during this run, so that
+	// import paths that share a base only resolve it once.
+	digests sync.Map
+}
+
+// metadataDir is where base image metadata is cached: under $KO_CACHE if it
+// is set, or else the user's cache directory.
+func metadataDir() (string, error) {
+	if dir := os.Getenv("KO_CACHE"); dir != "" {
+		return filepath.Join(dir, "metadata"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ko", "metadata"), nil
+}
+
+// resolve returns the digest of ref, asking the registry at most once per
+// run, and not at all if ref is already a digest.
+func (c *metadataCache) resolve(ref name.Reference, ropt []remote.Option) (v1.Hash, error) {
+	if d, ok := ref.(name.Digest); ok {
+		return v1.NewHash(d.DigestStr())
+	}
+	if h, ok := c.digests.Load(ref.String()); ok {
+		return h.(v1.Hash), nil
+	}
+	desc, err := remote.Head(ref, ropt...)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	c.digests.Store(ref.String(), desc.Digest)
+	return desc.Digest, nil
+}
+
+// blob returns the contents of the blob with digest h from the cache, or
+// else fetches and caches them.
+func (c *metadataCache) blob(h v1.Hash, fetch func() ([]byte, error)) ([]byte, error) {
+	if b, err := c.store.get(h); err == nil {
+		// Refetch anything that was truncated or corrupted.
+		if got, _, err := v1.SHA256(bytes.NewReader(b)); err == nil && got == h {
+			return b, nil
+		}
+	}
+	b, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if got, _, err := v1.SHA256(bytes.NewReader(b)); err != nil {
+		return nil, err
+	} else if got != h {
+		return nil, fmt.Errorf("fetched %s, but its digest is %s", h, got)
+	}
+	if err := c.store.put(h, b); err != nil {
+		log.Printf("Unable to cache %s: %v", h, err)
+	}
+	return b, nil
+}
+
+// writeAtomic writes b to path via a temporary file, so that concurrent
+// runs never read a partial file.
+func writeAtomic(path string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// fetch fetches the base image ref through the cache. Unless platform is
+// nil, an index is resolved to its image for that platform.
+func (c *metadataCache) fetch(ref name.Reference, platform *v1.Platform, ropt []remote.Option) (build.Result, error) {
+	h, err := c.resolve(ref, ropt)
+	if err != nil {
+		return nil, err
+	}
+	base, err := c.load(ref.Context(), h, ropt)
+	if err != nil {
+		return nil, err
+	}
+	idx, ok := base.(v1.ImageIndex)
+	if !ok || platform == nil {
+		return base, nil
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, desc := range im.Manifests {
+		// Like remote, assume that children without a platform are
+		// linux/amd64.
+		p := v1.Platform{OS: "linux", Architecture: "amd64"}
+		if desc.Platform != nil {
+			p = *desc.Platform
+		}
+		if p.OS != platform.OS || p.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant != "" && p.Variant != platform.Variant {
+			continue
+		}
+		return c.load(ref.Context(), desc.Digest, ropt)
+	}
+	return nil, fmt.Errorf("no child with platform %s/%s in index %s", platform.OS, platform.Architecture, ref)
+}
+
+// load returns the image or index with digest h in repo.
+func (c *metadataCache) load(repo name.Repository, h v1.Hash, ropt []remote.Option) (build.Result, error) {
+	raw, err := c.blob(h, func() ([]byte, error) {
+		desc, err := remote.Get(repo.Digest(h.String()), ropt...)
+		if err != nil {
+			return nil, err
+		}
+		return desc.Manifest, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var m struct {
+		MediaType types.MediaType `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	mt := m.MediaType
+	if mt == "" {
+		// OCI manifests don't have to say what they are.
+		mt = types.OCIManifestSchema1
+		if m.Manifests != nil {
+			mt = types.OCIImageIndex
+		}
+	}
+	switch {
+	case mt.IsIndex():
+		return &cachedIndex{c: c, repo: repo, raw: raw, mt: mt, ropt: ropt}, nil
+	case mt.IsImage():
+		manifest, err := v1.ParseManifest(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		img, err := partial.CompressedToImage(&cachedImageCore{c: c, repo: repo, raw: raw, mt: mt, manifest: manifest, ropt: ropt})
+		if err != nil {
+			return nil, err
+		}
+		return &mountableImage{Image: img, ref: repo.Digest(h.String())}, nil
+	default:
+		return nil, fmt.Errorf("base %s@%s has unsupported media type %s", repo, h, mt)
+	}
+}
+
+// cachedImageCore is an image whose manifest and config file are read
+// through the cache, and whose layers are read from the registry.
+type cachedImageCore struct {
+	c        *metadataCache
+	repo     name.Repository
+	raw      []byte
+	mt       types.MediaType
+	manifest *v1.Manifest
+	ropt     []remote.Option
+}
+
+var _ partial.CompressedImageCore = (*cachedImageCore)(nil)
+
+// MediaType implements partial.CompressedImageCore
+func (i *cachedImageCore) MediaType() (types.MediaType, error) { return i.mt, nil }
+
+// RawManifest implements partial.CompressedImageCore
+func (i *cachedImageCore) RawManifest() ([]byte, error) { return i.raw, nil }
+
+// RawConfigFile implements partial.CompressedImageCore
+func (i *cachedImageCore) RawConfigFile() ([]byte, error) {
+	h := i.manifest.Config.Digest
+	return i.c.blob(h, func() ([]byte, error) {
+		l, err := remote.Layer(i.repo.Digest(h.String()), i.ropt...)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := l.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	})
+}
+
+// LayerByDigest implements partial.CompressedImageCore
+func (i *cachedImageCore) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	for k, desc := range i.manifest.Layers {
+		if desc.Digest != h {
+			continue
+		}
+		// Take the diffid from the config file, since computing it would
+		// mean reading the layer.
+		raw, err := i.RawConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		cfg, err := v1.ParseConfigFile(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		if k >= len(cfg.RootFS.DiffIDs) {
+			return nil, fmt.Errorf("image %s has more layers than diffids", i.repo)
+		}
+		return &describedLayer{ref: i.repo.Digest(h.String()), desc: desc, diffID: cfg.RootFS.DiffIDs[k], ropt: i.ropt}, nil
+	}
+	return nil, fmt.Errorf("no layer %s in image %s", h, i.repo)
+}
+
+// describedLayer is a layer in a registry whose size, media type and diffid
+// come from its image, and which is only fetched once it is read.
+type describedLayer struct {
+	ref    name.Digest
+	desc   v1.Descriptor
+	diffID v1.Hash
+	ropt   []remote.Option
+}
+
+// Digest implements partial.CompressedLayer
+func (l *describedLayer) Digest() (v1.Hash, error) { return l.desc.Digest, nil }
+
+// DiffID implements partial.WithDiffID
+func (l *describedLayer) DiffID() (v1.Hash, error) { return l.diffID, nil }
+
+// Compressed implements partial.CompressedLayer
+func (l *describedLayer) Compressed() (io.ReadCloser, error) {
+	rl, err := remote.Layer(l.ref, l.ropt...)
+	if err != nil {
+		return nil, err
+	}
+	return rl.Compressed()
+}
+
+// Size implements partial.CompressedLayer
+func (l *describedLayer) Size() (int64, error) { return l.desc.Size, nil }
+
+// MediaType implements partial.CompressedLayer
+func (l *describedLayer) MediaType() (types.MediaType, error) { return l.desc.MediaType, nil }
+
+// mountableImage makes the layers of an image mountable from ref when they
+// are published, like the images of remote are, so that publishing to
+// another repository of the same registry never reads them.
+type mountableImage struct {
+	v1.Image
+	ref name.Reference
+}
+
+// Layers implements v1.Image
+func (i *mountableImage) Layers() ([]v1.Layer, error) {
+	ls, err := i.Image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	mls := make([]v1.Layer, 0, len(ls))
+	for _, l := range ls {
+		mls = append(mls, i.mountable(l))
+	}
+	return mls, nil
+}
+
+// LayerByDigest implements v1.Image
+func (i *mountableImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	l, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.mountable(l), nil
+}
+
+// LayerByDiffID implements v1.Image
+func (i *mountableImage) LayerByDiffID(h v1.Hash) (v1.Layer, error) {
+	l, err := i.Image.LayerByDiffID(h)
+	if err != nil {
+		return nil, err
+	}
+	return i.mountable(l), nil
+}
+
+func (i *mountableImage) mountable(l v1.Layer) v1.Layer {
+	if _, ok := l.(*remote.MountableLayer); ok || i.ref == nil {
+		return l
+	}
+	return &remote.MountableLayer{Layer: l, Reference: i.ref}
+}
+
+// cachedIndex is an index whose manifest, and those of its children, are
+// read through the cache.
+type cachedIndex struct {
+	c    *metadataCache
+	repo name.Repository
+	raw  []byte
+	mt   types.MediaType
+	ropt []remote.Option
+}
+
+var _ v1.ImageIndex = (*cachedIndex)(nil)
+
+// MediaType implements v1.ImageIndex
+func (i *cachedIndex) MediaType() (types.MediaType, error) { return i.mt, nil }
+
+// Digest implements v1.ImageIndex
+func (i *cachedIndex) Digest() (v1.Hash, error) { return partial.Digest(i) }
+
+// Size implements v1.ImageIndex
+func (i *cachedIndex) Size() (int64, error) { return partial.Size(i) }
+
+// IndexManifest implements v1.ImageIndex
+func (i *cachedIndex) IndexManifest() (*v1.IndexManifest, error) {
+	return v1.ParseIndexManifest(bytes.NewReader(i.raw))
+}
+
+// RawManifest implements v1.ImageIndex
+func (i *cachedIndex) RawManifest() ([]byte, error) { return i.raw, nil }
+
+// Image implements v1.ImageIndex
+func (i *cachedIndex) Image(h v1.Hash) (v1.Image, error) {
+	res, err := i.c.load(i.repo, h, i.ropt)
+	if err != nil {
+		return nil, err
+	}
+	img, ok := res.(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("child %s of index %s is not an image", h, i.repo)
+	}
+	return img, nil
+}
+
+// ImageIndex implements v1.ImageIndex
+func (i *cachedIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	res, err := i.c.load(i.repo, h, i.ropt)
+	if err != nil {
+		return nil, err
+	}
+	idx, ok := res.(v1.ImageIndex)
+	if !ok {
+		return nil, fmt.Errorf("child %s of index %s is not an index", h, i.repo)
+	}
+	return idx, nil
+}
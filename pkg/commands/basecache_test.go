@@ -0,0 +1,401 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestMetadataCache(t *testing.T) {
+	// Count the requests for manifests and blobs.
+	var manifests, blobs int32
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/manifests/") && r.Method == http.MethodGet:
+			atomic.AddInt32(&manifests, 1)
+		case strings.Contains(r.URL.Path, "/blobs/") && r.Method == http.MethodGet:
+			atomic.AddInt32(&blobs, 1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	dir, err := ioutil.TempDir("", "ko-metadata")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	tag, err := name.NewTag(u.Host + "/base:latest")
+	if err != nil {
+		t.Fatalf("NewTag() = %v", err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	fetch := func(ref name.Reference) v1.Image {
+		t.Helper()
+		// Each fetch stands for a separate run of ko.
+		c := &metadataCache{store: dirStore(dir)}
+		base, err := c.fetch(ref, &v1.Platform{OS: "linux", Architecture: "amd64"}, nil)
+		if err != nil {
+			t.Fatalf("fetch() = %v", err)
+		}
+		img, ok := base.(v1.Image)
+		if !ok {
+			t.Fatalf("fetch() = %T, want an image", base)
+		}
+		if got, err := img.Digest(); err != nil {
+			t.Fatalf("Digest() = %v", err)
+		} else if got != want {
+			t.Errorf("Digest() = %v, want %v", got, want)
+		}
+		if _, err := img.ConfigFile(); err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		return img
+	}
+
+	fetch(tag)
+	if manifests != 1 || blobs != 1 {
+		t.Errorf("first fetch made %d manifest and %d blob requests, want 1 and 1", manifests, blobs)
+	}
+
+	// Tags are still resolved, but nothing else is fetched again.
+	fetch(tag)
+	if manifests != 1 || blobs != 1 {
+		t.Errorf("second fetch made %d manifest and %d blob requests, want none", manifests-1, blobs-1)
+	}
+
+	// Digests don't need the registry at all, until layers are read.
+	server.Close()
+	cached := fetch(tag.Context().Digest(want.String()))
+	ls, err := cached.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	if len(ls) != 3 {
+		t.Errorf("len(Layers()) = %d, want 3", len(ls))
+	}
+	for _, l := range ls {
+		if _, ok := l.(*remote.MountableLayer); !ok {
+			t.Errorf("Layers() = %T, want mountable layers", l)
+		}
+	}
+}
+
+func TestMetadataCacheIndex(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	dir, err := ioutil.TempDir("", "ko-metadata")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	tag, err := name.NewTag(u.Host + "/base:latest")
+	if err != nil {
+		t.Fatalf("NewTag() = %v", err)
+	}
+	if err := remote.WriteIndex(tag, idx); err != nil {
+		t.Fatalf("WriteIndex() = %v", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+
+	c := &metadataCache{store: dirStore(dir)}
+	base, err := c.fetch(tag, nil, nil)
+	if err != nil {
+		t.Fatalf("fetch() = %v", err)
+	}
+	got, ok := base.(v1.ImageIndex)
+	if !ok {
+		t.Fatalf("fetch() = %T, want an index", base)
+	}
+	for _, desc := range im.Manifests {
+		child, err := got.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("Image(%v) = %v", desc.Digest, err)
+		}
+		if h, err := child.Digest(); err != nil {
+			t.Fatalf("Digest() = %v", err)
+		} else if h != desc.Digest {
+			t.Errorf("Image(%v) = %v", desc.Digest, h)
+		}
+	}
+
+	// Children without a platform are linux/amd64, so the first one matches.
+	base, err = c.fetch(tag, &v1.Platform{OS: "linux", Architecture: "amd64"}, nil)
+	if err != nil {
+		t.Fatalf("fetch() = %v", err)
+	}
+	if h, err := base.Digest(); err != nil {
+		t.Fatalf("Digest() = %v", err)
+	} else if h != im.Manifests[0].Digest {
+		t.Errorf("fetch() = %v, want %v", h, im.Manifests[0].Digest)
+	}
+	if _, err := c.fetch(tag, &v1.Platform{OS: "linux", Architecture: "s390x"}, nil); err == nil {
+		t.Error("fetch() = nil, want an error for a platform that isn't in the index")
+	}
+}
+
+func TestFetchBaseMountsLayers(t *testing.T) {
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ls, err := img.Layers()
+	if err != nil {
+		t.Fatalf("Layers() = %v", err)
+	}
+	baseLayers := map[string]bool{}
+	for _, l := range ls {
+		h, err := l.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		baseLayers[h.String()] = true
+	}
+
+	// Count the reads of the base's layers.
+	var reads int32
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && baseLayers[path.Base(r.URL.Path)] {
+			atomic.AddInt32(&reads, 1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	tag, err := name.NewTag(u.Host + "/base:latest")
+	if err != nil {
+		t.Fatalf("NewTag() = %v", err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "ko-cache")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c cache.Cache, m *metadataCache) {
+		baseCache, baseMetadata = c, m
+	}(baseCache, baseMetadata)
+
+	for _, tc := range []struct {
+		name     string
+		cache    cache.Cache
+		metadata *metadataCache
+	}{{
+		name: "remote",
+	}, {
+		name:     "metadata cache",
+		metadata: &metadataCache{store: dirStore(filepath.Join(dir, "metadata"))},
+	}, {
+		name:     "layer cache",
+		cache:    cache.NewFilesystemCache(filepath.Join(dir, "layers")),
+		metadata: &metadataCache{store: dirStore(filepath.Join(dir, "metadata"))},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			baseCache, baseMetadata = tc.cache, tc.metadata
+			atomic.StoreInt32(&reads, 0)
+
+			base, err := fetchBase(context.Background(), tag, "linux/amd64")
+			if err != nil {
+				t.Fatalf("fetchBase() = %v", err)
+			}
+			added, err := random.Layer(1024, types.DockerLayer)
+			if err != nil {
+				t.Fatalf("random.Layer() = %v", err)
+			}
+			built, err := mutate.AppendLayers(base.(v1.Image), added)
+			if err != nil {
+				t.Fatalf("AppendLayers() = %v", err)
+			}
+			dst, err := name.NewTag(u.Host + "/app-" + strings.ReplaceAll(tc.name, " ", "-") + ":latest")
+			if err != nil {
+				t.Fatalf("NewTag() = %v", err)
+			}
+			if err := remote.Write(dst, built); err != nil {
+				t.Fatalf("Write() = %v", err)
+			}
+			if reads != 0 {
+				t.Errorf("publishing read %d layers of the base, want them mounted", reads)
+			}
+		})
+	}
+}
+
+func TestFetchBaseSinglePlatform(t *testing.T) {
+	amd64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	arm64, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        amd64,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	}, mutate.IndexAddendum{
+		Add:        arm64,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}},
+	})
+
+	// Record every manifest and blob read, so that we can tell whether
+	// anything of the amd64 child was fetched.
+	var mu sync.Mutex
+	read := map[string]bool{}
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			read[path.Base(r.URL.Path)] = true
+			mu.Unlock()
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	tag, err := name.NewTag(u.Host + "/base:latest")
+	if err != nil {
+		t.Fatalf("NewTag() = %v", err)
+	}
+	if err := remote.WriteIndex(tag, idx); err != nil {
+		t.Fatalf("WriteIndex() = %v", err)
+	}
+	unwanted := map[string]bool{}
+	for _, f := range []func() (v1.Hash, error){amd64.Digest, amd64.ConfigName} {
+		h, err := f()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		unwanted[h.String()] = true
+	}
+	want, err := arm64.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "ko-metadata")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(c cache.Cache, m *metadataCache) {
+		baseCache, baseMetadata = c, m
+	}(baseCache, baseMetadata)
+
+	for _, tc := range []struct {
+		name     string
+		platform string
+		metadata *metadataCache
+	}{{
+		name:     "remote",
+		platform: "linux/arm64",
+	}, {
+		name:     "metadata cache",
+		platform: "linux/arm64",
+		metadata: &metadataCache{store: dirStore(dir)},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			baseCache, baseMetadata = nil, tc.metadata
+			mu.Lock()
+			read = map[string]bool{}
+			mu.Unlock()
+
+			base, err := fetchBase(context.Background(), tag, tc.platform)
+			if err != nil {
+				t.Fatalf("fetchBase() = %v", err)
+			}
+			img, ok := base.(v1.Image)
+			if !ok {
+				t.Fatalf("fetchBase() = %T, want an image", base)
+			}
+			if got, err := img.Digest(); err != nil {
+				t.Fatalf("Digest() = %v", err)
+			} else if got != want {
+				t.Errorf("Digest() = %v, want %v", got, want)
+			}
+			if _, err := img.ConfigFile(); err != nil {
+				t.Fatalf("ConfigFile() = %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for h := range unwanted {
+				if read[h] {
+					t.Errorf("fetched %s of the linux/amd64 child", h)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/spf13/cobra"
+)
+
+// addCheckBaseUpdates augments our CLI surface with check-base-updates.
+func addCheckBaseUpdates(topLevel *cobra.Command) {
+	var (
+		lockfilePath string
+		scan         string
+		severity     string
+		platform     string
+		fail         bool
+	)
+
+	check := &cobra.Command{
+		Use:   "check-base-updates",
+		Short: "Report import paths that are built on base images that have since been updated.",
+		Long: `This sub-command compares the base images that import paths were built on, as recorded in the lockfile by ko resolve --lock, and the base images that .ko.yaml pins by tag and digest (e.g. gcr.io/distroless/static:nonroot@sha256:...), against what their tags point to now, and reports which are stale.
+
+With --scan, it also counts the vulnerabilities in the old and the new base images, so that refreshing bases is a deliberate, visible step.`,
+		Example: `
+  # See which import paths in ko.lock are built on stale bases.
+  ko check-base-updates
+
+  # Fail CI when a base has been updated, and show what updating fixes.
+  ko check-base-updates --scan --fail`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx := createCancellableContext()
+			if configErr != nil {
+				log.Fatal(configErr)
+			}
+
+			var lf *lockfile
+			if l, err := readLockfile(lockfilePath); err == nil {
+				lf = l
+			} else if !os.IsNotExist(err) || cmd.Flags().Changed("lockfile") {
+				log.Fatalf("error reading lockfile: %v", err)
+			}
+			checks := baseChecks(lf, defaultBaseImage, baseImageOverrides)
+			if len(checks) == 0 {
+				log.Fatalf("No base images to check: record them in %s with ko resolve --lock, or pin them in .ko.yaml by tag and digest", lockfilePath)
+			}
+
+			c := &baseChecker{
+				platform: platform,
+				ropt: []remote.Option{
+					remote.WithAuthFromKeychain(keychain),
+					remote.WithUserAgent(ua()),
+					remote.WithContext(ctx),
+				},
+			}
+			if scan != "" {
+				var err error
+				if c.scan, err = newScanner(scan); err != nil {
+					log.Fatal(err)
+				}
+				c.threshold = strings.ToUpper(severity)
+				if severityRank(c.threshold) < 0 {
+					log.Fatalf("unknown --scan-severity %q, expected one of %s", severity, strings.ToLower(strings.Join(severities, ", ")))
+				}
+			}
+
+			updates := c.check(ctx, checks)
+			if err := writeBaseUpdates(os.Stdout, updates, c.scan != nil); err != nil {
+				log.Fatal(err)
+			}
+			for _, u := range updates {
+				if u.Err != nil || (fail && u.Stale) {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+	check.Flags().StringVar(&lockfilePath, "lockfile", "ko.lock",
+		"Lockfile (written by ko resolve --lock) recording the base image of each import path.")
+	check.Flags().StringVar(&scan, "scan", "",
+		"Count the vulnerabilities in stale and updated base images, with trivy or grype (which must be on the PATH).")
+	check.Flags().Lookup("scan").NoOptDefVal = "trivy"
+	check.Flags().StringVar(&severity, "scan-severity", "high",
+		"The lowest severity of vulnerability that --scan counts: low, medium, high or critical.")
+	check.Flags().StringVar(&platform, "platform", "linux/amd64",
+		"The platform of multi-platform base images to scan.")
+	check.Flags().BoolVar(&fail, "fail", false,
+		"Exit with an error if any base image is stale.")
+	topLevel.AddCommand(check)
+}
+
+// baseCheck is a base image to check for updates.
+type baseCheck struct {
+	// For is the import path (or .ko.yaml key) that uses the base.
+	For string
+	// Reference is the base as it was configured, e.g. by tag.
+	Reference string
+	// Digest is the digest that was built on, or pinned.
+	Digest string
+}
+
+// baseUpdate is what checking a baseCheck found.
+type baseUpdate struct {
+	baseCheck
+	// Current is the digest that the tag of Reference points to now.
+	Current string
+	Stale   bool
+	Err     error
+	// OldVulns and NewVulns count the vulnerabilities in the base at
+	// Digest and at Current, when scanning.
+	OldVulns, NewVulns int
+}
+
+// baseChecks returns the bases recorded in lf (which may be nil), and then
+// those that the default base and overrides pin by tag and digest.
+func baseChecks(lf *lockfile, defaultBase name.Reference, overrides map[string]name.Reference) []baseCheck {
+	var checks []baseCheck
+	if lf != nil {
+		for ip, b := range lf.Bases {
+			checks = append(checks, baseCheck{
+				For:       strings.TrimPrefix(ip, build.StrictScheme),
+				Reference: b.Reference,
+				Digest:    b.Digest,
+			})
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].For < checks[j].For })
+
+	pinned := func(key string, ref name.Reference) {
+		if d, ok := ref.(name.Digest); ok {
+			if _, ok := baseTag(d.String()); ok {
+				checks = append(checks, baseCheck{For: key, Reference: d.String(), Digest: d.DigestStr()})
+			}
+		}
+	}
+	if defaultBase != nil {
+		pinned("defaultBaseImage", defaultBase)
+	}
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		pinned(k, overrides[k])
+	}
+	return checks
+}
+
+// baseTag returns the tag of a reference, which may also have a digest, and
+// whether it has one.
+func baseTag(ref string) (name.Tag, bool) {
+	ref = strings.SplitN(ref, "@", 2)[0]
+	if !strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		// name.NewTag would default to latest.
+		return name.Tag{}, false
+	}
+	tag, err := name.NewTag(ref)
+	return tag, err == nil
+}
+
+// baseChecker checks bases against what their tags point to now.
+type baseChecker struct {
+	ropt []remote.Option
+	// scan, if set, counts the vulnerabilities of stale bases and their
+	// updates, of threshold severity or higher, for platform.
+	scan      scanner
+	threshold string
+	platform  string
+}
+
+// check checks each base, once per distinct base and digest.
+func (c *baseChecker) check(ctx context.Context, checks []baseCheck) []baseUpdate {
+	type key struct{ ref, digest string }
+	seen := map[key]baseUpdate{}
+	updates := make([]baseUpdate, 0, len(checks))
+	for _, bc := range checks {
+		k := key{bc.Reference, bc.Digest}
+		u, ok := seen[k]
+		if !ok {
+			u = c.checkOne(ctx, bc)
+			seen[k] = u
+		}
+		u.baseCheck = bc
+		updates = append(updates, u)
+	}
+	return updates
+}
+
+func (c *baseChecker) checkOne(ctx context.Context, bc baseCheck) baseUpdate {
+	u := baseUpdate{baseCheck: bc, OldVulns: -1, NewVulns: -1}
+	tag, ok := baseTag(bc.Reference)
+	if !ok {
+		u.Err = fmt.Errorf("%s has no tag to compare with", bc.Reference)
+		return u
+	}
+	desc, err := remote.Get(tag, c.ropt...)
+	if err != nil {
+		u.Err = err
+		return u
+	}
+	u.Current = desc.Digest.String()
+	u.Stale, err = staleBase(desc, bc.Digest)
+	if err != nil {
+		u.Err = err
+		return u
+	}
+	if !u.Stale || c.scan == nil {
+		return u
+	}
+
+	old, err := name.NewDigest(tag.Context().Name() + "@" + bc.Digest)
+	if err != nil {
+		u.Err = err
+		return u
+	}
+	if u.OldVulns, err = c.countVulnerabilities(ctx, old); err != nil {
+		u.Err = fmt.Errorf("scanning %s: %v", old, err)
+		return u
+	}
+	if u.NewVulns, err = c.countVulnerabilities(ctx, tag); err != nil {
+		u.Err = fmt.Errorf("scanning %s: %v", tag, err)
+	}
+	return u
+}
+
+// staleBase returns whether desc, which a base's tag points to now, is no
+// longer digest or (for an index) an index that has it.
+func staleBase(desc *remote.Descriptor, digest string) (bool, error) {
+	if desc.Digest.String() == digest {
+		return false, nil
+	}
+	if !desc.MediaType.IsIndex() {
+		return true, nil
+	}
+	// Builds for a single platform record the digest of the image of
+	// that platform.
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return false, err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return false, err
+	}
+	for _, m := range im.Manifests {
+		if m.Digest.String() == digest {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (c *baseChecker) countVulnerabilities(ctx context.Context, ref name.Reference) (int, error) {
+	ropt := c.ropt
+	if p, err := parsePlatform(c.platform); err == nil {
+		ropt = append(ropt[:len(ropt):len(ropt)], remote.WithPlatform(p))
+	}
+	img, err := remote.Image(ref, ropt...)
+	if err != nil {
+		return 0, err
+	}
+	dir, err := ioutil.TempDir("", "ko-scan-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	vulns, err := scanImage(ctx, c.scan, img, dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, v := range vulns {
+		if severityRank(v.Severity) >= severityRank(c.threshold) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// parsePlatform parses an os/arch[/variant] platform.
+func parsePlatform(s string) (v1.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v1.Platform{}, fmt.Errorf("platform %q isn't os/arch[/variant]", s)
+	}
+	p := v1.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// writeBaseUpdates writes a table of updates to w.
+func writeBaseUpdates(w io.Writer, updates []baseUpdate, scanned bool) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := "IMPORT PATH\tBASE\tBUILT ON\tCURRENT\tSTATUS"
+	if scanned {
+		header += "\tVULNERABILITIES"
+	}
+	fmt.Fprintln(tw, header)
+	for _, u := range updates {
+		status := "up to date"
+		switch {
+		case u.Err != nil:
+			status = "error: " + u.Err.Error()
+		case u.Stale:
+			status = "stale"
+		}
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%s", u.For, strings.SplitN(u.Reference, "@", 2)[0], shortDigest(u.Digest), shortDigest(u.Current), status)
+		if scanned {
+			vulns := ""
+			if u.OldVulns >= 0 && u.NewVulns >= 0 {
+				vulns = fmt.Sprintf("%d -> %d", u.OldVulns, u.NewVulns)
+			}
+			line += "\t" + vulns
+		}
+		fmt.Fprintln(tw, line)
+	}
+	return tw.Flush()
+}
+
+// shortDigest abbreviates a digest, like docker does.
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 && len(digest) > i+13 {
+		return digest[:i+13]
+	}
+	return digest
+}
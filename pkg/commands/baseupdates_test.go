@@ -0,0 +1,165 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestBaseChecks(t *testing.T) {
+	lf := &lockfile{Bases: map[string]lockedBase{
+		"ko://github.com/foo/cmd/b": {Reference: "gcr.io/distroless/static:nonroot", Digest: "sha256:b"},
+		"ko://github.com/foo/cmd/a": {Reference: "gcr.io/distroless/static:nonroot", Digest: "sha256:a"},
+	}}
+	digest := "sha256:" + strings.Repeat("0", 64)
+	overrides := map[string]name.Reference{
+		"github.com/foo/cmd/pinned":   mustReference("gcr.io/distroless/base:debug@" + digest),
+		"github.com/foo/cmd/untagged": mustReference("gcr.io/distroless/base@" + digest),
+		"github.com/foo/cmd/floating": mustReference("gcr.io/distroless/base:debug"),
+	}
+	got := baseChecks(lf, mustReference("gcr.io/distroless/static:nonroot"), overrides)
+	want := []baseCheck{
+		{For: "github.com/foo/cmd/a", Reference: "gcr.io/distroless/static:nonroot", Digest: "sha256:a"},
+		{For: "github.com/foo/cmd/b", Reference: "gcr.io/distroless/static:nonroot", Digest: "sha256:b"},
+		{For: "github.com/foo/cmd/pinned", Reference: "gcr.io/distroless/base:debug@" + digest, Digest: digest},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("baseChecks() (-want +got) = %s", diff)
+	}
+}
+
+func TestBaseChecker(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	// static:v1 has moved on from old, and index:v1 is an index that
+	// still has the image that a single-platform build recorded.
+	old, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	current, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	static := u.Host + "/static:v1"
+	index := u.Host + "/index:v1"
+	if err := remote.Write(mustReference(u.Host+"/static:old"), old); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := remote.Write(mustReference(static), current); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := remote.WriteIndex(mustReference(index), idx); err != nil {
+		t.Fatalf("WriteIndex() = %v", err)
+	}
+	oldDigest, err := old.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	currentDigest, err := current.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+
+	// The old image has 2 high vulnerabilities (and a low one), and the
+	// current one has none.
+	scans := 0
+	c := &baseChecker{
+		threshold: "HIGH",
+		platform:  "linux/amd64",
+		scan: func(context.Context, string) ([]vulnerability, error) {
+			scans++
+			if scans == 1 {
+				return []vulnerability{{Severity: "HIGH"}, {Severity: "CRITICAL"}, {Severity: "LOW"}}, nil
+			}
+			return nil, nil
+		},
+	}
+	updates := c.check(context.Background(), []baseCheck{
+		{For: "a", Reference: static, Digest: oldDigest.String()},
+		{For: "b", Reference: static, Digest: oldDigest.String()},
+		{For: "c", Reference: static, Digest: currentDigest.String()},
+		{For: "d", Reference: index, Digest: im.Manifests[1].Digest.String()},
+		{For: "e", Reference: u.Host + "/static@" + oldDigest.String(), Digest: oldDigest.String()},
+	})
+
+	for i, want := range []struct {
+		stale, err bool
+		vulns      [2]int
+	}{
+		{stale: true, vulns: [2]int{2, 0}},
+		{stale: true, vulns: [2]int{2, 0}},
+		{vulns: [2]int{-1, -1}},
+		{vulns: [2]int{-1, -1}},
+		{err: true, vulns: [2]int{-1, -1}},
+	} {
+		u := updates[i]
+		if u.Stale != want.stale || (u.Err != nil) != want.err || [2]int{u.OldVulns, u.NewVulns} != want.vulns {
+			t.Errorf("check(%s) = stale %t, err %v, vulnerabilities %d -> %d; want stale %t, err %t, vulnerabilities %v",
+				u.For, u.Stale, u.Err, u.OldVulns, u.NewVulns, want.stale, want.err, want.vulns)
+		}
+	}
+	// a and b share a base, which is only checked (and scanned) once.
+	if scans != 2 {
+		t.Errorf("scanned %d times, want 2", scans)
+	}
+
+	var buf bytes.Buffer
+	if err := writeBaseUpdates(&buf, updates, true); err != nil {
+		t.Fatalf("writeBaseUpdates() = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("writeBaseUpdates() wrote %d lines, want 6:\n%s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[1], "stale") || !strings.Contains(lines[1], "2 -> 0") {
+		t.Errorf("writeBaseUpdates() line for a = %q, want it stale with 2 -> 0 vulnerabilities", lines[1])
+	}
+	if !strings.Contains(lines[4], "up to date") {
+		t.Errorf("writeBaseUpdates() line for d = %q, want it up to date", lines[4])
+	}
+}
+
+func mustReference(s string) name.Reference {
+	ref, err := name.ParseReference(s)
+	if err != nil {
+		panic(err)
+	}
+	return ref
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// metadataStore persists the blobs of a metadataCache by digest.
+type metadataStore interface {
+	get(h v1.Hash) ([]byte, error)
+	put(h v1.Hash, b []byte) error
+}
+
+// useCacheBackend points baseMetadata (and, for a directory, baseCache) at
+// the backend of --cache-backend, so that a long-lived ko doesn't start cold
+// whenever it restarts:
+//
+//	file:///path             the layout of $KO_CACHE under path
+//	registry://registry/repo manifests and config files as blobs
+//
+// Only a directory caches layers: images pushed to a registry mount their
+// base layers without reading them.
+func useCacheBackend(backend string) error {
+	if backend == "" {
+		return nil
+	}
+	u, err := url.Parse(backend)
+	if err != nil {
+		return fmt.Errorf("--cache-backend: %v", err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return fmt.Errorf("--cache-backend %s: missing a path", backend)
+		}
+		baseCache = cache.NewFilesystemCache(filepath.Join(u.Path, "layers"))
+		baseMetadata = &metadataCache{store: dirStore(filepath.Join(u.Path, "metadata"))}
+	case "registry":
+		repo, err := name.NewRepository(strings.TrimPrefix(backend, "registry://"))
+		if err != nil {
+			return fmt.Errorf("--cache-backend %s: %v", backend, err)
+		}
+		baseMetadata = &metadataCache{store: &registryStore{
+			repo: repo,
+			ropt: []remote.Option{remote.WithAuthFromKeychain(keychain), remote.WithUserAgent(ua())},
+		}}
+	default:
+		return fmt.Errorf("--cache-backend %s: unsupported scheme %q, want file or registry", backend, u.Scheme)
+	}
+	return nil
+}
+
+// dirStore stores blobs as files named after their digest.
+type dirStore string
+
+func (d dirStore) path(h v1.Hash) string {
+	return filepath.Join(string(d), h.Algorithm, h.Hex)
+}
+
+func (d dirStore) get(h v1.Hash) ([]byte, error) {
+	return ioutil.ReadFile(d.path(h))
+}
+
+func (d dirStore) put(h v1.Hash, b []byte) error {
+	return writeAtomic(d.path(h), b)
+}
+
+// registryStore stores blobs in a repository of a registry.
+type registryStore struct {
+	repo name.Repository
+	ropt []remote.Option
+}
+
+func (rs *registryStore) get(h v1.Hash) ([]byte, error) {
+	l, err := remote.Layer(rs.repo.Digest(h.String()), rs.ropt...)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := l.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (rs *registryStore) put(h v1.Hash, b []byte) error {
+	return remote.WriteLayer(rs.repo, &blobLayer{h: h, b: b}, rs.ropt...)
+}
+
+// blobLayer is a blob that we already hold, as a layer that remote can
+// upload.
+type blobLayer struct {
+	h v1.Hash
+	b []byte
+}
+
+func (l *blobLayer) Digest() (v1.Hash, error) { return l.h, nil }
+func (l *blobLayer) DiffID() (v1.Hash, error) { return l.h, nil }
+func (l *blobLayer) Size() (int64, error)     { return int64(len(l.b)), nil }
+func (l *blobLayer) MediaType() (types.MediaType, error) {
+	return types.MediaType("application/octet-stream"), nil
+}
+func (l *blobLayer) Compressed() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(l.b)), nil
+}
+func (l *blobLayer) Uncompressed() (io.ReadCloser, error) { return l.Compressed() }
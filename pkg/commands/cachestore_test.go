@@ -0,0 +1,125 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+)
+
+func TestMetadataStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-cache-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(server.URL, "http://") + "/ko-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := []byte(`{"schemaVersion": 2}`)
+	h, _, err := v1.SHA256(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]metadataStore{
+		"dir":      dirStore(dir),
+		"registry": &registryStore{repo: repo},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.get(h); err == nil {
+				t.Error("get() = nil before put(), want error")
+			}
+			if err := store.put(h, b); err != nil {
+				t.Fatalf("put() = %v", err)
+			}
+			got, err := store.get(h)
+			if err != nil {
+				t.Fatalf("get() = %v", err)
+			}
+			if diff := cmp.Diff(string(b), string(got)); diff != "" {
+				t.Errorf("get() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestUseCacheBackend(t *testing.T) {
+	defer func(c cache.Cache, m *metadataCache) {
+		baseCache, baseMetadata = c, m
+	}(baseCache, baseMetadata)
+
+	for _, tc := range []struct {
+		backend    string
+		wantStore  metadataStore
+		wantLayers bool
+		wantErr    bool
+	}{{
+		backend:    "file:///var/cache/ko",
+		wantStore:  dirStore("/var/cache/ko/metadata"),
+		wantLayers: true,
+	}, {
+		backend: "redis://redis.example.com:6379/0",
+		wantErr: true,
+	}, {
+		backend: "file://",
+		wantErr: true,
+	}, {
+		backend: "gs://bucket/ko",
+		wantErr: true,
+	}} {
+		t.Run(tc.backend, func(t *testing.T) {
+			baseCache, baseMetadata = nil, nil
+			err := useCacheBackend(tc.backend)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("useCacheBackend() = %v, want error: %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if diff := cmp.Diff(tc.wantStore, baseMetadata.store); diff != "" {
+				t.Errorf("store (-want +got) = %s", diff)
+			}
+			if got := baseCache != nil; got != tc.wantLayers {
+				t.Errorf("baseCache set = %v, want %v", got, tc.wantLayers)
+			}
+		})
+	}
+
+	baseCache, baseMetadata = nil, nil
+	if err := useCacheBackend("registry://registry.example.com/ko-cache"); err != nil {
+		t.Fatalf("useCacheBackend() = %v", err)
+	}
+	rs, ok := baseMetadata.store.(*registryStore)
+	if !ok {
+		t.Fatalf("store = %T, want a registry store", baseMetadata.store)
+	}
+	if got, want := rs.repo.String(), "registry.example.com/ko-cache"; got != want {
+		t.Errorf("repo = %s, want %s", got, want)
+	}
+}
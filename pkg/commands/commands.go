@@ -15,9 +15,14 @@
 package commands
 
 import (
+	"errors"
+	"os"
 	"os/exec"
 
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/internal/trace"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // AddKubeCommands augments our CLI surface with a passthru delete command, and an apply
@@ -31,7 +36,77 @@ func AddKubeCommands(topLevel *cobra.Command) {
 	addResolve(topLevel)
 	addPublish(topLevel)
 	addRun(topLevel)
+	addGC(topLevel)
+	addIndex(topLevel)
+	addDiff(topLevel)
+	addPrefetch(topLevel)
+	addCheckBaseUpdates(topLevel)
+	addDoctor(topLevel)
+	addAuth(topLevel)
+	addWebhook(topLevel)
+	addServe(topLevel)
 	addCompletion(topLevel)
+	registerFlagCompletions(topLevel)
+
+	topLevel.PersistentFlags().String("profile", "",
+		"Name of the entry in the profiles section of .ko.yaml to override the rest of .ko.yaml with.")
+	po := &options.ProfileOptions{}
+	options.AddProfileArgs(topLevel, po)
+	ao := &options.AuthOptions{}
+	options.AddAuthArgs(topLevel, ao)
+	stopProfiling := func() error { return nil }
+
+	// Flags can also be set by environment variables and .ko.yaml.
+	topLevel.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		profile := viper.GetString("profile")
+		if f := cmd.Flags().Lookup("profile"); f != nil && f.Changed {
+			profile = f.Value.String()
+		}
+		// Configuration errors aren't usage errors.
+		cmd.SilenceUsage = true
+		if profile != "" {
+			if err := applyProfile(profile); err != nil {
+				return err
+			}
+		}
+		if err := bindFlags(cmd); err != nil {
+			return err
+		}
+		if err := useDockerConfig(ao.DockerConfig); err != nil {
+			return err
+		}
+		if err := useCredentialHelpers(ao.CredentialHelpers); err != nil {
+			return err
+		}
+		// The password and the input can't both come from stdin.
+		if ao.PasswordStdin && readsStdin(cmd, args) {
+			return errors.New("--password-stdin can't be combined with reading input from stdin; pass the input in a file")
+		}
+		if err := useBasicAuth(ao, os.Stdin); err != nil {
+			return err
+		}
+		trace.Init(cmd.CommandPath())
+		stop, err := startProfiling(po)
+		if err != nil {
+			return err
+		}
+		stopProfiling = stop
+		return nil
+	}
+	topLevel.PersistentPostRunE = func(cmd *cobra.Command, _ []string) error {
+		stopProgress()
+		if f := cmd.Flags().Lookup("tags"); f != nil && f.Changed {
+			if tags, err := cmd.Flags().GetStringSlice("tags"); err == nil {
+				// Only for completion, so failing to remember them is fine.
+				_ = recordTags(tags)
+			}
+		}
+		reportGoCaches()
+		if err := stopProfiling(); err != nil {
+			return err
+		}
+		return trace.Shutdown(cmd.Context())
+	}
 }
 
 // check if kubectl is installed
@@ -15,8 +15,15 @@
 package commands
 
 import (
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
 	"github.com/spf13/cobra"
 )
 
@@ -42,3 +49,193 @@ func addCompletion(topLevel *cobra.Command) {
 	completionCmd.Flags().BoolVar(&completionFlags.Zsh, "zsh", false, "Generates completion code for Zsh shell.")
 	topLevel.AddCommand(completionCmd)
 }
+
+// completeImportPaths completes positional arguments to the import paths of
+// the main packages in the current module. Arguments that look like relative
+// paths complete to relative paths, and ko:// references keep their prefix.
+func completeImportPaths(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	out, err := exec.Command("go", "list", "-f", `{{if eq .Name "main"}}{{.ImportPath}} {{.Dir}}{{end}}`, "./...").Output()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	completions := importPathCompletions(string(out), wd, toComplete)
+	return append(completions, overrideCompletions(baseImageOverrides, completions, toComplete)...), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeFirstImportPath completes only the first positional argument to an
+// import path, for commands whose remaining arguments are something else.
+func completeFirstImportPath(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return completeImportPaths(cmd, args, toComplete)
+}
+
+// importPathCompletions parses the "<importpath> <dir>" lines that go list
+// printed, and returns those matching toComplete.
+func importPathCompletions(goList, wd, toComplete string) []string {
+	var completions []string
+	for _, line := range strings.Split(goList, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		importpath, dir := parts[0], parts[1]
+
+		var candidate string
+		switch {
+		case strings.HasPrefix(toComplete, "."):
+			rel, err := filepath.Rel(wd, dir)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			candidate = "./" + filepath.ToSlash(rel)
+			if rel == "." {
+				candidate = "."
+			}
+		case strings.HasPrefix(toComplete, build.StrictScheme):
+			candidate = build.StrictScheme + importpath
+		default:
+			candidate = importpath
+		}
+		if strings.HasPrefix(candidate, toComplete) {
+			completions = append(completions, candidate)
+		}
+	}
+	return completions
+}
+
+// overrideCompletions returns the import paths with baseImageOverrides that
+// match toComplete and aren't already among completions, since overrides can
+// name main packages outside the current module.
+func overrideCompletions(overrides map[string]name.Reference, completions []string, toComplete string) []string {
+	if strings.HasPrefix(toComplete, ".") {
+		return nil
+	}
+	seen := make(map[string]bool, len(completions))
+	for _, c := range completions {
+		seen[c] = true
+	}
+	var out []string
+	for importpath := range overrides {
+		candidate := importpath
+		if strings.HasPrefix(toComplete, build.StrictScheme) {
+			candidate = build.StrictScheme + importpath
+		}
+		if !seen[candidate] && strings.HasPrefix(candidate, toComplete) {
+			out = append(out, candidate)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// registerFlagCompletions completes the values of --platform and --tags
+// wherever they are flags.
+func registerFlagCompletions(root *cobra.Command) {
+	for name, f := range map[string]func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective){
+		"platform": completePlatforms,
+		"tags":     completeTags,
+	} {
+		if root.Flags().Lookup(name) != nil {
+			// Only fails for flags that don't exist, or already complete.
+			_ = root.RegisterFlagCompletionFunc(name, f)
+		}
+	}
+	for _, cmd := range root.Commands() {
+		registerFlagCompletions(cmd)
+	}
+}
+
+// completePlatforms completes --platform to "all", or to a comma-separated
+// list of the platforms that the Go toolchain can build for.
+func completePlatforms(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	out, err := exec.Command("go", "tool", "dist", "list").Output()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	platforms := strings.Fields(string(out))
+	if !strings.Contains(toComplete, ",") {
+		platforms = append([]string{"all"}, platforms...)
+	}
+	return listCompletions(platforms, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeTags completes --tags to the tags that were recently pushed.
+func completeTags(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return listCompletions(tagHistory(), toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// listCompletions completes the last element of the comma-separated list
+// toComplete to the candidates that match it and aren't already listed.
+func listCompletions(candidates []string, toComplete string) []string {
+	i := strings.LastIndex(toComplete, ",")
+	prefix, last := toComplete[:i+1], toComplete[i+1:]
+	listed := map[string]bool{}
+	for _, e := range strings.Split(prefix, ",") {
+		listed[e] = true
+	}
+	var completions []string
+	for _, c := range candidates {
+		if !listed[c] && strings.HasPrefix(c, last) {
+			completions = append(completions, prefix+c)
+		}
+	}
+	return completions
+}
+
+// maxTagHistory is how many of the most recently pushed tags --tags
+// completes to.
+const maxTagHistory = 50
+
+// tagHistoryFile is where the tags passed to --tags are remembered: under
+// $KO_CACHE if it is set, or else the user's cache directory.
+func tagHistoryFile() (string, error) {
+	if dir := os.Getenv("KO_CACHE"); dir != "" {
+		return filepath.Join(dir, "tags"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ko", "tags"), nil
+}
+
+// tagHistory returns the remembered tags, most recent first.
+func tagHistory() []string {
+	path, err := tagHistoryFile()
+	if err != nil {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(b))
+}
+
+// recordTags remembers tags for --tags completion, ahead of the ones
+// remembered before.
+func recordTags(tags []string) error {
+	path, err := tagHistoryFile()
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	var history []string
+	for _, t := range append(tags, tagHistory()...) {
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		history = append(history, t)
+	}
+	if len(history) > maxTagHistory {
+		history = history[:maxTagHistory]
+	}
+	return writeAtomic(path, []byte(strings.Join(history, "\n")+"\n"))
+}
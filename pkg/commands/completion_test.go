@@ -0,0 +1,144 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestImportPathCompletions(t *testing.T) {
+	goList := `github.com/google/ko/cmd/ko /src/ko/cmd/ko
+github.com/google/ko/cmd/help /src/ko/cmd/help
+
+github.com/google/ko /src/ko
+`
+	for _, c := range []struct {
+		toComplete string
+		want       []string
+	}{{
+		toComplete: "",
+		want:       []string{"github.com/google/ko/cmd/ko", "github.com/google/ko/cmd/help", "github.com/google/ko"},
+	}, {
+		toComplete: "github.com/google/ko/cmd/k",
+		want:       []string{"github.com/google/ko/cmd/ko"},
+	}, {
+		toComplete: "ko://github.com/google/ko/cmd/",
+		want:       []string{"ko://github.com/google/ko/cmd/ko", "ko://github.com/google/ko/cmd/help"},
+	}, {
+		toComplete: "./cmd/h",
+		want:       []string{"./cmd/help"},
+	}, {
+		toComplete: ".",
+		want:       []string{"./cmd/ko", "./cmd/help", "."},
+	}} {
+		t.Run(c.toComplete, func(t *testing.T) {
+			got := importPathCompletions(goList, "/src/ko", c.toComplete)
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("importPathCompletions(%q) (-want +got) = %s", c.toComplete, diff)
+			}
+		})
+	}
+}
+
+func TestOverrideCompletions(t *testing.T) {
+	overrides := map[string]name.Reference{}
+	for _, importpath := range []string{"github.com/google/ko/cmd/ko", "github.com/other/tool", "github.com/other/app"} {
+		overrides[importpath] = nil
+	}
+	for _, c := range []struct {
+		toComplete  string
+		completions []string
+		want        []string
+	}{{
+		toComplete:  "github.com/",
+		completions: []string{"github.com/google/ko/cmd/ko"},
+		want:        []string{"github.com/other/app", "github.com/other/tool"},
+	}, {
+		toComplete: "ko://github.com/other/t",
+		want:       []string{"ko://github.com/other/tool"},
+	}, {
+		toComplete: "./",
+	}} {
+		t.Run(c.toComplete, func(t *testing.T) {
+			got := overrideCompletions(overrides, c.completions, c.toComplete)
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("overrideCompletions(%q) (-want +got) = %s", c.toComplete, diff)
+			}
+		})
+	}
+}
+
+func TestListCompletions(t *testing.T) {
+	platforms := []string{"linux/amd64", "linux/arm", "linux/arm64", "windows/amd64"}
+	for _, c := range []struct {
+		toComplete string
+		want       []string
+	}{{
+		toComplete: "linux/a",
+		want:       []string{"linux/amd64", "linux/arm", "linux/arm64"},
+	}, {
+		toComplete: "linux/amd64,linux/",
+		want:       []string{"linux/amd64,linux/arm", "linux/amd64,linux/arm64"},
+	}, {
+		toComplete: "darwin",
+	}} {
+		t.Run(c.toComplete, func(t *testing.T) {
+			got := listCompletions(platforms, c.toComplete)
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("listCompletions(%q) (-want +got) = %s", c.toComplete, diff)
+			}
+		})
+	}
+}
+
+func TestTagHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("KO_CACHE", os.Getenv("KO_CACHE"))
+	os.Setenv("KO_CACHE", dir)
+
+	if got := tagHistory(); len(got) != 0 {
+		t.Errorf("tagHistory() = %v, want none", got)
+	}
+	if err := recordTags([]string{"v1", "latest"}); err != nil {
+		t.Fatalf("recordTags() = %v", err)
+	}
+	if err := recordTags([]string{"v2", "latest"}); err != nil {
+		t.Fatalf("recordTags() = %v", err)
+	}
+	if diff := cmp.Diff([]string{"v2", "latest", "v1"}, tagHistory()); diff != "" {
+		t.Errorf("tagHistory() (-want +got) = %s", diff)
+	}
+
+	var many []string
+	for i := 0; i < maxTagHistory+10; i++ {
+		many = append(many, fmt.Sprintf("t%d", i))
+	}
+	if err := recordTags(many); err != nil {
+		t.Fatalf("recordTags() = %v", err)
+	}
+	if diff := cmp.Diff(many[:maxTagHistory], tagHistory()); diff != "" {
+		t.Errorf("tagHistory() (-want +got) = %s", diff)
+	}
+}
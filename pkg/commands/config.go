@@ -20,26 +20,82 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/internal/ignore"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 var (
 	defaultBaseImage   name.Reference
 	baseImageOverrides map[string]name.Reference
+
+	// buildConfigs configure how particular import paths are built.
+	buildConfigs []build.Config
+
+	// defaultUser, if set, is the user that images run as unless their
+	// builds entry says otherwise.
+	defaultUser string
+
+	// initCfg configures the init that entrypoints can be wrapped in, if
+	// .ko.yaml has an init section.
+	initCfg *initConfig
+
+	// baseImagePolicy restricts which base images may be used, if
+	// .ko.yaml has a basePolicy.
+	baseImagePolicy *basePolicy
+
+	// importPathAliases map the (lowercase) aliases that can be referenced
+	// as ko://<alias> to the import paths they stand for.
+	importPathAliases map[string]string
+
+	// baseCache caches the layers of base images under $KO_CACHE, if set.
+	baseCache cache.Cache
+
+	// baseMetadata caches the manifests and config files of base images
+	// across runs.
+	baseMetadata *metadataCache
+
+	// configErr is any problem with .ko.yaml, which is reported by the
+	// commands that depend on it (and by ko doctor).
+	configErr error
+
+	// configFiles are the config files that were read, in increasing order
+	// of precedence.
+	configFiles []string
+
+	// koIgnore holds the patterns of ./.koignore, if there is one.
+	koIgnore *ignore.Matcher
 )
 
-func getBaseImage(platform string) build.GetBase {
+// defaultBase returns the base image to use for import paths without an
+// override: --base-image if it was passed, or else defaultBaseImage.
+func defaultBase(bo *options.BuildOptions) (name.Reference, error) {
+	if bo.BaseImage == "" {
+		return defaultBaseImage, nil
+	}
+	ref, err := name.ParseReference(bo.BaseImage)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing --base-image %q as image reference: %v", bo.BaseImage, err)
+	}
+	return ref, nil
+}
+
+func getBaseImage(platform string, defaultBase name.Reference) build.GetBase {
 	return func(ctx context.Context, s string) (build.Result, error) {
 		s = strings.TrimPrefix(s, build.StrictScheme)
 		// Viper configuration file keys are case insensitive, and are
@@ -50,53 +106,125 @@ func getBaseImage(platform string) build.GetBase {
 		//    github.com/googlecloudplatform/foo/cmd/bar
 		ref, ok := baseImageOverrides[strings.ToLower(s)]
 		if !ok {
-			ref = defaultBaseImage
-		}
-		ropt := []remote.Option{
-			remote.WithAuthFromKeychain(authn.DefaultKeychain),
-			remote.WithUserAgent(ua()),
-			remote.WithContext(ctx),
-		}
-
-		// Using --platform=all will use an image index for the base,
-		// otherwise we'll resolve it to the appropriate platform.
-		//
-		// Platforms can be comma-separated if we only want a subset of the base
-		// image.
-		multiplatform := platform == "all" || strings.Contains(platform, ",")
-		var p v1.Platform
-		if platform != "" && !multiplatform {
-			parts := strings.Split(platform, "/")
-			if len(parts) > 0 {
-				p.OS = parts[0]
-			}
-			if len(parts) > 1 {
-				p.Architecture = parts[1]
+			ref = defaultBase
+			// A //ko:base directive in the package beats the default,
+			// but not .ko.yaml.
+			if d := build.BaseDirective(ctx); d != "" {
+				var err error
+				if ref, err = name.ParseReference(d); err != nil {
+					return nil, fmt.Errorf("error parsing //ko:base %q of %s as image reference: %v", d, s, err)
+				}
 			}
-			if len(parts) > 2 {
-				p.Variant = parts[2]
-			}
-			if len(parts) > 3 {
-				return nil, fmt.Errorf("too many slashes in platform spec: %s", platform)
-			}
-			ropt = append(ropt, remote.WithPlatform(p))
+		}
+
+		if err := baseImagePolicy.checkRef(ref); err != nil {
+			return nil, err
 		}
 
 		log.Printf("Using base %s for %s", ref, s)
-		desc, err := remote.Get(ref, ropt...)
+		base, err := fetchBase(ctx, ref, platform)
 		if err != nil {
 			return nil, err
 		}
-		switch desc.MediaType {
-		case types.OCIImageIndex, types.DockerManifestList:
-			if multiplatform {
-				return desc.ImageIndex()
+		if err := baseImagePolicy.checkAge(ref, base, time.Now()); err != nil {
+			return nil, err
+		}
+
+		baseRefs.Store(s, ref.String())
+		return base, nil
+	}
+}
+
+// fetchBase fetches the base image ref for the given platform spec, reading
+// its metadata through baseMetadata and its layers through baseCache if they
+// are set.
+func fetchBase(ctx context.Context, ref name.Reference, platform string) (build.Result, error) {
+	ropt := []remote.Option{
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithUserAgent(ua()),
+		remote.WithContext(ctx),
+	}
+
+	// Using --platform=all will use an image index for the base,
+	// otherwise we'll resolve it to the appropriate platform.
+	//
+	// Platforms can be comma-separated if we only want a subset of the base
+	// image.
+	var base build.Result
+	if baseMetadata != nil {
+		var p *v1.Platform
+		if !build.IsMultiPlatform(platform) {
+			parsed, err := build.ParsePlatform(platform)
+			if err != nil {
+				return nil, err
 			}
-			return desc.Image()
-		default:
-			return desc.Image()
+			p = &parsed
+			if p.OS == "" {
+				// This is what remote picks without a platform.
+				p = &v1.Platform{OS: "linux", Architecture: "amd64"}
+			}
+		}
+		var err error
+		if base, err = baseMetadata.fetch(ref, p, ropt); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if base, err = build.FetchBase(ref, platform, ropt...); err != nil {
+			return nil, err
 		}
 	}
+	if baseCache == nil {
+		return base, nil
+	}
+	switch b := base.(type) {
+	case v1.ImageIndex:
+		return &cachingIndex{inner: b, c: baseCache, ref: ref}, nil
+	case v1.Image:
+		return &mountableImage{Image: cache.Image(b, baseCache), ref: ref}, nil
+	}
+	return base, nil
+}
+
+// cachingIndex reads the layers of the images in an index through a cache,
+// keeping them mountable from ref.
+type cachingIndex struct {
+	inner v1.ImageIndex
+	c     cache.Cache
+	ref   name.Reference
+}
+
+// MediaType implements v1.ImageIndex
+func (i *cachingIndex) MediaType() (types.MediaType, error) { return i.inner.MediaType() }
+
+// Digest implements v1.ImageIndex
+func (i *cachingIndex) Digest() (v1.Hash, error) { return i.inner.Digest() }
+
+// Size implements v1.ImageIndex
+func (i *cachingIndex) Size() (int64, error) { return i.inner.Size() }
+
+// IndexManifest implements v1.ImageIndex
+func (i *cachingIndex) IndexManifest() (*v1.IndexManifest, error) { return i.inner.IndexManifest() }
+
+// RawManifest implements v1.ImageIndex
+func (i *cachingIndex) RawManifest() ([]byte, error) { return i.inner.RawManifest() }
+
+// Image implements v1.ImageIndex
+func (i *cachingIndex) Image(h v1.Hash) (v1.Image, error) {
+	img, err := i.inner.Image(h)
+	if err != nil {
+		return nil, err
+	}
+	return &mountableImage{Image: cache.Image(img, i.c), ref: i.ref}, nil
+}
+
+// ImageIndex implements v1.ImageIndex
+func (i *cachingIndex) ImageIndex(h v1.Hash) (v1.ImageIndex, error) {
+	idx, err := i.inner.ImageIndex(h)
+	if err != nil {
+		return nil, err
+	}
+	return &cachingIndex{inner: idx, c: i.c, ref: i.ref}, nil
 }
 
 func getCreationTime() (*v1.Time, error) {
@@ -113,7 +241,7 @@ func getCreationTime() (*v1.Time, error) {
 }
 
 func createCancellableContext() context.Context {
-	signals := make(chan os.Signal)
+	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -128,36 +256,297 @@ func createCancellableContext() context.Context {
 func init() {
 	// If omitted, use this base image.
 	viper.SetDefault("defaultBaseImage", "gcr.io/distroless/static:nonroot")
-	viper.SetConfigName(".ko") // .yaml is implicit
 	viper.SetEnvPrefix("KO")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
-	if override := os.Getenv("KO_CONFIG_PATH"); override != "" {
-		viper.AddConfigPath(override)
+	if dir := os.Getenv("KO_CACHE"); dir != "" {
+		baseCache = cache.NewFilesystemCache(filepath.Join(dir, "layers"))
+	}
+	if dir, err := metadataDir(); err == nil {
+		baseMetadata = &metadataCache{store: dirStore(dir)}
+	}
+
+	configErr = loadConfig()
+}
+
+// systemConfigDir holds the configuration shared by every user.
+const systemConfigDir = "/etc/ko"
+
+// userConfigDir holds the configuration of the current user, i.e.
+// $XDG_CONFIG_HOME/ko or ~/.config/ko.
+func userConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "ko")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "ko")
+	}
+	return ""
+}
+
+// findConfigFiles returns the config files that exist, from lowest to highest
+// precedence: config.yaml in the system and user directories, then the
+// project's config: the file override names, or .ko.yaml in the directory it
+// names, or else ./.ko.yaml.
+func findConfigFiles(override, userDir, systemDir string) ([]string, error) {
+	var files []string
+	for _, dir := range []string{systemDir, userDir} {
+		if dir == "" {
+			continue
+		}
+		if f := findConfigFile(dir, "config"); f != "" {
+			files = append(files, f)
+		}
+	}
+
+	projectDir := "."
+	if override != "" {
+		fi, err := os.Stat(override)
+		if err != nil {
+			return nil, fmt.Errorf("KO_CONFIG_PATH: %v", err)
+		}
+		if !fi.IsDir() {
+			return append(files, override), nil
+		}
+		projectDir = override
+	}
+	if f := findConfigFile(projectDir, ".ko"); f != "" {
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// findConfigFile returns the file in dir named name with any extension that
+// viper supports, e.g. .ko.yaml, or "" if there is none.
+func findConfigFile(dir, name string) string {
+	for _, ext := range viper.SupportedExts {
+		f := filepath.Join(dir, name+"."+ext)
+		if fi, err := os.Stat(f); err == nil && !fi.IsDir() {
+			return f
+		}
+	}
+	return ""
+}
+
+func isSupportedExt(ext string) bool {
+	for _, e := range viper.SupportedExts {
+		if "."+e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig reads the config files into viper, and parses them.
+func loadConfig() error {
+	ki, err := ignore.Load(".")
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", ignore.Filename, err)
 	}
+	koIgnore = ki
 
-	viper.AddConfigPath("./")
+	files, err := findConfigFiles(os.Getenv("KO_CONFIG_PATH"), userConfigDir(), systemConfigDir)
+	if err != nil {
+		return err
+	}
+	configFiles = files
+	// Each file overrides the ones before it.
+	for i, f := range files {
+		viper.SetConfigFile(f)
+		// Files without a known extension (e.g. KO_CONFIG_PATH=ko.conf)
+		// are yaml.
+		viper.SetConfigType("")
+		if !isSupportedExt(filepath.Ext(f)) {
+			viper.SetConfigType("yaml")
+		}
+		read := viper.MergeInConfig
+		if i == 0 {
+			read = viper.ReadInConfig
+		}
+		if err := read(); err != nil {
+			return fmt.Errorf("error reading config file %s: %v", f, err)
+		}
+	}
+	return parseConfig()
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			log.Fatalf("error reading config file: %v", err)
+// applyProfile overrides the config with the named entry of its profiles
+// section.
+func applyProfile(profile string) error {
+	profiles := viper.GetStringMap("profiles")
+	p, ok := profiles[strings.ToLower(profile)]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
 		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown profile %q, expected one of %v", profile, names)
+	}
+	settings, ok := p.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("profile %q is not a map", profile)
 	}
+	if err := viper.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("error applying profile %q: %v", profile, err)
+	}
+	return parseConfig()
+}
 
+// parseConfig parses the base image and build configuration from viper.
+func parseConfig() error {
 	ref := viper.GetString("defaultBaseImage")
 	dbi, err := name.ParseReference(ref)
 	if err != nil {
-		log.Fatalf("'defaultBaseImage': error parsing %q as image reference: %v", ref, err)
+		return fmt.Errorf("'defaultBaseImage': error parsing %q as image reference: %v", ref, err)
 	}
 	defaultBaseImage = dbi
 
+	defaultUser = viper.GetString("defaultUser")
+
 	baseImageOverrides = make(map[string]name.Reference)
 	overrides := viper.GetStringMapString("baseImageOverrides")
 	for k, v := range overrides {
 		bi, err := name.ParseReference(v)
 		if err != nil {
-			log.Fatalf("'baseImageOverrides': error parsing %q as image reference: %v", v, err)
+			return fmt.Errorf("'baseImageOverrides': error parsing %q as image reference: %v", v, err)
 		}
 		baseImageOverrides[k] = bi
 	}
+
+	buildConfigs = nil
+	if err := viper.UnmarshalKey("builds", &buildConfigs); err != nil {
+		return fmt.Errorf("'builds': %v", err)
+	}
+
+	baseImagePolicy = nil
+	if viper.IsSet("basePolicy") {
+		var p basePolicy
+		if err := viper.UnmarshalKey("basePolicy", &p); err != nil {
+			return fmt.Errorf("'basePolicy': %v", err)
+		}
+		if err := parseBasePolicy(&p); err != nil {
+			return err
+		}
+		baseImagePolicy = &p
+	}
+
+	initCfg = nil
+	if viper.IsSet("init") {
+		var c initConfig
+		if err := viper.UnmarshalKey("init", &c); err != nil {
+			return fmt.Errorf("'init': %v", err)
+		}
+		if err := parseInitConfig(&c); err != nil {
+			return err
+		}
+		initCfg = &c
+	}
+
+	importPathAliases = make(map[string]string)
+	for alias, ip := range viper.GetStringMapString("aliases") {
+		ip = strings.TrimPrefix(ip, build.StrictScheme)
+		if ip == "" {
+			return fmt.Errorf("'aliases': alias %q has no import path", alias)
+		}
+		importPathAliases[strings.ToLower(alias)] = ip
+	}
+	return nil
+}
+
+// expandAlias returns the reference to the import path that ref names, if
+// ref is a ko://<alias> reference to one of the aliases in .ko.yaml, or else
+// ref itself.
+func expandAlias(ref string) string {
+	s := strings.TrimSpace(ref)
+	if !strings.HasPrefix(s, build.StrictScheme) {
+		return ref
+	}
+	alias := strings.TrimPrefix(s, build.StrictScheme)
+	if ip, ok := importPathAliases[strings.ToLower(alias)]; ok {
+		return build.StrictScheme + ip
+	}
+	return ref
+}
+
+// scopedFlag annotates flags that only their command's KO_<COMMAND>_<FLAG>
+// environment variable sets, because other commands have flags of the same
+// name that mean something else.
+const scopedFlag = "ko_scoped_env"
+
+// scopeFlag makes the flag name of cmd settable from the environment only
+// with KO_<COMMAND>_<FLAG>, and not with KO_<FLAG> or .ko.yaml.
+func scopeFlag(cmd *cobra.Command, name string) {
+	cmd.Flags().SetAnnotation(name, scopedFlag, []string{"true"})
+}
+
+// flagEnv returns the environment variable that sets the flag name of the
+// command with the given path below ko, e.g. KO_SERVE_ADDR, or KO_<FLAG>
+// with no path.
+func flagEnv(path []string, name string) string {
+	return strings.ToUpper(strings.Replace(strings.Join(append(append([]string{"ko"}, path...), name), "_"), "-", "_", -1))
+}
+
+// bindFlags sets every flag of cmd that wasn't passed on the command line
+// from the KO_<COMMAND>_<FLAG> environment variable (e.g. KO_SERVE_ADDR),
+// or else from KO_<FLAG> (with dashes as underscores), or else from the key
+// of the same name in .ko.yaml. Flags marked with scopeFlag are only set
+// from KO_<COMMAND>_<FLAG>.
+func bindFlags(cmd *cobra.Command) error {
+	path := strings.Fields(cmd.CommandPath())[1:]
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		var values []string
+		source := flagEnv(path, f.Name)
+		_, scoped := f.Annotations[scopedFlag]
+		switch v, ok := os.LookupEnv(source); {
+		case ok && len(path) != 0:
+			values = []string{v}
+		case scoped || !viper.IsSet(f.Name):
+			return
+		default:
+			source = flagEnv(nil, f.Name) + " or .ko.yaml"
+			v := viper.Get(f.Name)
+			values = []string{flagValue(v)}
+			if l, ok := v.([]interface{}); ok && f.Value.Type() == "stringArray" {
+				// Each element of a repeatable flag is set on its own.
+				values = values[:0]
+				for _, e := range l {
+					values = append(values, fmt.Sprint(e))
+				}
+			}
+		}
+		for _, value := range values {
+			if serr := cmd.Flags().Set(f.Name, value); serr != nil && err == nil {
+				err = fmt.Errorf("error setting --%s from %s: %v", f.Name, source, serr)
+			}
+		}
+	})
+	return err
+}
+
+// flagValue formats a value from .ko.yaml as a flag would be passed, e.g.
+// lists become comma-separated and maps become comma-separated k=v pairs.
+func flagValue(v interface{}) string {
+	switch v := v.(type) {
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for _, e := range v {
+			ss = append(ss, fmt.Sprint(e))
+		}
+		return strings.Join(ss, ",")
+	case map[string]interface{}:
+		ss := make([]string, 0, len(v))
+		for k, e := range v {
+			ss = append(ss, fmt.Sprintf("%s=%v", k, e))
+		}
+		sort.Strings(ss)
+		return strings.Join(ss, ",")
+	default:
+		return fmt.Sprint(v)
+	}
 }
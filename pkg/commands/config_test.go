@@ -0,0 +1,341 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestBindFlags(t *testing.T) {
+	var platform, repo string
+	var tags, labels []string
+	var jobs int
+	var results map[string]string
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().StringVar(&platform, "test-platform", "", "")
+	cmd.Flags().StringVar(&repo, "test-repo", "default", "")
+	cmd.Flags().StringSliceVar(&tags, "test-tags", []string{"latest"}, "")
+	cmd.Flags().IntVar(&jobs, "test-jobs", 1, "")
+	cmd.Flags().StringToStringVar(&results, "test-results", nil, "")
+	cmd.Flags().StringArrayVar(&labels, "test-labels", nil, "")
+
+	// Stand-ins for .ko.yaml.
+	viper.Set("test-tags", []interface{}{"v1", "v1.2"})
+	viper.Set("test-jobs", 4)
+	viper.Set("test-results", map[string]interface{}{"b": "2", "a": "1"})
+	viper.Set("test-repo", "from-config")
+	viper.Set("test-labels", []interface{}{"a=1,2", "b=3"})
+	defer os.Unsetenv("KO_TEST_PLATFORM")
+	os.Setenv("KO_TEST_PLATFORM", "linux/arm64")
+
+	// Flags on the command line win.
+	if err := cmd.Flags().Parse([]string{"--test-repo=from-flag"}); err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	if err := bindFlags(cmd); err != nil {
+		t.Fatalf("bindFlags() = %v", err)
+	}
+
+	if platform != "linux/arm64" {
+		t.Errorf("platform = %q, wanted it from the environment", platform)
+	}
+	if repo != "from-flag" {
+		t.Errorf("repo = %q, wanted it from the flag", repo)
+	}
+	if jobs != 4 {
+		t.Errorf("jobs = %d, wanted 4", jobs)
+	}
+	if diff := cmp.Diff([]string{"v1", "v1.2"}, tags); diff != "" {
+		t.Errorf("tags (-want +got) = %s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{"a": "1", "b": "2"}, results); diff != "" {
+		t.Errorf("results (-want +got) = %s", diff)
+	}
+	if diff := cmp.Diff([]string{"a=1,2", "b=3"}, labels); diff != "" {
+		t.Errorf("labels (-want +got) = %s", diff)
+	}
+
+	viper.Set("test-jobs", "lots")
+	cmd.Flags().Lookup("test-jobs").Changed = false
+	if err := bindFlags(cmd); err == nil {
+		t.Error("bindFlags() = nil, wanted an error for an invalid value")
+	}
+	viper.Set("test-jobs", 1)
+}
+
+func TestBindFlagsScoped(t *testing.T) {
+	var serveAddr, webhookAddr, lockfile string
+	var lockfiles []string
+	root := &cobra.Command{Use: "ko"}
+	serve := &cobra.Command{Use: "serve"}
+	serve.Flags().StringVar(&serveAddr, "addr", ":8080", "")
+	scopeFlag(serve, "addr")
+	webhook := &cobra.Command{Use: "webhook"}
+	webhook.Flags().StringVar(&webhookAddr, "addr", ":8443", "")
+	scopeFlag(webhook, "addr")
+	resolve := &cobra.Command{Use: "resolve"}
+	resolve.Flags().StringVar(&lockfile, "lockfile", "ko.lock", "")
+	gc := &cobra.Command{Use: "gc"}
+	gc.Flags().StringSliceVar(&lockfiles, "lockfile", nil, "")
+	scopeFlag(gc, "lockfile")
+	root.AddCommand(serve, webhook, resolve, gc)
+
+	for k, v := range map[string]string{
+		"KO_ADDR":         ":9999",
+		"KO_WEBHOOK_ADDR": ":9443",
+		"KO_LOCKFILE":     "other.lock",
+	} {
+		defer os.Unsetenv(k)
+		os.Setenv(k, v)
+	}
+	for _, cmd := range []*cobra.Command{serve, webhook, resolve, gc} {
+		if err := bindFlags(cmd); err != nil {
+			t.Fatalf("bindFlags(%s) = %v", cmd.Name(), err)
+		}
+	}
+
+	if serveAddr != ":8080" {
+		t.Errorf("ko serve --addr = %q, wanted the default, not KO_ADDR", serveAddr)
+	}
+	if webhookAddr != ":9443" {
+		t.Errorf("ko webhook --addr = %q, wanted KO_WEBHOOK_ADDR", webhookAddr)
+	}
+	if lockfile != "other.lock" {
+		t.Errorf("ko resolve --lockfile = %q, wanted KO_LOCKFILE", lockfile)
+	}
+	if len(lockfiles) != 0 {
+		t.Errorf("ko gc --lockfile = %q, wanted the default, not KO_LOCKFILE", lockfiles)
+	}
+
+	// The command's own variable wins over KO_<FLAG>.
+	os.Setenv("KO_RESOLVE_LOCKFILE", "resolve.lock")
+	defer os.Unsetenv("KO_RESOLVE_LOCKFILE")
+	resolve.Flags().Lookup("lockfile").Changed = false
+	if err := bindFlags(resolve); err != nil {
+		t.Fatalf("bindFlags(resolve) = %v", err)
+	}
+	if lockfile != "resolve.lock" {
+		t.Errorf("ko resolve --lockfile = %q, wanted KO_RESOLVE_LOCKFILE", lockfile)
+	}
+}
+
+// TestFlagNamesUnambiguous checks that KO_<FLAG> and .ko.yaml only set flags
+// that mean the same thing on every command that has them.
+func TestFlagNamesUnambiguous(t *testing.T) {
+	// The flags of the option groups that several commands add mean the same
+	// thing on each of them, so KO_<FLAG> and .ko.yaml can set them all.
+	shared := map[string]bool{}
+	scratch := &cobra.Command{}
+	options.AddAnnotateArg(scratch, &options.AnnotateOptions{})
+	options.AddBuildOptions(scratch, &options.BuildOptions{})
+	options.AddCacheArg(scratch, &options.CacheOptions{})
+	options.AddFileArg(scratch, &options.FilenameOptions{})
+	options.AddGitHubArg(scratch, &options.GitHubOptions{})
+	options.AddProvenanceArg(scratch, &options.ProvenanceOptions{})
+	options.AddPublishArg(scratch, &options.PublishOptions{})
+	options.AddSelectorArg(scratch, &options.SelectorOptions{})
+	options.AddStateArg(scratch, &options.StateOptions{})
+	options.AddSummaryArg(scratch, &options.SummaryOptions{})
+	options.AddTektonArg(scratch, &options.TektonOptions{})
+	options.AddValidateArg(scratch, &options.ValidateOptions{})
+	genericclioptions.NewConfigFlags(false).AddFlags(scratch.Flags())
+	scratch.Flags().VisitAll(func(f *pflag.Flag) {
+		shared[f.Name] = true
+	})
+	// check-base-updates defines its own --platform, --lockfile and --scan
+	// flags, but they mean the same as everywhere else.
+	for _, name := range []string{"platform", "lockfile", "scan", "scan-severity"} {
+		shared[name] = true
+	}
+
+	root := &cobra.Command{Use: "ko"}
+	AddKubeCommands(root)
+	cmds := map[string][]string{}
+	unscoped := map[string][]string{}
+	var walk func(*cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+			cmds[f.Name] = append(cmds[f.Name], cmd.CommandPath())
+			if _, scoped := f.Annotations[scopedFlag]; !scoped {
+				unscoped[f.Name] = append(unscoped[f.Name], cmd.CommandPath())
+			}
+		})
+		for _, c := range cmd.Commands() {
+			walk(c)
+		}
+	}
+	walk(root)
+	for name, u := range unscoped {
+		if len(cmds[name]) > 1 && !shared[name] {
+			t.Errorf("--%s is defined by %v, and KO_%s sets it for %v; scopeFlag it, or add it to shared if it means the same thing everywhere", name, cmds[name], strings.ToUpper(strings.Replace(name, "-", "_", -1)), u)
+		}
+	}
+}
+
+func TestFindConfigFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-config")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(path string) string {
+		t.Helper()
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll() = %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatalf("WriteFile() = %v", err)
+		}
+		return path
+	}
+	system := write("etc/ko/config.yaml")
+	user := write("home/.config/ko/config.yml")
+	project := write("project/.ko.yaml")
+	file := write("ko.conf")
+
+	for _, c := range []struct {
+		desc     string
+		override string
+		user     string
+		want     []string
+	}{{
+		desc:     "directory",
+		override: filepath.Join(dir, "project"),
+		user:     filepath.Join(dir, "home/.config/ko"),
+		want:     []string{system, user, project},
+	}, {
+		desc:     "file",
+		override: file,
+		want:     []string{system, file},
+	}, {
+		desc:     "no user config",
+		override: filepath.Join(dir, "project"),
+		user:     filepath.Join(dir, "missing"),
+		want:     []string{system, project},
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			got, err := findConfigFiles(c.override, c.user, filepath.Join(dir, "etc/ko"))
+			if err != nil {
+				t.Fatalf("findConfigFiles() = %v", err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("findConfigFiles() (-want +got) = %s", diff)
+			}
+		})
+	}
+
+	if _, err := findConfigFiles(filepath.Join(dir, "missing.yaml"), "", ""); err == nil {
+		t.Error("findConfigFiles() = nil, wanted an error for a missing KO_CONFIG_PATH")
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	defer func(dbi name.Reference, bio map[string]name.Reference, bcs []build.Config) {
+		defaultBaseImage, baseImageOverrides, buildConfigs = dbi, bio, bcs
+		viper.MergeConfigMap(map[string]interface{}{"defaultBaseImage": dbi.String()})
+	}(defaultBaseImage, baseImageOverrides, buildConfigs)
+
+	if err := viper.MergeConfigMap(map[string]interface{}{
+		"test-profile-platform": "linux/amd64",
+		"profiles": map[string]interface{}{
+			"release": map[string]interface{}{
+				"defaultBaseImage":      "gcr.io/distroless/base:release",
+				"test-profile-platform": "linux/amd64,linux/arm64",
+			},
+		},
+	}); err != nil {
+		t.Fatalf("MergeConfigMap() = %v", err)
+	}
+
+	if err := applyProfile("dev"); err == nil {
+		t.Error("applyProfile(dev) = nil, wanted an error for an unknown profile")
+	}
+	if err := applyProfile("release"); err != nil {
+		t.Fatalf("applyProfile(release) = %v", err)
+	}
+	if got, want := defaultBaseImage.String(), "gcr.io/distroless/base:release"; got != want {
+		t.Errorf("defaultBaseImage = %s, wanted %s", got, want)
+	}
+	if got, want := viper.GetString("test-profile-platform"), "linux/amd64,linux/arm64"; got != want {
+		t.Errorf("platform = %s, wanted %s", got, want)
+	}
+}
+
+func TestDefaultBase(t *testing.T) {
+	defer func(dbi name.Reference) {
+		defaultBaseImage = dbi
+	}(defaultBaseImage)
+	defer os.Unsetenv("KO_DEFAULTBASEIMAGE")
+	os.Setenv("KO_DEFAULTBASEIMAGE", "gcr.io/hardened/base:env")
+	if err := parseConfig(); err != nil {
+		t.Fatalf("parseConfig() = %v", err)
+	}
+
+	for _, c := range []struct {
+		baseImage string
+		want      string
+	}{{
+		want: "gcr.io/hardened/base:env",
+	}, {
+		baseImage: "gcr.io/hardened/base:flag",
+		want:      "gcr.io/hardened/base:flag",
+	}} {
+		got, err := defaultBase(&options.BuildOptions{BaseImage: c.baseImage})
+		if err != nil {
+			t.Fatalf("defaultBase() = %v", err)
+		}
+		if got.String() != c.want {
+			t.Errorf("defaultBase(%q) = %s, wanted %s", c.baseImage, got, c.want)
+		}
+	}
+
+	if _, err := defaultBase(&options.BuildOptions{BaseImage: "::bad"}); err == nil {
+		t.Error("defaultBase() = nil, wanted an error for a bad reference")
+	}
+}
+
+func TestExpandAlias(t *testing.T) {
+	defer func(aliases map[string]string) {
+		importPathAliases = aliases
+	}(importPathAliases)
+	importPathAliases = map[string]string{"controller": "github.com/acme/platform/cmd/controller"}
+
+	for ref, want := range map[string]string{
+		"ko://controller":            "ko://github.com/acme/platform/cmd/controller",
+		"ko://Controller":            "ko://github.com/acme/platform/cmd/controller",
+		"ko://github.com/foo/bar":    "ko://github.com/foo/bar",
+		"controller":                 "controller",
+		"gcr.io/foo/controller:v1.0": "gcr.io/foo/controller:v1.0",
+	} {
+		if got := expandAlias(ref); got != want {
+			t.Errorf("expandAlias(%q) = %q, wanted %q", ref, got, want)
+		}
+	}
+}
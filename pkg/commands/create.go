@@ -33,6 +33,7 @@ func addCreate(topLevel *cobra.Command) {
 	po := &options.PublishOptions{}
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
+	ao := &options.AnnotateOptions{}
 	bo := &options.BuildOptions{}
 	create := &cobra.Command{
 		Use:   "create -f FILENAME",
@@ -71,10 +72,14 @@ func addCreate(topLevel *cobra.Command) {
 			// Cancel on signals.
 			ctx := createCancellableContext()
 
+			if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
 			builder, err := makeBuilder(ctx, bo)
 			if err != nil {
 				log.Fatalf("error creating builder: %v", err)
 			}
+			po.WorkDir = bo.WorkDir
 			publisher, err := makePublisher(po)
 			if err != nil {
 				log.Fatalf("error creating publisher: %v", err)
@@ -127,7 +132,7 @@ func addCreate(topLevel *cobra.Command) {
 					stdin.Write([]byte("---\n"))
 				}
 				// Once primed kick things off.
-				return resolveFilesToWriter(ctx, builder, publisher, fo, so, stdin)
+				return resolveFilesToWriter(ctx, builder, publisher, fo, so, ao, stdin, nil)
 			})
 
 			g.Go(func() error {
@@ -139,13 +144,14 @@ func addCreate(topLevel *cobra.Command) {
 			})
 
 			if err := g.Wait(); err != nil {
-				log.Fatal(err)
+				log.Fatal(withHint(err))
 			}
 		},
 	}
 	options.AddPublishArg(create, po)
 	options.AddFileArg(create, fo)
 	options.AddSelectorArg(create, so)
+	options.AddAnnotateArg(create, ao)
 	options.AddBuildOptions(create, bo)
 
 	// Collect the ko-specific apply flags before registering the kubectl global
@@ -0,0 +1,343 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/internal/humanize"
+	"github.com/spf13/cobra"
+)
+
+// addDiff augments our CLI surface with diff.
+func addDiff(topLevel *cobra.Command) {
+	po := &options.PublishOptions{}
+	bo := &options.BuildOptions{}
+
+	diff := &cobra.Command{
+		Use:   "diff IMPORTPATH | OLD NEW",
+		Short: "Compare two images, or an import path against what is currently published.",
+		Long: `This sub-command compares the layers, config, binary and kodata of two images.
+
+Given a single import path, it builds the import path and compares it against the image ko last published for it (with the first of --tags). Given two images, either can be an import path prefixed with ko:// to build it instead of pulling it.
+
+Of multi-platform images, the images for --platform (linux/amd64 by default) are compared.`,
+		Example: `
+  # Compare what ./cmd/app builds to now against what was published.
+  ko diff ./cmd/app
+
+  # Compare two published images.
+  ko diff gcr.io/my-project/app:v1 gcr.io/my-project/app:v2
+
+  # Compare a published image against a fresh build.
+  ko diff gcr.io/my-project/app:v1 ko://github.com/my/project/cmd/app`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeImportPaths,
+		Run: func(_ *cobra.Command, args []string) {
+			ctx := createCancellableContext()
+			platform, err := diffPlatform(bo.Platform)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if po.SkipUnchanged {
+				log.Fatal("--skip-unchanged would compare against the pushed image instead of a fresh build, so it cannot be used with ko diff")
+			}
+			ropt := []remote.Option{
+				remote.WithAuthFromKeychain(keychain),
+				remote.WithUserAgent(ua()),
+				remote.WithContext(ctx),
+				remote.WithPlatform(platform),
+			}
+
+			var builder build.Interface
+			buildImage := func(importpath string) (v1.Image, error) {
+				if builder == nil {
+					var err error
+					if builder, err = makeBuilder(ctx, bo); err != nil {
+						return nil, fmt.Errorf("error creating builder: %v", err)
+					}
+				}
+				importpath, err := qualifyImportPath(strings.TrimPrefix(importpath, build.StrictScheme))
+				if err != nil {
+					return nil, err
+				}
+				br, err := builder.Build(ctx, importpath)
+				if err != nil {
+					return nil, err
+				}
+				return resultImage(br, platform)
+			}
+			getImage := func(s string) (v1.Image, error) {
+				if strings.HasPrefix(s, build.StrictScheme) {
+					return buildImage(s)
+				}
+				ref, err := name.ParseReference(s)
+				if err != nil {
+					return nil, err
+				}
+				return remote.Image(ref, ropt...)
+			}
+
+			oldArg, newArg := "", ""
+			if len(args) == 1 {
+				repoName := os.Getenv("KO_DOCKER_REPO")
+				if repoName == "" {
+					log.Fatal("KO_DOCKER_REPO environment variable is unset")
+				}
+				importpath, err := qualifyImportPath(args[0])
+				if err != nil {
+					log.Fatalf("error qualifying %q: %v", args[0], err)
+				}
+				namer := options.MakeNamer(po)
+				oldArg = fmt.Sprintf("%s:%s", namer(repoName, strings.ToLower(importpath)), po.Tags[0])
+				newArg = build.StrictScheme + importpath
+			} else {
+				oldArg, newArg = args[0], args[1]
+			}
+
+			oldImg, err := getImage(oldArg)
+			if err != nil {
+				log.Fatalf("error getting %s: %v", oldArg, err)
+			}
+			newImg, err := getImage(newArg)
+			if err != nil {
+				log.Fatalf("error getting %s: %v", newArg, err)
+			}
+			fmt.Printf("--- %s\n+++ %s\n", oldArg, newArg)
+			if err := writeImageDiff(os.Stdout, oldImg, newImg); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	options.AddPublishArg(diff, po)
+	options.AddBuildOptions(diff, bo)
+	topLevel.AddCommand(diff)
+}
+
+// diffPlatform returns the platform of multi-platform images that ko diff
+// compares: the one platform of spec, or linux/amd64, which is also what
+// images in registries are resolved to by default.
+func diffPlatform(spec string) (v1.Platform, error) {
+	if spec == "" {
+		return v1.Platform{OS: "linux", Architecture: "amd64"}, nil
+	}
+	if spec == "all" || strings.Contains(spec, ",") {
+		return v1.Platform{}, fmt.Errorf("ko diff compares one platform, but --platform=%s names more", spec)
+	}
+	return parsePlatform(spec)
+}
+
+// resultImage returns br if it is an image, or its image for platform if it
+// is an index.
+func resultImage(br build.Result, platform v1.Platform) (v1.Image, error) {
+	switch r := br.(type) {
+	case v1.Image:
+		return r, nil
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range im.Manifests {
+			p := desc.Platform
+			if p != nil && p.OS == platform.OS && p.Architecture == platform.Architecture &&
+				(platform.Variant == "" || p.Variant == platform.Variant) {
+				return r.Image(desc.Digest)
+			}
+		}
+		return nil, fmt.Errorf("index has no image for %s", path.Join(platform.OS, platform.Architecture, platform.Variant))
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", br)
+	}
+}
+
+// writeImageDiff writes a summary of the differences between oldImg and
+// newImg to w.
+func writeImageDiff(w io.Writer, oldImg, newImg v1.Image) error {
+	oldM, err := oldImg.Manifest()
+	if err != nil {
+		return err
+	}
+	newM, err := newImg.Manifest()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Layers:")
+	var oldTotal, newTotal int64
+	for i := 0; i < len(oldM.Layers) || i < len(newM.Layers); i++ {
+		var oldL, newL *v1.Descriptor
+		if i < len(oldM.Layers) {
+			oldL = &oldM.Layers[i]
+			oldTotal += oldL.Size
+		}
+		if i < len(newM.Layers) {
+			newL = &newM.Layers[i]
+			newTotal += newL.Size
+		}
+		fmt.Fprintf(w, "  %2d %s\n", i, diffLayer(oldL, newL))
+	}
+	fmt.Fprintf(w, "  total %s -> %s (%s)\n", humanize.Bytes(oldTotal), humanize.Bytes(newTotal), humanDelta(newTotal-oldTotal))
+
+	oldCfg, err := oldImg.ConfigFile()
+	if err != nil {
+		return err
+	}
+	newCfg, err := newImg.ConfigFile()
+	if err != nil {
+		return err
+	}
+	if lines := diffConfig(oldCfg, newCfg); len(lines) != 0 {
+		fmt.Fprintln(w, "Config:")
+		for _, l := range lines {
+			fmt.Fprintf(w, "  %s\n", l)
+		}
+	}
+
+	oldFiles, err := koFiles(oldImg)
+	if err != nil {
+		return err
+	}
+	newFiles, err := koFiles(newImg)
+	if err != nil {
+		return err
+	}
+	if lines := diffFiles(oldFiles, newFiles); len(lines) != 0 {
+		fmt.Fprintln(w, "Files:")
+		for _, l := range lines {
+			fmt.Fprintf(w, "  %s\n", l)
+		}
+	}
+	return nil
+}
+
+func diffLayer(oldL, newL *v1.Descriptor) string {
+	switch {
+	case oldL == nil:
+		return fmt.Sprintf("added   %s (%s)", newL.Digest, humanize.Bytes(newL.Size))
+	case newL == nil:
+		return fmt.Sprintf("removed %s (%s)", oldL.Digest, humanize.Bytes(oldL.Size))
+	case oldL.Digest == newL.Digest:
+		return fmt.Sprintf("same    %s (%s)", newL.Digest, humanize.Bytes(newL.Size))
+	default:
+		return fmt.Sprintf("changed %s -> %s (%s)", humanize.Bytes(oldL.Size), humanize.Bytes(newL.Size), humanDelta(newL.Size-oldL.Size))
+	}
+}
+
+// diffConfig describes the differences between the parts of two configs
+// that ko sets or that affect how the image runs.
+func diffConfig(oldCfg, newCfg *v1.ConfigFile) []string {
+	var lines []string
+	check := func(field string, o, n interface{}) {
+		if !reflect.DeepEqual(o, n) {
+			lines = append(lines, fmt.Sprintf("%s: %v -> %v", field, o, n))
+		}
+	}
+	check("Platform", oldCfg.OS+"/"+oldCfg.Architecture, newCfg.OS+"/"+newCfg.Architecture)
+	check("Created", oldCfg.Created.Time.UTC(), newCfg.Created.Time.UTC())
+	check("Entrypoint", oldCfg.Config.Entrypoint, newCfg.Config.Entrypoint)
+	check("Cmd", oldCfg.Config.Cmd, newCfg.Config.Cmd)
+	check("Env", oldCfg.Config.Env, newCfg.Config.Env)
+	check("User", oldCfg.Config.User, newCfg.Config.User)
+	check("WorkingDir", oldCfg.Config.WorkingDir, newCfg.Config.WorkingDir)
+	check("Labels", oldCfg.Config.Labels, newCfg.Config.Labels)
+	return lines
+}
+
+// koFile summarizes a file that ko put in an image.
+type koFile struct {
+	Size   int64
+	Digest string
+}
+
+// koFiles returns the binaries and kodata files in img's filesystem.
+func koFiles(img v1.Image) (map[string]koFile, error) {
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	files := make(map[string]koFile)
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		name := path.Clean("/" + hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !(strings.HasPrefix(name, "/ko-app/") || strings.HasPrefix(name, "/var/run/ko/")) {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return nil, err
+		}
+		files[name] = koFile{Size: hdr.Size, Digest: hex.EncodeToString(h.Sum(nil))}
+	}
+}
+
+// diffFiles describes the files that were added, removed or changed.
+func diffFiles(oldFiles, newFiles map[string]koFile) []string {
+	names := make(map[string]struct{})
+	for n := range oldFiles {
+		names[n] = struct{}{}
+	}
+	for n := range newFiles {
+		names[n] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+
+	var lines []string
+	for _, n := range sorted {
+		o, inOld := oldFiles[n]
+		f, inNew := newFiles[n]
+		switch {
+		case !inOld:
+			lines = append(lines, fmt.Sprintf("+ %s (%s)", n, humanize.Bytes(f.Size)))
+		case !inNew:
+			lines = append(lines, fmt.Sprintf("- %s (%s)", n, humanize.Bytes(o.Size)))
+		case o.Digest != f.Digest:
+			lines = append(lines, fmt.Sprintf("~ %s %s -> %s (%s)", n, humanize.Bytes(o.Size), humanize.Bytes(f.Size), humanDelta(f.Size-o.Size)))
+		}
+	}
+	return lines
+}
+
+// humanDelta formats a change of n bytes with its sign.
+func humanDelta(n int64) string {
+	if n > 0 {
+		return "+" + humanize.Bytes(n)
+	}
+	return humanize.Bytes(n)
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestHumanDelta(t *testing.T) {
+	if got, want := humanDelta(2048), "+2.0 KiB"; got != want {
+		t.Errorf("humanDelta(2048) = %q, want %q", got, want)
+	}
+}
+
+func TestDiffFiles(t *testing.T) {
+	oldFiles := map[string]koFile{
+		"/ko-app/app":          {Size: 2048, Digest: "a"},
+		"/var/run/ko/same.txt": {Size: 1, Digest: "s"},
+		"/var/run/ko/gone.txt": {Size: 2, Digest: "g"},
+	}
+	newFiles := map[string]koFile{
+		"/ko-app/app":          {Size: 4096, Digest: "b"},
+		"/var/run/ko/same.txt": {Size: 1, Digest: "s"},
+		"/var/run/ko/new.txt":  {Size: 3, Digest: "n"},
+	}
+	want := []string{
+		"~ /ko-app/app 2.0 KiB -> 4.0 KiB (+2.0 KiB)",
+		"- /var/run/ko/gone.txt (2 B)",
+		"+ /var/run/ko/new.txt (3 B)",
+	}
+	if diff := cmp.Diff(want, diffFiles(oldFiles, newFiles)); diff != "" {
+		t.Errorf("diffFiles() (-want +got) = %s", diff)
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	oldCfg := &v1.ConfigFile{OS: "linux", Architecture: "amd64"}
+	newCfg := &v1.ConfigFile{OS: "linux", Architecture: "amd64"}
+	newCfg.Config.Env = []string{"FOO=bar"}
+	want := []string{"Env: [] -> [FOO=bar]"}
+	if diff := cmp.Diff(want, diffConfig(oldCfg, newCfg)); diff != "" {
+		t.Errorf("diffConfig() (-want +got) = %s", diff)
+	}
+}
+
+func TestWriteImageDiff(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	extra, err := random.Layer(2048, "")
+	if err != nil {
+		t.Fatalf("random.Layer() = %v", err)
+	}
+	img, err := mutate.AppendLayers(base, extra)
+	if err != nil {
+		t.Fatalf("mutate.AppendLayers() = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeImageDiff(&buf, base, img); err != nil {
+		t.Fatalf("writeImageDiff() = %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{"   0 same", "   1 added"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeImageDiff() = %s, want to contain %q", got, want)
+		}
+	}
+}
+
+func TestResultImage(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	want := map[string]v1.Hash{}
+	for _, p := range []v1.Platform{
+		{OS: "linux", Architecture: "arm64"},
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+	} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		d, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := p
+		want[path.Join(p.OS, p.Architecture, p.Variant)] = d
+		adds = append(adds, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &p}})
+	}
+	idx := mutate.AppendManifests(empty.Index, adds...)
+
+	for spec, wantSpec := range map[string]string{
+		"":            "linux/amd64",
+		"linux/arm64": "linux/arm64",
+		"linux/arm":   "linux/arm/v7",
+	} {
+		p, err := diffPlatform(spec)
+		if err != nil {
+			t.Fatalf("diffPlatform(%q) = %v", spec, err)
+		}
+		img, err := resultImage(idx, p)
+		if err != nil {
+			t.Fatalf("resultImage(%q) = %v", spec, err)
+		}
+		got, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want[wantSpec] {
+			t.Errorf("resultImage(%q) = %v, want the image for %s", spec, got, wantSpec)
+		}
+	}
+
+	p, err := diffPlatform("windows/amd64")
+	if err != nil {
+		t.Fatalf("diffPlatform() = %v", err)
+	}
+	if _, err := resultImage(idx, p); err == nil {
+		t.Error("resultImage(windows/amd64) = nil, want error")
+	}
+	for _, spec := range []string{"all", "linux/amd64,linux/arm64", "linux"} {
+		if _, err := diffPlatform(spec); err == nil {
+			t.Errorf("diffPlatform(%q) = nil, want error", spec)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// addDoctor augments our CLI surface with doctor.
+func addDoctor(topLevel *cobra.Command) {
+	topLevel.AddCommand(&cobra.Command{
+		Use:   "doctor",
+		Short: "Check ko's configuration and environment for problems.",
+		Long:  `This sub-command validates .ko.yaml, checks that KO_DOCKER_REPO can be pushed to, that base images can be pulled, and that the Go toolchain works, so that problems are found before a long build starts.`,
+		Example: `
+  # Check everything before building.
+  ko doctor`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			ctx := createCancellableContext()
+			addFlagConfigKeys(cmd.Root())
+			if !runDoctor(ctx, os.Stdout, doctorChecks) {
+				os.Exit(1)
+			}
+		},
+	})
+}
+
+// doctorCheck is a single diagnostic. It returns warnings about things
+// that might be a problem, and an error for things that are.
+type doctorCheck struct {
+	Name string
+	Run  func(context.Context) ([]string, error)
+}
+
+var doctorChecks = []doctorCheck{
+	{"config", checkConfig},
+	{"KO_DOCKER_REPO", checkDockerRepo},
+	{"base images", checkBaseImages},
+	{"go", checkGo},
+}
+
+// runDoctor runs each check, writing the results to w, and returns whether
+// all of them passed.
+func runDoctor(ctx context.Context, w io.Writer, checks []doctorCheck) bool {
+	ok := true
+	for _, c := range checks {
+		warnings, err := c.Run(ctx)
+		switch {
+		case err != nil:
+			ok = false
+			fmt.Fprintf(w, "[FAIL] %s: %v\n", c.Name, err)
+		case len(warnings) != 0:
+			fmt.Fprintf(w, "[WARN] %s\n", c.Name)
+		default:
+			fmt.Fprintf(w, "[ OK ] %s\n", c.Name)
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(w, "       %s\n", warning)
+		}
+	}
+	return ok
+}
+
+// knownConfigKeys are the top-level keys of .ko.yaml that ko understands.
+var knownConfigKeys = map[string]bool{
+	"defaultbaseimage":   true,
+	"baseimageoverrides": true,
+	"builds":             true,
+	"profiles":           true,
+	"aliases":            true,
+	"basepolicy":         true,
+	"defaultuser":        true,
+	"init":               true,
+}
+
+// addFlagConfigKeys adds the flags of cmd and its subcommands to the known
+// keys, since any of them can be set in .ko.yaml.
+func addFlagConfigKeys(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		knownConfigKeys[f.Name] = true
+	})
+	for _, sub := range cmd.Commands() {
+		addFlagConfigKeys(sub)
+	}
+}
+
+// unknownConfigKeys returns the top-level keys (as reported by viper) that
+// ko doesn't understand, which are probably typos.
+func unknownConfigKeys(keys []string) []string {
+	seen := make(map[string]bool)
+	var unknown []string
+	for _, k := range keys {
+		top := strings.SplitN(k, ".", 2)[0]
+		if knownConfigKeys[top] || seen[top] {
+			continue
+		}
+		seen[top] = true
+		unknown = append(unknown, top)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func checkConfig(context.Context) ([]string, error) {
+	if configErr != nil {
+		return nil, configErr
+	}
+	var warnings []string
+	if len(configFiles) != 0 {
+		for _, k := range unknownConfigKeys(viper.AllKeys()) {
+			warnings = append(warnings, fmt.Sprintf("unknown key %q in %s", k, strings.Join(configFiles, " or ")))
+		}
+	}
+	return warnings, nil
+}
+
+func checkDockerRepo(context.Context) ([]string, error) {
+	repoName := os.Getenv("KO_DOCKER_REPO")
+	switch repoName {
+	case "":
+		return nil, fmt.Errorf("KO_DOCKER_REPO environment variable is unset")
+	case publish.LocalDomain, publish.KindDomain:
+		return nil, nil
+	}
+	repo, err := name.NewRepository(repoName)
+	if err != nil {
+		if _, rerr := name.NewRegistry(repoName); rerr != nil {
+			return nil, fmt.Errorf("failed to parse KO_DOCKER_REPO=%q as repository: %v", repoName, err)
+		}
+		// A bare registry; ko will push to repositories within it.
+		return []string{fmt.Sprintf("can't check push access to the registry %q itself", repoName)}, nil
+	}
+	if err := remote.CheckPushPermission(repo.Tag("ko-doctor"), keychain, http.DefaultTransport); err != nil {
+		return nil, fmt.Errorf("can't push to %s with the current credentials: %v", repo, err)
+	}
+	return nil, nil
+}
+
+func checkBaseImages(ctx context.Context) ([]string, error) {
+	if configErr != nil {
+		return nil, fmt.Errorf("see config")
+	}
+	var failed []string
+	for _, ref := range baseImages(defaultBaseImage) {
+		if _, err := remote.Head(ref,
+			remote.WithAuthFromKeychain(keychain),
+			remote.WithUserAgent(ua()),
+			remote.WithContext(ctx)); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", ref, err))
+		}
+	}
+	if len(failed) != 0 {
+		return nil, fmt.Errorf("can't pull base images:\n  %s", strings.Join(failed, "\n  "))
+	}
+	return nil, nil
+}
+
+func checkGo(ctx context.Context) ([]string, error) {
+	if out, err := exec.CommandContext(ctx, "go", "version").CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("go is not available: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	var warnings []string
+	if os.Getenv("CGO_ENABLED") == "1" {
+		warnings = append(warnings, "CGO_ENABLED=1 will produce binaries that may not run on the base image")
+	}
+	return warnings, nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnknownConfigKeys(t *testing.T) {
+	got := unknownConfigKeys([]string{
+		"defaultbaseimage",
+		"baseimageoverrides.github.com/foo/bar",
+		"basimageoverrides.github.com/foo/bar",
+		"basimageoverrides.github.com/foo/baz",
+		"defaultbase",
+	})
+	want := []string{"basimageoverrides", "defaultbase"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unknownConfigKeys() (-want +got) = %s", diff)
+	}
+}
+
+func TestRunDoctor(t *testing.T) {
+	ctx := context.Background()
+	checks := []doctorCheck{
+		{"good", func(context.Context) ([]string, error) { return nil, nil }},
+		{"meh", func(context.Context) ([]string, error) { return []string{"hmm"}, nil }},
+		{"bad", func(context.Context) ([]string, error) { return nil, errors.New("broken") }},
+	}
+
+	var buf bytes.Buffer
+	if runDoctor(ctx, &buf, checks) {
+		t.Error("runDoctor() = true, wanted false")
+	}
+	want := `[ OK ] good
+[WARN] meh
+       hmm
+[FAIL] bad: broken
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("runDoctor() (-want +got) = %s", diff)
+	}
+
+	buf.Reset()
+	if !runDoctor(ctx, &buf, checks[:2]) {
+		t.Error("runDoctor() = false, wanted true")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+)
+
+// placeholderDigest stands in for the digest of images that a dry run has
+// no previous digest for.
+const placeholderDigest = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+
+// dryRunBuilder recognizes the same references as inner, but doesn't build
+// anything.
+type dryRunBuilder struct {
+	inner build.Interface
+}
+
+// IsSupportedReference implements build.Interface
+func (b *dryRunBuilder) IsSupportedReference(s string) error {
+	return b.inner.IsSupportedReference(s)
+}
+
+// Build implements build.Interface
+func (b *dryRunBuilder) Build(context.Context, string) (build.Result, error) {
+	return empty.Image, nil
+}
+
+func makeDryRunBuilder(ctx context.Context, bo *options.BuildOptions) (*build.Caching, error) {
+	innerBuilder, err := makeUncachedBuilder(ctx, bo)
+	if err != nil {
+		return nil, err
+	}
+	return build.NewCaching(&dryRunBuilder{inner: innerBuilder})
+}
+
+// dryRunPublisher predicts the reference that each import path would be
+// published as, using the digest it was last published with if known.
+type dryRunPublisher struct {
+	repoName string
+	namer    publish.Namer
+	digests  map[string]string
+}
+
+// Publish implements publish.Interface
+func (p *dryRunPublisher) Publish(_ context.Context, _ build.Result, s string) (name.Reference, error) {
+	digest, ok := p.digests[s]
+	s = strings.TrimPrefix(s, build.StrictScheme)
+	if !ok {
+		if digest, ok = p.digests[s]; !ok {
+			digest = placeholderDigest
+		}
+	}
+	return name.NewDigest(fmt.Sprintf("%s@%s", p.namer(p.repoName, s), digest))
+}
+
+// Close implements publish.Interface
+func (p *dryRunPublisher) Close() error { return nil }
+
+func makeDryRunPublisher(po *options.PublishOptions, sto *options.StateOptions) (publish.Interface, error) {
+	repoName := os.Getenv("KO_DOCKER_REPO")
+	if po.Local {
+		repoName = publish.LocalDomain
+	}
+	if repoName == "" {
+		return nil, fmt.Errorf("KO_DOCKER_REPO environment variable is unset")
+	}
+	digests, err := knownDigests(sto)
+	if err != nil {
+		return nil, err
+	}
+	return &dryRunPublisher{
+		repoName: repoName,
+		namer:    options.MakeNamer(po),
+		digests:  digests,
+	}, nil
+}
+
+// knownDigests collects the digest each import path was last published with
+// from the state file and lockfile, preferring the lockfile.
+func knownDigests(sto *options.StateOptions) (map[string]string, error) {
+	digests := make(map[string]string)
+	if sto.StateFile != "" {
+		state, err := readState(sto.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		for importpath, ref := range state.Images {
+			if i := strings.LastIndex(ref, "@"); i != -1 {
+				digests[importpath] = ref[i+1:]
+			}
+		}
+	}
+	if sto.LockFile != "" {
+		lf, err := readLockfile(sto.LockFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading lockfile: %v", err)
+		}
+		if lf != nil {
+			for importpath, digest := range lf.Images {
+				digests[importpath] = digest
+			}
+		}
+	}
+	return digests, nil
+}
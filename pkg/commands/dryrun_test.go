@@ -0,0 +1,102 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestResolveDryRun(t *testing.T) {
+	builder, err := build.NewCaching(&dryRunBuilder{inner: testBuilder})
+	if err != nil {
+		t.Fatalf("NewCaching() = %v", err)
+	}
+	publisher := &dryRunPublisher{
+		repoName: "gcr.io/dry",
+		namer: func(base, s string) string {
+			return base + "/" + filepath.Base(s)
+		},
+		digests: map[string]string{fooRef: fooHash.String()},
+	}
+	f := yamlToTmpFile(t, []byte(`images:
+- ko://`+fooRef+`
+- ko://`+barRef+`
+`))
+
+	buf := bytes.NewBuffer(nil)
+	if err := resolveFilesToWriter(context.Background(), builder, publisher,
+		&options.FilenameOptions{Filenames: []string{f}},
+		&options.SelectorOptions{}, nil, nopWriteCloser{buf}, nil); err != nil {
+		t.Fatalf("resolveFilesToWriter() = %v", err)
+	}
+
+	want := `images:
+  - gcr.io/dry/foo@` + fooHash.String() + `
+  - gcr.io/dry/bar@` + placeholderDigest + `
+
+---
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("resolveFilesToWriter() (-want +got) = %s", diff)
+	}
+}
+
+func TestKnownDigests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-dry-run")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "state.json")
+	if err := writeState(stateFile, &resolveState{Images: map[string]string{
+		fooRef: "gcr.io/dry/foo@sha256:" + strings.Repeat("a", 64),
+		barRef: "gcr.io/dry/bar@sha256:" + strings.Repeat("b", 64),
+	}}); err != nil {
+		t.Fatalf("writeState() = %v", err)
+	}
+	lockFile := filepath.Join(dir, "ko.lock")
+	if err := writeLockfile(lockFile, &lockfile{Images: map[string]string{
+		fooRef: "sha256:" + strings.Repeat("c", 64),
+	}}); err != nil {
+		t.Fatalf("writeLockfile() = %v", err)
+	}
+
+	got, err := knownDigests(&options.StateOptions{StateFile: stateFile, LockFile: lockFile})
+	if err != nil {
+		t.Fatalf("knownDigests() = %v", err)
+	}
+	want := map[string]string{
+		fooRef: "sha256:" + strings.Repeat("c", 64),
+		barRef: "sha256:" + strings.Repeat("b", 64),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("knownDigests() (-want +got) = %s", diff)
+	}
+
+	// A missing lockfile is fine.
+	if _, err := knownDigests(&options.StateOptions{LockFile: filepath.Join(dir, "missing.lock")}); err != nil {
+		t.Errorf("knownDigests() = %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// hint returns advice on how to fix err, if it is one of the failures that
+// build and publish distinguish, or else "".
+func hint(err error) string {
+	switch {
+	case errors.Is(err, build.ErrNotMainPackage):
+		return "ko can only build binaries: reference an import path that is `package main`."
+	case errors.Is(err, build.ErrUnsupportedReference):
+		return "References must be ko:// followed by the import path of a main package in this module (or a dependency of it), e.g. ko://github.com/my-org/my-repo/cmd/app."
+	case errors.Is(err, build.ErrBaseImageUnavailable):
+		return "Check defaultBaseImage and baseImageOverrides in .ko.yaml (or --base-image), and that you can pull them; `ko doctor` checks both."
+	case errors.Is(err, errBasePolicy):
+		return "Use a base image that the basePolicy in .ko.yaml (or in /etc/ko/config.yaml or ~/.config/ko/config.yaml) allows, or ask whoever maintains that policy."
+	case errors.Is(err, errVulnerable):
+		return "Update the base image or the Go dependencies with the vulnerabilities above, or change --scan-severity."
+	case errors.Is(err, build.ErrKoDataSecret):
+		return "Remove the secrets from kodata (or list them in .koignore), and rotate any that were already published. Use --kodata-secrets=warn to build anyway."
+	case errors.Is(err, publish.ErrPushDenied):
+		return "Check that KO_DOCKER_REPO is right and that you are logged in (e.g. with `docker login`) with credentials that can push to it; `ko doctor` checks both."
+	}
+	return ""
+}
+
+// withHint formats err followed by the hint for fixing it, if there is one.
+// The output of failed builds is replaced by what formatCompileError makes of
+// it.
+func withHint(err error) string {
+	msg := err.Error()
+	color := stderrIsTerminal()
+	for _, ce := range compileErrors(err) {
+		msg = strings.Replace(msg, ce.Error(), formatCompileError(ce, color), 1)
+	}
+	if h := hint(err); h != "" {
+		return msg + "\n" + h
+	}
+	return msg
+}
+
+// compileErrors returns the failed builds in err, one per import path.
+func compileErrors(err error) []*build.CompileError {
+	var ces []*build.CompileError
+	var ce *build.CompileError
+	if ipes := importPathErrors(err); len(ipes) != 0 {
+		for _, ipe := range ipes {
+			if errors.As(ipe, &ce) {
+				ces = append(ces, ce)
+			}
+		}
+	} else if errors.As(err, &ce) {
+		ces = append(ces, ce)
+	}
+	return ces
+}
+
+const (
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiReset = "\x1b[0m"
+)
+
+// formatCompileError formats the problems that go build reported, grouped by
+// package, optionally with ANSI colors. Output that can't be parsed is kept
+// as it is.
+func formatCompileError(ce *build.CompileError, color bool) string {
+	if len(ce.Diagnostics) == 0 {
+		return ce.Error()
+	}
+	style := func(code, s string) string {
+		if !color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+	problems := "problems"
+	if len(ce.Diagnostics) == 1 {
+		problems = "problem"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s for %s: %d %s", style(ansiRed, "failed to build"), style(ansiBold, ce.ImportPath), ce.Platform, len(ce.Diagnostics), problems)
+	pkg := ""
+	for i, d := range ce.Diagnostics {
+		if i == 0 || d.Package != pkg {
+			pkg = d.Package
+			if pkg != "" {
+				fmt.Fprintf(&b, "\n  %s", style(ansiBold, pkg))
+			}
+		}
+		message := strings.Replace(d.Message, "\n", "\n      ", -1)
+		fmt.Fprintf(&b, "\n    %s: %s", style(ansiBold, d.Position()), message)
+	}
+	return b.String()
+}
+
+// stderrIsTerminal reports whether stderr is a terminal that understands
+// colors.
+func stderrIsTerminal() bool {
+	return os.Getenv("NO_COLOR") == "" && stderrIsTTY()
+}
+
+// stderrIsTTY reports whether stderr is a terminal that can move the cursor.
+func stderrIsTTY() bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
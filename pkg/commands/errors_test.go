@@ -0,0 +1,84 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/resolve"
+)
+
+func TestWithHint(t *testing.T) {
+	err := &fileError{
+		File: "config/app.yaml",
+		Err: &resolve.ImportPathError{
+			ImportPath: "ko://github.com/foo/bar",
+			Err:        fmt.Errorf("found strict reference but ko://github.com/foo/bar is not a valid import path: %w", build.ErrNotMainPackage),
+		},
+	}
+	if got, want := withHint(err), "`package main`"; !strings.Contains(got, "\n") || !strings.HasSuffix(got, want+".") {
+		t.Errorf("withHint() = %q, wanted a hint ending in %s", got, want)
+	}
+
+	other := errors.New("something else")
+	if got, want := withHint(other), other.Error(); got != want {
+		t.Errorf("withHint() = %q, wanted %q", got, want)
+	}
+}
+
+func TestWithHintCompileErrors(t *testing.T) {
+	output := `# github.com/foo/bar/pkg/baz
+pkg/baz/baz.go:3:2: undefined: qux
+# github.com/foo/bar/cmd/app
+./main.go:10:5: x declared but not used
+`
+	ce := &build.CompileError{
+		ImportPath:  "github.com/foo/bar/cmd/app",
+		Platform:    "linux/amd64",
+		Output:      output,
+		Diagnostics: build.ParseDiagnostics(output),
+		Err:         errors.New("exit status 2"),
+	}
+	err := resolve.Errors{{
+		ImportPath: "ko://github.com/foo/bar/cmd/app",
+		Err:        ce,
+	}, {
+		ImportPath: "ko://github.com/foo/bar/cmd/other",
+		Err:        errors.New("something else"),
+	}}
+
+	// Don't color the output, even when the tests run in a terminal.
+	defer os.Setenv("NO_COLOR", os.Getenv("NO_COLOR"))
+	os.Setenv("NO_COLOR", "1")
+	got := withHint(&fileError{File: "config/app.yaml", Err: err})
+	want := `error processing import paths in "config/app.yaml": failed to build github.com/foo/bar/cmd/app for linux/amd64: 2 problems
+  github.com/foo/bar/pkg/baz
+    pkg/baz/baz.go:3:2: undefined: qux
+  github.com/foo/bar/cmd/app
+    main.go:10:5: x declared but not used
+something else`
+	if got != want {
+		t.Errorf("withHint() = %s, wanted %s", got, want)
+	}
+
+	if got := formatCompileError(ce, true); !strings.Contains(got, ansiBold+"main.go:10:5"+ansiReset) {
+		t.Errorf("formatCompileError() = %q, wanted colored positions", got)
+	}
+}
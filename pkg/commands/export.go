@@ -0,0 +1,44 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"os"
+
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+)
+
+// makeExportingPublisher returns a publisher that also unpacks images into
+// eo.Output, if it is set. When that is all that is asked for, it is the
+// only publisher, so that no repository needs to be configured.
+func makeExportingPublisher(po *options.PublishOptions, eo *options.ExportOptions) (publish.Interface, error) {
+	if eo.Output == "" {
+		return makePublisher(po)
+	}
+	namer := options.MakeNamer(po)
+	repoName := os.Getenv("KO_DOCKER_REPO")
+	if repoName == "" && !po.Push && !po.Local && po.OCILayoutPath == "" && po.TarballFile == "" && po.Bundle == "" {
+		// References still need a repository, so name them like
+		// images that are only local.
+		return publish.NewExport(eo.Output, publish.LocalDomain, namer), nil
+	}
+	inner, err := makePublisher(po)
+	if err != nil {
+		return nil, err
+	}
+	// The last publisher's references win.
+	return publish.MultiPublisher(publish.NewExport(eo.Output, repoName, namer), inner), nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestMakeExportingPublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-export")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(v string) { os.Setenv("KO_DOCKER_REPO", v) }(os.Getenv("KO_DOCKER_REPO"))
+	os.Unsetenv("KO_DOCKER_REPO")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+
+	po := &options.PublishOptions{BaseImportPaths: true}
+	eo := &options.ExportOptions{Output: dir}
+	// Exporting is enough to need neither a registry nor KO_DOCKER_REPO.
+	p, err := makeExportingPublisher(po, eo)
+	if err != nil {
+		t.Fatalf("makeExportingPublisher() = %v", err)
+	}
+	ref, err := p.Publish(context.Background(), img, build.StrictScheme+"github.com/google/ko/cmd/app")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if !strings.HasPrefix(ref.String(), "ko.local/app@sha256:") {
+		t.Errorf("Publish() = %v, wanted ko.local/app@sha256:...", ref)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app")); err != nil {
+		t.Errorf("Stat() = %v, wanted the image exported", err)
+	}
+
+	po.Push = true
+	if _, err := makeExportingPublisher(po, eo); err == nil {
+		t.Error("makeExportingPublisher() = nil, wanted an error pushing without KO_DOCKER_REPO")
+	}
+}
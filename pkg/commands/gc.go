@@ -0,0 +1,287 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+)
+
+// addGC augments our CLI surface with gc.
+func addGC(topLevel *cobra.Command) {
+	po := &options.PublishOptions{}
+	var keep int
+	var lockfiles []string
+	var dryRun bool
+
+	gc := &cobra.Command{
+		Use:   "gc [IMPORTPATH...]",
+		Short: "Delete old images that ko published to KO_DOCKER_REPO.",
+		Long: `This sub-command lists the images in each repository ko publishes the given import paths to (or, with no import paths, every repository under KO_DOCKER_REPO), keeps the most recent ones and any that are referenced by a lockfile, and prints the rest. With --dry-run=false, it deletes them.
+
+Only images that ko built, for an import path that ko publishes to the repository they are in, are ever deleted; anything else in the registry is left alone. Images are ordered by their creation time, so build with SOURCE_DATE_EPOCH set. Images without a creation time are never deleted.`,
+		Example: `
+  # See what would be deleted from the repositories of these
+  # import paths, keeping the 5 most recent images of each.
+  ko gc ./cmd/foo ./cmd/bar
+
+  # Delete all but the 10 most recent images of every repository
+  # under KO_DOCKER_REPO, except those pinned in ko.lock.
+  ko gc --dry-run=false --keep=10 --lockfile=ko.lock`,
+		ValidArgsFunction: completeImportPaths,
+		Run: func(_ *cobra.Command, args []string) {
+			ctx := createCancellableContext()
+			if keep < 0 {
+				log.Fatal("--keep must not be negative")
+			}
+
+			pinned := make(map[string]bool)
+			for _, path := range lockfiles {
+				lf, err := readLockfile(path)
+				if err != nil {
+					log.Fatalf("error reading lockfile: %v", err)
+				}
+				for _, digest := range lf.Images {
+					pinned[digest] = true
+				}
+			}
+
+			repos, err := gcRepositories(ctx, po, args)
+			if err != nil {
+				log.Fatal(err)
+			}
+			owns := koPublished(os.Getenv("KO_DOCKER_REPO"), options.MakeNamer(po))
+			ropt := []remote.Option{
+				remote.WithAuthFromKeychain(keychain),
+				remote.WithUserAgent(ua()),
+				remote.WithContext(ctx),
+			}
+			for _, repo := range repos {
+				if err := gcRepository(repo, owns, keep, pinned, dryRun, ropt...); err != nil {
+					log.Fatalf("error collecting %s: %v", repo, err)
+				}
+			}
+		},
+	}
+	// Only the flags that affect where images are published apply.
+	gc.Flags().BoolVarP(&po.PreserveImportPaths, "preserve-import-paths", "P", po.PreserveImportPaths,
+		"Whether import paths were published with their full import path after KO_DOCKER_REPO.")
+	gc.Flags().BoolVarP(&po.BaseImportPaths, "base-import-paths", "B", po.BaseImportPaths,
+		"Whether import paths were published with the base path without MD5 hash after KO_DOCKER_REPO.")
+	gc.Flags().BoolVar(&po.Bare, "bare", po.Bare,
+		"Whether import paths were published to KO_DOCKER_REPO without additional context.")
+	gc.Flags().BoolVar(&po.InsecureRegistry, "insecure-registry", po.InsecureRegistry,
+		"Whether to skip TLS verification on the registry")
+	gc.Flags().IntVar(&keep, "keep", 5,
+		"How many of the most recent images to keep in each repository.")
+	gc.Flags().StringSliceVar(&lockfiles, "lockfile", nil,
+		"Lockfiles whose images should never be deleted.")
+	scopeFlag(gc, "lockfile")
+	gc.Flags().BoolVar(&dryRun, "dry-run", true,
+		"Only print what would be deleted. Pass --dry-run=false to delete it.")
+	scopeFlag(gc, "dry-run")
+	topLevel.AddCommand(gc)
+}
+
+// gcRepositories returns the repositories that ko publishes importpaths to,
+// or every repository under KO_DOCKER_REPO if there are none.
+func gcRepositories(ctx context.Context, po *options.PublishOptions, importpaths []string) ([]name.Repository, error) {
+	repoName := os.Getenv("KO_DOCKER_REPO")
+	if repoName == "" {
+		return nil, fmt.Errorf("KO_DOCKER_REPO environment variable is unset")
+	}
+	var no []name.Option
+	if po.InsecureRegistry {
+		no = append(no, name.Insecure)
+	}
+
+	var repos []name.Repository
+	if len(importpaths) != 0 {
+		importpaths, err := expandImportPaths(importpaths)
+		if err != nil {
+			return nil, err
+		}
+		namer := options.MakeNamer(po)
+		for _, ip := range importpaths {
+			ip, err := qualifyImportPath(ip)
+			if err != nil {
+				return nil, err
+			}
+			repo, err := name.NewRepository(namer(repoName, strings.ToLower(ip)), no...)
+			if err != nil {
+				return nil, err
+			}
+			repos = append(repos, repo)
+		}
+		return repos, nil
+	}
+
+	base, err := name.NewRepository(repoName, no...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse environment variable KO_DOCKER_REPO=%q as repository: %v", repoName, err)
+	}
+	all, err := remote.Catalog(ctx, base.Registry, remote.WithAuthFromKeychain(keychain), remote.WithUserAgent(ua()))
+	if err != nil {
+		return nil, fmt.Errorf("listing repositories: %v", err)
+	}
+	for _, r := range all {
+		if r != base.RepositoryStr() && !strings.HasPrefix(r, base.RepositoryStr()+"/") {
+			continue
+		}
+		repo, err := name.NewRepository(base.RegistryStr()+"/"+r, no...)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// gcImage is an image in a repository, along with the tags pointing at it.
+type gcImage struct {
+	Digest  string
+	Tags    []string
+	Created time.Time
+}
+
+// koPublished returns whether ko publishes the import path to repo with
+// namer, under the given KO_DOCKER_REPO.
+func koPublished(repoName string, namer publish.Namer) func(repo name.Repository, importpath string) bool {
+	return func(repo name.Repository, importpath string) bool {
+		if importpath == "" {
+			return false
+		}
+		want, err := name.NewRepository(namer(repoName, strings.ToLower(importpath)), name.WeakValidation)
+		return err == nil && want.RepositoryStr() == repo.RepositoryStr() && want.RegistryStr() == repo.RegistryStr()
+	}
+}
+
+// koImportPath returns the import path that ko built an image from, from
+// the history of its layers, or "" if ko didn't build it.
+func koImportPath(cf *v1.ConfigFile) string {
+	for _, h := range cf.History {
+		if strings.HasPrefix(h.CreatedBy, "ko publish ") {
+			return strings.TrimPrefix(strings.TrimPrefix(h.CreatedBy, "ko publish "), build.StrictScheme)
+		}
+	}
+	return ""
+}
+
+// gcRepository deletes (or with dryRun, prints) all but the keep most
+// recent images in repo that aren't pinned, of those that ko published to
+// repo, as told by owns.
+func gcRepository(repo name.Repository, owns func(name.Repository, string) bool, keep int, pinned map[string]bool, dryRun bool, ropt ...remote.Option) error {
+	garbage, err := gcGarbage(repo, owns, keep, pinned, ropt...)
+	if err != nil {
+		return err
+	}
+	for _, img := range garbage {
+		ref := repo.Digest(img.Digest)
+		if dryRun {
+			fmt.Printf("Would delete %s (tags: %s)\n", ref, strings.Join(img.Tags, ", "))
+			continue
+		}
+		log.Printf("Deleting %s (tags: %s)", ref, strings.Join(img.Tags, ", "))
+		if err := remote.Delete(ref, ropt...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcGarbage returns the images that gcRepository deletes from repo.
+func gcGarbage(repo name.Repository, owns func(name.Repository, string) bool, keep int, pinned map[string]bool, ropt ...remote.Option) ([]gcImage, error) {
+	tags, err := remote.List(repo, ropt...)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make(map[string]*gcImage)
+	foreign := make(map[string]bool)
+	for _, t := range tags {
+		desc, err := remote.Get(repo.Tag(t), ropt...)
+		if err != nil {
+			return nil, err
+		}
+		digest := desc.Digest.String()
+		if img, ok := images[digest]; ok {
+			img.Tags = append(img.Tags, t)
+			continue
+		}
+		if foreign[digest] {
+			continue
+		}
+		// For an index, this is the image for our platform.
+		var cf *v1.ConfigFile
+		if i, err := desc.Image(); err == nil {
+			cf, _ = i.ConfigFile()
+		}
+		if cf == nil || !owns(repo, koImportPath(cf)) {
+			foreign[digest] = true
+			continue
+		}
+		images[digest] = &gcImage{Digest: digest, Tags: []string{t}, Created: cf.Created.Time}
+	}
+	if len(foreign) != 0 {
+		log.Printf("Leaving %d images in %s alone that ko didn't publish there.", len(foreign), repo)
+	}
+
+	var list []gcImage
+	for _, img := range images {
+		list = append(list, *img)
+	}
+	return gcCollect(list, keep, pinned), nil
+}
+
+// gcCollect returns the images that should be deleted: everything but the
+// keep most recently created images, except for pinned digests and images
+// whose age we can't tell.
+func gcCollect(images []gcImage, keep int, pinned map[string]bool) []gcImage {
+	sort.Slice(images, func(i, j int) bool {
+		if !images[i].Created.Equal(images[j].Created) {
+			return images[i].Created.After(images[j].Created)
+		}
+		return images[i].Digest < images[j].Digest
+	})
+
+	var unknown int
+	var garbage []gcImage
+	for i, img := range images {
+		switch {
+		case i < keep, pinned[img.Digest]:
+		case img.Created.IsZero() || img.Created.Unix() == 0:
+			unknown++
+		default:
+			garbage = append(garbage, img)
+		}
+	}
+	if unknown != 0 {
+		log.Printf("Keeping %d images without a creation time; build with SOURCE_DATE_EPOCH to allow collecting them.", unknown)
+	}
+	return garbage
+}
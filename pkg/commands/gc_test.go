@@ -0,0 +1,118 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestGCCollect(t *testing.T) {
+	day := func(n int) time.Time {
+		return time.Date(2021, time.January, n, 0, 0, 0, 0, time.UTC)
+	}
+	images := []gcImage{
+		{Digest: "sha256:a", Created: day(1)},
+		{Digest: "sha256:b", Created: day(4)},
+		{Digest: "sha256:c", Created: day(2)},
+		{Digest: "sha256:d", Created: day(3)},
+		{Digest: "sha256:e", Created: time.Unix(0, 0)},
+	}
+	for _, c := range []struct {
+		desc   string
+		keep   int
+		pinned map[string]bool
+		want   []string
+	}{{
+		desc: "keep two",
+		keep: 2,
+		want: []string{"sha256:c", "sha256:a"},
+	}, {
+		desc:   "pinned",
+		keep:   2,
+		pinned: map[string]bool{"sha256:a": true},
+		want:   []string{"sha256:c"},
+	}, {
+		desc: "keep none",
+		keep: 0,
+		want: []string{"sha256:b", "sha256:d", "sha256:c", "sha256:a"},
+	}, {
+		desc: "keep all",
+		keep: 10,
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			var got []string
+			for _, img := range gcCollect(append([]gcImage(nil), images...), c.keep, c.pinned) {
+				got = append(got, img.Digest)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("gcCollect() (-want +got) = %s", diff)
+			}
+		})
+	}
+}
+
+func TestKoPublished(t *testing.T) {
+	owns := koPublished("gcr.io/ko", func(base, importpath string) string {
+		return path.Join(base, path.Base(importpath))
+	})
+	app, err := name.NewRepository("gcr.io/ko/app")
+	if err != nil {
+		t.Fatalf("NewRepository() = %v", err)
+	}
+	for _, c := range []struct {
+		desc    string
+		history []v1.History
+		want    bool
+	}{{
+		desc:    "strict",
+		history: []v1.History{{CreatedBy: "base"}, {CreatedBy: "ko publish ko://example.com/app"}},
+		want:    true,
+	}, {
+		desc:    "not strict",
+		history: []v1.History{{CreatedBy: "ko publish example.com/cmd/app"}},
+		want:    true,
+	}, {
+		desc:    "not ko",
+		history: []v1.History{{CreatedBy: "COPY app /app"}},
+	}, {
+		desc:    "another import path",
+		history: []v1.History{{CreatedBy: "ko publish ko://example.com/other"}},
+	}, {
+		desc: "no history",
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			ip := koImportPath(&v1.ConfigFile{History: c.history})
+			if got := owns(app, ip); got != c.want {
+				t.Errorf("koPublished()(%s, %q) = %t, want %t", app, ip, got, c.want)
+			}
+		})
+	}
+
+	// Bare repositories are shared, but only with ko's images.
+	bare := koPublished("gcr.io/ko", func(base, _ string) string { return base })
+	ko, err := name.NewRepository("gcr.io/ko")
+	if err != nil {
+		t.Fatalf("NewRepository() = %v", err)
+	}
+	if bare(ko, koImportPath(&v1.ConfigFile{})) {
+		t.Error("koPublished() = true for an image ko didn't build")
+	}
+}
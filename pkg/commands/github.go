@@ -0,0 +1,113 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/ko/pkg/build"
+)
+
+// invalidOutputChars are those that can't appear in the name of a GitHub
+// Actions step output.
+var invalidOutputChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// githubOutputName returns the step output name for importpath, e.g.
+// github.com/foo/bar/cmd/baz becomes github_com_foo_bar_cmd_baz.
+func githubOutputName(importpath string) string {
+	return invalidOutputChars.ReplaceAllString(importpath, "_")
+}
+
+// writeGitHubOutputs appends the published reference of each import path to
+// $GITHUB_OUTPUT, both as its own output and as a JSON map named "images",
+// and appends a table of them to $GITHUB_STEP_SUMMARY if it is set.
+func writeGitHubOutputs(images map[string]string) error {
+	outputs := os.Getenv("GITHUB_OUTPUT")
+	if outputs == "" {
+		return fmt.Errorf("GITHUB_OUTPUT is unset; --github-outputs only works in GitHub Actions")
+	}
+	m := make(map[string]string, len(images))
+	for importpath, ref := range images {
+		m[strings.TrimPrefix(importpath, build.StrictScheme)] = ref
+	}
+	images = m
+	if err := appendFile(outputs, func(w io.Writer) error {
+		return writeGitHubOutputFile(w, images)
+	}); err != nil {
+		return fmt.Errorf("error writing GitHub outputs: %v", err)
+	}
+	if summary := os.Getenv("GITHUB_STEP_SUMMARY"); summary != "" {
+		if err := appendFile(summary, func(w io.Writer) error {
+			return writeGitHubSummary(w, images)
+		}); err != nil {
+			return fmt.Errorf("error writing GitHub job summary: %v", err)
+		}
+	}
+	return nil
+}
+
+func writeGitHubOutputFile(w io.Writer, images map[string]string) error {
+	b, err := json.Marshal(images)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "images=%s\n", b)
+	for _, importpath := range sortedKeys(images) {
+		fmt.Fprintf(buf, "%s=%s\n", githubOutputName(importpath), images[importpath])
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+func writeGitHubSummary(w io.Writer, images map[string]string) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "| Import path | Image |")
+	fmt.Fprintln(buf, "| --- | --- |")
+	for _, importpath := range sortedKeys(images) {
+		fmt.Fprintf(buf, "| `%s` | `%s` |\n", importpath, images[importpath])
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendFile calls write with path opened for appending.
+func appendFile(path string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteGitHubOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-github")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputs, summary := filepath.Join(dir, "output"), filepath.Join(dir, "summary")
+	if err := ioutil.WriteFile(outputs, []byte("before=1\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	for k, v := range map[string]string{"GITHUB_OUTPUT": outputs, "GITHUB_STEP_SUMMARY": summary} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	if err := writeGitHubOutputs(map[string]string{
+		"ko://github.com/foo/bar/cmd/baz": "gcr.io/foo/baz@sha256:abc",
+		"github.com/foo/bar/cmd/qux":      "gcr.io/foo/qux@sha256:def",
+	}); err != nil {
+		t.Fatalf("writeGitHubOutputs() = %v", err)
+	}
+
+	for path, want := range map[string]string{
+		outputs: `before=1
+images={"github.com/foo/bar/cmd/baz":"gcr.io/foo/baz@sha256:abc","github.com/foo/bar/cmd/qux":"gcr.io/foo/qux@sha256:def"}
+github_com_foo_bar_cmd_baz=gcr.io/foo/baz@sha256:abc
+github_com_foo_bar_cmd_qux=gcr.io/foo/qux@sha256:def
+`,
+		summary: "| Import path | Image |\n" +
+			"| --- | --- |\n" +
+			"| `github.com/foo/bar/cmd/baz` | `gcr.io/foo/baz@sha256:abc` |\n" +
+			"| `github.com/foo/bar/cmd/qux` | `gcr.io/foo/qux@sha256:def` |\n",
+	} {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("%s (-want +got) = %s", filepath.Base(path), diff)
+		}
+	}
+
+	os.Setenv("GITHUB_OUTPUT", "")
+	if err := writeGitHubOutputs(nil); err == nil {
+		t.Error("writeGitHubOutputs() = nil, wanted an error outside of GitHub Actions")
+	}
+}
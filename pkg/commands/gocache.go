@@ -0,0 +1,125 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+// goCache is a GOCACHE or GOMODCACHE that builds were pointed at.
+type goCache struct {
+	env, dir string
+	// before is what was in it beforehand, if --go-cache-stats is set.
+	before *cacheStats
+}
+
+var (
+	goCachesMu sync.Mutex
+	goCaches   = map[string]*goCache{}
+)
+
+// cacheStats describe the contents of a cache directory.
+type cacheStats struct {
+	files int
+	bytes int64
+}
+
+func (s cacheStats) String() string {
+	return fmt.Sprintf("%d files, %.1f MiB", s.files, float64(s.bytes)/(1<<20))
+}
+
+// statCache adds up the files in dir.
+func statCache(dir string) (cacheStats, error) {
+	var s cacheStats
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			s.files++
+			s.bytes += info.Size()
+		}
+		return nil
+	})
+	return s, err
+}
+
+// useGoCaches points every go command that ko runs (go build, go list and
+// go mod download) at the GOCACHE and GOMODCACHE of bo, if they are set.
+func useGoCaches(bo *options.BuildOptions) error {
+	goCachesMu.Lock()
+	defer goCachesMu.Unlock()
+	for _, c := range []struct{ env, dir string }{
+		{"GOCACHE", bo.GoCache},
+		{"GOMODCACHE", bo.GoModCache},
+	} {
+		if c.dir == "" {
+			continue
+		}
+		// The go command insists on absolute paths.
+		dir, err := filepath.Abs(c.dir)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("creating %s: %v", c.env, err)
+		}
+		if err := os.Setenv(c.env, dir); err != nil {
+			return err
+		}
+		if _, ok := goCaches[c.env]; ok {
+			continue
+		}
+		gc := &goCache{env: c.env, dir: dir}
+		if bo.GoCacheStats {
+			s, err := statCache(dir)
+			if err != nil {
+				return err
+			}
+			gc.before = &s
+		}
+		goCaches[c.env] = gc
+	}
+	return nil
+}
+
+// reportGoCaches logs what is in the caches that useGoCaches was asked for
+// statistics of, and how much that grew.
+func reportGoCaches() {
+	goCachesMu.Lock()
+	defer goCachesMu.Unlock()
+	for _, env := range []string{"GOCACHE", "GOMODCACHE"} {
+		gc, ok := goCaches[env]
+		if !ok || gc.before == nil {
+			continue
+		}
+		after, err := statCache(gc.dir)
+		if err != nil {
+			log.Printf("Unable to read %s %s: %v", env, gc.dir, err)
+			continue
+		}
+		log.Printf("%s %s: %v (%+d files, %+.1f MiB)", env, gc.dir, after,
+			after.files-gc.before.files, float64(after.bytes-gc.before.bytes)/(1<<20))
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestUseGoCaches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-gocache")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, env := range []string{"GOCACHE", "GOMODCACHE"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	defer func() { goCaches = map[string]*goCache{} }()
+
+	bo := &options.BuildOptions{
+		GoCache:      filepath.Join(dir, "gocache"),
+		GoModCache:   filepath.Join(dir, "gomodcache"),
+		GoCacheStats: true,
+	}
+	if err := useGoCaches(bo); err != nil {
+		t.Fatalf("useGoCaches() = %v", err)
+	}
+	if got := os.Getenv("GOCACHE"); got != bo.GoCache {
+		t.Errorf("GOCACHE = %q, want %q", got, bo.GoCache)
+	}
+	if got := os.Getenv("GOMODCACHE"); got != bo.GoModCache {
+		t.Errorf("GOMODCACHE = %q, want %q", got, bo.GoModCache)
+	}
+	if fi, err := os.Stat(bo.GoCache); err != nil || !fi.IsDir() {
+		t.Errorf("Stat(%s) = %v, want a directory", bo.GoCache, err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(bo.GoCache, "entry"), []byte("12345"), 0644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	s, err := statCache(bo.GoCache)
+	if err != nil {
+		t.Fatalf("statCache() = %v", err)
+	}
+	if s.files != 1 || s.bytes != 5 {
+		t.Errorf("statCache() = %v, want 1 file of 5 bytes", s)
+	}
+	if gc := goCaches["GOCACHE"]; gc == nil || gc.before == nil || gc.before.files != 0 {
+		t.Errorf("goCaches[GOCACHE] = %+v, want it empty beforehand", gc)
+	}
+	reportGoCaches()
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+// useModuleEnv points every go command that ko runs at the module proxy,
+// private modules and credentials of bo, if they are set, so that builds
+// don't depend on how the environment happens to be configured.
+func useModuleEnv(bo *options.BuildOptions) error {
+	for _, e := range []struct{ env, value string }{
+		{"GOPROXY", bo.GoProxy},
+		{"GOPRIVATE", bo.GoPrivate},
+		{"GONOSUMDB", bo.GoNoSumDB},
+	} {
+		if e.value == "" {
+			continue
+		}
+		if err := os.Setenv(e.env, e.value); err != nil {
+			return err
+		}
+	}
+	if bo.Netrc != "" {
+		netrc, err := filepath.Abs(bo.Netrc)
+		if err != nil {
+			return err
+		}
+		// Fail here, rather than with an authentication error for a
+		// private module halfway through the build.
+		if _, err := os.Stat(netrc); err != nil {
+			return fmt.Errorf("--netrc: %v", err)
+		}
+		if err := os.Setenv("NETRC", netrc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
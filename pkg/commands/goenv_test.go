@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestUseModuleEnv(t *testing.T) {
+	for _, env := range []string{"GOPROXY", "GOPRIVATE", "GONOSUMDB", "NETRC"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	dir, err := ioutil.TempDir("", "ko-goenv")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, ".netrc")
+	if err := ioutil.WriteFile(netrc, []byte("machine example.com login me password secret\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	os.Setenv("GONOSUMDB", "unchanged.example.com")
+
+	bo := &options.BuildOptions{
+		GoProxy:   "https://proxy.example.com,direct",
+		GoPrivate: "example.com/private/*",
+		Netrc:     netrc,
+	}
+	if err := useModuleEnv(bo); err != nil {
+		t.Fatalf("useModuleEnv() = %v", err)
+	}
+	for env, want := range map[string]string{
+		"GOPROXY":   bo.GoProxy,
+		"GOPRIVATE": bo.GoPrivate,
+		"GONOSUMDB": "unchanged.example.com",
+		"NETRC":     netrc,
+	} {
+		if got := os.Getenv(env); got != want {
+			t.Errorf("%s = %q, want %q", env, got, want)
+		}
+	}
+
+	bo.Netrc = filepath.Join(dir, "missing")
+	if err := useModuleEnv(bo); err == nil {
+		t.Error("useModuleEnv() = nil, want an error for a missing --netrc")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// imageRecord describes an image produced by a resolve, for consumption by
+// release tooling and security scanners.
+type imageRecord struct {
+	ImportPath string   `json:"importPath"`
+	Repository string   `json:"repository"`
+	Digest     string   `json:"digest"`
+	Tags       []string `json:"tags,omitempty"`
+	Platforms  []string `json:"platforms,omitempty"`
+	// Size is the total size in bytes of the manifests, configs and
+	// (compressed) layers that make up the image.
+	Size int64 `json:"size"`
+	// BuildSeconds and PushSeconds are how long building and publishing
+	// the image took, and CacheHits is how many of its references shared
+	// an earlier build instead of building it again.
+	BuildSeconds float64 `json:"buildSeconds,omitempty"`
+	PushSeconds  float64 `json:"pushSeconds,omitempty"`
+	CacheHits    int     `json:"cacheHits,omitempty"`
+}
+
+// imageManifest is the machine-readable list of every image produced by a
+// resolve, written by --image-manifest.
+type imageManifest struct {
+	Images []imageRecord `json:"images"`
+}
+
+// imageRecorder is a publish.Interface that describes everything published
+// through it.
+type imageRecorder struct {
+	inner publish.Interface
+	tags  []string
+
+	m      sync.Mutex
+	images map[string]imageRecord
+	// publishes counts how many times each import path was published,
+	// once for every file that references it.
+	publishes map[string]int
+}
+
+func newImageRecorder(inner publish.Interface, tags []string) *imageRecorder {
+	return &imageRecorder{
+		inner:  inner,
+		tags:   tags,
+		images:    make(map[string]imageRecord),
+		publishes: make(map[string]int),
+	}
+}
+
+// Publish implements publish.Interface
+func (r *imageRecorder) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	start := time.Now()
+	ref, err := r.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	pushed := time.Since(start).Seconds()
+	rec, err := describeResult(br)
+	if err != nil {
+		return nil, fmt.Errorf("describing %s: %v", s, err)
+	}
+	rec.ImportPath = s
+	rec.Repository = ref.Context().String()
+	rec.Tags = r.tags
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	rec.PushSeconds = r.images[s].PushSeconds + pushed
+	r.images[s] = rec
+	r.publishes[s]++
+	return ref, nil
+}
+
+// Close implements publish.Interface
+func (r *imageRecorder) Close() error {
+	return r.inner.Close()
+}
+
+// Manifest returns the images recorded so far, sorted by import path, with
+// how long the builds of each took and how often they were shared, if builds
+// (recorded beneath the build cache) are given.
+func (r *imageRecorder) Manifest(builds ...build.BuildRecord) *imageManifest {
+	seconds := map[string]float64{}
+	count := map[string]int{}
+	for _, b := range builds {
+		seconds[b.ImportPath] += b.Seconds
+		count[b.ImportPath]++
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	m := &imageManifest{Images: make([]imageRecord, 0, len(r.images))}
+	for ip, rec := range r.images {
+		if n, ok := count[ip]; ok {
+			rec.BuildSeconds = seconds[ip]
+			if hits := r.publishes[ip] - n; hits > 0 {
+				rec.CacheHits = hits
+			}
+		}
+		m.Images = append(m.Images, rec)
+	}
+	sort.Slice(m.Images, func(i, j int) bool {
+		return m.Images[i].ImportPath < m.Images[j].ImportPath
+	})
+	return m
+}
+
+func writeImageManifest(path string, m *imageManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// buildManifest is the JSON document written by --build-manifest.
+type buildManifest struct {
+	Builds []build.BuildRecord `json:"builds"`
+}
+
+// writeBuildManifest writes the builds, sorted by import path, to path.
+func writeBuildManifest(path string, builds []build.BuildRecord) error {
+	m := buildManifest{Builds: append([]build.BuildRecord{}, builds...)}
+	sort.SliceStable(m.Builds, func(i, j int) bool {
+		return m.Builds[i].ImportPath < m.Builds[j].ImportPath
+	})
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// describeResult fills in the digest, platforms and size of br.
+func describeResult(br build.Result) (imageRecord, error) {
+	var rec imageRecord
+	h, err := br.Digest()
+	if err != nil {
+		return rec, err
+	}
+	rec.Digest = h.String()
+
+	platforms, err := build.Platforms(br)
+	if err != nil {
+		return rec, err
+	}
+	for _, p := range platforms {
+		rec.Platforms = append(rec.Platforms, platformString(p))
+	}
+	if rec.Size, err = build.TotalSize(br); err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// platformString formats p as os/arch[/variant].
+func platformString(p v1.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestImageRecorder(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	inner := kotesting.NewFixedPublish(base, testHashes)
+
+	r := newImageRecorder(inner, []string{"latest", "v1"})
+	if _, err := r.Publish(context.Background(), foo, fooRef); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	wantSize, err := build.TotalSize(foo)
+	if err != nil {
+		t.Fatalf("TotalSize() = %v", err)
+	}
+	want := &imageManifest{Images: []imageRecord{{
+		ImportPath: fooRef,
+		Repository: "gcr.io/multi-pass/" + fooRef,
+		Digest:     fooHash.String(),
+		Tags:       []string{"latest", "v1"},
+		Size:       wantSize,
+	}}}
+	// How long publishing took varies.
+	ignoreTime := cmpopts.IgnoreFields(imageRecord{}, "PushSeconds")
+	if diff := cmp.Diff(want, r.Manifest(), ignoreTime); diff != "" {
+		t.Errorf("Manifest() (-want +got) = %v", diff)
+	}
+
+	// Publishing it again, say for another file, shares the one build.
+	if _, err := r.Publish(context.Background(), foo, fooRef); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	want.Images[0].BuildSeconds = 1.5
+	want.Images[0].CacheHits = 1
+	got := r.Manifest(build.BuildRecord{ImportPath: fooRef, Seconds: 1.5})
+	if diff := cmp.Diff(want, got, ignoreTime); diff != "" {
+		t.Errorf("Manifest() (-want +got) = %v", diff)
+	}
+	if got.Images[0].PushSeconds <= 0 {
+		t.Errorf("PushSeconds = %v, want the time of both publishes", got.Images[0].PushSeconds)
+	}
+}
+
+func TestDescribeIndex(t *testing.T) {
+	idx, err := random.Index(1024, 2, 3)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	rec, err := describeResult(idx)
+	if err != nil {
+		t.Fatalf("describeResult() = %v", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	// Each child has a manifest, a config and two layers of 1024 bytes.
+	if rec.Size <= int64(len(im.Manifests))*2*1024 {
+		t.Errorf("Size = %d, want more than the layers of each child", rec.Size)
+	}
+	if h, _ := idx.Digest(); rec.Digest != h.String() {
+		t.Errorf("Digest = %s, want %s", rec.Digest, h)
+	}
+}
+
+func TestPlatformString(t *testing.T) {
+	for _, c := range []struct {
+		p    v1.Platform
+		want string
+	}{
+		{v1.Platform{OS: "linux", Architecture: "amd64"}, "linux/amd64"},
+		{v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "linux/arm/v7"},
+	} {
+		if got := platformString(c.p); got != c.want {
+			t.Errorf("platformString(%v) = %q, want %q", c.p, got, c.want)
+		}
+	}
+}
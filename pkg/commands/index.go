@@ -0,0 +1,185 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/spf13/cobra"
+)
+
+// addIndex augments our CLI surface with index.
+func addIndex(topLevel *cobra.Command) {
+	po := &options.PublishOptions{}
+
+	index := &cobra.Command{
+		Use:   "index IMPORTPATH IMAGE...",
+		Short: "Publish previously published per-platform images as a single multi-platform image.",
+		Long:  `This sub-command combines the given images (e.g. built separately on native runners for each platform) into an image index, and publishes it where "ko publish" would publish the import path.`,
+		Example: `
+  # Combine images built on amd64 and arm64 runners, and publish
+  # the index as:
+  #   ${KO_DOCKER_REPO}/<package name>-<hash of import path>
+  ko index ./cmd/app \
+    gcr.io/my-project/app-amd64@sha256:... \
+    gcr.io/my-project/app-arm64@sha256:...`,
+		Args:              cobra.MinimumNArgs(2),
+		ValidArgsFunction: completeFirstImportPath,
+		Run: func(_ *cobra.Command, args []string) {
+			ctx := createCancellableContext()
+			if po.SkipUnchanged {
+				log.Fatal("ko index doesn't build anything, so --skip-unchanged has no effect on it")
+			}
+			importpath, err := qualifyImportPath(args[0])
+			if err != nil {
+				log.Fatalf("error qualifying %q: %v", args[0], err)
+			}
+
+			ropt := []remote.Option{
+				remote.WithAuthFromKeychain(keychain),
+				remote.WithUserAgent(ua()),
+				remote.WithContext(ctx),
+			}
+			var adds []mutate.IndexAddendum
+			for _, arg := range args[1:] {
+				more, err := fetchIndexAddenda(arg, ropt...)
+				if err != nil {
+					log.Fatalf("error fetching %s: %v", arg, err)
+				}
+				adds = append(adds, more...)
+			}
+			idx, err := assembleIndex(adds)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			publisher, err := makePublisher(po)
+			if err != nil {
+				log.Fatalf("error creating publisher: %v", err)
+			}
+			defer publisher.Close()
+			ref, err := publisher.Publish(ctx, idx, importpath)
+			if err != nil {
+				log.Fatalf("failed to publish index: %v", err)
+			}
+			fmt.Println(ref)
+		},
+	}
+	options.AddPublishArg(index, po)
+	topLevel.AddCommand(index)
+}
+
+// fetchIndexAddenda returns the entries that the image (or each image in the
+// index) referenced by s contributes to a combined index.
+func fetchIndexAddenda(s string, ropt ...remote.Option) ([]mutate.IndexAddendum, error) {
+	ref, err := name.ParseReference(s)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Get(ref, ropt...)
+	if err != nil {
+		return nil, err
+	}
+	switch desc.MediaType {
+	case types.OCIImageIndex, types.DockerManifestList:
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var adds []mutate.IndexAddendum
+		for _, child := range im.Manifests {
+			img, err := idx.Image(child.Digest)
+			if err != nil {
+				return nil, err
+			}
+			add, err := indexAddendum(img, child.Platform)
+			if err != nil {
+				return nil, err
+			}
+			adds = append(adds, add)
+		}
+		return adds, nil
+	default:
+		img, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		add, err := indexAddendum(img, nil)
+		if err != nil {
+			return nil, err
+		}
+		return []mutate.IndexAddendum{add}, nil
+	}
+}
+
+// indexAddendum describes img as an entry of an index. If platform is nil,
+// it is taken from img's config.
+func indexAddendum(img v1.Image, platform *v1.Platform) (mutate.IndexAddendum, error) {
+	mt, err := img.MediaType()
+	if err != nil {
+		return mutate.IndexAddendum{}, err
+	}
+	if platform == nil {
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return mutate.IndexAddendum{}, err
+		}
+		if cf.OS == "" || cf.Architecture == "" {
+			return mutate.IndexAddendum{}, fmt.Errorf("image has no platform in its config")
+		}
+		platform = &v1.Platform{
+			OS:           cf.OS,
+			Architecture: cf.Architecture,
+			OSVersion:    cf.OSVersion,
+		}
+	}
+	return mutate.IndexAddendum{
+		Add: img,
+		Descriptor: v1.Descriptor{
+			MediaType: mt,
+			Platform:  platform,
+		},
+	}, nil
+}
+
+// assembleIndex combines adds into an index, which is a Docker manifest list
+// if the images are Docker images and an OCI image index otherwise.
+func assembleIndex(adds []mutate.IndexAddendum) (v1.ImageIndex, error) {
+	seen := make(map[string]bool)
+	mt := types.DockerManifestList
+	for _, add := range adds {
+		p := platformString(*add.Platform)
+		if seen[p] {
+			return nil, fmt.Errorf("more than one image for platform %s", p)
+		}
+		seen[p] = true
+		if add.MediaType != types.DockerManifestSchema2 {
+			mt = types.OCIImageIndex
+		}
+	}
+	return mutate.IndexMediaType(mutate.AppendManifests(empty.Index, adds...), mt), nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func TestAssembleIndex(t *testing.T) {
+	var adds []mutate.IndexAddendum
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(1024, 1)
+		if err != nil {
+			t.Fatalf("random.Image() = %v", err)
+		}
+		cf, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile() = %v", err)
+		}
+		cf = cf.DeepCopy()
+		cf.OS, cf.Architecture = "linux", arch
+		img, err = mutate.ConfigFile(img, cf)
+		if err != nil {
+			t.Fatalf("mutate.ConfigFile() = %v", err)
+		}
+		add, err := indexAddendum(img, nil)
+		if err != nil {
+			t.Fatalf("indexAddendum() = %v", err)
+		}
+		adds = append(adds, add)
+	}
+
+	idx, err := assembleIndex(adds)
+	if err != nil {
+		t.Fatalf("assembleIndex() = %v", err)
+	}
+	if mt, err := idx.MediaType(); err != nil || mt != types.DockerManifestList {
+		t.Errorf("MediaType() = %v, %v, want %v", mt, err, types.DockerManifestList)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	var got []string
+	for _, desc := range im.Manifests {
+		got = append(got, platformString(*desc.Platform))
+	}
+	if len(got) != 2 || got[0] != "linux/amd64" || got[1] != "linux/arm64" {
+		t.Errorf("platforms = %v, want [linux/amd64 linux/arm64]", got)
+	}
+
+	if _, err := assembleIndex(append(adds, adds[0])); err == nil {
+		t.Error("assembleIndex(duplicate platform) = nil, want error")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// initConfig is the init section of .ko.yaml: a static init binary, like
+// tini, for each platform, pinned by its checksum.
+type initConfig struct {
+	// All wraps every import path in the init, except those whose builds
+	// entry has init: false. Otherwise only those with init: true are.
+	All bool `mapstructure:"all"`
+
+	// Args go between the init and the app, e.g. -- for tini.
+	Args []string `mapstructure:"args"`
+
+	// Binaries are keyed by platform, e.g. linux/arm64.
+	Binaries map[string]initBinary `mapstructure:"binaries"`
+}
+
+// initBinary is where to get the init for a platform: a URL, or a path
+// relative to the working directory.
+type initBinary struct {
+	URL    string `mapstructure:"url"`
+	SHA256 string `mapstructure:"sha256"`
+}
+
+// parseInitConfig checks that every binary has a source and a checksum.
+func parseInitConfig(c *initConfig) error {
+	if len(c.Binaries) == 0 {
+		return fmt.Errorf("'init': no binaries")
+	}
+	for platform, b := range c.Binaries {
+		if b.URL == "" {
+			return fmt.Errorf("'init': binary for %s has no url", platform)
+		}
+		if _, err := hex.DecodeString(b.SHA256); err != nil || len(b.SHA256) != sha256.Size*2 {
+			return fmt.Errorf("'init': binary for %s needs the sha256 of %s, got %q", platform, b.URL, b.SHA256)
+		}
+	}
+	return nil
+}
+
+// buildInit returns the build.Init for c.
+func (c *initConfig) buildInit() build.Init {
+	ids := make([]string, 0, len(c.Binaries))
+	for platform, b := range c.Binaries {
+		ids = append(ids, platform+"="+b.SHA256)
+	}
+	sort.Strings(ids)
+	return build.Init{
+		Binary: c.binary,
+		Args:   c.Args,
+		All:    c.All,
+		ID:     strings.Join(ids, ","),
+	}
+}
+
+// binary returns the path of the init for platform, fetching and verifying
+// it if it isn't cached yet.
+func (c *initConfig) binary(ctx context.Context, platform v1.Platform) (string, error) {
+	b, ok := c.Binaries[platformString(platform)]
+	if !ok {
+		b, ok = c.Binaries[platform.OS+"/"+platform.Architecture]
+	}
+	if !ok {
+		return "", fmt.Errorf("no init binary for %s in .ko.yaml", platformString(platform))
+	}
+	dir, err := initCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cached := filepath.Join(dir, "sha256", b.SHA256)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+
+	data, err := fetchInit(ctx, b.URL)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != b.SHA256 {
+		return "", fmt.Errorf("init binary %s has sha256 %s, expected %s", b.URL, got, b.SHA256)
+	}
+	if err := writeAtomic(cached, data); err != nil {
+		return "", err
+	}
+	return cached, nil
+}
+
+// fetchInit reads the init binary at url, which is a path if it has no
+// scheme.
+func fetchInit(ctx context.Context, url string) ([]byte, error) {
+	if !strings.Contains(url, "://") {
+		return ioutil.ReadFile(url)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", ua())
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching init binary %s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// initCacheDir is where init binaries are cached by checksum: under
+// $KO_CACHE if it is set, or else the user's cache directory.
+func initCacheDir() (string, error) {
+	if dir := os.Getenv("KO_CACHE"); dir != "" {
+		return filepath.Join(dir, "init"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ko", "init"), nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestInitBinary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-cache")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(v string) { os.Setenv("KO_CACHE", v) }(os.Getenv("KO_CACHE"))
+	os.Setenv("KO_CACHE", dir)
+
+	tini := []byte("pretend this is tini")
+	sum := sha256.Sum256(tini)
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(tini)
+	}))
+	defer s.Close()
+
+	c := &initConfig{Binaries: map[string]initBinary{
+		"linux/amd64": {URL: s.URL + "/tini-static-amd64", SHA256: hex.EncodeToString(sum[:])},
+		"linux/arm64": {URL: s.URL + "/tini-static-arm64", SHA256: strings.Repeat("0", 64)},
+	}}
+	if err := parseInitConfig(c); err != nil {
+		t.Fatalf("parseInitConfig() = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		path, err := c.binary(context.Background(), v1.Platform{OS: "linux", Architecture: "amd64"})
+		if err != nil {
+			t.Fatalf("binary() = %v", err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		if string(got) != string(tini) {
+			t.Errorf("binary() = %q, want %q", got, tini)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("fetched the binary %d times, want once", requests)
+	}
+
+	if _, err := c.binary(context.Background(), v1.Platform{OS: "linux", Architecture: "arm64"}); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Errorf("binary(linux/arm64) = %v, want checksum mismatch", err)
+	}
+	if _, err := c.binary(context.Background(), v1.Platform{OS: "windows", Architecture: "amd64"}); err == nil {
+		t.Error("binary(windows/amd64) = nil, want error")
+	}
+}
+
+func TestParseInitConfig(t *testing.T) {
+	for _, c := range []*initConfig{
+		{},
+		{Binaries: map[string]initBinary{"linux/amd64": {SHA256: strings.Repeat("0", 64)}}},
+		{Binaries: map[string]initBinary{"linux/amd64": {URL: "./tini", SHA256: "abc"}}},
+	} {
+		if err := parseInitConfig(c); err == nil {
+			t.Errorf("parseInitConfig(%+v) = nil, want error", c)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+)
+
+// keychain resolves the credentials of registries: from the Docker config of
+// --docker-config, if it was passed, or else like docker does.
+var keychain authn.Keychain = authn.DefaultKeychain
+
+// registeredKeychains are consulted ahead of the Docker config.
+var registeredKeychains []authn.Keychain
+
+// RegisterKeychain makes ko ask kc for the credentials of every registry that
+// it pulls base images from or publishes to, ahead of the Docker config, for
+// registries with token services that the Docker config can't describe.
+// Binaries that embed ko's commands call it before executing them.
+func RegisterKeychain(kc authn.Keychain) {
+	registeredKeychains = append(registeredKeychains, kc)
+}
+
+// useDockerConfig makes keychain read credentials from the Docker config
+// file at path (or path/config.json, if path is a directory), unless path is
+// empty, behind any registered keychains.
+func useDockerConfig(path string) error {
+	var kc authn.Keychain = authn.DefaultKeychain
+	if path != "" {
+		var err error
+		if kc, err = newDockerConfigKeychain(path); err != nil {
+			return fmt.Errorf("--docker-config: %v", err)
+		}
+	}
+	keychain = kc
+	if len(registeredKeychains) != 0 {
+		keychain = authn.NewMultiKeychain(append(append([]authn.Keychain{}, registeredKeychains...), kc)...)
+	}
+	return nil
+}
+
+// useCredentialHelpers puts the Docker credential helpers of
+// --credential-helper in front of keychain, in order: each either name, for
+// every registry, or registry=name.
+func useCredentialHelpers(helpers []string) error {
+	if len(helpers) == 0 {
+		return nil
+	}
+	var kcs []authn.Keychain
+	for _, h := range helpers {
+		hk := &helperKeychain{helper: h}
+		if i := strings.Index(h, "="); i >= 0 {
+			reg, err := name.NewRegistry(h[:i])
+			if err != nil {
+				return fmt.Errorf("--credential-helper %s: %v", h, err)
+			}
+			hk.registry, hk.helper = reg.RegistryStr(), h[i+1:]
+		}
+		if hk.helper == "" {
+			return fmt.Errorf("--credential-helper %s: missing the name of the helper", h)
+		}
+		if _, err := exec.LookPath("docker-credential-" + hk.helper); err != nil {
+			return fmt.Errorf("--credential-helper %s: %v", h, err)
+		}
+		kcs = append(kcs, hk)
+	}
+	keychain = authn.NewMultiKeychain(append(kcs, keychain)...)
+	return nil
+}
+
+// helperKeychain gets credentials from a Docker credential helper, like
+// the credHelpers of a Docker config do.
+type helperKeychain struct {
+	helper string
+	// registry, if set, is the only registry to ask the helper about.
+	registry string
+}
+
+// Resolve implements authn.Keychain.
+func (k *helperKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	reg := target.RegistryStr()
+	if k.registry != "" && reg != k.registry {
+		return authn.Anonymous, nil
+	}
+	cmd := exec.Command("docker-credential-"+k.helper, "get")
+	cmd.Stdin = strings.NewReader(reg)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// Helpers print this (on stdout or stderr) for registries they
+		// have nothing for, which isn't an error.
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return authn.Anonymous, nil
+		}
+		return nil, fmt.Errorf("docker-credential-%s get %s: %v: %s", k.helper, reg, err, strings.TrimSpace(stderr.String()))
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return nil, fmt.Errorf("docker-credential-%s get %s: %v", k.helper, reg, err)
+	}
+	if creds.Username == "" && creds.Secret == "" {
+		return authn.Anonymous, nil
+	}
+	if creds.Username == "<token>" {
+		// This is how helpers hand out identity tokens.
+		return authn.FromConfig(authn.AuthConfig{IdentityToken: creds.Secret}), nil
+	}
+	return &authn.Basic{Username: creds.Username, Password: creds.Secret}, nil
+}
+
+// readsStdin reports whether cmd reads its input from stdin, because of
+// `-f -` or, for ko publish, a "-" import path.
+func readsStdin(cmd *cobra.Command, args []string) bool {
+	if fns, err := cmd.Flags().GetStringSlice("filename"); err == nil {
+		for _, fn := range fns {
+			if fn == "-" {
+				return true
+			}
+		}
+	}
+	if cmd.Name() == "publish" {
+		for _, arg := range args {
+			if arg == "-" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// useBasicAuth puts the --username credentials, with the password read from
+// stdin, in front of keychain for the registries they are scoped to, so that
+// short-lived CI tokens never have to be written to a Docker config.
+func useBasicAuth(ao *options.AuthOptions, stdin io.Reader) error {
+	if ao.Username == "" {
+		if ao.PasswordStdin {
+			return errors.New("--password-stdin requires --username")
+		}
+		return nil
+	}
+	if !ao.PasswordStdin {
+		return errors.New("--username requires --password-stdin")
+	}
+	b, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("reading password from stdin: %v", err)
+	}
+	password := strings.TrimRight(string(b), "\r\n")
+	if password == "" {
+		return errors.New("--password-stdin: empty password")
+	}
+
+	registries := ao.Registries
+	if len(registries) == 0 {
+		repoName := os.Getenv("KO_DOCKER_REPO")
+		if repoName == "" || repoName == publish.LocalDomain || repoName == publish.KindDomain {
+			return errors.New("--username requires --auth-registry, or KO_DOCKER_REPO set to a registry")
+		}
+		repo, err := name.NewRepository(repoName)
+		if err != nil {
+			return fmt.Errorf("parsing KO_DOCKER_REPO: %v", err)
+		}
+		registries = []string{repo.RegistryStr()}
+	}
+	bk := &basicKeychain{
+		auth:       &authn.Basic{Username: ao.Username, Password: password},
+		registries: map[string]bool{},
+	}
+	for _, r := range registries {
+		reg, err := name.NewRegistry(r)
+		if err != nil {
+			return fmt.Errorf("--auth-registry: %v", err)
+		}
+		bk.registries[reg.RegistryStr()] = true
+	}
+	keychain = authn.NewMultiKeychain(bk, keychain)
+	return nil
+}
+
+// basicKeychain resolves to auth for its registries, and to anonymous (so that
+// the next keychain is consulted) for any other.
+type basicKeychain struct {
+	auth       authn.Authenticator
+	registries map[string]bool
+}
+
+// Resolve implements authn.Keychain.
+func (k *basicKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	if k.registries[target.RegistryStr()] {
+		return k.auth, nil
+	}
+	return authn.Anonymous, nil
+}
+
+// dockerConfigKeychain is authn.DefaultKeychain, but for a particular config
+// file.
+type dockerConfigKeychain struct {
+	cf *configfile.ConfigFile
+}
+
+func newDockerConfigKeychain(path string) (authn.Keychain, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		path = filepath.Join(path, config.ConfigFileName)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cf, err := config.LoadFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("loading %s: %v", path, err)
+	}
+	cf.Filename = path
+	return &dockerConfigKeychain{cf: cf}, nil
+}
+
+// Resolve implements authn.Keychain.
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	key := target.RegistryStr()
+	if key == name.DefaultRegistry {
+		key = authn.DefaultAuthKey
+	}
+	cfg, err := k.cf.GetAuthConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == (types.AuthConfig{}) {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
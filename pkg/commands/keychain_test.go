@@ -0,0 +1,305 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/spf13/cobra"
+)
+
+func TestDockerConfigKeychain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := `{"auths": {
+	"registry.example.com": {"username": "user", "password": "pass"},
+	"https://index.docker.io/v1/": {"auth": "aHViOnNla3JpdA=="}
+}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{dir, filepath.Join(dir, "config.json")} {
+		kc, err := newDockerConfigKeychain(path)
+		if err != nil {
+			t.Fatalf("newDockerConfigKeychain(%q) = %v", path, err)
+		}
+		for reg, want := range map[string]authn.AuthConfig{
+			"registry.example.com": {Username: "user", Password: "pass"},
+			"index.docker.io":      {Username: "hub", Password: "sekrit"},
+			"other.example.com":    {},
+		} {
+			r, err := name.NewRegistry(reg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth, err := kc.Resolve(r)
+			if err != nil {
+				t.Fatalf("Resolve(%s) = %v", reg, err)
+			}
+			got, err := auth.Authorization()
+			if err != nil {
+				t.Fatalf("Authorization(%s) = %v", reg, err)
+			}
+			if got.Username != want.Username || got.Password != want.Password {
+				t.Errorf("Resolve(%s) = %s:%s, want %s:%s", reg, got.Username, got.Password, want.Username, want.Password)
+			}
+		}
+	}
+
+	if _, err := newDockerConfigKeychain(filepath.Join(dir, "missing")); err == nil {
+		t.Error("newDockerConfigKeychain(missing) = nil, want error")
+	}
+}
+
+func TestUseDockerConfig(t *testing.T) {
+	defer func() { keychain = authn.DefaultKeychain }()
+	if err := useDockerConfig("/does/not/exist"); err == nil {
+		t.Error("useDockerConfig(/does/not/exist) = nil, want error")
+	}
+	if err := useDockerConfig(""); err != nil {
+		t.Fatalf("useDockerConfig() = %v", err)
+	}
+	if keychain != authn.DefaultKeychain {
+		t.Errorf("keychain = %v, want authn.DefaultKeychain", keychain)
+	}
+}
+
+func TestUseBasicAuth(t *testing.T) {
+	defer func() { keychain = authn.DefaultKeychain }()
+	defer os.Setenv("KO_DOCKER_REPO", os.Getenv("KO_DOCKER_REPO"))
+	os.Setenv("KO_DOCKER_REPO", "registry.example.com/team")
+
+	for _, tc := range []struct {
+		name  string
+		ao    options.AuthOptions
+		stdin string
+		want  map[string]authn.AuthConfig
+	}{{
+		name:  "KO_DOCKER_REPO",
+		ao:    options.AuthOptions{Username: "ci", PasswordStdin: true},
+		stdin: "token\n",
+		want: map[string]authn.AuthConfig{
+			"registry.example.com": {Username: "ci", Password: "token"},
+			"other.example.com":    {},
+		},
+	}, {
+		name:  "auth registries",
+		ao:    options.AuthOptions{Username: "ci", PasswordStdin: true, Registries: []string{"other.example.com", "docker.io"}},
+		stdin: "token",
+		want: map[string]authn.AuthConfig{
+			"registry.example.com": {},
+			"other.example.com":    {Username: "ci", Password: "token"},
+			"index.docker.io":      {Username: "ci", Password: "token"},
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			keychain = authn.NewMultiKeychain()
+			if err := useBasicAuth(&tc.ao, strings.NewReader(tc.stdin)); err != nil {
+				t.Fatalf("useBasicAuth() = %v", err)
+			}
+			for reg, want := range tc.want {
+				r, err := name.NewRegistry(reg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				auth, err := keychain.Resolve(r)
+				if err != nil {
+					t.Fatalf("Resolve(%s) = %v", reg, err)
+				}
+				got, err := auth.Authorization()
+				if err != nil {
+					t.Fatalf("Authorization(%s) = %v", reg, err)
+				}
+				if got.Username != want.Username || got.Password != want.Password {
+					t.Errorf("Resolve(%s) = %s:%s, want %s:%s", reg, got.Username, got.Password, want.Username, want.Password)
+				}
+			}
+		})
+	}
+
+	for _, tc := range []struct {
+		name  string
+		ao    options.AuthOptions
+		stdin string
+	}{
+		{"no username", options.AuthOptions{PasswordStdin: true}, "token"},
+		{"no password-stdin", options.AuthOptions{Username: "ci"}, ""},
+		{"empty password", options.AuthOptions{Username: "ci", PasswordStdin: true}, "\n"},
+		{"bad registry", options.AuthOptions{Username: "ci", PasswordStdin: true, Registries: []string{"not a registry"}}, "token"},
+	} {
+		if err := useBasicAuth(&tc.ao, strings.NewReader(tc.stdin)); err == nil {
+			t.Errorf("useBasicAuth(%s) = nil, want error", tc.name)
+		}
+	}
+
+	os.Setenv("KO_DOCKER_REPO", "ko.local")
+	if err := useBasicAuth(&options.AuthOptions{Username: "ci", PasswordStdin: true}, strings.NewReader("token")); err == nil {
+		t.Error("useBasicAuth(ko.local) = nil, want error")
+	}
+}
+
+func TestRegisterKeychain(t *testing.T) {
+	defer func() { keychain, registeredKeychains = authn.DefaultKeychain, nil }()
+	hub, err := name.NewRegistry(name.DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterKeychain(staticKeychain{auth: &authn.Basic{Username: "token-service", Password: "minted"}})
+	if err := useDockerConfig(""); err != nil {
+		t.Fatalf("useDockerConfig() = %v", err)
+	}
+	auth, err := keychain.Resolve(hub)
+	if err != nil {
+		t.Fatalf("Resolve() = %v", err)
+	}
+	got, err := auth.Authorization()
+	if err != nil {
+		t.Fatalf("Authorization() = %v", err)
+	}
+	if got.Username != "token-service" || got.Password != "minted" {
+		t.Errorf("Resolve() = %s:%s, want token-service:minted", got.Username, got.Password)
+	}
+}
+
+func TestUseCredentialHelpers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-credential-helper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for helper, script := range map[string]string{
+		// Knows the registry it was asked about, and nothing else.
+		"corp": `read reg
+if [ "$reg" = registry.corp.example.com ]; then
+  echo '{"ServerURL": "registry.corp.example.com", "Username": "robot", "Secret": "s3cret"}'
+else
+  echo "credentials not found in native keychain"
+  exit 1
+fi`,
+		"token":  `echo '{"Username": "<token>", "Secret": "refresh"}'`,
+		"broken": `echo "keyring is locked" >&2; exit 1`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "docker-credential-"+helper), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func() { keychain = authn.DefaultKeychain }()
+
+	for _, tc := range []struct {
+		name    string
+		helpers []string
+		want    map[string]authn.AuthConfig
+	}{{
+		name:    "every registry",
+		helpers: []string{"corp"},
+		want: map[string]authn.AuthConfig{
+			"registry.corp.example.com": {Username: "robot", Password: "s3cret"},
+			"other.example.com":         {},
+		},
+	}, {
+		name:    "scoped",
+		helpers: []string{"other.example.com=token", "corp"},
+		want: map[string]authn.AuthConfig{
+			"registry.corp.example.com": {Username: "robot", Password: "s3cret"},
+			"other.example.com":         {IdentityToken: "refresh"},
+			"third.example.com":         {},
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			keychain = authn.NewMultiKeychain()
+			if err := useCredentialHelpers(tc.helpers); err != nil {
+				t.Fatalf("useCredentialHelpers() = %v", err)
+			}
+			for reg, want := range tc.want {
+				r, err := name.NewRegistry(reg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				auth, err := keychain.Resolve(r)
+				if err != nil {
+					t.Fatalf("Resolve(%s) = %v", reg, err)
+				}
+				got, err := auth.Authorization()
+				if err != nil {
+					t.Fatalf("Authorization(%s) = %v", reg, err)
+				}
+				if *got != want {
+					t.Errorf("Resolve(%s) = %+v, want %+v", reg, *got, want)
+				}
+			}
+		})
+	}
+
+	hub, err := name.NewRegistry(name.DefaultRegistry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keychain = authn.NewMultiKeychain()
+	if err := useCredentialHelpers([]string{"broken"}); err != nil {
+		t.Fatalf("useCredentialHelpers() = %v", err)
+	}
+	if _, err := keychain.Resolve(hub); err == nil || !strings.Contains(err.Error(), "keyring is locked") {
+		t.Errorf("Resolve() = %v, want the helper's error", err)
+	}
+
+	for _, helpers := range [][]string{{"missing"}, {"registry.example.com="}, {"not a registry=corp"}} {
+		if err := useCredentialHelpers(helpers); err == nil {
+			t.Errorf("useCredentialHelpers(%v) = nil, want error", helpers)
+		}
+	}
+}
+
+func TestReadsStdin(t *testing.T) {
+	newCmd := func(name string) *cobra.Command {
+		cmd := &cobra.Command{Use: name}
+		options.AddFileArg(cmd, &options.FilenameOptions{})
+		return cmd
+	}
+	for _, tc := range []struct {
+		name string
+		cmd  string
+		argv []string
+		want bool
+	}{
+		{"file", "apply", []string{"-f", "config/"}, false},
+		{"stdin", "apply", []string{"-f", "config/", "-f", "-"}, true},
+		{"publish file", "publish", []string{"./cmd/foo"}, false},
+		{"publish stdin", "publish", []string{"./cmd/foo", "-"}, true},
+		{"other command", "build", []string{"-"}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := newCmd(tc.cmd)
+			if err := cmd.ParseFlags(tc.argv); err != nil {
+				t.Fatalf("ParseFlags() = %v", err)
+			}
+			if got := readsStdin(cmd, cmd.Flags().Args()); got != tc.want {
+				t.Errorf("readsStdin(%v) = %v, want %v", tc.argv, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	"golang.org/x/sync/errgroup"
+)
+
+// titleAnnotation names the file that the blob of an artifact is written to.
+const titleAnnotation = "org.opencontainers.image.title"
+
+// writeLocalBinaries builds importpaths with b, which must build binary
+// artifacts (see build.WithBinaryArtifacts), and writes their binaries under
+// dir, returning the files written for each import path. The binaries of a
+// multi-platform build each go in a subdirectory named after their platform,
+// e.g. linux_arm64.
+func writeLocalBinaries(ctx context.Context, importpaths []string, b build.Interface, dir string) (map[string][]string, error) {
+	var m sync.Mutex
+	files := make(map[string][]string, len(importpaths))
+	writers := map[string]string{}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for _, importpath := range importpaths {
+		importpath := importpath
+		if err := b.IsSupportedReference(importpath); err != nil {
+			return nil, fmt.Errorf("importpath %q is not supported: %w", importpath, err)
+		}
+		g.Go(func() error {
+			res, err := b.Build(ctx, importpath)
+			if err != nil {
+				return fmt.Errorf("error building %q: %w", importpath, err)
+			}
+			bins, err := localBinaries(res, dir)
+			if err != nil {
+				return fmt.Errorf("error reading the binaries of %s: %w", importpath, err)
+			}
+
+			m.Lock()
+			var written []string
+			for file := range bins {
+				if other, ok := writers[file]; ok {
+					m.Unlock()
+					return fmt.Errorf("%s and %s would both be written to %s", other, importpath, file)
+				}
+				writers[file] = importpath
+				written = append(written, file)
+			}
+			sort.Strings(written)
+			files[importpath] = written
+			m.Unlock()
+
+			for _, file := range written {
+				if err := writeLocalBinary(file, bins[file]); err != nil {
+					return fmt.Errorf("error writing %s: %w", file, err)
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// localBinaries returns the binaries in the artifacts of res, by where they
+// go under dir.
+func localBinaries(res build.Result, dir string) (map[string]v1.Layer, error) {
+	switch res := res.(type) {
+	case v1.ImageIndex:
+		im, err := res.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		bins := make(map[string]v1.Layer, len(im.Manifests))
+		for _, desc := range im.Manifests {
+			if desc.Platform == nil {
+				return nil, fmt.Errorf("%s has no platform", desc.Digest)
+			}
+			art, err := res.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			name, layer, err := localBinary(art)
+			if err != nil {
+				return nil, err
+			}
+			p := desc.Platform
+			sub := strings.TrimSuffix(strings.Join([]string{p.OS, p.Architecture, p.Variant}, "_"), "_")
+			bins[filepath.Join(dir, sub, name)] = layer
+		}
+		return bins, nil
+	case v1.Image:
+		name, layer, err := localBinary(res)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]v1.Layer{filepath.Join(dir, name): layer}, nil
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", res)
+	}
+}
+
+// localBinary returns the name and blob of the binary in the artifact art.
+func localBinary(art v1.Image) (string, v1.Layer, error) {
+	m, err := art.Manifest()
+	if err != nil {
+		return "", nil, err
+	}
+	if m.Config.MediaType != build.BinaryConfigMediaType || len(m.Layers) != 1 {
+		return "", nil, fmt.Errorf("built an image rather than a binary (is artifact set to false in .ko.yaml?)")
+	}
+	name := m.Layers[0].Annotations[titleAnnotation]
+	if name == "" || name != filepath.Base(name) {
+		return "", nil, fmt.Errorf("binary has a bad name %q", name)
+	}
+	layer, err := art.LayerByDigest(m.Layers[0].Digest)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, layer, nil
+}
+
+// writeLocalBinary writes the blob layer to the executable file.
+func writeLocalBinary(file string, layer v1.Layer) error {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	if err := writeAtomic(file, b); err != nil {
+		return err
+	}
+	return os.Chmod(file, 0755)
+}
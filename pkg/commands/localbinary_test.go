@@ -0,0 +1,115 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func binaryArtifact(t *testing.T, name, contents string) v1.Image {
+	t.Helper()
+	art, err := build.NewArtifact(build.BinaryConfigMediaType, nil, build.Blob{
+		MediaType:   build.BinaryLayerMediaType,
+		Data:        []byte(contents),
+		Annotations: map[string]string{titleAnnotation: name},
+	})
+	if err != nil {
+		t.Fatalf("NewArtifact() = %v", err)
+	}
+	return art
+}
+
+func TestWriteLocalBinaries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-bin")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        binaryArtifact(t, "ctl", "linux"),
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}},
+	}, mutate.IndexAddendum{
+		Add:        binaryArtifact(t, "ctl.exe", "windows"),
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "windows", Architecture: "amd64"}},
+	})
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	b := kotesting.NewFixedBuild(map[string]build.Result{
+		"github.com/google/ko/cmd/ctl":    idx,
+		"github.com/google/ko/cmd/server": binaryArtifact(t, "server", "server"),
+		"github.com/google/ko/cmd/image":  img,
+		"github.com/google/ko/other/ctl":  idx,
+	})
+
+	files, err := writeLocalBinaries(context.Background(), []string{
+		build.StrictScheme + "github.com/google/ko/cmd/ctl",
+		build.StrictScheme + "github.com/google/ko/cmd/server",
+	}, b, dir)
+	if err != nil {
+		t.Fatalf("writeLocalBinaries() = %v", err)
+	}
+	want := map[string][]string{
+		build.StrictScheme + "github.com/google/ko/cmd/ctl": {
+			filepath.Join(dir, "linux_arm64", "ctl"),
+			filepath.Join(dir, "windows_amd64", "ctl.exe"),
+		},
+		build.StrictScheme + "github.com/google/ko/cmd/server": {filepath.Join(dir, "server")},
+	}
+	if diff := cmp.Diff(want, files); diff != "" {
+		t.Errorf("writeLocalBinaries() (-want +got) = %v", diff)
+	}
+	for file, contents := range map[string]string{
+		filepath.Join(dir, "linux_arm64", "ctl"):       "linux",
+		filepath.Join(dir, "windows_amd64", "ctl.exe"): "windows",
+		filepath.Join(dir, "server"):                   "server",
+	} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("Stat() = %v", err)
+		}
+		if fi.Mode().Perm() != 0755 {
+			t.Errorf("mode of %s = %v, want 0755", file, fi.Mode())
+		}
+		if got, err := ioutil.ReadFile(file); err != nil || string(got) != contents {
+			t.Errorf("ReadFile(%s) = %q, %v, want %q", file, got, err, contents)
+		}
+	}
+
+	for _, importpaths := range [][]string{
+		// Images aren't binaries.
+		{build.StrictScheme + "github.com/google/ko/cmd/image"},
+		// Binaries can't overwrite each other.
+		{build.StrictScheme + "github.com/google/ko/cmd/ctl", build.StrictScheme + "github.com/google/ko/other/ctl"},
+	} {
+		if _, err := writeLocalBinaries(context.Background(), importpaths, b, dir); err == nil {
+			t.Errorf("writeLocalBinaries(%v) = nil, wanted an error", importpaths)
+		}
+	}
+}
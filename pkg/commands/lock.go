@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// lockfile pins the digest that each import path builds to.
+type lockfile struct {
+	Images map[string]string `json:"images"`
+	// Bases records the base image that each import path was built on, for
+	// check-base-updates.
+	Bases map[string]lockedBase `json:"bases,omitempty"`
+}
+
+// lockedBase is the base image an import path was built on: the reference
+// it was configured as, and the digest that resolved to.
+type lockedBase struct {
+	Reference string `json:"reference,omitempty"`
+	Digest    string `json:"digest"`
+}
+
+func readLockfile(path string) (*lockfile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lf := &lockfile{}
+	if err := json.Unmarshal(b, lf); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	if lf.Images == nil {
+		lf.Images = make(map[string]string)
+	}
+	return lf, nil
+}
+
+func writeLockfile(path string, lf *lockfile) error {
+	b, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// lockingPublisher records the digest of everything it publishes and, if
+// frozen is set, refuses to publish anything that doesn't match it.
+type lockingPublisher struct {
+	inner  publish.Interface
+	frozen *lockfile
+
+	m       sync.Mutex
+	digests map[string]string
+	bases   map[string]lockedBase
+}
+
+func newLockingPublisher(inner publish.Interface, frozen *lockfile) *lockingPublisher {
+	return &lockingPublisher{
+		inner:   inner,
+		frozen:  frozen,
+		digests: make(map[string]string),
+		bases:   make(map[string]lockedBase),
+	}
+}
+
+// Publish implements publish.Interface
+func (l *lockingPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if l.frozen != nil {
+		want, ok := l.frozen.Images[s]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the lockfile", s)
+		}
+		if want != h.String() {
+			return nil, fmt.Errorf("%s built to %s, but the lockfile has %s", s, h, want)
+		}
+	}
+
+	base, hasBase := build.BaseDigest(br)
+	func() {
+		l.m.Lock()
+		defer l.m.Unlock()
+		l.digests[s] = h.String()
+		if hasBase {
+			lb := lockedBase{Digest: base.String()}
+			if ref, ok := baseRefs.Load(strings.TrimPrefix(s, build.StrictScheme)); ok {
+				lb.Reference = ref.(string)
+			}
+			l.bases[s] = lb
+		}
+	}()
+
+	return l.inner.Publish(ctx, br, s)
+}
+
+// Close implements publish.Interface
+func (l *lockingPublisher) Close() error {
+	return l.inner.Close()
+}
+
+// Lockfile returns the digests published so far.
+func (l *lockingPublisher) Lockfile() *lockfile {
+	l.m.Lock()
+	defer l.m.Unlock()
+	lf := &lockfile{Images: make(map[string]string, len(l.digests))}
+	for k, v := range l.digests {
+		lf.Images[k] = v
+	}
+	if len(l.bases) != 0 {
+		lf.Bases = make(map[string]lockedBase, len(l.bases))
+		for k, v := range l.bases {
+			lf.Bases[k] = v
+		}
+	}
+	return lf
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestLockingPublisher(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	inner := kotesting.NewFixedPublish(base, testHashes)
+
+	lp := newLockingPublisher(inner, nil)
+	if _, err := lp.Publish(context.Background(), foo, fooRef); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	want := &lockfile{Images: map[string]string{fooRef: fooHash.String()}}
+	if diff := cmp.Diff(want, lp.Lockfile()); diff != "" {
+		t.Errorf("Lockfile() (-want +got) = %v", diff)
+	}
+
+	frozen := newLockingPublisher(inner, want)
+	if _, err := frozen.Publish(context.Background(), foo, fooRef); err != nil {
+		t.Errorf("Publish(foo) = %v", err)
+	}
+	// bar isn't in the lockfile.
+	if _, err := frozen.Publish(context.Background(), bar, barRef); err == nil {
+		t.Error("Publish(bar) = nil, wanted error")
+	}
+	// foo's digest doesn't match.
+	if _, err := frozen.Publish(context.Background(), bar, fooRef); err == nil {
+		t.Error("Publish(bar as foo) = nil, wanted error")
+	}
+}
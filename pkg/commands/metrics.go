@@ -0,0 +1,145 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/internal/metrics"
+	"github.com/google/ko/pkg/publish"
+)
+
+// koMetrics are recorded by every command, and served on /metrics by the
+// long-running ones: serve, webhook, and --watch with --metrics-addr.
+var koMetrics = newMeters(metrics.NewRegistry())
+
+type meters struct {
+	registry *metrics.Registry
+
+	builds         *metrics.Counter
+	buildSeconds   *metrics.Histogram
+	cacheLookups   *metrics.Counter
+	cacheEvictions *metrics.Counter
+	publishes      *metrics.Counter
+	publishSeconds *metrics.Histogram
+	publishBytes   *metrics.Counter
+}
+
+func newMeters(r *metrics.Registry) *meters {
+	return &meters{
+		registry: r,
+		builds: r.NewCounter("ko_builds_total",
+			"Number of builds of import paths, by result (success or error).", "result"),
+		buildSeconds: r.NewHistogram("ko_build_duration_seconds",
+			"How long builds of import paths took.", metrics.DefaultBuckets),
+		cacheLookups: r.NewCounter("ko_build_cache_lookups_total",
+			"Number of lookups of import paths in the build cache, by result (hit or miss).", "result"),
+		cacheEvictions: r.NewCounter("ko_build_cache_evictions_total",
+			"Number of builds evicted from the build cache, by reason (ttl or size).", "reason"),
+		publishes: r.NewCounter("ko_publishes_total",
+			"Number of publishes of images, by result (success or error).", "result"),
+		publishSeconds: r.NewHistogram("ko_publish_duration_seconds",
+			"How long publishes of images took.", metrics.DefaultBuckets),
+		publishBytes: r.NewCounter("ko_publish_bytes_total",
+			"Total size of the images published, before deduplication of blobs the destination already has."),
+	}
+}
+
+// serveMetrics serves koMetrics (and profiles) on addr until the returned
+// server is closed.
+func serveMetrics(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", koMetrics.registry)
+	handlePprof(mux)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Serving metrics on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("error serving metrics: %v", err)
+		}
+	}()
+	return srv
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// meterCache records the lookups and evictions of c.
+func (m *meters) meterCache(c *build.Caching) {
+	c.OnLookup = func(hit bool) {
+		if hit {
+			m.cacheLookups.Inc("hit")
+		} else {
+			m.cacheLookups.Inc("miss")
+		}
+	}
+	c.OnEvict = func(reason string) {
+		m.cacheEvictions.Inc(reason)
+	}
+}
+
+// meteredBuilder records the builds of inner.
+type meteredBuilder struct {
+	inner build.Interface
+	m     *meters
+}
+
+// IsSupportedReference implements build.Interface
+func (b *meteredBuilder) IsSupportedReference(ip string) error {
+	return b.inner.IsSupportedReference(ip)
+}
+
+// Build implements build.Interface
+func (b *meteredBuilder) Build(ctx context.Context, ip string) (build.Result, error) {
+	start := time.Now()
+	res, err := b.inner.Build(ctx, ip)
+	b.m.buildSeconds.Observe(time.Since(start).Seconds())
+	b.m.builds.Inc(result(err))
+	return res, err
+}
+
+// meteredPublisher records the publishes of inner.
+type meteredPublisher struct {
+	inner publish.Interface
+	m     *meters
+}
+
+// Publish implements publish.Interface
+func (p *meteredPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	start := time.Now()
+	ref, err := p.inner.Publish(ctx, br, s)
+	p.m.publishSeconds.Observe(time.Since(start).Seconds())
+	p.m.publishes.Inc(result(err))
+	if err == nil {
+		if size, err := build.TotalSize(br); err == nil {
+			p.m.publishBytes.Add(float64(size))
+		}
+	}
+	return ref, err
+}
+
+// Close implements publish.Interface
+func (p *meteredPublisher) Close() error {
+	return p.inner.Close()
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// AnnotateOptions controls the metadata ko adds to resolved resources.
+type AnnotateOptions struct {
+	// BuildAnnotations annotates resolved pod templates with where their
+	// images came from.
+	BuildAnnotations bool
+}
+
+func AddAnnotateArg(cmd *cobra.Command, ao *AnnotateOptions) {
+	cmd.Flags().BoolVar(&ao.BuildAnnotations, "build-annotations", ao.BuildAnnotations,
+		"Annotate resolved pod templates with ko.build/import-path, ko.build/commit and ko.build/base-digest.")
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// AuthOptions control where ko finds registry credentials.
+type AuthOptions struct {
+	// DockerConfig, if set, is the Docker config file (or the directory
+	// with its config.json) to read credentials from, instead of the one
+	// found through DOCKER_CONFIG or HOME.
+	DockerConfig string
+
+	// Username, with the password read from stdin when PasswordStdin is
+	// set, authenticates to Registries ahead of any Docker config.
+	Username      string
+	PasswordStdin bool
+	// Registries are the registries the basic credentials are sent to,
+	// defaulting to the registry of KO_DOCKER_REPO.
+	Registries []string
+
+	// CredentialHelpers are the names of Docker credential helpers
+	// (docker-credential-<name> on PATH) to ask for credentials ahead of
+	// the Docker config, each either for every registry or, as
+	// registry=name, for just one.
+	CredentialHelpers []string
+}
+
+func AddAuthArgs(cmd *cobra.Command, ao *AuthOptions) {
+	cmd.PersistentFlags().StringVar(&ao.DockerConfig, "docker-config", ao.DockerConfig,
+		"Docker config file, or directory containing config.json, to read registry credentials from instead of $DOCKER_CONFIG or ~/.docker.")
+	cmd.PersistentFlags().StringVar(&ao.Username, "username", ao.Username,
+		"Username to authenticate to the registry of KO_DOCKER_REPO (or --auth-registry) with, instead of the Docker config.")
+	cmd.PersistentFlags().BoolVar(&ao.PasswordStdin, "password-stdin", ao.PasswordStdin,
+		"Read the password (or token) for --username from stdin.")
+	cmd.PersistentFlags().StringSliceVar(&ao.Registries, "auth-registry", ao.Registries,
+		"Registries to send --username credentials to, instead of the registry of KO_DOCKER_REPO.")
+	cmd.PersistentFlags().StringSliceVar(&ao.CredentialHelpers, "credential-helper", ao.CredentialHelpers,
+		"Docker credential helper (docker-credential-<name> on PATH) to get registry credentials from ahead of the Docker config, as name for every registry or registry=name for one.")
+}
@@ -15,8 +15,11 @@
 package options
 
 import (
-	"runtime"
+	"compress/gzip"
+	"time"
 
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/internal/resources"
 	"github.com/spf13/cobra"
 )
 
@@ -25,13 +28,153 @@ type BuildOptions struct {
 	ConcurrentBuilds     int
 	DisableOptimizations bool
 	Platform             string
+
+	// MemoryBackoff runs fewer concurrent builds while the machine is low
+	// on memory.
+	MemoryBackoff bool
+
+	// SizeReport prints a breakdown of the size of each image built.
+	SizeReport bool
+	// SizeAnalysis adds the largest packages and symbols of each binary to
+	// the size report.
+	SizeAnalysis bool
+
+	// Progress is how to show builds and pushes: auto (tty if stderr is a
+	// terminal), tty or plain.
+	Progress string
+
+	// Labels are KEY=VALUE pairs to add to the config of every image.
+	Labels []string
+
+	// SourceAnnotations labels and annotates images with where their source
+	// code is, derived from the module of each import path.
+	SourceAnnotations bool
+
+	// BaseImage, if set, overrides defaultBaseImage from .ko.yaml.
+	BaseImage string
+
+	// DisableCaching builds an import path every time it is referenced,
+	// instead of sharing the result.
+	DisableCaching bool
+	// CacheMaxEntries and CacheTTL, if set, bound how many results are
+	// shared and for how long, for long-running commands like --watch.
+	CacheMaxEntries int
+	CacheTTL        time.Duration
+
+	// WorkDir is where builds put their intermediate files, instead of the
+	// default directory for temporary files.
+	WorkDir string
+
+	// WorkDirCleanup is which working directories to remove: always,
+	// on-success or never.
+	WorkDirCleanup string
+
+	// BaseMetadata is what to do with the labels and annotations of base
+	// images: merge, preserve or drop.
+	BaseMetadata string
+
+	// BinaryArtifacts publishes just the binary of each import path as an
+	// OCI artifact, instead of an image.
+	BinaryArtifacts bool
+
+	// KoDataSecrets is what to do when kodata looks like it holds secrets:
+	// ignore, warn or fail.
+	KoDataSecrets string
+
+	// CompressionLevel is the gzip level of the layers that are built, or 0
+	// for the default.
+	CompressionLevel int
+
+	// GoCache and GoModCache, if set, are the GOCACHE and GOMODCACHE of
+	// the go commands that ko runs, e.g. directories that CI persists
+	// between runs.
+	GoCache    string
+	GoModCache string
+	// GoCacheStats logs how big GoCache and GoModCache are, and how much
+	// they grew.
+	GoCacheStats bool
+
+	// GoProxy, GoPrivate and GoNoSumDB, if set, are the GOPROXY, GOPRIVATE
+	// and GONOSUMDB of the go commands that ko runs.
+	GoProxy   string
+	GoPrivate string
+	GoNoSumDB string
+	// Netrc, if set, is a .netrc file with the credentials for private
+	// modules, which the go commands that ko runs read via NETRC.
+	Netrc string
+
+	// BatchWindow is how long builds wait for others to share a go build
+	// with, or 0 to build each import path by itself.
+	BatchWindow time.Duration
+
+	// Reproducible builds each import path twice, and fails unless both
+	// builds produce the same image. ReproducibleClean does the second
+	// build with a fresh GOPATH and GOCACHE, and implies Reproducible.
+	Reproducible      bool
+	ReproducibleClean bool
+
+	// Lookup, if set, finds images that were already built from the same
+	// inputs. It is set from --skip-unchanged, not a flag of its own.
+	Lookup build.Lookup
 }
 
 func AddBuildOptions(cmd *cobra.Command, bo *BuildOptions) {
-	cmd.Flags().IntVarP(&bo.ConcurrentBuilds, "jobs", "j", runtime.GOMAXPROCS(0),
-		"The maximum number of concurrent builds")
+	cmd.Flags().IntVarP(&bo.ConcurrentBuilds, "jobs", "j", resources.Jobs(resources.BuildMemory),
+		"The maximum number of concurrent builds, or 0 for no limit (defaults to the number of CPUs, or fewer if memory is short)")
+	cmd.Flags().BoolVar(&bo.MemoryBackoff, "memory-backoff", true,
+		"Start builds one at a time while the machine is low on memory, instead of up to --jobs at once.")
 	cmd.Flags().BoolVar(&bo.DisableOptimizations, "disable-optimizations", bo.DisableOptimizations,
 		"Disable optimizations when building Go code. Useful when you want to interactively debug the created container.")
 	cmd.Flags().StringVar(&bo.Platform, "platform", "",
 		"Which platform to use when pulling a multi-platform base. Format: all | <os>[/<arch>[/<variant>]][,platform]*")
+	cmd.Flags().BoolVar(&bo.SizeReport, "size-report", bo.SizeReport,
+		"Print a breakdown of the size of each image built (base, kodata and binary layers) to stderr.")
+	cmd.Flags().BoolVar(&bo.SizeAnalysis, "size-analysis", bo.SizeAnalysis,
+		"Add the largest packages and symbols of each binary to the size report (implies --size-report).")
+	cmd.Flags().StringVar(&bo.Progress, "progress", "auto",
+		"How to show builds and pushes: tty (a line per import path, updated in place), plain (log lines) or auto (tty if stderr is a terminal).")
+	cmd.Flags().StringArrayVar(&bo.Labels, "image-label", bo.Labels,
+		"KEY=VALUE label to add to every image built (can be repeated).")
+	cmd.Flags().BoolVar(&bo.SourceAnnotations, "source-annotations", bo.SourceAnnotations,
+		"Label and annotate images with org.opencontainers.image.source and org.opencontainers.image.revision, derived from the module of each import path (for github.com, gitlab.com, bitbucket.org, codeberg.org and golang.org/x). The revision changes the digest of images on every commit.")
+	cmd.Flags().StringVar(&bo.BaseImage, "base-image", bo.BaseImage,
+		"Base image for import paths without a baseImageOverrides entry, instead of defaultBaseImage from .ko.yaml.")
+	cmd.Flags().BoolVar(&bo.Reproducible, "reproducible", bo.Reproducible,
+		"Build each import path twice, and fail with a diff of the two images unless they are identical.")
+	cmd.Flags().BoolVar(&bo.ReproducibleClean, "reproducible-clean", bo.ReproducibleClean,
+		"Like --reproducible, but do the second build with a fresh GOPATH and GOCACHE (which downloads every module again).")
+	cmd.Flags().BoolVar(&bo.DisableCaching, "disable-build-caching", bo.DisableCaching,
+		"Build an import path every time it is referenced, instead of once per invocation. Useful for debugging.")
+	cmd.Flags().IntVar(&bo.CacheMaxEntries, "build-cache-max-entries", bo.CacheMaxEntries,
+		"The maximum number of import paths to remember the builds of, evicting the least recently used, or 0 for no limit.")
+	cmd.Flags().DurationVar(&bo.CacheTTL, "build-cache-ttl", bo.CacheTTL,
+		"How long to remember the build of an import path before building it again, or 0 for as long as ko runs.")
+	cmd.Flags().StringVar(&bo.WorkDir, "work-dir", bo.WorkDir,
+		"Directory for intermediate build files, instead of the default directory for temporary files.")
+	cmd.Flags().StringVar(&bo.WorkDirCleanup, "work-dir-cleanup", "always",
+		"Which working directories of builds to remove: always, on-success (keep failed builds) or never.")
+	cmd.Flags().StringVar(&bo.BaseMetadata, "base-metadata", "merge",
+		"What to do with the labels and annotations of base images: merge (with --image-label winning), preserve (base wins) or drop.")
+	cmd.Flags().BoolVar(&bo.BinaryArtifacts, "binary-artifact", bo.BinaryArtifacts,
+		"Publish just the binary of each import path as a non-runnable OCI artifact (for oras or crane to fetch), instead of an image.")
+	cmd.Flags().StringVar(&bo.KoDataSecrets, "kodata-secrets", "ignore",
+		"What to do when kodata looks like it holds secrets (private keys, AWS credentials, .env files): ignore (don't scan), warn or fail.")
+	cmd.Flags().IntVar(&bo.CompressionLevel, "compression-level", gzip.BestSpeed,
+		"How hard to gzip the layers that are built, from 1 (fastest) to 9 (smallest). Higher levels save registry storage at the cost of build time.")
+	cmd.Flags().StringVar(&bo.GoCache, "gocache", bo.GoCache,
+		"Directory for the go build cache (GOCACHE) of builds, instead of the one in the environment.")
+	cmd.Flags().StringVar(&bo.GoModCache, "gomodcache", bo.GoModCache,
+		"Directory for the Go module cache (GOMODCACHE) of builds, instead of the one in the environment.")
+	cmd.Flags().BoolVar(&bo.GoCacheStats, "go-cache-stats", bo.GoCacheStats,
+		"Log the size of --gocache and --gomodcache after building, and how much they grew.")
+	cmd.Flags().StringVar(&bo.GoProxy, "goproxy", bo.GoProxy,
+		"Module proxies (GOPROXY) for builds to download modules from, instead of the ones in the environment.")
+	cmd.Flags().StringVar(&bo.GoPrivate, "goprivate", bo.GoPrivate,
+		"Patterns of private module paths (GOPRIVATE) that builds fetch directly, instead of through the proxy or checksum database.")
+	cmd.Flags().StringVar(&bo.GoNoSumDB, "gonosumdb", bo.GoNoSumDB,
+		"Patterns of module paths (GONOSUMDB) that builds don't check against the checksum database.")
+	cmd.Flags().StringVar(&bo.Netrc, "netrc", bo.Netrc,
+		"A .netrc file with credentials for private module hosts (NETRC), for builds to fetch private modules with.")
+	cmd.Flags().DurationVar(&bo.BatchWindow, "batch-window", bo.BatchWindow,
+		"How long each build waits for others for the same platform to compile with it in one go build, e.g. 200ms (0 builds each import path by itself).")
 }
@@ -0,0 +1,32 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// CacheOptions control where a long-lived ko persists its base image caches.
+type CacheOptions struct {
+	// Backend is a file:// or registry:// URL to persist the metadata
+	// (and, for file://, the layers) of base images to, so that restarts
+	// don't start cold.
+	Backend string
+}
+
+func AddCacheArg(cmd *cobra.Command, co *CacheOptions) {
+	cmd.Flags().StringVar(&co.Backend, "cache-backend", co.Backend,
+		"Where to persist base image caches across restarts: file:///path or registry://registry/repo.")
+}
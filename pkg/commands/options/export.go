@@ -0,0 +1,38 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// ExportOptions controls where ko writes what it builds locally, as well as
+// (or instead of) publishing it.
+type ExportOptions struct {
+	// Output, if set, is a directory to unpack the filesystem of each
+	// image to.
+	Output string
+
+	// LocalBinary writes the binary of each import path to Output, instead
+	// of building and publishing images.
+	LocalBinary bool
+}
+
+func AddExportArg(cmd *cobra.Command, eo *ExportOptions) {
+	cmd.Flags().StringVarP(&eo.Output, "output", "o", eo.Output,
+		"Directory to unpack the filesystem of each image to, named like its repository under KO_DOCKER_REPO (with --push=false, KO_DOCKER_REPO needn't be set).")
+	cmd.Flags().BoolVar(&eo.LocalBinary, "local-binary", eo.LocalBinary,
+		"Only build the binary of each import path, exactly as for its image, and write it to -o instead of building and publishing images.")
+}
@@ -15,11 +15,17 @@
 package options
 
 import (
+	"bufio"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/google/ko/pkg/internal/debounce"
 	"github.com/spf13/cobra"
 )
 
@@ -28,16 +34,106 @@ type FilenameOptions struct {
 	Filenames []string
 	Recursive bool
 	Watch     bool
+
+	// ConcurrentResolves bounds the number of files that are resolved at
+	// the same time.
+	ConcurrentResolves int
+
+	// Stream writes each file as soon as it is resolved, instead of in the
+	// order the files were given.
+	Stream bool
+
+	// MetricsAddr is where to serve Prometheus metrics in --watch mode.
+	MetricsAddr string
+
+	// WatchDebounce is how long --watch waits for changes to stop before
+	// rebuilding everything they affect at once, or 0 to rebuild on every
+	// change. WatchMaxDelay bounds the wait while changes keep coming.
+	WatchDebounce time.Duration
+	WatchMaxDelay time.Duration
 }
 
 func AddFileArg(cmd *cobra.Command, fo *FilenameOptions) {
 	// From pkg/kubectl
 	cmd.Flags().StringSliceVarP(&fo.Filenames, "filename", "f", fo.Filenames,
-		"Filename, directory, or URL to files to use to create the resource")
+		"Filename, directory, or URL to files to use to create the resource, or @file to read them from file, one per line")
 	cmd.Flags().BoolVarP(&fo.Recursive, "recursive", "R", fo.Recursive,
 		"Process the directory used in -f, --filename recursively. Useful when you want to manage related manifests organized within the same directory.")
 	cmd.Flags().BoolVarP(&fo.Watch, "watch", "W", fo.Watch,
 		"Continuously monitor the transitive dependencies of the passed yaml files, and redeploy whenever anything changes.")
+	cmd.Flags().IntVar(&fo.ConcurrentResolves, "resolve-jobs", runtime.GOMAXPROCS(0),
+		"The maximum number of files to resolve concurrently. Output is still written in input order.")
+	cmd.Flags().BoolVar(&fo.Stream, "stream", fo.Stream,
+		"Write each file as soon as it is resolved, rather than in input order. Only use this when the files don't need to be applied in order.")
+	cmd.Flags().StringVar(&fo.MetricsAddr, "metrics-addr", fo.MetricsAddr,
+		"With --watch, the address to serve Prometheus metrics on at /metrics, e.g. :9090.")
+	cmd.Flags().DurationVar(&fo.WatchDebounce, "watch-debounce", 250*time.Millisecond,
+		"With --watch, how long to wait for changes to stop before rebuilding everything they affect in one go, or 0 to rebuild on every change.")
+	cmd.Flags().DurationVar(&fo.WatchMaxDelay, "watch-max-delay", 2*time.Second,
+		"With --watch, the longest to put off a rebuild while changes keep coming, or 0 for no limit.")
+}
+
+// ExpandFilenames replaces each "@list" in filenames with the entries of the
+// file list: one filename, directory, URL or glob per line. Blank lines and
+// lines starting with # are ignored, and relative paths are relative to the
+// directory of list.
+func ExpandFilenames(filenames []string) ([]string, error) {
+	var expanded []string
+	for _, f := range filenames {
+		if !strings.HasPrefix(f, "@") {
+			expanded = append(expanded, f)
+			continue
+		}
+		entries, err := readFilenameList(strings.TrimPrefix(f, "@"))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, entries...)
+	}
+	return expanded, nil
+}
+
+func readFilenameList(list string) ([]string, error) {
+	f, err := os.Open(list)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []string
+	dir := filepath.Dir(list)
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		entry := strings.TrimSpace(s.Text())
+		switch {
+		case entry == "", strings.HasPrefix(entry, "#"):
+			continue
+		case strings.HasPrefix(entry, "@"):
+			return nil, fmt.Errorf("%s:%d: lists can't include other lists", list, line)
+		case entry == "-", strings.Contains(entry, "://"), strings.HasPrefix(entry, "git::"):
+			entries = append(entries, entry)
+			continue
+		}
+		if !filepath.IsAbs(entry) {
+			entry = filepath.Join(dir, entry)
+		}
+		if !strings.ContainsAny(entry, "*?[") {
+			entries = append(entries, entry)
+			continue
+		}
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", list, line, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s:%d: %s matches no files", list, line, entry)
+		}
+		entries = append(entries, matches...)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", list, err)
+	}
+	return entries, nil
 }
 
 // Based heavily on pkg/kubectl
@@ -116,12 +212,19 @@ func EnumerateFiles(fo *FilenameOptions) chan string {
 		// Now listen for change events from the watches we set up and resend
 		// files that change as if we just saw them (so they can be reprocessed).
 		if watcher != nil {
+			// Editors write several events for each save, so batch them.
+			changed := debounce.New(fo.WatchDebounce, fo.WatchMaxDelay, func(names []string) {
+				for _, name := range names {
+					files <- name
+				}
+			})
+			defer changed.Stop()
 			for {
 				select {
 				case event := <-watcher.Events:
 					switch filepath.Ext(event.Name) {
 					case ".json", ".yaml":
-						files <- event.Name
+						changed.Add(event.Name)
 					}
 				case err := <-watcher.Errors:
 					log.Fatalf("Error watching: %v", err)
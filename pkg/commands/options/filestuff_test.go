@@ -0,0 +1,80 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandFilenames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-filenames")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"a.yaml", "b.yaml", "c.json"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := filepath.Join(dir, "files.txt")
+	if err := ioutil.WriteFile(list, []byte(`# Generated by the pipeline.
+*.yaml
+
+  c.json
+/abs/config
+https://example.com/deploy.yaml
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ExpandFilenames([]string{"first.yaml", "@" + list, "-"})
+	if err != nil {
+		t.Fatalf("ExpandFilenames() = %v", err)
+	}
+	want := []string{
+		"first.yaml",
+		filepath.Join(dir, "a.yaml"),
+		filepath.Join(dir, "b.yaml"),
+		filepath.Join(dir, "c.json"),
+		"/abs/config",
+		"https://example.com/deploy.yaml",
+		"-",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ExpandFilenames() (-want +got) = %s", diff)
+	}
+
+	for name, content := range map[string]string{
+		"nested.txt":    "@files.txt\n",
+		"unmatched.txt": "*.yml\n",
+	} {
+		bad := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(bad, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ExpandFilenames([]string{"@" + bad}); err == nil {
+			t.Errorf("ExpandFilenames(%s) = nil, want error", name)
+		}
+	}
+	if _, err := ExpandFilenames([]string{"@" + filepath.Join(dir, "missing.txt")}); err == nil {
+		t.Error("ExpandFilenames(missing) = nil, want error")
+	}
+}
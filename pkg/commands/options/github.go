@@ -0,0 +1,31 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// GitHubOptions controls how ko reports what it published to GitHub Actions.
+type GitHubOptions struct {
+	// Outputs appends the published references to $GITHUB_OUTPUT, and a
+	// table of them to $GITHUB_STEP_SUMMARY.
+	Outputs bool
+}
+
+func AddGitHubArg(cmd *cobra.Command, gho *GitHubOptions) {
+	cmd.Flags().BoolVar(&gho.Outputs, "github-outputs", gho.Outputs,
+		"Append the published reference of each import path to $GITHUB_OUTPUT, and a table of them to $GITHUB_STEP_SUMMARY.")
+}
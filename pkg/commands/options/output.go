@@ -0,0 +1,66 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// OutputOptions controls where resolved yaml is written.
+type OutputOptions struct {
+	// OutputDir, if set, is a directory that each resolved document is
+	// written to as its own file, instead of writing a stream to stdout.
+	OutputDir string
+
+	// Report, if set, is a file to write a JUnit or SARIF report of the
+	// resolve to, for CI systems to annotate failures with.
+	Report string
+	// ReportFormat is "junit" or "sarif", and otherwise is inferred from
+	// the extension of Report.
+	ReportFormat string
+
+	// ImageManifest, if set, is a file to write a JSON description of every
+	// image produced by the resolve to.
+	ImageManifest string
+
+	// BuildManifest, if set, is a file to write a JSON description of every
+	// build done by the resolve to, for provenance.
+	BuildManifest string
+
+	// AttestationDir, if set, is a directory to write an in-toto statement
+	// of how each image was built to.
+	AttestationDir string
+
+	// DryRun skips building and publishing, and resolves each import path
+	// to the reference it is predicted to be published as.
+	DryRun bool
+}
+
+func AddOutputArg(cmd *cobra.Command, oo *OutputOptions) {
+	cmd.Flags().StringVar(&oo.OutputDir, "output-dir", oo.OutputDir,
+		"Directory to write each resolved document to as <kind>_<namespace>_<name>.yaml, instead of stdout.")
+	cmd.Flags().StringVar(&oo.Report, "report", oo.Report,
+		"File to write a report of resolved import paths and failures to, for CI systems.")
+	cmd.Flags().StringVar(&oo.ReportFormat, "report-format", oo.ReportFormat,
+		"Format of --report: junit or sarif (default is sarif for *.sarif files, junit otherwise).")
+	cmd.Flags().StringVar(&oo.ImageManifest, "image-manifest", oo.ImageManifest,
+		"File to write a JSON list of every image produced (import path, repository, digest, tags, platforms and size) to.")
+	cmd.Flags().StringVar(&oo.BuildManifest, "build-manifest", oo.BuildManifest,
+		"File to write a JSON list of every build (import path, digest, platforms, base image digest and timing) to.")
+	cmd.Flags().StringVar(&oo.AttestationDir, "attestation-dir", oo.AttestationDir,
+		"Directory to write an in-toto statement for each image to (ko version, flags, environment, module checksums and base image digest), named sha256-<digest>.intoto.json.")
+	cmd.Flags().BoolVar(&oo.DryRun, "dry-run", oo.DryRun,
+		"Don't build or publish anything, and substitute predicted references (with the digest from --lockfile or --state-file, or a placeholder).")
+}
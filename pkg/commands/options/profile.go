@@ -0,0 +1,37 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// ProfileOptions control profiling of ko itself, e.g. to find out where the
+// memory of a large resolve goes.
+type ProfileOptions struct {
+	// CPUProfile is a file to write a CPU profile of the command to.
+	CPUProfile string
+
+	// MemProfile is a file to write a heap profile to when the command is
+	// done.
+	MemProfile string
+}
+
+func AddProfileArgs(cmd *cobra.Command, po *ProfileOptions) {
+	cmd.PersistentFlags().StringVar(&po.CPUProfile, "profile-cpu", po.CPUProfile,
+		"Write a CPU profile of ko to this file, for go tool pprof.")
+	cmd.PersistentFlags().StringVar(&po.MemProfile, "profile-mem", po.MemProfile,
+		"Write a heap profile of ko to this file when the command finishes, for go tool pprof.")
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// ProvenanceOptions controls the provenance record of a build.
+type ProvenanceOptions struct {
+	// Provenance, if set, is a file to write a JSON record of how each
+	// image was built and published to.
+	Provenance string
+}
+
+func AddProvenanceArg(cmd *cobra.Command, pvo *ProvenanceOptions) {
+	cmd.Flags().StringVar(&pvo.Provenance, "provenance", pvo.Provenance,
+		"File to write a JSON record of how each image was built to (ko and Go versions, modules, base image, flags, environment, timings and digest).")
+}
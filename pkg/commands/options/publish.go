@@ -17,8 +17,11 @@ package options
 import (
 	"crypto/md5" //nolint: gosec // No strong cryptography needed.
 	"encoding/hex"
+	"os"
 	"path"
+	"strings"
 
+	"github.com/google/ko/pkg/internal/resources"
 	"github.com/google/ko/pkg/publish"
 	"github.com/spf13/cobra"
 )
@@ -26,6 +29,8 @@ import (
 // PublishOptions encapsulates options when publishing.
 type PublishOptions struct {
 	Tags []string
+	// DigestOnly pushes images to registries by digest, without any tags.
+	DigestOnly bool
 
 	// Push publishes images to a registry.
 	Push bool
@@ -34,15 +39,59 @@ type PublishOptions struct {
 	Local            bool
 	InsecureRegistry bool
 
+	// PortForward pushes to KO_DOCKER_REPO, a registry Service in the
+	// cluster, through kubectl port-forward.
+	PortForward bool
+
 	OCILayoutPath string
 	TarballFile   string
 
+	// Bucket, if set, is an s3://, gs:// or file:// URL of a bucket to
+	// write images to instead of pushing them, for KO_DOCKER_REPO to serve
+	// as a static registry.
+	Bucket string
+
+	// Bundle is a directory in which to write an OCI image layout of the
+	// built images along with the resolved yaml, for air-gapped installs.
+	Bundle string
+	// BundleRepo is the repository that images in the bundle will be pushed
+	// to, defaulting to KO_DOCKER_REPO.
+	BundleRepo string
+
 	// PreserveImportPaths preserves the full import path after KO_DOCKER_REPO.
 	PreserveImportPaths bool
 	// BaseImportPaths uses the base path without MD5 hash after KO_DOCKER_REPO.
 	BaseImportPaths bool
 	// Base uses a tag on the KO_DOCKER_REPO without anything additional.
 	Bare bool
+
+	// Repositories are the repositories to publish import paths to instead
+	// of KO_DOCKER_REPO, by import path or path.Match pattern of import
+	// paths.
+	Repositories map[string]string
+
+	// DisableCaching publishes an image every time it is referenced,
+	// instead of sharing the result.
+	DisableCaching bool
+
+	// Jobs is how many layers of each image are uploaded at once.
+	Jobs int
+
+	// WorkDir is where images are written to be scanned, instead of the
+	// default directory for temporary files. Commands that build set it
+	// from --work-dir.
+	WorkDir string
+
+	// SkipUnchanged reuses images already pushed from the same inputs,
+	// instead of building them again.
+	SkipUnchanged bool
+
+	// Scan is the scanner (trivy or grype) to check images for
+	// vulnerabilities with before publishing them, or empty to not scan.
+	Scan string
+	// ScanSeverity is the lowest severity of vulnerability that fails a
+	// scan.
+	ScanSeverity string
 }
 
 func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
@@ -50,6 +99,9 @@ func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
 		"Which tags to use for the produced image instead of the default 'latest' tag "+
 			"(may not work properly with --base-import-paths or --bare).")
 
+	cmd.Flags().BoolVar(&po.DigestOnly, "digest-only", po.DigestOnly,
+		"Push images to KO_DOCKER_REPO by digest alone, without --tags or any tags of ko's own, for registries with tag immutability or retention policies.")
+
 	cmd.Flags().BoolVar(&po.Push, "push", true, "Push images to KO_DOCKER_REPO")
 
 	cmd.Flags().BoolVarP(&po.Local, "local", "L", po.Local,
@@ -57,8 +109,16 @@ func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
 	cmd.Flags().BoolVar(&po.InsecureRegistry, "insecure-registry", po.InsecureRegistry,
 		"Whether to skip TLS verification on the registry")
 
+	cmd.Flags().BoolVar(&po.PortForward, "port-forward", po.PortForward,
+		"Push through kubectl port-forward to KO_DOCKER_REPO, a registry Service in the cluster named like registry.kube-system.svc:5000, and reference images by that name.")
 	cmd.Flags().StringVar(&po.OCILayoutPath, "oci-layout-path", "", "Path to save the OCI image layout of the built images")
 	cmd.Flags().StringVar(&po.TarballFile, "tarball", "", "File to save images tarballs")
+	cmd.Flags().StringVar(&po.Bucket, "bucket", "",
+		"Write images to this bucket (s3://bucket/prefix, gs://bucket/prefix or file:///dir) laid out for static serving as the registry KO_DOCKER_REPO, instead of pushing them.")
+	cmd.Flags().StringVar(&po.Bundle, "bundle", "",
+		"Directory to save an air-gap bundle to: an OCI image layout of the built images and the resolved yaml.")
+	cmd.Flags().StringVar(&po.BundleRepo, "bundle-repo", "",
+		"Repository that images in the --bundle will be pushed to (defaults to KO_DOCKER_REPO).")
 
 	cmd.Flags().BoolVarP(&po.PreserveImportPaths, "preserve-import-paths", "P", po.PreserveImportPaths,
 		"Whether to preserve the full import path after KO_DOCKER_REPO.")
@@ -66,6 +126,19 @@ func AddPublishArg(cmd *cobra.Command, po *PublishOptions) {
 		"Whether to use the base path without MD5 hash after KO_DOCKER_REPO (may not work properly with --tags).")
 	cmd.Flags().BoolVar(&po.Bare, "bare", po.Bare,
 		"Whether to just use KO_DOCKER_REPO without additional context (will not work properly with --tags).")
+	cmd.Flags().StringToStringVar(&po.Repositories, "repositories", po.Repositories,
+		"Repositories to publish particular import paths to instead of KO_DOCKER_REPO, as comma-separated import path (or pattern)=repository pairs, e.g. github.com/org/repo/web/*=gcr.io/frontend.")
+	cmd.Flags().BoolVar(&po.DisableCaching, "disable-publish-caching", po.DisableCaching,
+		"Publish an image every time it is referenced, instead of once per invocation. Useful for debugging.")
+	cmd.Flags().IntVar(&po.Jobs, "push-jobs", resources.PushJobs(),
+		"The maximum number of layers of each image to upload concurrently.")
+	cmd.Flags().BoolVar(&po.SkipUnchanged, "skip-unchanged", po.SkipUnchanged,
+		"Skip building import paths whose inputs are unchanged since an image was last pushed to KO_DOCKER_REPO, and reuse that image.")
+	cmd.Flags().StringVar(&po.Scan, "scan", po.Scan,
+		"Scan images for vulnerabilities before publishing them, with trivy or grype (which must be on the PATH).")
+	cmd.Flags().Lookup("scan").NoOptDefVal = "trivy"
+	cmd.Flags().StringVar(&po.ScanSeverity, "scan-severity", "high",
+		"Fail instead of publishing images that --scan finds vulnerabilities of this severity or higher in: low, medium, high or critical.")
 }
 
 func packageWithMD5(base, importpath string) string {
@@ -87,12 +160,44 @@ func bareDockerRepo(base, _ string) string {
 }
 
 func MakeNamer(po *PublishOptions) publish.Namer {
+	namer := packageWithMD5
 	if po.PreserveImportPaths {
-		return preserveImportPath
+		namer = preserveImportPath
 	} else if po.BaseImportPaths {
-		return baseImportPaths
+		namer = baseImportPaths
 	} else if po.Bare {
-		return bareDockerRepo
+		namer = bareDockerRepo
+	}
+	if len(po.Repositories) == 0 {
+		return namer
+	}
+	// Only images bound for KO_DOCKER_REPO go elsewhere, not those for
+	// the daemon, kind, or a --bundle-repo.
+	repoName := os.Getenv("KO_DOCKER_REPO")
+	return func(base, importpath string) string {
+		if base == repoName && base != publish.LocalDomain && base != publish.KindDomain {
+			if repo := RepositoryFor(po, importpath); repo != "" {
+				base = repo
+			}
+		}
+		return namer(base, importpath)
+	}
+}
+
+// RepositoryFor returns the repository of po.Repositories that importpath is
+// published to, or "" for KO_DOCKER_REPO. An exact match wins, and then the
+// longest matching pattern.
+func RepositoryFor(po *PublishOptions, importpath string) string {
+	importpath = strings.ToLower(importpath)
+	var repo, matched string
+	for pattern, r := range po.Repositories {
+		pattern = strings.ToLower(pattern)
+		if pattern == importpath {
+			return r
+		}
+		if ok, _ := path.Match(pattern, importpath); ok && len(pattern) > len(matched) {
+			repo, matched = r, pattern
+		}
 	}
-	return packageWithMD5
+	return repo
 }
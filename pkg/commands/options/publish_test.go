@@ -0,0 +1,52 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/ko/pkg/publish"
+)
+
+func TestMakeNamerRepositories(t *testing.T) {
+	defer func(old string) { os.Setenv("KO_DOCKER_REPO", old) }(os.Getenv("KO_DOCKER_REPO"))
+	os.Setenv("KO_DOCKER_REPO", "gcr.io/backend")
+
+	po := &PublishOptions{
+		PreserveImportPaths: true,
+		Repositories: map[string]string{
+			"github.com/org/repo/web/*":       "gcr.io/frontend",
+			"github.com/org/repo/web/cmd/*":   "gcr.io/frontend-cmds",
+			"github.com/Org/repo/web/special": "gcr.io/special",
+		},
+	}
+	namer := MakeNamer(po)
+	for _, c := range []struct {
+		base, importpath, want string
+	}{
+		{"gcr.io/backend", "github.com/org/repo/cmd/api", "gcr.io/backend/github.com/org/repo/cmd/api"},
+		{"gcr.io/backend", "github.com/org/repo/web/app", "gcr.io/frontend/github.com/org/repo/web/app"},
+		{"gcr.io/backend", "github.com/org/repo/web/cmd/ui", "gcr.io/frontend-cmds/github.com/org/repo/web/cmd/ui"},
+		{"gcr.io/backend", "github.com/org/repo/web/special", "gcr.io/special/github.com/org/repo/web/special"},
+		// Only images for KO_DOCKER_REPO are redirected.
+		{publish.LocalDomain, "github.com/org/repo/web/app", publish.LocalDomain + "/github.com/org/repo/web/app"},
+		{"registry.airgap/mirror", "github.com/org/repo/web/app", "registry.airgap/mirror/github.com/org/repo/web/app"},
+	} {
+		if got := namer(c.base, c.importpath); got != c.want {
+			t.Errorf("namer(%s, %s) = %s, want %s", c.base, c.importpath, got, c.want)
+		}
+	}
+}
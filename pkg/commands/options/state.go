@@ -0,0 +1,49 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// StateOptions controls how the results of a resolve are persisted and
+// compared against the previous resolve.
+type StateOptions struct {
+	// StateFile is where the importpath -> digest map of the last resolve
+	// is persisted.
+	StateFile string
+	// Diff reports what changed since the state in StateFile was written.
+	Diff bool
+
+	// LockFile is where the importpath -> digest lockfile lives.
+	LockFile string
+	// Lock writes the digests of the resolve to LockFile.
+	Lock bool
+	// Frozen fails the resolve if any digest differs from LockFile.
+	Frozen bool
+}
+
+func AddStateArg(cmd *cobra.Command, sto *StateOptions) {
+	cmd.Flags().StringVar(&sto.StateFile, "state-file", sto.StateFile,
+		"File in which to persist the importpath to digest map of the resolve.")
+	cmd.Flags().BoolVar(&sto.Diff, "diff", sto.Diff,
+		"Report to stderr which images and files changed since the last resolve recorded in --state-file.")
+	cmd.Flags().StringVar(&sto.LockFile, "lockfile", "ko.lock",
+		"File to write (with --lock) or check (with --frozen) the importpath to digest lockfile.")
+	cmd.Flags().BoolVar(&sto.Lock, "lock", sto.Lock,
+		"Write the digest of each built image to --lockfile.")
+	cmd.Flags().BoolVar(&sto.Frozen, "frozen", sto.Frozen,
+		"Refuse to publish any image whose digest differs from the one recorded in --lockfile.")
+}
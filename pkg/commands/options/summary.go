@@ -0,0 +1,32 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// SummaryOptions controls the summary of what was built that ko prints at the
+// end of a run.
+type SummaryOptions struct {
+	// Summary prints a table of the images that were built and published
+	// to stderr once they all have been.
+	Summary bool
+}
+
+func AddSummaryArg(cmd *cobra.Command, smo *SummaryOptions) {
+	cmd.Flags().BoolVar(&smo.Summary, "summary", true,
+		"Print a table of the images built (import path, digest, tags, platforms, size, build and push time, and cache hits) to stderr at the end.")
+}
@@ -0,0 +1,31 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// TektonOptions controls how ko reports what it published to Tekton.
+type TektonOptions struct {
+	// Results maps import paths to the Tekton result files, i.e.
+	// $(results.<name>.path), to write their published references to.
+	Results map[string]string
+}
+
+func AddTektonArg(cmd *cobra.Command, to *TektonOptions) {
+	cmd.Flags().StringToStringVar(&to.Results, "tekton-result", to.Results,
+		"IMPORTPATH=FILE pairs of import paths and the Tekton result file, e.g. $(results.image.path), to write the published reference of each to.")
+}
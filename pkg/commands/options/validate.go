@@ -0,0 +1,34 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// ValidateOptions controls how resolved documents are validated before they
+// are emitted or applied.
+type ValidateOptions struct {
+	// Validate is empty to skip validation, "builtin" to validate against
+	// the schemas of the Kubernetes types built into ko, or "cluster" to
+	// validate against the OpenAPI schema served by the target cluster.
+	Validate string
+}
+
+func AddValidateArg(cmd *cobra.Command, vo *ValidateOptions) {
+	cmd.Flags().StringVar(&vo.Validate, "validate", vo.Validate,
+		"Validate resolved documents against Kubernetes schemas before emitting them: builtin, or cluster to use the target cluster's schema via kubectl.")
+	cmd.Flags().Lookup("validate").NoOptDefVal = "builtin"
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dirWriter is an io.WriteCloser that splits the yaml stream written to it
+// into one file per document under dir. Each file is named after the
+// resource it contains, so repeated resolves of the same inputs produce the
+// same files, which keeps diffs minimal when the directory is checked in.
+// Files that an earlier resolve wrote but this one didn't are removed, so
+// that deleted resources don't linger; other files in dir are left alone.
+type dirWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+// writtenFiles lists the files in an output directory that ko wrote, so that
+// the next resolve knows which ones it may remove.
+const writtenFiles = ".ko-written"
+
+// newDirWriter returns a dirWriter for dir. With watch, a document may be
+// written again as its file changes, replacing its file.
+func newDirWriter(dir string, watch bool) (io.WriteCloser, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	dw := &dirWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := writeDocsToDir(dir, pr, watch)
+		// Unblock the writer if we bailed early.
+		pr.CloseWithError(err)
+		dw.done <- err
+	}()
+	return dw, nil
+}
+
+// Write implements io.Writer
+func (dw *dirWriter) Write(b []byte) (int, error) {
+	return dw.pw.Write(b)
+}
+
+// Close implements io.Closer, and waits for all of the documents to be
+// written out.
+func (dw *dirWriter) Close() error {
+	dw.pw.Close()
+	return <-dw.done
+}
+
+// writeDocsToDir decodes each yaml document from r and writes it to its own
+// file under dir, and then removes the files that ko wrote to dir before but
+// not this time. Unless watch is set, two documents with the same file name
+// are an error, since one would overwrite the other.
+func writeDocsToDir(dir string, r io.Reader, watch bool) error {
+	decoder := yaml.NewDecoder(r)
+	written := map[string]bool{}
+	for i := 0; ; {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return removeStaleFiles(dir, written)
+			}
+			return err
+		}
+		// Skip the empty documents between delimiters.
+		if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+			continue
+		}
+
+		fn, err := docFilename(&doc)
+		if err != nil {
+			return err
+		}
+		if fn == "" {
+			fn = fmt.Sprintf("document-%d.yaml", i)
+		}
+		i++
+		if written[fn] && !watch {
+			return fmt.Errorf("more than one document would be written to %s", filepath.Join(dir, fn))
+		}
+		written[fn] = true
+		buf := &bytes.Buffer{}
+		e := yaml.NewEncoder(buf)
+		e.SetIndent(2)
+		if err := e.Encode(&doc); err != nil {
+			return fmt.Errorf("failed to encode output: %v", err)
+		}
+		e.Close()
+
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+}
+
+// removeStaleFiles removes the files listed in dir's writtenFiles that aren't
+// in written, and then lists written there instead.
+func removeStaleFiles(dir string, written map[string]bool) error {
+	b, err := ioutil.ReadFile(filepath.Join(dir, writtenFiles))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fn := range strings.Fields(string(b)) {
+		// Only ever remove files of the kind ko writes.
+		if written[fn] || fn != filepath.Base(fn) || filepath.Ext(fn) != ".yaml" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, fn)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var fns []string
+	for fn := range written {
+		fns = append(fns, fn)
+	}
+	sort.Strings(fns)
+	return ioutil.WriteFile(filepath.Join(dir, writtenFiles), []byte(strings.Join(fns, "\n")+"\n"), 0644)
+}
+
+// docFilename returns <kind>_<namespace>_<name>.yaml for the given document,
+// omitting the namespace for cluster-scoped resources. It returns "" if the
+// document has no kind or name.
+func docFilename(doc *yaml.Node) (string, error) {
+	var obj struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+	}
+	if err := doc.Decode(&obj); err != nil {
+		// Not an object, so there's no identity to name the file after.
+		return "", nil
+	}
+	if obj.Kind == "" || obj.Metadata.Name == "" {
+		return "", nil
+	}
+
+	parts := []string{obj.Kind}
+	if obj.Metadata.Namespace != "" {
+		parts = append(parts, obj.Metadata.Namespace)
+	}
+	parts = append(parts, obj.Metadata.Name)
+	// Names may contain characters (e.g. ':' in RBAC) that are awkward in
+	// filenames.
+	fn := strings.ToLower(strings.Join(parts, "_"))
+	fn = strings.NewReplacer("/", "-", ":", "-").Replace(fn)
+	return fn + ".yaml", nil
+}
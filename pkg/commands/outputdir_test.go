@@ -0,0 +1,151 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDirWriter(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dw, err := newDirWriter(tmp, false)
+	if err != nil {
+		t.Fatalf("newDirWriter() = %v", err)
+	}
+
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: hello
+  namespace: default
+spec:
+  replicas: 1
+`
+	role := `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: system:hello
+`
+	// This is the shape of what resolveFilesToWriter produces.
+	for _, doc := range []string{deployment, role, "123\n"} {
+		if _, err := io.WriteString(dw, doc+"\n---\n"); err != nil {
+			t.Fatalf("Write() = %v", err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	want := map[string]string{
+		"deployment_default_hello.yaml": deployment,
+		"clusterrole_system-hello.yaml": role,
+		"document-2.yaml":               "123\n",
+		writtenFiles:                    "clusterrole_system-hello.yaml\ndeployment_default_hello.yaml\ndocument-2.yaml\n",
+	}
+	fis, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	var wantNames []string
+	for name := range want {
+		wantNames = append(wantNames, name)
+	}
+	sort.Strings(wantNames)
+	if diff := cmp.Diff(wantNames, names); diff != "" {
+		t.Fatalf("files (-want +got) = %v", diff)
+	}
+	for name, content := range want {
+		got, err := ioutil.ReadFile(filepath.Join(tmp, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(content, string(got)); diff != "" {
+			t.Errorf("%s (-want +got) = %v", name, diff)
+		}
+	}
+}
+
+func TestDirWriterRemovesStaleFiles(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	doc := func(kind, name string) string {
+		return "kind: " + kind + "\nmetadata:\n  name: " + name + "\n---\n"
+	}
+	resolve := func(watch bool, docs ...string) error {
+		t.Helper()
+		dw, err := newDirWriter(tmp, watch)
+		if err != nil {
+			t.Fatalf("newDirWriter() = %v", err)
+		}
+		if _, err := io.WriteString(dw, strings.Join(docs, "")); err != nil {
+			return err
+		}
+		return dw.Close()
+	}
+	exists := func(fn string) bool {
+		_, err := os.Stat(filepath.Join(tmp, fn))
+		return err == nil
+	}
+
+	if err := resolve(false, doc("Service", "a"), doc("Service", "b")); err != nil {
+		t.Fatalf("resolve() = %v", err)
+	}
+	// Not written by ko, so never removed.
+	if err := ioutil.WriteFile(filepath.Join(tmp, "kustomization.yaml"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolve(false, doc("Service", "a")); err != nil {
+		t.Fatalf("resolve() = %v", err)
+	}
+	for fn, want := range map[string]bool{
+		"service_a.yaml":     true,
+		"service_b.yaml":     false,
+		"kustomization.yaml": true,
+	} {
+		if got := exists(fn); got != want {
+			t.Errorf("%s exists = %v, want %v", fn, got, want)
+		}
+	}
+
+	// Both would be written to service_a.yaml.
+	if err := resolve(false, doc("Service", "a"), doc("service", "A")); err == nil {
+		t.Error("resolve(colliding documents) = nil, want error")
+	}
+	// With --watch, a changed file's documents are written again.
+	if err := resolve(true, doc("Service", "a"), doc("Service", "a")); err != nil {
+		t.Errorf("resolve(--watch) = %v", err)
+	}
+}
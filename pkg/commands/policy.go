@@ -0,0 +1,164 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// basePolicy restricts the base images that import paths may be built on,
+// from the basePolicy section of .ko.yaml.
+type basePolicy struct {
+	// AllowedRepositories are the registries and repositories (and their
+	// sub-repositories) that base images may come from, e.g. gcr.io or
+	// gcr.io/distroless. If empty, any repository is allowed.
+	AllowedRepositories []string `mapstructure:"allowedRepositories"`
+
+	// RequireDigest requires base images to be pinned by digest.
+	RequireDigest bool `mapstructure:"requireDigest"`
+
+	// MaxAge, if set, is how long ago base images may have been created.
+	MaxAge time.Duration `mapstructure:"maxAge"`
+}
+
+// errBasePolicy is what basePolicyErrors unwrap to.
+var errBasePolicy = errors.New("base image violates basePolicy")
+
+// basePolicyError explains why a base image isn't allowed.
+type basePolicyError struct {
+	Ref    string
+	Reason string
+}
+
+func (e *basePolicyError) Error() string {
+	return fmt.Sprintf("base image %s is not allowed by basePolicy: %s", e.Ref, e.Reason)
+}
+
+func (e *basePolicyError) Unwrap() error {
+	return errBasePolicy
+}
+
+// parseBasePolicy normalizes the allowed repositories of p, so that they can
+// be compared with the repositories of parsed references.
+func parseBasePolicy(p *basePolicy) error {
+	for i, allowed := range p.AllowedRepositories {
+		// A bare hostname like gcr.io is a registry, not a repository on
+		// Docker Hub.
+		if !strings.Contains(allowed, "/") && (strings.ContainsAny(allowed, ".:") || allowed == "localhost") {
+			reg, err := name.NewRegistry(allowed)
+			if err != nil {
+				return fmt.Errorf("'basePolicy': error parsing allowed registry %q: %v", allowed, err)
+			}
+			p.AllowedRepositories[i] = reg.Name()
+			continue
+		}
+		repo, err := name.NewRepository(allowed)
+		if err != nil {
+			return fmt.Errorf("'basePolicy': error parsing allowed repository %q: %v", allowed, err)
+		}
+		p.AllowedRepositories[i] = repo.Name()
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("'basePolicy': maxAge %v is negative", p.MaxAge)
+	}
+	return nil
+}
+
+// checkRef returns a basePolicyError if p doesn't allow ref. A nil policy
+// allows everything.
+func (p *basePolicy) checkRef(ref name.Reference) error {
+	if p == nil {
+		return nil
+	}
+	if len(p.AllowedRepositories) != 0 {
+		repo := ref.Context().Name()
+		allowed := false
+		for _, a := range p.AllowedRepositories {
+			if repo == a || strings.HasPrefix(repo, a+"/") {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &basePolicyError{
+				Ref:    ref.String(),
+				Reason: fmt.Sprintf("%s is not one of the allowed repositories %s", repo, strings.Join(p.AllowedRepositories, ", ")),
+			}
+		}
+	}
+	if _, ok := ref.(name.Digest); p.RequireDigest && !ok {
+		return &basePolicyError{
+			Ref:    ref.String(),
+			Reason: "base images must be pinned by digest, e.g. " + ref.Context().Name() + "@sha256:...",
+		}
+	}
+	return nil
+}
+
+// checkAge returns a basePolicyError if any image in base was created more
+// than p.MaxAge before now. Images without a creation time (including the
+// reproducible images that claim to be from 1970) are too old.
+func (p *basePolicy) checkAge(ref name.Reference, base build.Result, now time.Time) error {
+	if p == nil || p.MaxAge == 0 {
+		return nil
+	}
+	var imgs []v1.Image
+	switch b := base.(type) {
+	case v1.Image:
+		imgs = append(imgs, b)
+	case v1.ImageIndex:
+		im, err := b.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, desc := range im.Manifests {
+			if !desc.MediaType.IsImage() {
+				continue
+			}
+			img, err := b.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			imgs = append(imgs, img)
+		}
+	}
+	for _, img := range imgs {
+		cf, err := img.ConfigFile()
+		if err != nil {
+			return err
+		}
+		created := cf.Created.Time
+		if created.Unix() <= 0 {
+			return &basePolicyError{
+				Ref:    ref.String(),
+				Reason: fmt.Sprintf("it has no creation time, so it can't be shown to be newer than maxAge %v", p.MaxAge),
+			}
+		}
+		if age := now.Sub(created); age > p.MaxAge {
+			return &basePolicyError{
+				Ref:    ref.String(),
+				Reason: fmt.Sprintf("it was created %s, more than maxAge %v ago", created.UTC().Format(time.RFC3339), p.MaxAge),
+			}
+		}
+	}
+	return nil
+}
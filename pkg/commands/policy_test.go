@@ -0,0 +1,132 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/spf13/viper"
+)
+
+func TestParseBasePolicy(t *testing.T) {
+	v := viper.New()
+	v.Set("basePolicy", map[string]interface{}{
+		"allowedRepositories": []interface{}{"gcr.io/distroless", "docker.io/library/alpine", "cgr.dev"},
+		"requireDigest":       true,
+		"maxAge":              "720h",
+	})
+	var got basePolicy
+	if err := v.UnmarshalKey("basePolicy", &got); err != nil {
+		t.Fatalf("UnmarshalKey() = %v", err)
+	}
+	if err := parseBasePolicy(&got); err != nil {
+		t.Fatalf("parseBasePolicy() = %v", err)
+	}
+	want := basePolicy{
+		AllowedRepositories: []string{"gcr.io/distroless", "index.docker.io/library/alpine", "cgr.dev"},
+		RequireDigest:       true,
+		MaxAge:              720 * time.Hour,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseBasePolicy() (-want +got) = %v", diff)
+	}
+
+	if err := parseBasePolicy(&basePolicy{AllowedRepositories: []string{"UPPER/case"}}); err == nil {
+		t.Error("parseBasePolicy() = nil, want error")
+	}
+}
+
+func TestBasePolicyCheckRef(t *testing.T) {
+	const digest = "@sha256:deadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeefdeadbeef"
+	p := &basePolicy{AllowedRepositories: []string{"gcr.io/distroless", "cgr.dev"}}
+	for _, tc := range []struct {
+		ref           string
+		requireDigest bool
+		allowed       bool
+	}{
+		{ref: "gcr.io/distroless/static:nonroot", allowed: true},
+		{ref: "gcr.io/distroless", allowed: true},
+		{ref: "cgr.dev/chainguard/static", allowed: true},
+		{ref: "gcr.io/distroless-fake/static"},
+		{ref: "docker.io/random/image"},
+		{ref: "gcr.io/distroless/static:nonroot", requireDigest: true},
+		{ref: "gcr.io/distroless/static" + digest, requireDigest: true, allowed: true},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			ref, err := name.ParseReference(tc.ref)
+			if err != nil {
+				t.Fatalf("ParseReference() = %v", err)
+			}
+			p.RequireDigest = tc.requireDigest
+			err = p.checkRef(ref)
+			if got := err == nil; got != tc.allowed {
+				t.Errorf("checkRef() = %v, want allowed %v", err, tc.allowed)
+			}
+			if err != nil && !errors.Is(err, errBasePolicy) {
+				t.Errorf("errors.Is(%v, errBasePolicy) = false", err)
+			}
+		})
+	}
+
+	ref, err := name.ParseReference("docker.io/random/image")
+	if err != nil {
+		t.Fatalf("ParseReference() = %v", err)
+	}
+	var nilPolicy *basePolicy
+	if err := nilPolicy.checkRef(ref); err != nil {
+		t.Errorf("checkRef() without a policy = %v", err)
+	}
+}
+
+func TestBasePolicyCheckAge(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ref, err := name.ParseReference("gcr.io/distroless/static")
+	if err != nil {
+		t.Fatalf("ParseReference() = %v", err)
+	}
+	p := &basePolicy{MaxAge: 30 * 24 * time.Hour}
+
+	for _, tc := range []struct {
+		name    string
+		created time.Time
+		allowed bool
+	}{
+		{name: "new", created: now.Add(-24 * time.Hour), allowed: true},
+		{name: "old", created: now.Add(-60 * 24 * time.Hour)},
+		{name: "epoch", created: time.Unix(0, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			base, err := mutate.CreatedAt(img, v1.Time{Time: tc.created})
+			if err != nil {
+				t.Fatalf("CreatedAt() = %v", err)
+			}
+			err = p.checkAge(ref, base, now)
+			if got := err == nil; got != tc.allowed {
+				t.Errorf("checkAge() = %v, want allowed %v", err, tc.allowed)
+			}
+		})
+	}
+}
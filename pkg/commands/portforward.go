@@ -0,0 +1,152 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// portForwardTimeout bounds how long kubectl port-forward has to start.
+var portForwardTimeout = 30 * time.Second
+
+// clusterRegistry is a registry that runs as a Service in the cluster.
+type clusterRegistry struct {
+	// Host is how the cluster names the registry, e.g.
+	// registry.kube-system.svc:5000.
+	Host      string
+	Service   string
+	Namespace string
+	Port      string
+}
+
+// parseClusterRegistry parses the registry of repo, which must be named like
+// <service>.<namespace>.svc[.cluster.local]:<port>.
+func parseClusterRegistry(repo string) (*clusterRegistry, error) {
+	host := strings.SplitN(repo, "/", 2)[0]
+	i := strings.LastIndex(host, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("%q has no port: expected a registry Service like registry.kube-system.svc:5000", host)
+	}
+	dns, port := host[:i], host[i+1:]
+	dns = strings.TrimSuffix(dns, ".cluster.local")
+	parts := strings.Split(dns, ".")
+	if len(parts) != 3 || parts[2] != "svc" || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("%q isn't a Service: expected a registry Service like registry.kube-system.svc:5000", host)
+	}
+	return &clusterRegistry{Host: host, Service: parts[0], Namespace: parts[1], Port: port}, nil
+}
+
+// forwardingFrom matches the line that kubectl port-forward writes once it is
+// listening.
+var forwardingFrom = regexp.MustCompile(`Forwarding from (127\.0\.0\.1:\d+) ->`)
+
+// portForward runs kubectl port-forward to the Service of r on a free local
+// port, and returns the address it listens on and a func to stop it.
+//
+// kubectl writes to its stdout for each connection, so it also dies of
+// SIGPIPE once ko exits without stopping it.
+func portForward(r *clusterRegistry) (string, func(), error) {
+	cmd := exec.Command("kubectl", "port-forward", "--namespace", r.Namespace, "service/"+r.Service, ":"+r.Port)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("kubectl port-forward: %v", err)
+	}
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+	}
+
+	addr := make(chan string, 1)
+	go func() {
+		s := bufio.NewScanner(stdout)
+		for s.Scan() {
+			if m := forwardingFrom.FindStringSubmatch(s.Text()); m != nil {
+				addr <- m[1]
+				break
+			}
+		}
+		close(addr)
+		// Keep reading, so that kubectl doesn't block.
+		io.Copy(ioutil.Discard, stdout)
+	}()
+	select {
+	case a, ok := <-addr:
+		if !ok {
+			stop()
+			return "", nil, fmt.Errorf("kubectl port-forward to %s: %s", r.Host, strings.TrimSpace(stderr.String()))
+		}
+		return a, stop, nil
+	case <-time.After(portForwardTimeout):
+		stop()
+		return "", nil, fmt.Errorf("kubectl port-forward to %s didn't start within %v", r.Host, portForwardTimeout)
+	}
+}
+
+// forwardedPublisher publishes through inner, which pushes to a local port
+// that is forwarded to a registry in the cluster, and names what it publishes
+// by the cluster's name for the registry.
+type forwardedPublisher struct {
+	inner publish.Interface
+	// local is the registry that inner pushes to, and cluster the name
+	// the cluster knows it by.
+	local, cluster string
+	stop           func()
+}
+
+// Publish implements publish.Interface
+func (f *forwardedPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	ref, err := f.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	return rewriteRegistry(ref, f.local, f.cluster)
+}
+
+// Close implements publish.Interface
+func (f *forwardedPublisher) Close() error {
+	defer f.stop()
+	return f.inner.Close()
+}
+
+// rewriteRegistry returns ref in registry to instead of from.
+func rewriteRegistry(ref name.Reference, from, to string) (name.Reference, error) {
+	s := ref.String()
+	if !strings.HasPrefix(s, from+"/") {
+		return ref, nil
+	}
+	return name.ParseReference(to + strings.TrimPrefix(s, from))
+}
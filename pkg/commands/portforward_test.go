@@ -0,0 +1,126 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestParseClusterRegistry(t *testing.T) {
+	for repo, want := range map[string]clusterRegistry{
+		"registry.kube-system.svc:5000":                  {Host: "registry.kube-system.svc:5000", Service: "registry", Namespace: "kube-system", Port: "5000"},
+		"registry.kube-system.svc.cluster.local:5000/ko": {Host: "registry.kube-system.svc.cluster.local:5000", Service: "registry", Namespace: "kube-system", Port: "5000"},
+	} {
+		got, err := parseClusterRegistry(repo)
+		if err != nil {
+			t.Fatalf("parseClusterRegistry(%s) = %v", repo, err)
+		}
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Errorf("parseClusterRegistry(%s) (-want +got) = %s", repo, diff)
+		}
+	}
+	for _, repo := range []string{"registry.kube-system.svc", "gcr.io/foo", "localhost:5000", "registry.svc:5000"} {
+		if _, err := parseClusterRegistry(repo); err == nil {
+			t.Errorf("parseClusterRegistry(%s) = nil, want error", repo)
+		}
+	}
+}
+
+// fakeKubectl puts a kubectl on the PATH that claims to forward from addr.
+func fakeKubectl(t *testing.T, addr string) func() {
+	t.Helper()
+	return scriptedKubectl(t, fmt.Sprintf("#!/bin/sh\necho 'Forwarding from %s -> 5000'\nexec sleep 60\n", addr))
+}
+
+// scriptedKubectl puts a kubectl on the PATH that runs script.
+func scriptedKubectl(t *testing.T, script string) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ko-kubectl")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPortForwardPublisher(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake kubectl is a shell script")
+	}
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	defer fakeKubectl(t, u.Host)()
+	defer func(old string) { os.Setenv("KO_DOCKER_REPO", old) }(os.Getenv("KO_DOCKER_REPO"))
+	os.Setenv("KO_DOCKER_REPO", "registry.kube-system.svc:5000/ko")
+
+	pub, err := makePublisher(&options.PublishOptions{Push: true, PortForward: true, Bare: true, DisableCaching: true, Tags: []string{"latest"}})
+	if err != nil {
+		t.Fatalf("makePublisher() = %v", err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ref, err := pub.Publish(context.Background(), img, "ko://github.com/foo/bar")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if err := pub.Close(); err != nil {
+		t.Errorf("Close() = %v", err)
+	}
+	if !strings.HasPrefix(ref.String(), "registry.kube-system.svc:5000/ko@sha256:") {
+		t.Errorf("Publish() = %s, want it named by the cluster's registry", ref)
+	}
+
+	// It was pushed through the forwarded port.
+	d, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	pushed, err := name.NewDigest(u.Host + "/ko@" + d.String())
+	if err != nil {
+		t.Fatalf("NewDigest() = %v", err)
+	}
+	if _, err := remote.Head(pushed); err != nil {
+		t.Errorf("Head(%s) = %v", pushed, err)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+
+	"github.com/google/ko/pkg/commands/options"
+)
+
+// startProfiling starts a CPU profile if one was asked for, and returns a
+// func that stops it and writes a heap profile if one was asked for.
+func startProfiling(po *options.ProfileOptions) (func() error, error) {
+	var cpu *os.File
+	if po.CPUProfile != "" {
+		f, err := os.Create(po.CPUProfile)
+		if err != nil {
+			return nil, fmt.Errorf("creating CPU profile: %w", err)
+		}
+		if err := rpprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("starting CPU profile: %w", err)
+		}
+		cpu = f
+	}
+	return func() error {
+		if cpu != nil {
+			rpprof.StopCPUProfile()
+			if err := cpu.Close(); err != nil {
+				return err
+			}
+		}
+		if po.MemProfile == "" {
+			return nil
+		}
+		f, err := os.Create(po.MemProfile)
+		if err != nil {
+			return fmt.Errorf("creating heap profile: %w", err)
+		}
+		defer f.Close()
+		// Get up-to-date statistics.
+		runtime.GC()
+		if err := rpprof.WriteHeapProfile(f); err != nil {
+			return fmt.Errorf("writing heap profile: %w", err)
+		}
+		return f.Close()
+	}, nil
+}
+
+// handlePprof serves the profiles of net/http/pprof under /debug/pprof/ on
+// mux, for the long-running commands.
+func handlePprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// serveProfiles serves the profiles of handlePprof on addr until the returned
+// server is closed.
+func serveProfiles(addr string) *http.Server {
+	mux := http.NewServeMux()
+	handlePprof(mux)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Serving profiles on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("error serving profiles: %v", err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestStartProfiling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-profile")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	po := &options.ProfileOptions{
+		CPUProfile: filepath.Join(dir, "cpu.pprof"),
+		MemProfile: filepath.Join(dir, "mem.pprof"),
+	}
+	stop, err := startProfiling(po)
+	if err != nil {
+		t.Fatalf("startProfiling() = %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("stop() = %v", err)
+	}
+	for _, f := range []string{po.CPUProfile, po.MemProfile} {
+		if fi, err := os.Stat(f); err != nil {
+			t.Errorf("Stat(%s) = %v", f, err)
+		} else if fi.Size() == 0 {
+			t.Errorf("%s is empty", f)
+		}
+	}
+
+	// Without any profiles asked for, nothing is written.
+	stop, err = startProfiling(&options.ProfileOptions{})
+	if err != nil {
+		t.Fatalf("startProfiling() = %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("stop() = %v", err)
+	}
+}
+
+func TestProfileArgsUsage(t *testing.T) {
+	cmd := &cobra.Command{}
+	options.AddProfileArgs(cmd, &options.ProfileOptions{})
+	for _, name := range []string{"profile-cpu", "profile-mem"} {
+		// A backquoted word in the usage would name the value instead.
+		if got, _ := pflag.UnquoteUsage(cmd.PersistentFlags().Lookup(name)); got != "string" {
+			t.Errorf("--%s takes a %q, want a string", name, got)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// addPrefetch augments our CLI surface with prefetch.
+func addPrefetch(topLevel *cobra.Command) {
+	bo := &options.BuildOptions{}
+
+	prefetch := &cobra.Command{
+		Use:   "prefetch [IMPORTPATH...]",
+		Short: "Download base images and Go modules ahead of building.",
+		Long: `This sub-command pulls the layers of the default and overridden base images (for each platform that --platform selects) into $KO_CACHE, and downloads the Go modules needed to build the given import paths (or all of the main module's dependencies), so that later builds don't wait on downloads.
+
+Builds only read base images from the cache when KO_CACHE is set.`,
+		Example: `
+  # Warm caches on a fresh CI runner before building.
+  export KO_CACHE=/tmp/ko-cache
+  ko prefetch --platform=linux/amd64,linux/arm64 ./cmd/app`,
+		ValidArgsFunction: completeImportPaths,
+		Run: func(_ *cobra.Command, args []string) {
+			ctx := createCancellableContext()
+			if configErr != nil {
+				log.Fatal(configErr)
+			}
+			if baseCache == nil {
+				log.Fatal("KO_CACHE must be set to the directory to cache base images in")
+			}
+			if err := useGoCaches(bo); err != nil {
+				log.Fatal(err)
+			}
+			if err := useModuleEnv(bo); err != nil {
+				log.Fatal(err)
+			}
+			platform, err := platformSpec(bo)
+			if err != nil {
+				log.Fatal(err)
+			}
+			base, err := defaultBase(bo)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			g, gctx := errgroup.WithContext(ctx)
+			for _, ref := range baseImages(base) {
+				ref := ref
+				g.Go(func() error {
+					log.Printf("Prefetching base %s", ref)
+					base, err := fetchBase(gctx, ref, platform)
+					if err != nil {
+						return fmt.Errorf("fetching %s: %v", ref, err)
+					}
+					if err := warmResult(base, platform); err != nil {
+						return fmt.Errorf("caching %s: %v", ref, err)
+					}
+					return nil
+				})
+			}
+			g.Go(func() error {
+				return downloadModules(gctx, args)
+			})
+			if err := g.Wait(); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	options.AddBuildOptions(prefetch, bo)
+	topLevel.AddCommand(prefetch)
+}
+
+// baseImages returns the given default and the overridden base images,
+// without duplicates.
+func baseImages(defaultBase name.Reference) []name.Reference {
+	seen := map[string]bool{defaultBase.String(): true}
+	refs := []name.Reference{defaultBase}
+	for _, ref := range baseImageOverrides {
+		if !seen[ref.String()] {
+			seen[ref.String()] = true
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// warmResult reads every layer of the images in br that match platform,
+// which populates the cache they are read through.
+func warmResult(br build.Result, platform string) error {
+	switch r := br.(type) {
+	case v1.ImageIndex:
+		im, err := r.IndexManifest()
+		if err != nil {
+			return err
+		}
+		pm, err := build.ParsePlatformSpec(platform)
+		if err != nil {
+			return err
+		}
+		for _, desc := range im.Manifests {
+			if !pm.Matches(desc.Platform) {
+				continue
+			}
+			img, err := r.Image(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := warmImage(img); err != nil {
+				return err
+			}
+		}
+		return nil
+	case v1.Image:
+		return warmImage(r)
+	default:
+		return fmt.Errorf("result of type %T is not an image or index", br)
+	}
+}
+
+func warmImage(img v1.Image) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		rc, err := l.Compressed()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(ioutil.Discard, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadModules downloads the modules needed to build importpaths, or
+// every module in the build list if there are none.
+func downloadModules(ctx context.Context, importpaths []string) error {
+	args := []string{"mod", "download"}
+	if len(importpaths) != 0 {
+		args = append([]string{"list", "-deps", "-f", "{{.ImportPath}}"}, importpaths...)
+	}
+	log.Printf("Running go %s", strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Env = os.Environ()
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("downloading modules: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestWarmCachingIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-cache")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	c := cache.NewFilesystemCache(dir)
+
+	idx, err := random.Index(1024, 2, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	// random.Index doesn't set platforms, so select everything.
+	if err := warmResult(&cachingIndex{inner: idx, c: c}, "all"); err != nil {
+		t.Fatalf("warmResult() = %v", err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	for _, desc := range im.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("Image() = %v", err)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Layers() = %v", err)
+		}
+		for _, l := range layers {
+			h, err := l.Digest()
+			if err != nil {
+				t.Fatalf("Digest() = %v", err)
+			}
+			if _, err := c.Get(h); err != nil {
+				t.Errorf("cache.Get(%s) = %v", h, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/logs"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/internal/humanize"
+	"github.com/google/ko/pkg/publish"
+)
+
+// progress shows builds and pushes on a terminal, when --progress asks for
+// it and stderr is one.
+var progress *progressView
+
+// startProgress starts showing progress on stderr, unless mode (auto, tty or
+// plain) says to log as usual.
+func startProgress(mode string) error {
+	switch mode {
+	case "", "auto":
+		if !stderrIsTTY() {
+			return nil
+		}
+	case "tty":
+	case "plain":
+		return nil
+	default:
+		return fmt.Errorf("--progress must be auto, tty or plain, got %q", mode)
+	}
+	if progress != nil {
+		return nil
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	if width < 20 {
+		width = 80
+	}
+	progress = newProgressView(os.Stderr, width)
+	// Log lines are printed above the view instead of through it.
+	log.SetOutput(progress)
+	logs.Warn.SetOutput(progress)
+	logs.Progress.SetOutput(progress)
+	progress.start(100 * time.Millisecond)
+	return nil
+}
+
+// stopProgress draws the final state of the builds and pushes, if progress is
+// being shown, and goes back to logging as usual.
+func stopProgress() {
+	if progress == nil {
+		return
+	}
+	progress.stop()
+	log.SetOutput(os.Stderr)
+	logs.Warn.SetOutput(os.Stderr)
+	logs.Progress.SetOutput(os.Stderr)
+	progress = nil
+}
+
+// progressHooks are the publish hooks that update progress, if it is being
+// shown.
+func progressHooks() publish.Hooks {
+	if progress == nil {
+		return publish.Hooks{}
+	}
+	return progress.publishHooks()
+}
+
+// The states of an import path in the view.
+const (
+	stateBuilding = "building"
+	stateBuilt    = "built"
+	statePushing  = "pushing"
+	statePushed   = "pushed"
+	stateFailed   = "failed"
+)
+
+// maxProgressLines is how many import paths the view shows at most, so that
+// it fits on a screen. Finished ones make way for those in progress.
+const maxProgressLines = 20
+
+// progressView draws a line for each import path being built and pushed, in
+// place, like docker buildx does, rather than interleaving their logs.
+type progressView struct {
+	w     io.Writer
+	width int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	started time.Time
+	entries map[string]*progressEntry
+	order   []string
+	// drawn is how many lines the view took up when it was last drawn.
+	drawn int
+	frame int
+	dirty bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type progressEntry struct {
+	state string
+	// phases is the phase of each platform being built.
+	phases          map[string]string
+	complete, total int64
+	started, ended  time.Time
+}
+
+func newProgressView(w io.Writer, width int) *progressView {
+	return &progressView{
+		w:       w,
+		width:   width,
+		now:     time.Now,
+		started: time.Now(),
+		entries: map[string]*progressEntry{},
+		done:    make(chan struct{}),
+	}
+}
+
+// start redraws the view every interval while anything is in progress.
+func (v *progressView) start(interval time.Duration) {
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-v.done:
+				return
+			case <-t.C:
+				v.mu.Lock()
+				if v.dirty || v.active() > 0 {
+					v.frame++
+					v.redraw()
+				}
+				v.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// stop leaves the view on the screen. Commands print their output once
+// everything is done, so the view isn't erased again: if it changed since,
+// it is drawn anew below.
+func (v *progressView) stop() {
+	close(v.done)
+	v.wg.Wait()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.dirty {
+		v.drawn = 0
+		v.draw()
+	}
+	v.drawn = 0
+}
+
+// Write implements io.Writer, for logging above the view.
+func (v *progressView) Write(p []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.clear()
+	n, err := v.w.Write(p)
+	v.draw()
+	return n, err
+}
+
+// clear erases the view, leaving the cursor where it started.
+func (v *progressView) clear() {
+	if v.drawn > 0 {
+		// Move up to the first line of the view and erase to the end of
+		// the screen.
+		fmt.Fprintf(v.w, "\x1b[%dA\r\x1b[J", v.drawn)
+		v.drawn = 0
+	}
+}
+
+func (v *progressView) draw() {
+	lines := v.render()
+	if len(lines) != 0 {
+		io.WriteString(v.w, strings.Join(lines, "\n")+"\n")
+	}
+	v.drawn = len(lines)
+	v.dirty = false
+}
+
+func (v *progressView) redraw() {
+	v.clear()
+	v.draw()
+}
+
+// active is how many import paths are being built or pushed.
+func (v *progressView) active() int {
+	n := 0
+	for _, e := range v.entries {
+		if e.state == stateBuilding || e.state == statePushing {
+			n++
+		}
+	}
+	return n
+}
+
+// update applies f to the entry of the import path ip.
+func (v *progressView) update(ip string, f func(*progressEntry)) {
+	ip = strings.TrimPrefix(ip, build.StrictScheme)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	e, ok := v.entries[ip]
+	if !ok {
+		e = &progressEntry{}
+		v.entries[ip] = e
+		v.order = append(v.order, ip)
+	}
+	f(e)
+	v.dirty = true
+	if v.active() == 0 {
+		// Draw the final state right away, before the command prints
+		// anything to stdout below it.
+		v.redraw()
+	}
+}
+
+func (v *progressView) buildHooks() build.Hooks {
+	return build.Hooks{
+		OnBuildStart: func(_ context.Context, s build.BuildStart) {
+			v.update(s.ImportPath, func(e *progressEntry) {
+				*e = progressEntry{state: stateBuilding, phases: map[string]string{}, started: s.Started}
+			})
+		},
+		OnBuildPhase: func(_ context.Context, p build.BuildPhase) {
+			v.update(p.ImportPath, func(e *progressEntry) {
+				if e.phases == nil {
+					e.phases = map[string]string{}
+				}
+				e.phases[p.Platform] = p.Phase
+			})
+		},
+		OnBuildEnd: func(_ context.Context, b build.BuildEnd) {
+			v.update(b.ImportPath, func(e *progressEntry) {
+				e.state, e.ended = stateBuilt, v.now()
+				if b.Err != nil {
+					e.state = stateFailed
+				}
+			})
+		},
+	}
+}
+
+func (v *progressView) publishHooks() publish.Hooks {
+	return publish.Hooks{
+		OnPublishStart: func(_ context.Context, s publish.PublishStart) {
+			v.update(s.ImportPath, func(e *progressEntry) {
+				e.state = statePushing
+				e.complete, e.total = 0, 0
+				if e.started.IsZero() {
+					e.started = s.Started
+				}
+			})
+		},
+		OnPublishProgress: func(_ context.Context, p publish.PublishProgress) {
+			v.update(p.ImportPath, func(e *progressEntry) {
+				e.complete, e.total = p.Complete, p.Total
+			})
+		},
+		OnPublishEnd: func(_ context.Context, p publish.PublishEnd) {
+			v.update(p.ImportPath, func(e *progressEntry) {
+				e.state, e.ended = statePushed, v.now()
+				if p.Err != nil {
+					e.state = stateFailed
+				}
+			})
+		},
+	}
+}
+
+// render returns the lines of the view: a summary, and then a line for each
+// import path, in the order they started.
+func (v *progressView) render() []string {
+	if len(v.order) == 0 {
+		return nil
+	}
+	shown := v.order
+	if len(shown) > maxProgressLines {
+		// Show the import paths in progress and the failures first, then
+		// those that finished most recently.
+		shown = append([]string(nil), v.order...)
+		rank := func(ip string) int {
+			switch v.entries[ip].state {
+			case stateBuilding, statePushing, stateFailed:
+				return 0
+			}
+			return 1
+		}
+		sort.SliceStable(shown, func(i, j int) bool {
+			ri, rj := rank(shown[i]), rank(shown[j])
+			if ri != rj {
+				return ri < rj
+			}
+			return ri == 1 && v.entries[shown[i]].ended.After(v.entries[shown[j]].ended)
+		})
+		shown = shown[:maxProgressLines]
+		keep := map[string]bool{}
+		for _, ip := range shown {
+			keep[ip] = true
+		}
+		shown = shown[:0]
+		for _, ip := range v.order {
+			if keep[ip] {
+				shown = append(shown, ip)
+			}
+		}
+	}
+
+	pad := 0
+	for _, ip := range shown {
+		if len(ip) > pad {
+			pad = len(ip)
+		}
+	}
+	if pad > v.width/2 {
+		pad = v.width / 2
+	}
+
+	active := v.active()
+	verb := "Building"
+	if active == 0 {
+		verb = "Finished"
+	}
+	lines := []string{fmt.Sprintf("[+] %s %.1fs (%d/%d)", verb, v.now().Sub(v.started).Seconds(), len(v.order)-active, len(v.order))}
+	for _, ip := range shown {
+		name := ip
+		if len(name) > pad {
+			// The end of an import path tells them apart best.
+			name = "..." + name[len(name)-pad+3:]
+		}
+		lines = append(lines, v.truncate(fmt.Sprintf(" => %-*s  %s", pad, name, v.status(v.entries[ip]))))
+	}
+	if hidden := len(v.order) - len(shown); hidden > 0 {
+		lines = append(lines, fmt.Sprintf(" => ... and %d more", hidden))
+	}
+	return lines
+}
+
+var spinner = []string{"|", "/", "-", "\\"}
+
+// status describes what is happening to an import path.
+func (v *progressView) status(e *progressEntry) string {
+	elapsed := func(end time.Time) string {
+		return fmt.Sprintf("%.1fs", end.Sub(e.started).Seconds())
+	}
+	switch e.state {
+	case stateBuilding:
+		phase := "building"
+		compiling := 0
+		for _, p := range e.phases {
+			if p == build.PhaseCompile {
+				compiling++
+			}
+		}
+		if compiling == 0 && len(e.phases) != 0 {
+			phase = "compressing"
+		}
+		if len(e.phases) > 1 {
+			phase += fmt.Sprintf(" (%d platforms)", len(e.phases))
+		}
+		return fmt.Sprintf("%s %s %s", spinner[v.frame%len(spinner)], phase, elapsed(v.now()))
+	case statePushing:
+		if e.total == 0 {
+			return fmt.Sprintf("%s pushing %s", spinner[v.frame%len(spinner)], elapsed(v.now()))
+		}
+		return fmt.Sprintf("%s pushing %s %s / %s", spinner[v.frame%len(spinner)], progressBar(e.complete, e.total, 20), humanize.Bytes(e.complete), humanize.Bytes(e.total))
+	case stateBuilt:
+		return "DONE " + elapsed(e.ended)
+	case statePushed:
+		return "PUSHED " + elapsed(e.ended)
+	default:
+		return "FAILED " + elapsed(e.ended)
+	}
+}
+
+// truncate cuts line to fit the width of the terminal, so that it doesn't
+// wrap and throw off how many lines the view takes up.
+func (v *progressView) truncate(line string) string {
+	if len(line) >= v.width {
+		return line[:v.width-1]
+	}
+	return line
+}
+
+// progressBar draws complete out of total as a bar of width characters.
+func progressBar(complete, total int64, width int) string {
+	filled := int(complete * int64(width) / total)
+	if filled > width {
+		filled = width
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	return "[" + bar + "]"
+}
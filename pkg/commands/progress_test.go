@@ -0,0 +1,116 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+func TestProgressView(t *testing.T) {
+	var buf bytes.Buffer
+	v := newProgressView(&buf, 80)
+	start := time.Unix(1000, 0)
+	v.started = start
+	v.now = func() time.Time { return start.Add(2 * time.Second) }
+	ctx := context.Background()
+
+	bh, ph := v.buildHooks(), v.publishHooks()
+	bh.OnBuildStart(ctx, build.BuildStart{ImportPath: build.StrictScheme + "example.com/app", Started: start})
+	bh.OnBuildPhase(ctx, build.BuildPhase{ImportPath: "example.com/app", Platform: "linux/amd64", Phase: build.PhaseLayers})
+	bh.OnBuildStart(ctx, build.BuildStart{ImportPath: "example.com/broken", Started: start})
+	bh.OnBuildEnd(ctx, build.BuildEnd{BuildRecord: build.BuildRecord{ImportPath: "example.com/broken"}, Err: errors.New("boom")})
+	bh.OnBuildStart(ctx, build.BuildStart{ImportPath: "example.com/pushed", Started: start})
+	bh.OnBuildEnd(ctx, build.BuildEnd{BuildRecord: build.BuildRecord{ImportPath: "example.com/pushed"}})
+	ph.OnPublishStart(ctx, publish.PublishStart{ImportPath: "example.com/pushed", Started: start})
+	ph.OnPublishProgress(ctx, publish.PublishProgress{ImportPath: "example.com/pushed", Complete: 512, Total: 2048})
+
+	want := []string{
+		"[+] Building 2.0s (1/3)",
+		" => example.com/app     | compressing 2.0s",
+		" => example.com/broken  FAILED 2.0s",
+		" => example.com/pushed  | pushing [=====>              ] 512 B / 2.0 KiB",
+	}
+	if diff := cmp.Diff(want, v.render()); diff != "" {
+		t.Errorf("render() (-want +got) = %s", diff)
+	}
+
+	// Log lines go above the view, which is redrawn below them.
+	v.redraw()
+	buf.Reset()
+	if _, err := v.Write([]byte("hello\n")); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if got, want := buf.String(), "\x1b[4A\r\x1b[Jhello\n[+] Building"; !strings.HasPrefix(got, want) {
+		t.Errorf("Write() wrote %q, want prefix %q", got, want)
+	}
+
+	bh.OnBuildEnd(ctx, build.BuildEnd{BuildRecord: build.BuildRecord{ImportPath: "example.com/app"}})
+	ph.OnPublishEnd(ctx, publish.PublishEnd{PublishStart: publish.PublishStart{ImportPath: "example.com/pushed"}})
+	want = []string{
+		"[+] Finished 2.0s (3/3)",
+		" => example.com/app     DONE 2.0s",
+		" => example.com/broken  FAILED 2.0s",
+		" => example.com/pushed  PUSHED 2.0s",
+	}
+	if diff := cmp.Diff(want, v.render()); diff != "" {
+		t.Errorf("render() (-want +got) = %s", diff)
+	}
+}
+
+func TestProgressViewFitsScreen(t *testing.T) {
+	v := newProgressView(&bytes.Buffer{}, 40)
+	h := v.buildHooks()
+	for i := 0; i < maxProgressLines+5; i++ {
+		ip := "example.com/a/very/long/import/path/" + string(rune('a'+i))
+		h.OnBuildStart(context.Background(), build.BuildStart{ImportPath: ip, Started: time.Now()})
+		if i != 0 {
+			h.OnBuildEnd(context.Background(), build.BuildEnd{BuildRecord: build.BuildRecord{ImportPath: ip}})
+		}
+	}
+	lines := v.render()
+	if got, want := len(lines), maxProgressLines+2; got != want {
+		t.Fatalf("render() = %d lines, want %d", got, want)
+	}
+	// The build in progress is still shown.
+	if !strings.Contains(lines[1], "path/a") {
+		t.Errorf("render()[1] = %q, want the build in progress", lines[1])
+	}
+	for _, l := range lines {
+		if len(l) >= 40 {
+			t.Errorf("line %q doesn't fit in 40 columns", l)
+		}
+	}
+}
+
+func TestStartProgress(t *testing.T) {
+	if err := startProgress("fancy"); err == nil {
+		t.Error("startProgress(fancy) = nil, want error")
+	}
+	if err := startProgress("plain"); err != nil {
+		t.Fatalf("startProgress(plain) = %v", err)
+	}
+	if progress != nil {
+		t.Error("startProgress(plain) started showing progress")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// provenanceRecord describes how an image was built and published, in the
+// file written by --provenance.
+type provenanceRecord struct {
+	buildPredicate
+	// Reference is where the image was published, and Digest is its digest.
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+	// BaseReference is the base image as it was configured, which
+	// BaseDigest pins.
+	BaseReference string `json:"baseReference,omitempty"`
+	// Started is when the build (or, if it wasn't recorded, the publish)
+	// started. BuildSeconds is how long the build took, including any wait
+	// to start it, and PublishSeconds how long publishing took.
+	Started        time.Time `json:"started"`
+	BuildSeconds   float64   `json:"buildSeconds,omitempty"`
+	PublishSeconds float64   `json:"publishSeconds"`
+}
+
+// provenance is the JSON document written by --provenance.
+type provenance struct {
+	Artifacts []provenanceRecord `json:"artifacts"`
+}
+
+// provenanceRecorder is a publish.Interface that records how each image
+// published through it was built.
+type provenanceRecorder struct {
+	buildDescriber
+	inner publish.Interface
+	// builds, if set, records how long builds took.
+	builds *build.Recorder
+
+	m       sync.Mutex
+	records map[string]provenanceRecord
+}
+
+func newProvenanceRecorder(inner publish.Interface, builds *build.Recorder, flags map[string]string) *provenanceRecorder {
+	return &provenanceRecorder{
+		buildDescriber: buildDescriber{flags: flags, modules: listModules},
+		inner:          inner,
+		builds:         builds,
+		records:        map[string]provenanceRecord{},
+	}
+}
+
+// Publish implements publish.Interface
+func (r *provenanceRecorder) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	start := time.Now()
+	ref, err := r.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	ip := strings.TrimPrefix(s, build.StrictScheme)
+	r.m.Lock()
+	_, seen := r.records[ip]
+	r.m.Unlock()
+	if seen {
+		// Publishing the same import path again is cached, so the first
+		// record has the timings.
+		return ref, nil
+	}
+
+	rec := provenanceRecord{
+		Reference:      ref.String(),
+		Started:        start,
+		PublishSeconds: time.Since(start).Seconds(),
+	}
+	p, err := r.describe(ctx, br, s)
+	if err != nil {
+		return nil, fmt.Errorf("recording provenance of %s: %v", s, err)
+	}
+	rec.buildPredicate = *p
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+	rec.Digest = h.String()
+	if base, ok := baseRefs.Load(ip); ok {
+		rec.BaseReference = base.(string)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.records[ip] = rec
+	return ref, nil
+}
+
+// Close implements publish.Interface
+func (r *provenanceRecorder) Close() error {
+	return r.inner.Close()
+}
+
+// Provenance returns the records so far, sorted by import path, with the
+// timings of their builds.
+func (r *provenanceRecorder) Provenance() *provenance {
+	builds := map[string]build.BuildRecord{}
+	if r.builds != nil {
+		for _, b := range r.builds.Builds {
+			builds[strings.TrimPrefix(b.ImportPath, build.StrictScheme)] = b
+		}
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	p := &provenance{Artifacts: make([]provenanceRecord, 0, len(r.records))}
+	for ip, rec := range r.records {
+		if b, ok := builds[ip]; ok {
+			rec.Started = b.Started
+			rec.BuildSeconds = b.Seconds
+		}
+		p.Artifacts = append(p.Artifacts, rec)
+	}
+	sort.Slice(p.Artifacts, func(i, j int) bool {
+		return p.Artifacts[i].ImportPath < p.Artifacts[j].ImportPath
+	})
+	return p
+}
+
+func writeProvenance(path string, p *provenance) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-provenance")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	baseRefs.Store("github.com/foo/bar", "gcr.io/distroless/static:nonroot")
+	defer baseRefs.Delete("github.com/foo/bar")
+	started := time.Unix(1000, 0).UTC()
+	builds := &build.Recorder{Builds: []build.BuildRecord{{
+		ImportPath: build.StrictScheme + "github.com/foo/bar",
+		Started:    started,
+		Seconds:    12.5,
+	}}}
+
+	inner := nopPublisher{repoName: "gcr.io/project", namer: options.MakeNamer(&options.PublishOptions{Bare: true})}
+	r := newProvenanceRecorder(inner, builds, map[string]string{"platform": "linux/arm64"})
+	mods := []moduleChecksum{{Path: "github.com/google/go-cmp", Version: "v0.5.4", Sum: "h1:abc="}}
+	r.modules = func(context.Context, string) ([]moduleChecksum, error) { return mods, nil }
+	for _, ip := range []string{"github.com/foo/bar", "github.com/foo/baz", "github.com/foo/bar"} {
+		if _, err := r.Publish(context.Background(), img, build.StrictScheme+ip); err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+	}
+
+	file := filepath.Join(dir, "provenance.json")
+	if err := writeProvenance(file, r.Provenance()); err != nil {
+		t.Fatalf("writeProvenance() = %v", err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	var got provenance
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	if len(got.Artifacts) != 2 {
+		t.Fatalf("got %d artifacts, want 2: %s", len(got.Artifacts), b)
+	}
+	bar, baz := got.Artifacts[0], got.Artifacts[1]
+	if bar.ImportPath != "github.com/foo/bar" || baz.ImportPath != "github.com/foo/baz" {
+		t.Errorf("import paths = %q, %q, want them sorted", bar.ImportPath, baz.ImportPath)
+	}
+	if want := "gcr.io/project@" + h.String(); bar.Reference != want || bar.Digest != h.String() {
+		t.Errorf("reference, digest = %q, %q, want %q, %q", bar.Reference, bar.Digest, want, h)
+	}
+	if bar.BaseReference != "gcr.io/distroless/static:nonroot" || baz.BaseReference != "" {
+		t.Errorf("base references = %q, %q", bar.BaseReference, baz.BaseReference)
+	}
+	if !bar.Started.Equal(started) || bar.BuildSeconds != 12.5 {
+		t.Errorf("timings = %v, %v, want the build's", bar.Started, bar.BuildSeconds)
+	}
+	if baz.Started.IsZero() || baz.BuildSeconds != 0 {
+		t.Errorf("timings = %v, %v, want the publish's", baz.Started, baz.BuildSeconds)
+	}
+	if bar.GoVersion == "" || bar.KoVersion == "" {
+		t.Errorf("versions = %q, %q, want both", bar.GoVersion, bar.KoVersion)
+	}
+	if diff := cmp.Diff(map[string]string{"platform": "linux/arm64"}, bar.Flags); diff != "" {
+		t.Errorf("flags (-want +got) = %v", diff)
+	}
+	if diff := cmp.Diff(mods, bar.Modules); diff != "" {
+		t.Errorf("modules (-want +got) = %v", diff)
+	}
+}
@@ -17,7 +17,9 @@ package commands
 import (
 	"fmt"
 	"log"
+	"os"
 
+	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/spf13/cobra"
 )
@@ -26,11 +28,17 @@ import (
 func addPublish(topLevel *cobra.Command) {
 	po := &options.PublishOptions{}
 	bo := &options.BuildOptions{}
+	to := &options.TektonOptions{}
+	gho := &options.GitHubOptions{}
+	eo := &options.ExportOptions{}
+	pvo := &options.ProvenanceOptions{}
+	smo := &options.SummaryOptions{}
 
 	publish := &cobra.Command{
-		Use:   "publish IMPORTPATH...",
-		Short: "Build and publish container images from the given importpaths.",
-		Long:  `This sub-command builds the provided import paths into Go binaries, containerizes them, and publishes them.`,
+		Use:     "publish IMPORTPATH...",
+		Aliases: []string{"build"},
+		Short:   "Build and publish container images from the given importpaths.",
+		Long:    `This sub-command builds the provided import paths into Go binaries, containerizes them, and publishes them.`,
 		Example: `
   # Build and publish import path references to a Docker
   # Registry as:
@@ -55,29 +63,156 @@ func addPublish(topLevel *cobra.Command) {
   # daemon as:
   #   ko.local/<import path>
   # This always preserves import paths.
-  ko publish --local github.com/foo/bar/cmd/baz github.com/foo/bar/cmd/blah`,
-		Args: cobra.MinimumNArgs(1),
-		Run: func(_ *cobra.Command, args []string) {
+  ko publish --local github.com/foo/bar/cmd/baz github.com/foo/bar/cmd/blah
+
+  # Build and publish newline-delimited import paths read from stdin,
+  # printing one reference per line in the same order.
+  cat importpaths.txt | ko publish -
+
+  # Use ko as a Tekton task step, passing the published reference
+  # to later tasks as a result.
+  ko publish ./cmd/app --tekton-result=./cmd/app=$(results.image.path)
+
+  # Unpack the filesystem of the image into ./rootfs/blah, to
+  # inspect it or chroot into it, without pushing it anywhere.
+  ko publish --push=false -B -o ./rootfs ./cmd/blah
+
+  # Cross-compile binaries into ./bin exactly as they would be
+  # built for images, without building any images.
+  ko build --local-binary --platform=linux/amd64,darwin/arm64 -o ./bin ./cmd/blah
+
+  # In GitHub Actions, set step outputs and a job summary of the
+  # published references.
+  ko publish ./cmd/app --github-outputs`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeImportPaths,
+		Run: func(cmd *cobra.Command, args []string) {
 			ctx := createCancellableContext()
-			builder, err := makeBuilder(ctx, bo)
+			if eo.LocalBinary {
+				if eo.Output == "" {
+					log.Fatal("--local-binary needs a directory to write binaries to, with -o")
+				}
+				if pvo.Provenance != "" {
+					log.Fatal("--provenance records published images, so it cannot be used with --local-binary")
+				}
+				if po.SkipUnchanged {
+					log.Fatal("--skip-unchanged reuses published images, so it cannot be used with --local-binary")
+				}
+				bo.BinaryArtifacts = true
+			} else if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			innerBuilder, err := makeUncachedBuilder(ctx, bo)
 			if err != nil {
 				log.Fatalf("error creating builder: %v", err)
 			}
-			publisher, err := makePublisher(po)
+			// Record builds beneath the cache, so each is recorded once.
+			var builds *build.Recorder
+			if pvo.Provenance != "" || smo.Summary {
+				builds = &build.Recorder{Builder: innerBuilder}
+				innerBuilder = builds
+			}
+			builder, err := cacheBuilder(bo, innerBuilder)
+			if err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			importpaths, err := expandImportPaths(args)
+			if err != nil {
+				log.Fatalf("error reading import paths: %v", err)
+			}
+			for i, importpath := range importpaths {
+				importpaths[i], err = qualifyImportPath(importpath)
+				if err != nil {
+					log.Fatalf("error qualifying %q: %v", importpath, err)
+				}
+			}
+			if eo.LocalBinary {
+				files, err := writeLocalBinaries(ctx, importpaths, builder, eo.Output)
+				if err != nil {
+					log.Fatalf("failed to build binaries: %s", withHint(err))
+				}
+				for _, importpath := range importpaths {
+					for _, file := range files[importpath] {
+						fmt.Println(file)
+					}
+				}
+				return
+			}
+			po.WorkDir = bo.WorkDir
+			publisher, err := makeExportingPublisher(po, eo)
 			if err != nil {
 				log.Fatalf("error creating publisher: %v", err)
 			}
+			var prov *provenanceRecorder
+			if pvo.Provenance != "" {
+				prov = newProvenanceRecorder(publisher, builds, setFlags(cmd))
+				publisher = prov
+			}
+			var recorder *imageRecorder
+			if smo.Summary {
+				recorder = newImageRecorder(publisher, po.Tags)
+				publisher = recorder
+			}
 			defer publisher.Close()
-			images, err := publishImages(ctx, args, publisher, builder)
+			images, err := publishImages(ctx, importpaths, publisher, builder)
 			if err != nil {
-				log.Fatalf("failed to publish images: %v", err)
+				log.Fatalf("failed to publish images: %s", withHint(err))
+			}
+			if prov != nil {
+				if err := writeProvenance(pvo.Provenance, prov.Provenance()); err != nil {
+					log.Fatalf("error writing provenance: %v", err)
+				}
+			}
+			// Print references in the order they were requested so that
+			// the output can be zipped up with the input by scripts.
+			for _, importpath := range importpaths {
+				fmt.Println(images[importpath])
 			}
-			for _, img := range images {
-				fmt.Println(img)
+			if smo.Summary {
+				if err := writeSummary(os.Stderr, recorder.Manifest(builds.Builds...)); err != nil {
+					log.Fatalf("error writing summary: %v", err)
+				}
+			}
+			refs := make(map[string]string, len(images))
+			for importpath, ref := range images {
+				refs[importpath] = ref.String()
+			}
+			if len(to.Results) != 0 {
+				if err := writeTektonResults(to, refs); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if gho.Outputs {
+				if err := writeGitHubOutputs(refs); err != nil {
+					log.Fatal(err)
+				}
 			}
 		},
 	}
 	options.AddPublishArg(publish, po)
 	options.AddBuildOptions(publish, bo)
+	options.AddTektonArg(publish, to)
+	options.AddGitHubArg(publish, gho)
+	options.AddExportArg(publish, eo)
+	options.AddProvenanceArg(publish, pvo)
+	options.AddSummaryArg(publish, smo)
 	topLevel.AddCommand(publish)
 }
+
+// expandImportPaths replaces any "-" argument with the newline-delimited
+// import paths read from stdin.
+func expandImportPaths(args []string) ([]string, error) {
+	var importpaths []string
+	for _, arg := range args {
+		if arg != "-" {
+			importpaths = append(importpaths, arg)
+			continue
+		}
+		ips, err := readImportPaths(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		importpaths = append(importpaths, ips...)
+	}
+	return importpaths, nil
+}
@@ -15,15 +15,18 @@
 package commands
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	gb "go/build"
+	"io"
 	"strings"
+	"sync"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/publish"
-
+	"golang.org/x/sync/errgroup"
 	"golang.org/x/tools/go/packages"
 )
 
@@ -41,33 +44,76 @@ func qualifyLocalImport(importpath string) (string, error) {
 	return pkgs[0].PkgPath, nil
 }
 
+// qualifyImportPath turns a relative import path into a fully qualified one
+// and ensures it carries the ko:// prefix.
+func qualifyImportPath(importpath string) (string, error) {
+	if gb.IsLocalImport(importpath) {
+		var err error
+		importpath, err = qualifyLocalImport(importpath)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !strings.HasPrefix(importpath, build.StrictScheme) {
+		importpath = build.StrictScheme + importpath
+	}
+	return importpath, nil
+}
+
 func publishImages(ctx context.Context, importpaths []string, pub publish.Interface, b build.Interface) (map[string]name.Reference, error) {
+	var m sync.Mutex
 	imgs := make(map[string]name.Reference)
+
+	// Qualify and check every import path before building any of them, so
+	// that a bad one doesn't leave builds running behind the error.
+	qualified := make([]string, 0, len(importpaths))
 	for _, importpath := range importpaths {
-		if gb.IsLocalImport(importpath) {
-			var err error
-			importpath, err = qualifyLocalImport(importpath)
-			if err != nil {
-				return nil, err
-			}
-		}
-		if !strings.HasPrefix(importpath, build.StrictScheme) {
-			importpath = build.StrictScheme + importpath
+		importpath, err := qualifyImportPath(importpath)
+		if err != nil {
+			return nil, err
 		}
 
 		if err := b.IsSupportedReference(importpath); err != nil {
-			return nil, fmt.Errorf("importpath %q is not supported: %v", importpath, err)
+			return nil, fmt.Errorf("importpath %q is not supported: %w", importpath, err)
 		}
+		qualified = append(qualified, importpath)
+	}
 
-		img, err := b.Build(ctx, importpath)
-		if err != nil {
-			return nil, fmt.Errorf("error building %q: %v", importpath, err)
-		}
-		ref, err := pub.Publish(ctx, img, importpath)
-		if err != nil {
-			return nil, fmt.Errorf("error publishing %s: %v", importpath, err)
-		}
-		imgs[importpath] = ref
+	g, ctx := errgroup.WithContext(ctx)
+	for _, importpath := range qualified {
+		importpath := importpath
+		g.Go(func() error {
+			img, err := b.Build(ctx, importpath)
+			if err != nil {
+				return fmt.Errorf("error building %q: %w", importpath, err)
+			}
+			ref, err := pub.Publish(ctx, img, importpath)
+			if err != nil {
+				return fmt.Errorf("error publishing %s: %w", importpath, err)
+			}
+			m.Lock()
+			defer m.Unlock()
+			imgs[importpath] = ref
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 	return imgs, nil
 }
+
+// readImportPaths reads newline-delimited import paths from r, skipping
+// blank lines and lines starting with '#'.
+func readImportPaths(r io.Reader) ([]string, error) {
+	var importpaths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		importpaths = append(importpaths, line)
+	}
+	return importpaths, scanner.Err()
+}
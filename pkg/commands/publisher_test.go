@@ -0,0 +1,94 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestPublishImages(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	imgs, err := publishImages(context.Background(),
+		[]string{fooRef, build.StrictScheme + barRef},
+		kotesting.NewFixedPublish(base, testHashes),
+		testBuilder)
+	if err != nil {
+		t.Fatalf("publishImages() = %v", err)
+	}
+
+	for _, ref := range []string{fooRef, barRef} {
+		got, ok := imgs[build.StrictScheme+ref]
+		if !ok {
+			t.Fatalf("publishImages() missing %q", ref)
+		}
+		if want := kotesting.ComputeDigest(base, ref, testHashes[ref]); got.String() != want {
+			t.Errorf("publishImages()[%q] = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+// countingBuild counts the images it builds.
+type countingBuild struct {
+	build.Interface
+	builds int32
+}
+
+func (c *countingBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	atomic.AddInt32(&c.builds, 1)
+	return c.Interface.Build(ctx, s)
+}
+
+func TestPublishImagesUnsupported(t *testing.T) {
+	builder := &countingBuild{Interface: testBuilder}
+	_, err := publishImages(context.Background(),
+		[]string{fooRef, barRef, "github.com/awesomesauce/unsupported"},
+		kotesting.NewFixedPublish(mustRepository("gcr.io/multi-pass"), testHashes),
+		builder)
+	if err == nil {
+		t.Fatal("publishImages() = nil, wanted an error")
+	}
+	if got := atomic.LoadInt32(&builder.builds); got != 0 {
+		t.Errorf("publishImages() built %d images, wanted none", got)
+	}
+}
+
+func TestReadImportPaths(t *testing.T) {
+	input := `
+# A comment.
+github.com/awesomesauce/foo
+
+  ./cmd/bar
+ko://github.com/awesomesauce/baz
+`
+	got, err := readImportPaths(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readImportPaths() = %v", err)
+	}
+	want := []string{
+		"github.com/awesomesauce/foo",
+		"./cmd/bar",
+		"ko://github.com/awesomesauce/baz",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readImportPaths() (-want +got) = %v", diff)
+	}
+}
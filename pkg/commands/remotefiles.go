@@ -0,0 +1,291 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// remoteSource is a -f argument that was fetched into a local file or
+// directory.
+type remoteSource struct {
+	local, remote string
+}
+
+// remoteSources are the -f arguments that were fetched, for naming them in
+// errors.
+type remoteSources []remoteSource
+
+// name returns how the user referred to the local file f.
+func (rs remoteSources) name(f string) string {
+	for _, s := range rs {
+		if f == s.local {
+			return s.remote
+		}
+		if strings.HasPrefix(f, s.local+string(filepath.Separator)) {
+			return s.remote + "/" + filepath.ToSlash(strings.TrimPrefix(f, s.local+string(filepath.Separator)))
+		}
+	}
+	return f
+}
+
+// fetchFilenames fetches the -f arguments that are URLs (http:// or https://)
+// or git references (git::<repository>[//<path>][?ref=<ref>]) into the cache,
+// and returns the local files and directories to resolve in their place.
+func fetchFilenames(ctx context.Context, filenames []string) ([]string, remoteSources, error) {
+	var local []string
+	var sources remoteSources
+	for _, f := range filenames {
+		var (
+			path string
+			err  error
+		)
+		switch {
+		case strings.HasPrefix(f, "git::"):
+			path, err = fetchGit(ctx, f)
+		case strings.HasPrefix(f, "http://"), strings.HasPrefix(f, "https://"):
+			path, err = fetchURL(ctx, f)
+		default:
+			local = append(local, f)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("fetching %s: %v", f, err)
+		}
+		local = append(local, path)
+		sources = append(sources, remoteSource{local: path, remote: f})
+	}
+	return local, sources, nil
+}
+
+// manifestCacheDir is where remote -f arguments are cached: under $KO_CACHE
+// if it is set, or else the user's cache directory.
+func manifestCacheDir() (string, error) {
+	if dir := os.Getenv("KO_CACHE"); dir != "" {
+		return filepath.Join(dir, "manifests"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ko", "manifests"), nil
+}
+
+func cacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedResponse is what is remembered about a fetched URL, to ask the
+// server whether it changed.
+type cachedResponse struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// fetchURL downloads the file at u into the cache, unless the server says the
+// cached copy is current, and returns its path. The cached copy is used if
+// the server can't be reached.
+func fetchURL(ctx context.Context, u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	root, err := manifestCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(root, "http", cacheKey(u))
+	base := path.Base(parsed.Path)
+	if base == "/" || base == "." {
+		base = "manifest.yaml"
+	}
+	file := filepath.Join(dir, base)
+	metaFile := filepath.Join(dir, ".response.json")
+
+	var meta cachedResponse
+	_, statErr := os.Stat(file)
+	cached := statErr == nil
+	if cached {
+		if b, err := ioutil.ReadFile(metaFile); err == nil {
+			json.Unmarshal(b, &meta)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", ua())
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		if cached {
+			log.Printf("Using the cached copy of %s: %v", u, err)
+			return file, nil
+		}
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		return file, nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if err := writeAtomic(file, b); err != nil {
+		return "", err
+	}
+	meta = cachedResponse{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	if mb, err := json.Marshal(meta); err == nil {
+		writeAtomic(metaFile, mb)
+	}
+	return file, nil
+}
+
+// gitSource is a parsed git::<repository>[//<path>][?ref=<ref>] reference,
+// like those of Terraform and kustomize.
+type gitSource struct {
+	Repository string
+	Path       string
+	Ref        string
+}
+
+func parseGitSource(s string) (*gitSource, error) {
+	s = strings.TrimPrefix(s, "git::")
+	src := &gitSource{}
+	if i := strings.LastIndex(s, "?"); i >= 0 {
+		q, err := url.ParseQuery(s[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		for k := range q {
+			if k != "ref" {
+				return nil, fmt.Errorf("unsupported parameter %q, only ref is", k)
+			}
+		}
+		src.Ref = q.Get("ref")
+		// git would take it for an option.
+		if strings.HasPrefix(src.Ref, "-") {
+			return nil, fmt.Errorf("invalid ref %q", src.Ref)
+		}
+		s = s[:i]
+	}
+	// The path follows a // after the scheme's, if any.
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	src.Repository = s
+	if i := strings.Index(s[start:], "//"); i >= 0 {
+		src.Repository = s[:start+i]
+		src.Path = s[start+i+2:]
+	}
+	if src.Repository == "" {
+		return nil, fmt.Errorf("no repository in %q", s)
+	}
+	if src.Path != "" && (path.IsAbs(src.Path) || strings.HasPrefix(path.Clean(src.Path), "..")) {
+		return nil, fmt.Errorf("path %q is outside of the repository", src.Path)
+	}
+	return src, nil
+}
+
+// commitRef matches refs that are commits, which never change.
+var commitRef = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// fetchGit checks out the ref of the git reference s (the default branch if
+// it has none) into the cache, and returns the path to its file or
+// directory. Refs other than commits are fetched again each time, unless the
+// repository can't be reached.
+func fetchGit(ctx context.Context, s string) (string, error) {
+	src, err := parseGitSource(s)
+	if err != nil {
+		return "", err
+	}
+	root, err := manifestCacheDir()
+	if err != nil {
+		return "", err
+	}
+	ref := src.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	dir := filepath.Join(root, "git", cacheKey(src.Repository, ref))
+	target := filepath.Join(dir, filepath.FromSlash(src.Path))
+
+	_, statErr := os.Stat(filepath.Join(dir, ".git"))
+	cached := statErr == nil
+	if cached && commitRef.MatchString(ref) {
+		return target, nil
+	}
+	if !cached {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+		if err := runGit(ctx, dir, "init", "--quiet"); err != nil {
+			return "", err
+		}
+	}
+	if err := runGit(ctx, dir, "fetch", "--quiet", "--depth=1", "--", src.Repository, ref); err != nil {
+		if !cached {
+			os.RemoveAll(dir)
+			return "", err
+		}
+		log.Printf("Using the cached checkout of %s: %v", s, err)
+		return target, nil
+	}
+	if err := runGit(ctx, dir, "checkout", "--quiet", "--force", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(target); err != nil {
+		return "", fmt.Errorf("%s is not in %s at %s", src.Path, src.Repository, ref)
+	}
+	return target, nil
+}
+
+func runGit(ctx context.Context, dir string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// withCache points KO_CACHE at a temporary directory for the duration of a
+// test.
+func withCache(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ko-cache")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	old, ok := os.LookupEnv("KO_CACHE")
+	os.Setenv("KO_CACHE", dir)
+	return func() {
+		if ok {
+			os.Setenv("KO_CACHE", old)
+		} else {
+			os.Unsetenv("KO_CACHE")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFetchURL(t *testing.T) {
+	defer withCache(t)()
+
+	var requests, fresh int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fresh++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, "image: ko://github.com/foo/bar\n")
+	}))
+
+	u := s.URL + "/config/deploy.yaml"
+	for i := 0; i < 2; i++ {
+		got, err := fetchURL(context.Background(), u)
+		if err != nil {
+			t.Fatalf("fetchURL() = %v", err)
+		}
+		if filepath.Base(got) != "deploy.yaml" {
+			t.Errorf("fetchURL() = %s, want a deploy.yaml", got)
+		}
+		b, err := ioutil.ReadFile(got)
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		if string(b) != "image: ko://github.com/foo/bar\n" {
+			t.Errorf("fetched %q", b)
+		}
+	}
+	if requests != 2 || fresh != 1 {
+		t.Errorf("%d requests, %d fresh, want 2 requests, of which 1 fresh", requests, fresh)
+	}
+
+	// The cached copy is used once the server is gone.
+	s.Close()
+	if _, err := fetchURL(context.Background(), u); err != nil {
+		t.Errorf("fetchURL() = %v, want the cached copy", err)
+	}
+	if _, err := fetchURL(context.Background(), s.URL+"/other.yaml"); err == nil {
+		t.Error("fetchURL(uncached) = nil, want error")
+	}
+}
+
+func TestParseGitSource(t *testing.T) {
+	for s, want := range map[string]gitSource{
+		"git::https://github.com/foo/bar.git//config?ref=v1.2": {Repository: "https://github.com/foo/bar.git", Path: "config", Ref: "v1.2"},
+		"git::https://github.com/foo/bar.git":                  {Repository: "https://github.com/foo/bar.git"},
+		"git::git@github.com:foo/bar.git//deploy/app.yaml":     {Repository: "git@github.com:foo/bar.git", Path: "deploy/app.yaml"},
+	} {
+		got, err := parseGitSource(s)
+		if err != nil {
+			t.Fatalf("parseGitSource(%q) = %v", s, err)
+		}
+		if diff := cmp.Diff(want, *got); diff != "" {
+			t.Errorf("parseGitSource(%q) (-want +got) = %s", s, diff)
+		}
+	}
+	for _, s := range []string{
+		"git::https://github.com/foo/bar.git//config?depth=1",
+		"git::https://github.com/foo/bar.git//../etc",
+		"git::https://github.com/foo/bar.git?ref=--upload-pack=touch%20/tmp/pwned",
+		"git::",
+	} {
+		if _, err := parseGitSource(s); err == nil {
+			t.Errorf("parseGitSource(%q) = nil, want error", s)
+		}
+	}
+}
+
+func TestFetchGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	defer withCache(t)()
+
+	repo, err := ioutil.TempDir("", "ko-repo")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(repo)
+	git := func(args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=ko", "-c", "user.email=ko@example.com"}, args...)
+		if err := runGit(context.Background(), repo, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(repo, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filepath.Join(repo, "config", "app.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "--quiet")
+	write("v1\n")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1")
+	write("v2\n")
+	git("commit", "--quiet", "-am", "v2")
+
+	src := "git::file://" + filepath.ToSlash(repo)
+	for ref, want := range map[string]string{"?ref=v1": "v1\n", "": "v2\n"} {
+		dir, err := fetchGit(context.Background(), src+"//config"+ref)
+		if err != nil {
+			t.Fatalf("fetchGit(%s) = %v", ref, err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, "app.yaml"))
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		if string(b) != want {
+			t.Errorf("fetchGit(%s) checked out %q, want %q", ref, b, want)
+		}
+	}
+
+	if _, err := fetchGit(context.Background(), src+"//missing"); err == nil {
+		t.Error("fetchGit(missing path) = nil, want error")
+	}
+
+	// A repository that looks like an option is still a repository, even
+	// when the ref is one git could fetch.
+	pwned := filepath.Join(repo, "pwned")
+	if _, err := fetchGit(context.Background(), "git::--upload-pack=touch "+pwned+";?ref="+filepath.ToSlash(repo)); err == nil {
+		t.Error("fetchGit(--upload-pack) = nil, want error")
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Error("fetchGit(--upload-pack) ran the upload pack")
+	}
+}
+
+func TestRemoteSourcesName(t *testing.T) {
+	rs := remoteSources{{local: filepath.Join("cache", "abc", "config"), remote: "git::https://example.com/repo.git//config"}}
+	for f, want := range map[string]string{
+		filepath.Join("cache", "abc", "config"):             "git::https://example.com/repo.git//config",
+		filepath.Join("cache", "abc", "config", "app.yaml"): "git::https://example.com/repo.git//config/app.yaml",
+		filepath.Join("local", "app.yaml"):                  filepath.Join("local", "app.yaml"),
+	} {
+		if got := rs.name(f); got != want {
+			t.Errorf("name(%s) = %s, want %s", f, got, want)
+		}
+	}
+}
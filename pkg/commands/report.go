@@ -0,0 +1,324 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/resolve"
+)
+
+// reportEntry is the outcome of resolving one import path in one file.
+type reportEntry struct {
+	File       string
+	ImportPath string
+	// Failure is empty if the import path was resolved successfully.
+	Failure string
+	// Diagnostics are the problems that go build reported, if that is what
+	// failed.
+	Diagnostics []build.Diagnostic
+}
+
+// reportEntries flattens what was recorded while resolving, plus the error
+// that stopped the resolve (if any), into a list of entries, with one
+// failure for each import path that failed.
+func reportEntries(s *resolveState, resolveErr error) []reportEntry {
+	var entries []reportEntry
+
+	files := make([]string, 0, len(s.Files))
+	for f := range s.Files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		for _, ip := range s.Files[f] {
+			if _, ok := s.Images[ip]; !ok {
+				// This will be reported as the failure below, if it failed.
+				continue
+			}
+			entries = append(entries, reportEntry{File: f, ImportPath: ip})
+		}
+	}
+
+	if resolveErr == nil {
+		return entries
+	}
+	var file string
+	var fe *fileError
+	if errors.As(resolveErr, &fe) {
+		file = fe.File
+	}
+	failures := importPathErrors(resolveErr)
+	if len(failures) <= 1 {
+		failure := reportEntry{File: file, Failure: resolveErr.Error()}
+		if len(failures) == 1 {
+			failure.ImportPath = failures[0].ImportPath
+			failure.Diagnostics = diagnostics(failures[0])
+		}
+		return append(entries, failure)
+	}
+	for _, ipe := range failures {
+		entries = append(entries, reportEntry{
+			File:        file,
+			ImportPath:  ipe.ImportPath,
+			Failure:     ipe.Error(),
+			Diagnostics: diagnostics(ipe),
+		})
+	}
+	return entries
+}
+
+// importPathErrors returns the failures of each import path in err.
+func importPathErrors(err error) []*resolve.ImportPathError {
+	var errs resolve.Errors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	var ipe *resolve.ImportPathError
+	if errors.As(err, &ipe) {
+		return []*resolve.ImportPathError{ipe}
+	}
+	return nil
+}
+
+// diagnostics returns what go build reported, if err is a failure to
+// compile.
+func diagnostics(err error) []build.Diagnostic {
+	var ce *build.CompileError
+	if errors.As(err, &ce) {
+		return ce.Diagnostics
+	}
+	return nil
+}
+
+// reportFormat infers the format of the report from its filename unless
+// one was given explicitly.
+func reportFormat(path, format string) (string, error) {
+	if format == "" {
+		if strings.HasSuffix(path, ".sarif") || strings.HasSuffix(path, ".sarif.json") {
+			format = "sarif"
+		} else {
+			format = "junit"
+		}
+	}
+	switch format {
+	case "junit", "sarif":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown report format %q, expected junit or sarif", format)
+	}
+}
+
+// writeReport writes a report of entries to path in the given format.
+func writeReport(path, format string, entries []reportEntry) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch format {
+	case "sarif":
+		err = writeSARIF(f, entries)
+	default:
+		err = writeJUnit(f, entries)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+// writeJUnit writes entries as a JUnit XML report, with a test case per
+// import path, grouped by the file that references it.
+func writeJUnit(w io.Writer, entries []reportEntry) error {
+	suite := junitTestSuite{
+		Name:  "ko",
+		Tests: len(entries),
+	}
+	for _, e := range entries {
+		tc := junitTestCase{
+			ClassName: e.File,
+			Name:      e.ImportPath,
+		}
+		if e.Failure != "" {
+			suite.Failures++
+			// The first line is usually a good summary, with the full
+			// compiler output following it.
+			message := strings.SplitN(e.Failure, "\n", 2)[0]
+			tc.Failure = &junitFailure{
+				Message:  message,
+				Contents: e.Failure,
+			}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+const (
+	// sarifRuleID identifies ko's resolution failures in SARIF reports.
+	sarifRuleID = "ko/resolve"
+	// sarifCompileRuleID identifies the problems that go build reported.
+	sarifCompileRuleID = "ko/compile"
+)
+
+// writeSARIF writes the failures in entries as a SARIF 2.1.0 log.
+func writeSARIF(w io.Writer, entries []reportEntry) error {
+	type region struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region *region `json:"region,omitempty"`
+		} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID  string `json:"ruleId"`
+		Level   string `json:"level"`
+		Message struct {
+			Text string `json:"text"`
+		} `json:"message"`
+		Locations  []location        `json:"locations,omitempty"`
+		Properties map[string]string `json:"properties,omitempty"`
+	}
+	type rule struct {
+		ID string `json:"id"`
+	}
+	type driver struct {
+		Name           string `json:"name"`
+		Version        string `json:"version,omitempty"`
+		InformationURI string `json:"informationUri"`
+		Rules          []rule `json:"rules"`
+	}
+	type run struct {
+		Tool struct {
+			Driver driver `json:"driver"`
+		} `json:"tool"`
+		Results []result `json:"results"`
+	}
+	type sarifLog struct {
+		Version string `json:"version"`
+		Schema  string `json:"$schema"`
+		Runs    []run  `json:"runs"`
+	}
+
+	r := run{Results: []result{}}
+	r.Tool.Driver = driver{
+		Name:           "ko",
+		Version:        version(),
+		InformationURI: "https://github.com/google/ko",
+		Rules:          []rule{{ID: sarifRuleID}, {ID: sarifCompileRuleID}},
+	}
+	for _, e := range entries {
+		if e.Failure == "" {
+			continue
+		}
+		if len(e.Diagnostics) != 0 {
+			// Point at the source of each problem that go build
+			// reported, rather than at the yaml.
+			for _, d := range e.Diagnostics {
+				res := result{
+					RuleID: sarifCompileRuleID,
+					Level:  "error",
+				}
+				res.Message.Text = d.Message
+				var loc location
+				loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(d.File)
+				loc.PhysicalLocation.Region = &region{StartLine: d.Line, StartColumn: d.Column}
+				res.Locations = append(res.Locations, loc)
+				res.Properties = map[string]string{"importPath": e.ImportPath}
+				if d.Package != "" {
+					res.Properties["package"] = d.Package
+				}
+				r.Results = append(r.Results, res)
+			}
+			continue
+		}
+		res := result{
+			RuleID: sarifRuleID,
+			Level:  "error",
+		}
+		res.Message.Text = e.Failure
+		if e.File != "" && e.File != "-" {
+			var loc location
+			loc.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(e.File)
+			res.Locations = append(res.Locations, loc)
+		}
+		if e.ImportPath != "" {
+			res.Properties = map[string]string{"importPath": e.ImportPath}
+		}
+		r.Results = append(r.Results, res)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []run{r},
+	})
+}
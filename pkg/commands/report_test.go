@@ -0,0 +1,205 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/resolve"
+)
+
+func TestReportEntries(t *testing.T) {
+	s := &resolveState{
+		Images: map[string]string{
+			"github.com/foo/bar": "gcr.io/foo/bar@sha256:deadbeef",
+		},
+		Files: map[string][]string{
+			"config/b.yaml": {"github.com/foo/bar"},
+		},
+	}
+	resolveErr := &fileError{
+		File: "config/a.yaml",
+		Err: &resolve.ImportPathError{
+			ImportPath: "github.com/foo/baz",
+			Err:        errors.New("build failed"),
+		},
+	}
+
+	got := reportEntries(s, resolveErr)
+	want := []reportEntry{{
+		File:       "config/b.yaml",
+		ImportPath: "github.com/foo/bar",
+	}, {
+		File:       "config/a.yaml",
+		ImportPath: "github.com/foo/baz",
+		Failure:    resolveErr.Error(),
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("reportEntries() (-want +got) = %s", diff)
+	}
+}
+
+func TestReportFormat(t *testing.T) {
+	for _, c := range []struct {
+		path, format, want string
+		wantErr            bool
+	}{
+		{path: "report.xml", want: "junit"},
+		{path: "report.sarif", want: "sarif"},
+		{path: "report.sarif", format: "junit", want: "junit"},
+		{path: "report.xml", format: "tap", wantErr: true},
+	} {
+		got, err := reportFormat(c.path, c.format)
+		if (err != nil) != c.wantErr {
+			t.Errorf("reportFormat(%q, %q) = %v, wantErr %v", c.path, c.format, err, c.wantErr)
+		}
+		if got != c.want {
+			t.Errorf("reportFormat(%q, %q) = %q, want %q", c.path, c.format, got, c.want)
+		}
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	entries := []reportEntry{{
+		File:       "config/a.yaml",
+		ImportPath: "github.com/foo/bar",
+	}, {
+		File:       "config/b.yaml",
+		ImportPath: "github.com/foo/baz",
+		Failure:    "build failed\nmain.go:1: oops",
+	}}
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, entries); err != nil {
+		t.Fatalf("writeJUnit() = %v", err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		`<testsuite name="ko" tests="2" failures="1">`,
+		`<testcase classname="config/a.yaml" name="github.com/foo/bar"></testcase>`,
+		`<failure message="build failed">`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writeJUnit() = %s, want to contain %s", got, want)
+		}
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	entries := []reportEntry{{
+		File:       "config/a.yaml",
+		ImportPath: "github.com/foo/bar",
+	}, {
+		File:       "config/b.yaml",
+		ImportPath: "github.com/foo/baz",
+		Failure:    "build failed",
+	}}
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, entries); err != nil {
+		t.Fatalf("writeSARIF() = %v", err)
+	}
+
+	var got struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleID    string
+				Message   struct{ Text string }
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+					}
+				}
+				Properties map[string]string
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if got.Version != "2.1.0" {
+		t.Errorf("Version = %q, want 2.1.0", got.Version)
+	}
+	if len(got.Runs) != 1 || len(got.Runs[0].Results) != 1 {
+		t.Fatalf("writeSARIF() = %s, want one run with one result", buf.String())
+	}
+	res := got.Runs[0].Results[0]
+	if res.Message.Text != "build failed" {
+		t.Errorf("Message = %q, want %q", res.Message.Text, "build failed")
+	}
+	if uri := res.Locations[0].PhysicalLocation.ArtifactLocation.URI; uri != "config/b.yaml" {
+		t.Errorf("URI = %q, want config/b.yaml", uri)
+	}
+	if ip := res.Properties["importPath"]; ip != "github.com/foo/baz" {
+		t.Errorf("importPath = %q, want github.com/foo/baz", ip)
+	}
+}
+
+func TestWriteSARIFDiagnostics(t *testing.T) {
+	entries := []reportEntry{{
+		File:       "config/b.yaml",
+		ImportPath: "github.com/foo/baz",
+		Failure:    "build failed",
+		Diagnostics: []build.Diagnostic{{
+			Package: "github.com/foo/baz",
+			File:    "main.go",
+			Line:    10,
+			Column:  5,
+			Message: "undefined: qux",
+		}, {
+			File:    "util.go",
+			Line:    3,
+			Message: "missing return",
+		}},
+	}}
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, entries); err != nil {
+		t.Fatalf("writeSARIF() = %v", err)
+	}
+
+	var got struct {
+		Runs []struct {
+			Results []struct {
+				RuleID    string
+				Message   struct{ Text string }
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn int }
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if len(got.Runs) != 1 || len(got.Runs[0].Results) != 2 {
+		t.Fatalf("writeSARIF() = %s, want one run with a result per diagnostic", buf.String())
+	}
+	res := got.Runs[0].Results[0]
+	if res.RuleID != sarifCompileRuleID || res.Message.Text != "undefined: qux" {
+		t.Errorf("result = %+v, want the first diagnostic", res)
+	}
+	loc := res.Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "main.go" || loc.Region.StartLine != 10 || loc.Region.StartColumn != 5 {
+		t.Errorf("location = %+v, want main.go:10:5", loc)
+	}
+}
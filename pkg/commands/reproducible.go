@@ -0,0 +1,152 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// reproducibleBuilder builds each import path twice and fails, with a diff
+// of the two builds, unless they have the same digest.
+type reproducibleBuilder struct {
+	inner build.Interface
+	// clean makes the second build use a fresh GOPATH and GOCACHE, so that
+	// nothing is reused from the first.
+	clean bool
+	// workDir is where the fresh GOPATH and GOCACHE go, or "" for the
+	// default directory for temporary files.
+	workDir string
+}
+
+// IsSupportedReference implements build.Interface
+func (r *reproducibleBuilder) IsSupportedReference(ip string) error {
+	return r.inner.IsSupportedReference(ip)
+}
+
+// Build implements build.Interface
+func (r *reproducibleBuilder) Build(ctx context.Context, ip string) (build.Result, error) {
+	first, err := r.inner.Build(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	secondCtx := ctx
+	if r.clean {
+		dir, err := ioutil.TempDir(r.workDir, "ko-reproducible")
+		if err != nil {
+			return nil, err
+		}
+		defer removeModCache(dir)
+		gopath := filepath.Join(dir, "gopath")
+		secondCtx = build.WithBuildEnv(ctx,
+			"GOPATH="+gopath,
+			"GOMODCACHE="+filepath.Join(gopath, "pkg", "mod"),
+			"GOCACHE="+filepath.Join(dir, "gocache"))
+	}
+	second, err := r.inner.Build(secondCtx, ip)
+	if err != nil {
+		return nil, fmt.Errorf("building %s again: %v", ip, err)
+	}
+
+	d1, err := first.Digest()
+	if err != nil {
+		return nil, err
+	}
+	d2, err := second.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if d1 == d2 {
+		return first, nil
+	}
+	var diff bytes.Buffer
+	if err := writeResultDiff(&diff, first, second); err != nil {
+		fmt.Fprintf(&diff, "unable to diff them: %v\n", err)
+	}
+	return nil, fmt.Errorf("%s is not reproducible: it built as %s, then as %s\n%s", ip, d1, d2, diff.String())
+}
+
+// writeResultDiff writes the differences between two builds to w: between
+// the images, or between each pair of children of two indexes that differ.
+func writeResultDiff(w io.Writer, a, b build.Result) error {
+	ai, aok := a.(v1.ImageIndex)
+	bi, bok := b.(v1.ImageIndex)
+	if !aok || !bok {
+		aimg, aok := a.(v1.Image)
+		bimg, bok := b.(v1.Image)
+		if !aok || !bok {
+			return fmt.Errorf("can only diff two images or two indexes, not %T and %T", a, b)
+		}
+		return writeImageDiff(w, aimg, bimg)
+	}
+	am, err := ai.IndexManifest()
+	if err != nil {
+		return err
+	}
+	bm, err := bi.IndexManifest()
+	if err != nil {
+		return err
+	}
+	if len(am.Manifests) != len(bm.Manifests) {
+		fmt.Fprintf(w, "%d images, then %d\n", len(am.Manifests), len(bm.Manifests))
+		return nil
+	}
+	for i, ad := range am.Manifests {
+		bd := bm.Manifests[i]
+		if ad.Digest == bd.Digest {
+			continue
+		}
+		platform := fmt.Sprintf("image %d", i)
+		if ad.Platform != nil {
+			platform = ad.Platform.OS + "/" + ad.Platform.Architecture
+			if ad.Platform.Variant != "" {
+				platform += "/" + ad.Platform.Variant
+			}
+		}
+		fmt.Fprintf(w, "%s:\n", platform)
+		aimg, err := ai.Image(ad.Digest)
+		if err != nil {
+			return err
+		}
+		bimg, err := bi.Image(bd.Digest)
+		if err != nil {
+			return err
+		}
+		if err := writeImageDiff(w, aimg, bimg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeModCache removes dir, which holds a module cache whose files the go
+// command made read-only.
+func removeModCache(dir string) error {
+	filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.IsDir() {
+			os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	return os.RemoveAll(dir)
+}
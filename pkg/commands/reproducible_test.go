@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+)
+
+// sequenceBuild returns each of results in turn.
+type sequenceBuild struct {
+	build.Interface
+	results []build.Result
+}
+
+func (s *sequenceBuild) Build(context.Context, string) (build.Result, error) {
+	br := s.results[0]
+	s.results = s.results[1:]
+	return br, nil
+}
+
+func TestReproducibleBuilder(t *testing.T) {
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	other, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	otherIdx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	dir, err := ioutil.TempDir("", "ko-reproducible-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name    string
+		results []build.Result
+		clean   bool
+		wantErr string
+	}{{
+		name:    "same image",
+		results: []build.Result{img, img},
+	}, {
+		name:    "same image clean",
+		results: []build.Result{img, img},
+		clean:   true,
+	}, {
+		name:    "different images",
+		results: []build.Result{img, other},
+		wantErr: "Layers:",
+	}, {
+		name:    "different indexes",
+		results: []build.Result{idx, otherIdx},
+		wantErr: "image 0:\nLayers:",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &reproducibleBuilder{inner: &sequenceBuild{results: tc.results}, clean: tc.clean, workDir: dir}
+			got, err := r.Build(context.Background(), "github.com/google/ko/test")
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), "is not reproducible") || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Build() = %v, want a diff containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() = %v", err)
+			}
+			if got != tc.results[0] {
+				t.Errorf("Build() = %v, want the first build", got)
+			}
+			if fis, err := ioutil.ReadDir(dir); err != nil {
+				t.Fatal(err)
+			} else if len(fis) != 0 {
+				t.Errorf("work dir has %d leftover entries, want none", len(fis))
+			}
+		})
+	}
+}
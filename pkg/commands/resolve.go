@@ -15,9 +15,16 @@
 package commands
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
 
+	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
 	"github.com/spf13/cobra"
 )
@@ -27,7 +34,15 @@ func addResolve(topLevel *cobra.Command) {
 	po := &options.PublishOptions{}
 	fo := &options.FilenameOptions{}
 	so := &options.SelectorOptions{}
+	ao := &options.AnnotateOptions{}
 	bo := &options.BuildOptions{}
+	sto := &options.StateOptions{}
+	oo := &options.OutputOptions{}
+	vo := &options.ValidateOptions{}
+	to := &options.TektonOptions{}
+	gho := &options.GitHubOptions{}
+	pvo := &options.ProvenanceOptions{}
+	smo := &options.SummaryOptions{}
 
 	resolve := &cobra.Command{
 		Use:   "resolve -f FILENAME",
@@ -52,27 +67,297 @@ func addResolve(topLevel *cobra.Command) {
   # daemon as:
   #   ko.local/<import path>
   # This always preserves import paths.
-  ko resolve --local -f config/`,
+  ko resolve --local -f config/
+
+  # Build import path references into an air-gap bundle under out/,
+  # with the resolved yaml referencing images as if they were pushed
+  # to registry.internal/team. The bundle can then be copied into an
+  # offline environment and pushed there.
+  ko resolve --push=false --bundle=out/ --bundle-repo=registry.internal/team -f config/
+
+  # Report which images and files changed since the last resolve,
+  # and record this resolve for next time.
+  ko resolve --state-file=.ko-state.json --diff -f config/
+
+  # Write each resolved document to its own file, named after the
+  # resource, for checking into a GitOps repository.
+  ko resolve --output-dir=manifests/rendered/ -f config/
+
+  # Record the digest of each image in ko.lock, and later make sure
+  # that exactly the same images are produced.
+  ko resolve --lock -f config/
+  ko resolve --frozen -f config/
+
+  # Write a JUnit report attributing any failure to the import path
+  # and file that referenced it, for CI systems to display.
+  ko resolve --report=report.xml -f config/
+
+  # Check the resolved yaml for typos (e.g. unknown fields) against
+  # the schema of the current cluster before printing it.
+  ko resolve --validate=cluster -f config/
+
+  # Also write a JSON list of the images that were built, for
+  # release tooling and security scanners.
+  ko resolve --image-manifest=images.json -f config/
+
+  # Also write an in-toto statement of how each image was built,
+  # for policy engines to check.
+  ko resolve --attestation-dir=attestations/ -f config/
+
+  # Check the templating of the yaml in seconds, without building
+  # anything, using the digests in ko.lock where available.
+  ko resolve --dry-run -f config/`,
 		Args: cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := createCancellableContext()
-			builder, err := makeBuilder(ctx, bo)
+			if oo.DryRun {
+				if err := resolveDryRun(ctx, po, fo, so, ao, bo, sto); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			innerBuilder, err := makeUncachedBuilder(ctx, bo)
+			if err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			// Record builds beneath the cache, so each is recorded once.
+			var builds *build.Recorder
+			if oo.BuildManifest != "" || pvo.Provenance != "" || oo.ImageManifest != "" || smo.Summary {
+				builds = &build.Recorder{Builder: innerBuilder}
+				innerBuilder = builds
+			}
+			builder, err := cacheBuilder(bo, innerBuilder)
 			if err != nil {
 				log.Fatalf("error creating builder: %v", err)
 			}
+			if err := validateMode(vo.Validate); err != nil {
+				log.Fatal(err)
+			}
+			if vo.Validate != "" && fo.Watch {
+				log.Fatal("--validate cannot be used with --watch")
+			}
+			if sto.Diff && sto.StateFile == "" {
+				log.Fatal("--diff requires --state-file")
+			}
+			po.WorkDir = bo.WorkDir
 			publisher, err := makePublisher(po)
 			if err != nil {
 				log.Fatalf("error creating publisher: %v", err)
 			}
+			if sto.Lock && sto.Frozen {
+				log.Fatal("--lock and --frozen are mutually exclusive")
+			}
+			var locker *lockingPublisher
+			if sto.Lock || sto.Frozen {
+				var frozen *lockfile
+				if sto.Frozen {
+					frozen, err = readLockfile(sto.LockFile)
+					if err != nil {
+						log.Fatalf("error reading lockfile: %v", err)
+					}
+				}
+				locker = newLockingPublisher(publisher, frozen)
+				publisher = locker
+			}
+			var format string
+			if oo.Report != "" {
+				if format, err = reportFormat(oo.Report, oo.ReportFormat); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if oo.AttestationDir != "" {
+				publisher, err = newAttestor(publisher, oo.AttestationDir, setFlags(cmd))
+				if err != nil {
+					log.Fatalf("error creating attestation directory: %v", err)
+				}
+			}
+			var prov *provenanceRecorder
+			if pvo.Provenance != "" {
+				prov = newProvenanceRecorder(publisher, builds, setFlags(cmd))
+				publisher = prov
+			}
+			var images *imageRecorder
+			if oo.ImageManifest != "" || smo.Summary {
+				images = newImageRecorder(publisher, po.Tags)
+				publisher = images
+			}
+			var rec *stateRecorder
+			if sto.StateFile != "" || oo.Report != "" || len(to.Results) != 0 || gho.Outputs {
+				rec = newStateRecorder()
+				publisher = rec.Publisher(publisher)
+			}
 			defer publisher.Close()
-			if err := resolveFilesToWriter(ctx, builder, publisher, fo, so, os.Stdout); err != nil {
-				log.Fatal(err)
+			var out io.WriteCloser = os.Stdout
+			if oo.OutputDir != "" {
+				out, err = newDirWriter(oo.OutputDir, fo.Watch)
+				if err != nil {
+					log.Fatalf("error creating output directory: %v", err)
+				}
+			}
+			if po.Bundle != "" {
+				// Also save the resolved yaml alongside the bundled images.
+				f, err := os.Create(filepath.Join(po.Bundle, "resolved.yaml"))
+				if err != nil {
+					log.Fatalf("error creating bundle manifest: %v", err)
+				}
+				out = teeWriteCloser(out, f)
+			}
+			// Hold onto the resolved yaml until it has been validated.
+			var resolved *bytes.Buffer
+			validated := out
+			if vo.Validate != "" {
+				resolved = &bytes.Buffer{}
+				out = nopWriteCloser{resolved}
+			}
+			err = resolveFilesToWriter(ctx, builder, publisher, fo, so, ao, out, rec)
+			if oo.Report != "" {
+				if rerr := writeReport(oo.Report, format, reportEntries(rec.State(), err)); rerr != nil {
+					log.Printf("error writing report: %v", rerr)
+				}
+			}
+			if err != nil {
+				log.Fatal(withHint(err))
+			}
+			if resolved != nil {
+				if err := validateDocuments(ctx, vo.Validate, resolved.Bytes(), nil); err != nil {
+					log.Fatal(err)
+				}
+				if _, err := io.Copy(validated, resolved); err != nil {
+					log.Fatalf("error writing resolved yaml: %v", err)
+				}
+				if err := validated.Close(); err != nil {
+					log.Fatalf("error writing resolved yaml: %v", err)
+				}
+			}
+			if oo.ImageManifest != "" {
+				if err := writeImageManifest(oo.ImageManifest, images.Manifest(builds.Builds...)); err != nil {
+					log.Fatalf("error writing image manifest: %v", err)
+				}
+			}
+			if smo.Summary {
+				if err := writeSummary(os.Stderr, images.Manifest(builds.Builds...)); err != nil {
+					log.Fatalf("error writing summary: %v", err)
+				}
+			}
+			if oo.BuildManifest != "" {
+				if err := writeBuildManifest(oo.BuildManifest, builds.Builds); err != nil {
+					log.Fatalf("error writing build manifest: %v", err)
+				}
+			}
+			if prov != nil {
+				if err := writeProvenance(pvo.Provenance, prov.Provenance()); err != nil {
+					log.Fatalf("error writing provenance: %v", err)
+				}
+			}
+			if len(to.Results) != 0 {
+				if err := writeTektonResults(to, rec.State().Images); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if gho.Outputs {
+				if err := writeGitHubOutputs(rec.State().Images); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if sto.StateFile != "" {
+				if err := persistState(sto, rec.State()); err != nil {
+					log.Fatal(err)
+				}
+			}
+			if sto.Lock {
+				if err := writeLockfile(sto.LockFile, locker.Lockfile()); err != nil {
+					log.Fatalf("error writing lockfile: %v", err)
+				}
 			}
 		},
 	}
 	options.AddPublishArg(resolve, po)
 	options.AddFileArg(resolve, fo)
 	options.AddSelectorArg(resolve, so)
+	options.AddAnnotateArg(resolve, ao)
 	options.AddBuildOptions(resolve, bo)
+	options.AddStateArg(resolve, sto)
+	options.AddOutputArg(resolve, oo)
+	// Unlike ko gc's, it defaults to false.
+	scopeFlag(resolve, "dry-run")
+	options.AddValidateArg(resolve, vo)
+	options.AddTektonArg(resolve, to)
+	options.AddGitHubArg(resolve, gho)
+	options.AddProvenanceArg(resolve, pvo)
+	options.AddSummaryArg(resolve, smo)
 	topLevel.AddCommand(resolve)
 }
+
+// resolveDryRun resolves the files to stdout with predicted references,
+// without building or publishing anything.
+func resolveDryRun(ctx context.Context, po *options.PublishOptions, fo *options.FilenameOptions, so *options.SelectorOptions, ao *options.AnnotateOptions, bo *options.BuildOptions, sto *options.StateOptions) error {
+	switch {
+	case fo.Watch:
+		return errors.New("--dry-run cannot be used with --watch")
+	case sto.Lock || sto.Frozen:
+		return errors.New("--dry-run cannot be used with --lock or --frozen")
+	}
+	builder, err := makeDryRunBuilder(ctx, bo)
+	if err != nil {
+		return fmt.Errorf("error creating builder: %v", err)
+	}
+	publisher, err := makeDryRunPublisher(po, sto)
+	if err != nil {
+		return fmt.Errorf("error creating publisher: %v", err)
+	}
+	defer publisher.Close()
+	return resolveFilesToWriter(ctx, builder, publisher, fo, so, ao, os.Stdout, nil)
+}
+
+// teeWriteCloser returns an io.WriteCloser that duplicates its writes to
+// each of the provided writers, and closes all of them when closed.
+func teeWriteCloser(wcs ...io.WriteCloser) io.WriteCloser {
+	ws := make([]io.Writer, 0, len(wcs))
+	for _, wc := range wcs {
+		ws = append(ws, wc)
+	}
+	return &multiWriteCloser{
+		Writer: io.MultiWriter(ws...),
+		wcs:    wcs,
+	}
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type multiWriteCloser struct {
+	io.Writer
+	wcs []io.WriteCloser
+}
+
+func (m *multiWriteCloser) Close() (err error) {
+	for _, wc := range m.wcs {
+		if cerr := wc.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// persistState reports the difference from the previously persisted state,
+// if requested, and then replaces it with s.
+func persistState(sto *options.StateOptions, s *resolveState) error {
+	if sto.Diff {
+		prev, err := readState(sto.StateFile)
+		if err != nil {
+			return fmt.Errorf("error reading state: %v", err)
+		}
+		diffStates(prev, s).Write(os.Stderr)
+	}
+	if err := writeState(sto.StateFile, s); err != nil {
+		return fmt.Errorf("error writing state: %v", err)
+	}
+	return nil
+}
@@ -27,10 +27,11 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/ko/pkg/build"
 	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/internal/debounce"
+	"github.com/google/ko/pkg/internal/resources"
 	"github.com/google/ko/pkg/publish"
 	"github.com/google/ko/pkg/resolve"
 	"github.com/mattmoor/dep-notify/pkg/graph"
@@ -46,12 +47,9 @@ func ua() string {
 	return "ko"
 }
 
-func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
-	creationTime, err := getCreationTime()
-	if err != nil {
-		return nil, err
-	}
-
+// platformSpec returns the platforms to build for, defaulting to GOOS and
+// GOARCH (or linux/amd64) when --platform isn't set.
+func platformSpec(bo *options.BuildOptions) (string, error) {
 	platform := bo.Platform
 	if platform == "" {
 		platform = "linux/amd64"
@@ -71,13 +69,40 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 		// Make sure these are all unset
 		for _, env := range []string{"GOOS", "GOARCH", "GOARM"} {
 			if s, ok := os.LookupEnv(env); ok {
-				return nil, fmt.Errorf("cannot use --platform with %s=%q", env, s)
+				return "", fmt.Errorf("cannot use --platform with %s=%q", env, s)
 			}
 		}
 	}
+	return platform, nil
+}
+
+// sizeAnalysisTop is how many packages and symbols --size-analysis lists.
+const sizeAnalysisTop = 10
+
+func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
+	if err := useGoCaches(bo); err != nil {
+		return nil, err
+	}
+	if err := useModuleEnv(bo); err != nil {
+		return nil, err
+	}
+	creationTime, err := getCreationTime()
+	if err != nil {
+		return nil, err
+	}
+
+	platform, err := platformSpec(bo)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := defaultBase(bo)
+	if err != nil {
+		return nil, err
+	}
 
 	opts := []build.Option{
-		build.WithBaseImages(getBaseImage(platform)),
+		build.WithBaseImages(getBaseImage(platform, base)),
 		build.WithPlatforms(platform),
 	}
 	if creationTime != nil {
@@ -86,20 +111,126 @@ func gobuildOptions(bo *options.BuildOptions) ([]build.Option, error) {
 	if bo.DisableOptimizations {
 		opts = append(opts, build.WithDisabledOptimizations())
 	}
+	if len(bo.Labels) != 0 {
+		labels := make(map[string]string, len(bo.Labels))
+		for _, l := range bo.Labels {
+			parts := strings.SplitN(l, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return nil, fmt.Errorf("invalid --image-label %q, expected KEY=VALUE", l)
+			}
+			labels[parts[0]] = parts[1]
+		}
+		opts = append(opts, build.WithLabels(labels))
+	}
+	if bo.SourceAnnotations {
+		opts = append(opts, build.WithSourceAnnotations())
+	}
+	if len(buildConfigs) != 0 {
+		opts = append(opts, build.WithConfigs(buildConfigs))
+	}
+	if defaultUser != "" {
+		opts = append(opts, build.WithDefaultUser(defaultUser))
+	}
+	if bo.BinaryArtifacts {
+		opts = append(opts, build.WithBinaryArtifacts())
+	}
+	if initCfg != nil {
+		opts = append(opts, build.WithInit(initCfg.buildInit()))
+	}
+	if koIgnore != nil {
+		opts = append(opts, build.WithIgnore(koIgnore.Match))
+	}
+	if bo.WorkDir != "" || bo.WorkDirCleanup != "" {
+		opts = append(opts, build.WithWorkDir(bo.WorkDir, build.CleanupPolicy(bo.WorkDirCleanup)))
+	}
+	if bo.BaseMetadata != "" {
+		opts = append(opts, build.WithBaseMetadata(build.BaseMetadataPolicy(bo.BaseMetadata)))
+	}
+	if bo.KoDataSecrets != "" {
+		opts = append(opts, build.WithSecretsPolicy(build.SecretsPolicy(bo.KoDataSecrets)))
+	}
+	if bo.CompressionLevel != 0 {
+		opts = append(opts, build.WithCompressionLevel(bo.CompressionLevel))
+	}
+	if bo.BatchWindow > 0 {
+		opts = append(opts, build.WithBatching(bo.BatchWindow))
+	}
+	if bo.Lookup != nil {
+		opts = append(opts, build.WithLookup(bo.Lookup))
+	}
+	if bo.SizeReport || bo.SizeAnalysis {
+		opts = append(opts, build.WithSizeReporter(func(r build.SizeReport) {
+			// Builds are concurrent, so write each report all at once.
+			var buf bytes.Buffer
+			r.Write(&buf)
+			// The progress view, if any, writes the report above itself.
+			log.Writer().Write(buf.Bytes())
+		}))
+	}
+	if bo.SizeAnalysis {
+		opts = append(opts, build.WithSizeAnalysis(sizeAnalysisTop))
+	}
+	if err := startProgress(bo.Progress); err != nil {
+		return nil, err
+	}
+	if progress != nil {
+		opts = append(opts, build.WithHooks(progress.buildHooks()))
+	}
 	return opts, nil
 }
 
-func makeBuilder(ctx context.Context, bo *options.BuildOptions) (*build.Caching, error) {
-	opt, err := gobuildOptions(bo)
+// lookupUnchanged sets bo.Lookup to find images that were already pushed from
+// the same inputs, when --skip-unchanged is set. Since only images pushed to
+// KO_DOCKER_REPO are tagged with their inputs, it fails if they won't be.
+func lookupUnchanged(bo *options.BuildOptions, po *options.PublishOptions) error {
+	if !po.SkipUnchanged {
+		return nil
+	}
+	repoName := os.Getenv("KO_DOCKER_REPO")
+	switch {
+	case po.DigestOnly:
+		return errors.New("--skip-unchanged finds earlier images by tag, so it cannot be used with --digest-only")
+	case bo.Reproducible || bo.ReproducibleClean:
+		return errors.New("--skip-unchanged reuses earlier images instead of building them, so it cannot be used with --reproducible")
+	case !po.Push:
+		return errors.New("--skip-unchanged finds images pushed to KO_DOCKER_REPO, so it cannot be used with --push=false")
+	case po.Bucket != "":
+		return errors.New("--skip-unchanged finds images pushed to KO_DOCKER_REPO, so it cannot be used with --bucket")
+	case po.Local || repoName == publish.LocalDomain:
+		return errors.New("--skip-unchanged finds images pushed to KO_DOCKER_REPO, so it cannot be used with --local")
+	case po.PortForward:
+		return errors.New("--skip-unchanged finds images pushed to KO_DOCKER_REPO, so it cannot be used with --port-forward")
+	case repoName == publish.KindDomain:
+		return errors.New("--skip-unchanged finds images pushed to KO_DOCKER_REPO, so it cannot be used with kind.local")
+	case repoName == "":
+		return errors.New("--skip-unchanged finds images pushed to KO_DOCKER_REPO, which is not set")
+	}
+	lookup, err := publish.NewLookup(repoName,
+		publish.WithUserAgent(ua()),
+		publish.WithAuthFromKeychain(keychain),
+		publish.WithNamer(options.MakeNamer(po)),
+		publish.Insecure(po.InsecureRegistry))
 	if err != nil {
-		return nil, fmt.Errorf("error setting up builder options: %v", err)
+		return err
 	}
-	innerBuilder, err := build.NewGo(ctx, opt...)
+	bo.Lookup = lookup
+	return nil
+}
+
+func makeBuilder(ctx context.Context, bo *options.BuildOptions) (build.Interface, error) {
+	innerBuilder, err := makeUncachedBuilder(ctx, bo)
 	if err != nil {
 		return nil, err
 	}
+	return cacheBuilder(bo, innerBuilder)
+}
 
-	innerBuilder = build.NewLimiter(innerBuilder, bo.ConcurrentBuilds)
+// cacheBuilder wraps innerBuilder so that each import path is built once,
+// unless caching is disabled.
+func cacheBuilder(bo *options.BuildOptions, innerBuilder build.Interface) (build.Interface, error) {
+	if bo.DisableCaching {
+		return innerBuilder, nil
+	}
 
 	// tl;dr Wrap builder in a caching builder.
 	//
@@ -117,7 +248,41 @@ func makeBuilder(ctx context.Context, bo *options.BuildOptions) (*build.Caching,
 	//    we can elide subsequent builds by blocking on the same image future.
 	// 2. When an affected yaml file has multiple import paths (mostly unaffected)
 	//    we can elide the builds of unchanged import paths.
-	return build.NewCaching(innerBuilder)
+	c, err := build.NewCaching(innerBuilder,
+		build.WithCacheMaxEntries(bo.CacheMaxEntries),
+		build.WithCacheTTL(bo.CacheTTL))
+	if err != nil {
+		return nil, err
+	}
+	koMetrics.meterCache(c)
+	return c, nil
+}
+
+// makeUncachedBuilder returns the builder that makeBuilder wraps, which
+// builds every time it is asked to.
+func makeUncachedBuilder(ctx context.Context, bo *options.BuildOptions) (build.Interface, error) {
+	if configErr != nil {
+		return nil, configErr
+	}
+	opt, err := gobuildOptions(bo)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up builder options: %v", err)
+	}
+	gb, err := build.NewGo(ctx, opt...)
+	if err != nil {
+		return nil, err
+	}
+	var innerBuilder build.Interface = &meteredBuilder{inner: gb, m: koMetrics}
+	if bo.Reproducible || bo.ReproducibleClean {
+		innerBuilder = &reproducibleBuilder{inner: innerBuilder, clean: bo.ReproducibleClean, workDir: bo.WorkDir}
+	}
+	if bo.ConcurrentBuilds <= 0 {
+		return innerBuilder, nil
+	}
+	if bo.MemoryBackoff {
+		return build.NewAdaptiveLimiter(innerBuilder, bo.ConcurrentBuilds, resources.LowMemory), nil
+	}
+	return build.NewLimiter(innerBuilder, bo.ConcurrentBuilds), nil
 }
 
 func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
@@ -126,6 +291,9 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 	innerPublisher, err := func() (publish.Interface, error) {
 		repoName := os.Getenv("KO_DOCKER_REPO")
 		namer := options.MakeNamer(po)
+		if po.DigestOnly && (repoName == publish.LocalDomain || po.Local || repoName == publish.KindDomain) {
+			return nil, errors.New("--digest-only pushes to a registry, but images loaded into docker or kind need tags")
+		}
 		if repoName == publish.LocalDomain || po.Local {
 			// TODO(jonjohnsonjr): I'm assuming that nobody will
 			// use local with other publishers, but that might
@@ -144,6 +312,14 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 				return nil, fmt.Errorf("failed to parse environment variable KO_DOCKER_REPO=%q as repository: %v", repoName, err)
 			}
 		}
+		for pattern, repo := range po.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("--repositories: bad pattern %q: %v", pattern, err)
+			}
+			if _, err := name.NewRepository(repo); err != nil {
+				return nil, fmt.Errorf("--repositories: failed to parse %q as repository: %v", repo, err)
+			}
+		}
 
 		publishers := []publish.Interface{}
 		if po.OCILayoutPath != "" {
@@ -157,18 +333,64 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 			tp := publish.NewTarball(po.TarballFile, repoName, namer, po.Tags)
 			publishers = append(publishers, tp)
 		}
-		if po.Push {
-			dp, err := publish.NewDefault(repoName,
+		tags := po.Tags
+		if po.DigestOnly {
+			tags = nil
+		}
+		if po.Bucket != "" {
+			store, err := publish.NewObjectStore(po.Bucket)
+			if err != nil {
+				return nil, fmt.Errorf("--bucket: %v", err)
+			}
+			publishers = append(publishers, publish.NewBucket(store, repoName, namer, tags))
+		} else if po.Push {
+			// With --port-forward, push to a local port forwarded to
+			// the cluster's registry, and name images as the cluster does.
+			pushRepo := repoName
+			var cluster *clusterRegistry
+			var local string
+			stop := func() {}
+			if po.PortForward {
+				var err error
+				if cluster, err = parseClusterRegistry(repoName); err != nil {
+					return nil, fmt.Errorf("--port-forward: KO_DOCKER_REPO: %v", err)
+				}
+				if local, stop, err = portForward(cluster); err != nil {
+					return nil, err
+				}
+				log.Printf("Pushing to %s through %s", cluster.Host, local)
+				pushRepo = local + strings.TrimPrefix(repoName, cluster.Host)
+			}
+			dp, err := publish.NewDefault(pushRepo,
 				publish.WithUserAgent(ua()),
-				publish.WithAuthFromKeychain(authn.DefaultKeychain),
+				publish.WithAuthFromKeychain(keychain),
 				publish.WithNamer(namer),
-				publish.WithTags(po.Tags),
+				publish.WithTags(tags),
+				publish.WithJobs(po.Jobs),
+				publish.WithHooks(progressHooks()),
 				publish.Insecure(po.InsecureRegistry))
 			if err != nil {
+				stop()
 				return nil, err
 			}
+			if cluster != nil {
+				dp = &forwardedPublisher{inner: dp, local: local, cluster: cluster.Host, stop: stop}
+			}
 			publishers = append(publishers, dp)
 		}
+		if po.Bundle != "" {
+			bundleRepo := po.BundleRepo
+			if bundleRepo == "" {
+				bundleRepo = repoName
+			}
+			bp, err := publish.NewBundle(po.Bundle, bundleRepo, namer, po.Tags)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create bundle publisher for %q: %v", po.Bundle, err)
+			}
+			// The last publisher's references win, and the resolved yaml
+			// should point at the bundle's target repository.
+			publishers = append(publishers, bp)
+		}
 
 		// If not publishing, at least generate a digest to simulate
 		// publishing.
@@ -184,6 +406,15 @@ func makePublisher(po *options.PublishOptions) (publish.Interface, error) {
 	if err != nil {
 		return nil, err
 	}
+	innerPublisher, err = newScanningPublisher(innerPublisher, po)
+	if err != nil {
+		return nil, err
+	}
+	innerPublisher = &meteredPublisher{inner: innerPublisher, m: koMetrics}
+
+	if po.DisableCaching {
+		return innerPublisher, nil
+	}
 
 	// Wrap publisher in a memoizing publisher implementation.
 	return publish.NewCaching(innerPublisher)
@@ -206,18 +437,53 @@ func (n nopPublisher) Publish(_ context.Context, br build.Result, s string) (nam
 
 func (n nopPublisher) Close() error { return nil }
 
+// fileError attributes a resolution failure to the file being resolved.
+type fileError struct {
+	File string
+	Err  error
+}
+
+func (e *fileError) Error() string {
+	return fmt.Sprintf("error processing import paths in %q: %v", e.File, e.Err)
+}
+
+func (e *fileError) Unwrap() error {
+	return e.Err
+}
+
 // resolvedFuture represents a "future" for the bytes of a resolved file.
 type resolvedFuture chan []byte
 
 func resolveFilesToWriter(
 	ctx context.Context,
-	builder *build.Caching,
+	builder build.Interface,
 	publisher publish.Interface,
 	fo *options.FilenameOptions,
 	so *options.SelectorOptions,
-	out io.WriteCloser) error {
+	ao *options.AnnotateOptions,
+	out io.WriteCloser,
+	rec *stateRecorder) error {
 	defer out.Close()
 
+	var annotate podAnnotator
+	if ao != nil && ao.BuildAnnotations {
+		annotate = buildAnnotator(builder)
+	}
+
+	// Lists of -f arguments are expanded, and remote ones are fetched
+	// first, and then resolved like local files.
+	filenames, err := options.ExpandFilenames(fo.Filenames)
+	if err != nil {
+		return err
+	}
+	filenames, sources, err := fetchFilenames(ctx, filenames)
+	if err != nil {
+		return err
+	}
+	local := *fo
+	local.Filenames = filenames
+	fo = &local
+
 	// By having this as a channel, we can hook this up to a filesystem
 	// watcher and leave `fs` open to stream the names of yaml files
 	// affected by code changes (including the modification of existing or
@@ -229,40 +495,72 @@ func resolveFilesToWriter(
 
 	var g graph.Interface
 	var errCh chan error
-	var err error
 	if fo.Watch {
 		// Start a dep-notify process that on notifications scans the
 		// file-to-recorded-build map and for each affected file resends
 		// the filename along the channel.
-		g, errCh, err = graph.New(func(ss graph.StringSet) {
+		// Changes to files in .koignore don't trigger rebuilds.
+		graphOpts := append([]graph.Option{graph.WithFileFilter(func(path string) bool {
+			return koIgnore.Match(path, false)
+		})}, graph.DefaultOptions...)
+		// Bursts of changes are rebuilt in one wave, with each affected
+		// import path invalidated and each affected file resent once.
+		affected := debounce.New(fo.WatchDebounce, fo.WatchMaxDelay, func(ips []string) {
+			changed := map[string]bool{}
+			for _, ip := range ips {
+				changed[ip] = true
+				// See the comment above about how "builder" works.
+				// Always use ko:// for the builder.
+				if c, ok := builder.(*build.Caching); ok {
+					c.Invalidate(build.StrictScheme + ip)
+				}
+			}
+			// Rebuild what just changed ahead of any cold builds still queued.
+			if p, ok := builder.(build.Prioritizer); ok {
+				p.Prioritize(ips...)
+			}
+			var files []string
 			sm.Range(func(k, v interface{}) bool {
-				key := k.(string)
-				value := v.([]string)
-
-				for _, ip := range value {
+				for _, ip := range v.([]string) {
 					// dep-notify doesn't understand the ko:// prefix
-					ip := strings.TrimPrefix(ip, build.StrictScheme)
-					if ss.Has(ip) {
-						// See the comment above about how "builder" works.
-						// Always use ko:// for the builder.
-						builder.Invalidate(build.StrictScheme + ip)
-						fs <- key
+					if changed[strings.TrimPrefix(ip, build.StrictScheme)] {
+						files = append(files, k.(string))
+						break
 					}
 				}
 				return true
 			})
+			for _, f := range files {
+				fs <- f
+			}
 		})
+		defer affected.Stop()
+		g, errCh, err = graph.NewWithOptions(func(ss graph.StringSet) {
+			affected.Add(ss.InOrder()...)
+		}, graphOpts...)
 		if err != nil {
 			return fmt.Errorf("creating dep-notify graph: %v", err)
 		}
 		// Cleanup the fsnotify hooks when we're done.
 		defer g.Shutdown()
+
+		if fo.MetricsAddr != "" {
+			srv := serveMetrics(fo.MetricsAddr)
+			defer srv.Close()
+		}
 	}
 
 	// This tracks resolution errors and ensures we cancel other builds if an
 	// individual build fails.
 	errs, ctx := errgroup.WithContext(ctx)
 
+	// In --stream mode, each future reports on done once it has its result,
+	// so that it can be written as soon as it is ready rather than in order.
+	var done chan resolvedFuture
+	if fo.Stream {
+		done = make(chan resolvedFuture)
+	}
+
 	var futures []resolvedFuture
 	for {
 		// Each iteration, if there is anything in the list of futures,
@@ -272,15 +570,26 @@ func resolveFilesToWriter(
 		// on the file enumerating channel.
 		var bf resolvedFuture
 		if len(futures) > 0 {
-			bf = futures[0]
+			if done == nil {
+				bf = futures[0]
+			}
 		} else if fs == nil {
 			// There are no more files to enumerate and the futures
 			// have been drained, so quit.
 			break
 		}
 
+		// Stop accepting new files while we have too many in flight.
+		// Since futures are only dequeued in order, this also bounds
+		// the number of resolved files we hold onto while waiting on
+		// a slow file at the head of the queue.
+		files := fs
+		if fo.ConcurrentResolves > 0 && len(futures) >= fo.ConcurrentResolves {
+			files = nil
+		}
+
 		select {
-		case file, ok := <-fs:
+		case file, ok := <-files:
 			if !ok {
 				// a nil channel is never available to receive on.
 				// This allows us to drain the list of in-process
@@ -293,22 +602,31 @@ func resolveFilesToWriter(
 			// Make a new future to use to ship the bytes back and append
 			// it to the list of futures (see comment below about ordering).
 			ch := make(resolvedFuture)
+			if done != nil {
+				// Don't block on the send, we'll receive once notified.
+				ch = make(resolvedFuture, 1)
+			}
 			futures = append(futures, ch)
 
 			// Kick off the resolution that will respond with its bytes on
 			// the future.
 			f := file // defensive copy
 			errs.Go(func() error {
-				defer close(ch)
+				defer func() {
+					close(ch)
+					if done != nil {
+						done <- ch
+					}
+				}()
 				// Record the builds we do via this builder.
 				recordingBuilder := &build.Recorder{
 					Builder: builder,
 				}
-				b, err := resolveFile(ctx, f, recordingBuilder, publisher, so)
+				b, err := resolveFile(ctx, f, recordingBuilder, publisher, so, annotate)
 				if err != nil {
 					// This error is sometimes expected during watch mode, so this
 					// isn't fatal. Just print it and keep the watch open.
-					err := fmt.Errorf("error processing import paths in %q: %v", f, err)
+					err := &fileError{File: sources.name(f), Err: err}
 					if fo.Watch {
 						log.Print(err)
 						return nil
@@ -317,6 +635,9 @@ func resolveFilesToWriter(
 				}
 				// Associate with this file the collection of binary import paths.
 				sm.Store(f, recordingBuilder.ImportPaths)
+				if rec != nil {
+					rec.recordFile(sources.name(f), recordingBuilder.ImportPaths)
+				}
 				ch <- b
 				if fo.Watch {
 					for _, ip := range recordingBuilder.ImportPaths {
@@ -344,11 +665,19 @@ func resolveFilesToWriter(
 			// the kubectl apply ordering, which matters!
 			futures = futures[1:]
 			if ok {
-				// Write the next body and a trailing delimiter.
-				// We write the delimeter LAST so that when streamed to
-				// kubectl it knows that the resource is complete and may
-				// be applied.
-				out.Write(append(b, []byte("\n---\n")...))
+				writeResolved(out, b)
+			}
+
+		case ch := <-done:
+			// In --stream mode, dequeue whichever future finished.
+			for i, future := range futures {
+				if future == ch {
+					futures = append(futures[:i], futures[i+1:]...)
+					break
+				}
+			}
+			if b, ok := <-ch; ok {
+				writeResolved(out, b)
 			}
 
 		case err := <-errCh:
@@ -361,12 +690,20 @@ func resolveFilesToWriter(
 	return errs.Wait()
 }
 
+// writeResolved writes the next body and a trailing delimiter. We write the
+// delimeter LAST so that when streamed to kubectl it knows that the resource
+// is complete and may be applied.
+func writeResolved(out io.Writer, b []byte) {
+	out.Write(append(b, []byte("\n---\n")...))
+}
+
 func resolveFile(
 	ctx context.Context,
 	f string,
 	builder build.Interface,
 	pub publish.Interface,
-	so *options.SelectorOptions) (b []byte, err error) {
+	so *options.SelectorOptions,
+	annotate podAnnotator) (b []byte, err error) {
 
 	var selector labels.Selector
 	if so.Selector != "" {
@@ -386,7 +723,18 @@ func resolveFile(
 	if err != nil {
 		return nil, err
 	}
+	return resolveBytes(ctx, b, builder, pub, selector, annotate)
+}
 
+// resolveBytes resolves the image references in the yaml documents b that
+// match selector (if any).
+func resolveBytes(
+	ctx context.Context,
+	b []byte,
+	builder build.Interface,
+	pub publish.Interface,
+	selector labels.Selector,
+	annotate podAnnotator) ([]byte, error) {
 	var docNodes []*yaml.Node
 
 	// The loop is to support multi-document yaml files.
@@ -414,8 +762,26 @@ func resolveFile(
 
 	}
 
+	// Expand aliases first, so they are built, annotated and recorded under
+	// the import paths they stand for.
+	resolve.ExpandAliases(docNodes, importPathAliases)
+
+	// Find the pods to annotate before their references are replaced.
+	var templates []*resolve.PodTemplate
+	if annotate != nil {
+		templates = resolve.PodTemplates(docNodes)
+	}
+
 	if err := resolve.ImageReferences(ctx, docNodes, builder, pub); err != nil {
-		return nil, fmt.Errorf("error resolving image references: %v", err)
+		return nil, fmt.Errorf("error resolving image references: %w", err)
+	}
+
+	for _, t := range templates {
+		annotations, err := annotate(ctx, t.ImportPaths)
+		if err != nil {
+			return nil, fmt.Errorf("error annotating pod template: %w", err)
+		}
+		t.Annotate(annotations)
 	}
 
 	buf := &bytes.Buffer{}
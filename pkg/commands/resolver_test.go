@@ -20,7 +20,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -70,7 +76,8 @@ func TestResolveMultiDocumentYAMLs(t *testing.T) {
 		yamlToTmpFile(t, buf.Bytes()),
 		testBuilder,
 		kotesting.NewFixedPublish(base, testHashes),
-		&options.SelectorOptions{})
+		&options.SelectorOptions{},
+		nil)
 
 	if err != nil {
 		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
@@ -125,7 +132,8 @@ kind: Bar
 		kotesting.NewFixedPublish(base, testHashes),
 		&options.SelectorOptions{
 			Selector: "qux=baz",
-		})
+		},
+		nil)
 	if err != nil {
 		t.Fatalf("ImageReferences(%v) = %v", string(inputYAML), err)
 	}
@@ -134,6 +142,165 @@ kind: Bar
 	}
 }
 
+// concurrentBuild records how many builds run at once, holding each for a
+// moment so that they overlap if they can.
+type concurrentBuild struct {
+	build.Interface
+	running, max int32
+}
+
+func (c *concurrentBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	n := atomic.AddInt32(&c.running, 1)
+	defer atomic.AddInt32(&c.running, -1)
+	for {
+		max := atomic.LoadInt32(&c.max)
+		if n <= max || atomic.CompareAndSwapInt32(&c.max, max, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return c.Interface.Build(ctx, s)
+}
+
+func TestResolveFilesToWriterOrdering(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+
+	refs := []string{fooRef, barRef, fooRef, barRef, fooRef}
+	var filenames, want []string
+	for _, ref := range refs {
+		filenames = append(filenames, yamlToTmpFile(t, []byte(build.StrictScheme+ref)))
+		want = append(want, kotesting.ComputeDigest(base, ref, testHashes[ref]))
+	}
+
+	for _, jobs := range []int{0, 1, 2, len(refs) + 1} {
+		t.Run(fmt.Sprintf("jobs=%d", jobs), func(t *testing.T) {
+			// Each file builds its own image, so no more builds can
+			// run at once than files are resolved at once.
+			builder := &concurrentBuild{Interface: testBuilder}
+			buf := bytes.NewBuffer(nil)
+			if err := resolveFilesToWriter(
+				context.Background(),
+				builder,
+				kotesting.NewFixedPublish(base, testHashes),
+				&options.FilenameOptions{
+					Filenames:          filenames,
+					ConcurrentResolves: jobs,
+				},
+				&options.SelectorOptions{},
+				nil,
+				nopWriteCloser{buf},
+				nil); err != nil {
+				t.Fatalf("resolveFilesToWriter() = %v", err)
+			}
+
+			var got []string
+			decoder := yaml.NewDecoder(buf)
+			for {
+				var output string
+				if err := decoder.Decode(&output); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("yaml.Decode() = %v", err)
+				}
+				// Each file is followed by a "---", which produces a final empty document.
+				if output != "" {
+					got = append(got, output)
+				}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("resolveFilesToWriter() (-want +got) = %v", diff)
+			}
+			if got := atomic.LoadInt32(&builder.max); jobs > 0 && int(got) > jobs {
+				t.Errorf("resolveFilesToWriter() resolved %d files at once, wanted at most %d", got, jobs)
+			}
+		})
+	}
+}
+
+// gatedBuild blocks builds of gated until release is closed.
+type gatedBuild struct {
+	build.Interface
+	gated   string
+	release chan struct{}
+}
+
+func (g *gatedBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	if strings.TrimPrefix(s, build.StrictScheme) == g.gated {
+		<-g.release
+	}
+	return g.Interface.Build(ctx, s)
+}
+
+// firstWriter closes first when it is first written to.
+type firstWriter struct {
+	bytes.Buffer
+	first chan struct{}
+	once  sync.Once
+}
+
+func (w *firstWriter) Write(b []byte) (int, error) {
+	defer w.once.Do(func() { close(w.first) })
+	return w.Buffer.Write(b)
+}
+
+func (w *firstWriter) Close() error { return nil }
+
+func TestResolveFilesToWriterStream(t *testing.T) {
+	base := mustRepository("gcr.io/multi-pass")
+	out := &firstWriter{first: make(chan struct{})}
+
+	// foo can't finish building until bar has been written, so this only
+	// completes if bar isn't stuck behind foo.
+	builder, err := build.NewCaching(&gatedBuild{
+		Interface: testBuilder,
+		gated:     fooRef,
+		release:   out.first,
+	})
+	if err != nil {
+		t.Fatalf("NewCaching() = %v", err)
+	}
+
+	filenames := []string{
+		yamlToTmpFile(t, []byte(build.StrictScheme+fooRef)),
+		yamlToTmpFile(t, []byte(build.StrictScheme+barRef)),
+	}
+	if err := resolveFilesToWriter(
+		context.Background(),
+		builder,
+		kotesting.NewFixedPublish(base, testHashes),
+		&options.FilenameOptions{
+			Filenames: filenames,
+			Stream:    true,
+		},
+		&options.SelectorOptions{},
+		nil,
+		out,
+		nil); err != nil {
+		t.Fatalf("resolveFilesToWriter() = %v", err)
+	}
+
+	var got []string
+	decoder := yaml.NewDecoder(&out.Buffer)
+	for {
+		var s string
+		if err := decoder.Decode(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode() = %v", err)
+		}
+		if s != "" {
+			got = append(got, s)
+		}
+	}
+	want := []string{
+		kotesting.ComputeDigest(base, barRef, testHashes[barRef]),
+		kotesting.ComputeDigest(base, fooRef, testHashes[fooRef]),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("resolveFilesToWriter() (-want +got) = %v", diff)
+	}
+}
+
 func mustRepository(s string) name.Repository {
 	n, err := name.NewRepository(s)
 	if err != nil {
@@ -176,3 +343,120 @@ func yamlToTmpFile(t *testing.T, yaml []byte) string {
 
 	return tmpfile.Name()
 }
+
+func TestMakeBuilderCaching(t *testing.T) {
+	ctx := context.Background()
+	for _, bo := range []*options.BuildOptions{
+		{ConcurrentBuilds: 1},
+		{ConcurrentBuilds: 0},
+		{ConcurrentBuilds: 1, DisableCaching: true},
+	} {
+		builder, err := makeBuilder(ctx, bo)
+		if err != nil {
+			t.Fatalf("makeBuilder() = %v", err)
+		}
+		if _, got := builder.(*build.Caching); got == bo.DisableCaching {
+			t.Errorf("makeBuilder(%+v) caching = %v", bo, got)
+		}
+	}
+}
+
+func TestDigestOnlyConflicts(t *testing.T) {
+	defer func(old string) { os.Setenv("KO_DOCKER_REPO", old) }(os.Getenv("KO_DOCKER_REPO"))
+	os.Setenv("KO_DOCKER_REPO", "registry.example.com/ko")
+
+	// The daemon and kind need tags to find images by.
+	if _, err := makePublisher(&options.PublishOptions{DigestOnly: true, Local: true}); err == nil {
+		t.Error("makePublisher(--digest-only --local) = nil, wanted an error")
+	}
+	// --skip-unchanged looks for the tags that --digest-only doesn't push.
+	po := &options.PublishOptions{DigestOnly: true, SkipUnchanged: true, Push: true}
+	if err := lookupUnchanged(&options.BuildOptions{}, po); err == nil {
+		t.Error("lookupUnchanged(--digest-only --skip-unchanged) = nil, wanted an error")
+	}
+	if _, err := makePublisher(&options.PublishOptions{DigestOnly: true, Push: true}); err != nil {
+		t.Errorf("makePublisher(--digest-only) = %v", err)
+	}
+}
+
+func TestReproducibleConflicts(t *testing.T) {
+	defer func(old string) { os.Setenv("KO_DOCKER_REPO", old) }(os.Getenv("KO_DOCKER_REPO"))
+	os.Setenv("KO_DOCKER_REPO", "registry.example.com/ko")
+
+	// Images that --skip-unchanged finds aren't built at all.
+	po := &options.PublishOptions{SkipUnchanged: true, Push: true}
+	for _, bo := range []*options.BuildOptions{{Reproducible: true}, {ReproducibleClean: true}} {
+		if err := lookupUnchanged(bo, po); err == nil {
+			t.Errorf("lookupUnchanged(%+v --skip-unchanged) = nil, wanted an error", *bo)
+		}
+	}
+}
+
+func TestLookupUnchanged(t *testing.T) {
+	defer func(old string) { os.Setenv("KO_DOCKER_REPO", old) }(os.Getenv("KO_DOCKER_REPO"))
+
+	for _, tc := range []struct {
+		desc     string
+		repo     string
+		po       options.PublishOptions
+		wantErr  bool
+		wantFind bool
+	}{
+		{desc: "not set", repo: "registry.example.com/ko", po: options.PublishOptions{Push: true}},
+		{desc: "registry", repo: "registry.example.com/ko", po: options.PublishOptions{SkipUnchanged: true, Push: true}, wantFind: true},
+		{desc: "no push", repo: "registry.example.com/ko", po: options.PublishOptions{SkipUnchanged: true}, wantErr: true},
+		{desc: "bucket", repo: "registry.example.com/ko", po: options.PublishOptions{SkipUnchanged: true, Push: true, Bucket: "file:///tmp/ko"}, wantErr: true},
+		{desc: "local", repo: "registry.example.com/ko", po: options.PublishOptions{SkipUnchanged: true, Push: true, Local: true}, wantErr: true},
+		{desc: "port-forward", repo: "registry.example.com/ko", po: options.PublishOptions{SkipUnchanged: true, Push: true, PortForward: true}, wantErr: true},
+		{desc: "ko.local", repo: "ko.local", po: options.PublishOptions{SkipUnchanged: true, Push: true}, wantErr: true},
+		{desc: "kind", repo: "kind.local", po: options.PublishOptions{SkipUnchanged: true, Push: true}, wantErr: true},
+		{desc: "no repo", po: options.PublishOptions{SkipUnchanged: true, Push: true}, wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			os.Setenv("KO_DOCKER_REPO", tc.repo)
+			bo := &options.BuildOptions{}
+			err := lookupUnchanged(bo, &tc.po)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("lookupUnchanged() = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got := bo.Lookup != nil; got != tc.wantFind {
+				t.Errorf("lookupUnchanged() set Lookup = %v, want %v", got, tc.wantFind)
+			}
+		})
+	}
+}
+
+func TestMakePublisherBucket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-bucket")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { os.Setenv("KO_DOCKER_REPO", old) }(os.Getenv("KO_DOCKER_REPO"))
+	os.Setenv("KO_DOCKER_REPO", "registry.example.com/ko")
+
+	pub, err := makePublisher(&options.PublishOptions{Push: true, Bucket: "file://" + dir, Bare: true, DisableCaching: true, Tags: []string{"latest"}})
+	if err != nil {
+		t.Fatalf("makePublisher() = %v", err)
+	}
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	ref, err := pub.Publish(context.Background(), img, "ko://github.com/foo/bar")
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got, want := ref.String(), "registry.example.com/ko@"+h.String(); got != want {
+		t.Errorf("Publish() = %v, wanted %v", got, want)
+	}
+	for _, f := range []string{"manifests/latest", "manifests/" + h.String()} {
+		if _, err := os.Stat(filepath.Join(dir, "v2", "ko", filepath.FromSlash(f))); err != nil {
+			t.Errorf("Stat(%s) = %v", f, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// rolloutTarget is a workload whose rollout we can wait on.
+type rolloutTarget struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (t rolloutTarget) String() string {
+	return strings.ToLower(t.Kind) + "/" + t.Name
+}
+
+// rolloutKinds are the kinds that "kubectl rollout status" understands.
+var rolloutKinds = map[string]bool{
+	"Deployment":  true,
+	"StatefulSet": true,
+	"DaemonSet":   true,
+}
+
+// rolloutTargets returns the workloads in the given yaml stream, in order.
+func rolloutTargets(r io.Reader) ([]rolloutTarget, error) {
+	var targets []rolloutTarget
+	decoder := yaml.NewDecoder(r)
+	for {
+		var obj struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				return targets, nil
+			}
+			return nil, err
+		}
+		if !rolloutKinds[obj.Kind] || obj.Metadata.Name == "" {
+			continue
+		}
+		targets = append(targets, rolloutTarget{
+			Kind:      obj.Kind,
+			Namespace: obj.Metadata.Namespace,
+			Name:      obj.Metadata.Name,
+		})
+	}
+}
+
+// waitForRollouts runs "kubectl rollout status" for each target, passing
+// through kubectlFlags, and returns an error if any rollout fails or does not
+// finish within timeout.
+func waitForRollouts(ctx context.Context, targets []rolloutTarget, kubectlFlags []string, timeout time.Duration) error {
+	for _, t := range targets {
+		argv := []string{"rollout", "status", t.String(), "--timeout", timeout.String()}
+		argv = append(argv, kubectlFlags...)
+		if t.Namespace != "" {
+			// This comes last so that it wins over any --namespace flag.
+			argv = append(argv, "--namespace", t.Namespace)
+		}
+
+		log.Printf("Waiting for rollout of %s", t)
+		kubectlCmd := exec.CommandContext(ctx, "kubectl", argv...)
+		kubectlCmd.Env = os.Environ()
+		kubectlCmd.Stderr = os.Stderr
+		kubectlCmd.Stdout = os.Stderr
+		if err := kubectlCmd.Run(); err != nil {
+			return fmt.Errorf("rollout of %s did not complete: %v", t, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRolloutTargets(t *testing.T) {
+	input := `---
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+---
+`
+	got, err := rolloutTargets(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("rolloutTargets() = %v", err)
+	}
+	want := []rolloutTarget{{
+		Kind:      "Deployment",
+		Namespace: "prod",
+		Name:      "web",
+	}, {
+		Kind: "DaemonSet",
+		Name: "agent",
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rolloutTargets() (-want +got) = %v", diff)
+	}
+	if got, want := got[0].String(), "deployment/web"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
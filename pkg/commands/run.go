@@ -46,6 +46,7 @@ func addRun(topLevel *cobra.Command) {
 
   # You can also supply args and flags to the command.
   ko run ./cmd/baz -- -v arg1 arg2 --yes`,
+		ValidArgsFunction: completeFirstImportPath,
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := createCancellableContext()
 
@@ -65,10 +66,14 @@ func addRun(topLevel *cobra.Command) {
 				kubectlArgs = os.Args[dashes:]
 			}
 
+			if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
 			builder, err := makeBuilder(ctx, bo)
 			if err != nil {
 				log.Fatalf("error creating builder: %v", err)
 			}
+			po.WorkDir = bo.WorkDir
 			publisher, err := makePublisher(po)
 			if err != nil {
 				log.Fatalf("error creating publisher: %v", err)
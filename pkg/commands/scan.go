@@ -0,0 +1,363 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+)
+
+// severities are the severities that scanners report, from least to most
+// severe.
+var severities = []string{"UNKNOWN", "NEGLIGIBLE", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// severityRank returns the position of s in severities, or -1.
+func severityRank(s string) int {
+	s = strings.ToUpper(s)
+	for i, sev := range severities {
+		if sev == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// vulnerability is a finding of a scanner, in either the base image or the
+// Go dependencies of the binary.
+type vulnerability struct {
+	ID           string
+	Package      string
+	Version      string
+	FixedVersion string
+	Severity     string
+	// Platform is set for images of a multi-platform index.
+	Platform string
+}
+
+func (v vulnerability) String() string {
+	s := fmt.Sprintf("%s (%s) in %s %s", v.ID, v.Severity, v.Package, v.Version)
+	if v.FixedVersion != "" {
+		s += ", fixed in " + v.FixedVersion
+	}
+	if v.Platform != "" {
+		s += " [" + v.Platform + "]"
+	}
+	return s
+}
+
+// errVulnerable is what vulnerabilityErrors unwrap to.
+var errVulnerable = errors.New("image has vulnerabilities")
+
+// vulnerabilityError is returned instead of publishing an image with
+// vulnerabilities at or above the --scan-severity threshold.
+type vulnerabilityError struct {
+	Threshold string
+	Found     []vulnerability
+}
+
+func (e *vulnerabilityError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "found %d vulnerabilities with severity %s or higher:", len(e.Found), e.Threshold)
+	for _, v := range e.Found {
+		b.WriteString("\n  ")
+		b.WriteString(v.String())
+	}
+	return b.String()
+}
+
+func (e *vulnerabilityError) Unwrap() error {
+	return errVulnerable
+}
+
+// scanner scans the only image in the OCI image layout at path.
+type scanner func(ctx context.Context, path string) ([]vulnerability, error)
+
+// newScanner returns the scanner for --scan.
+func newScanner(tool string) (scanner, error) {
+	switch tool {
+	case "trivy":
+		return scanTrivy, nil
+	case "grype":
+		return scanGrype, nil
+	default:
+		return nil, fmt.Errorf("unknown --scan tool %q, expected trivy or grype", tool)
+	}
+}
+
+func scanTrivy(ctx context.Context, path string) ([]vulnerability, error) {
+	out, err := runScanner(ctx, "trivy", "image", "--quiet", "--format", "json", "--input", path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTrivy(out)
+}
+
+func scanGrype(ctx context.Context, path string) ([]vulnerability, error) {
+	out, err := runScanner(ctx, "grype", "oci-dir:"+path, "--quiet", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	return parseGrype(out)
+}
+
+func runScanner(ctx context.Context, tool string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %v: %s", tool, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// parseTrivy parses the output of trivy image --format json.
+func parseTrivy(b []byte) ([]vulnerability, error) {
+	var report struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID  string
+				PkgName          string
+				InstalledVersion string
+				FixedVersion     string
+				Severity         string
+			}
+		}
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("parsing trivy output: %v", err)
+	}
+	var vulns []vulnerability
+	for _, r := range report.Results {
+		for _, v := range r.Vulnerabilities {
+			vulns = append(vulns, vulnerability{
+				ID:           v.VulnerabilityID,
+				Package:      v.PkgName,
+				Version:      v.InstalledVersion,
+				FixedVersion: v.FixedVersion,
+				Severity:     strings.ToUpper(v.Severity),
+			})
+		}
+	}
+	return vulns, nil
+}
+
+// parseGrype parses the output of grype --output json.
+func parseGrype(b []byte) ([]vulnerability, error) {
+	var report struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+				Fix      struct {
+					Versions []string `json:"versions"`
+				} `json:"fix"`
+			} `json:"vulnerability"`
+			Artifact struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"artifact"`
+		} `json:"matches"`
+	}
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, fmt.Errorf("parsing grype output: %v", err)
+	}
+	var vulns []vulnerability
+	for _, m := range report.Matches {
+		vulns = append(vulns, vulnerability{
+			ID:           m.Vulnerability.ID,
+			Package:      m.Artifact.Name,
+			Version:      m.Artifact.Version,
+			FixedVersion: strings.Join(m.Vulnerability.Fix.Versions, ", "),
+			Severity:     strings.ToUpper(m.Vulnerability.Severity),
+		})
+	}
+	return vulns, nil
+}
+
+// scanningPublisher scans images before publishing them, and refuses to
+// publish those with vulnerabilities at or above threshold.
+type scanningPublisher struct {
+	inner     publish.Interface
+	scan      scanner
+	threshold string
+	// workDir is where dir is created, the default directory for
+	// temporary files if it's empty.
+	workDir string
+
+	// Scanners lock their vulnerability databases, so scan one image at a
+	// time.
+	m sync.Mutex
+	// dir is the layout that images are scanned in, created by the first
+	// scan.
+	dir string
+}
+
+// newScanningPublisher wraps inner to scan images with --scan, if it is set.
+func newScanningPublisher(inner publish.Interface, po *options.PublishOptions) (publish.Interface, error) {
+	if po.Scan == "" {
+		return inner, nil
+	}
+	scan, err := newScanner(po.Scan)
+	if err != nil {
+		return nil, err
+	}
+	threshold := strings.ToUpper(po.ScanSeverity)
+	if threshold == "" {
+		threshold = "HIGH"
+	}
+	if severityRank(threshold) < 0 {
+		return nil, fmt.Errorf("unknown --scan-severity %q, expected one of %s", po.ScanSeverity, strings.ToLower(strings.Join(severities, ", ")))
+	}
+	return &scanningPublisher{inner: inner, scan: scan, threshold: threshold, workDir: po.WorkDir}, nil
+}
+
+// Publish implements publish.Interface
+func (s *scanningPublisher) Publish(ctx context.Context, br build.Result, ref string) (name.Reference, error) {
+	imgs, err := scannableImages(br)
+	if err != nil {
+		return nil, err
+	}
+	var found []vulnerability
+	for _, pi := range imgs {
+		vulns, err := s.scanImage(ctx, pi.img)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vulns {
+			if severityRank(v.Severity) >= severityRank(s.threshold) {
+				v.Platform = pi.platform
+				found = append(found, v)
+			}
+		}
+	}
+	if len(found) != 0 {
+		sort.SliceStable(found, func(i, j int) bool {
+			return severityRank(found[i].Severity) > severityRank(found[j].Severity)
+		})
+		return nil, &vulnerabilityError{Threshold: s.threshold, Found: found}
+	}
+	return s.inner.Publish(ctx, br, ref)
+}
+
+// Close implements publish.Interface
+func (s *scanningPublisher) Close() error {
+	s.m.Lock()
+	if s.dir != "" {
+		os.RemoveAll(s.dir)
+		s.dir = ""
+	}
+	s.m.Unlock()
+	return s.inner.Close()
+}
+
+func (s *scanningPublisher) scanImage(ctx context.Context, img v1.Image) ([]vulnerability, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.dir == "" {
+		dir, err := ioutil.TempDir(s.workDir, "ko-scan-")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+	}
+	vulns, err := scanImage(ctx, s.scan, img, s.dir)
+	if err != nil {
+		// A blob that was cut short would be taken as written by the
+		// next scan.
+		os.RemoveAll(s.dir)
+		s.dir = ""
+	}
+	return vulns, err
+}
+
+// scanImage scans img with scan, by way of the OCI image layout in dir. The
+// blobs of images scanned in dir before stay there, so that the layers images
+// share, like those of their base image, are only fetched once.
+func scanImage(ctx context.Context, scan scanner, img v1.Image, dir string) ([]vulnerability, error) {
+	// Only list img in the layout, so that it's what the scanner scans.
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: img})
+	if _, err := layout.Write(dir, idx); err != nil {
+		return nil, err
+	}
+	return scan(ctx, dir)
+}
+
+// platformImage is an image to scan, and the platform it is for when it is
+// part of an index.
+type platformImage struct {
+	platform string
+	img      v1.Image
+}
+
+// scannableImages returns the images in br, skipping artifacts.
+func scannableImages(br build.Result) ([]platformImage, error) {
+	if img, ok := br.(v1.Image); ok {
+		if artifact, err := build.IsArtifact(img); err != nil || artifact {
+			return nil, err
+		}
+		return []platformImage{{img: img}}, nil
+	}
+	idx, ok := br.(v1.ImageIndex)
+	if !ok {
+		return nil, fmt.Errorf("unexpected build result %T", br)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var imgs []platformImage
+	for _, desc := range im.Manifests {
+		if !desc.MediaType.IsImage() {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if artifact, err := build.IsArtifact(img); err != nil {
+			return nil, err
+		} else if artifact {
+			continue
+		}
+		pi := platformImage{img: img}
+		if desc.Platform != nil {
+			pi.platform = desc.Platform.OS + "/" + desc.Platform.Architecture
+			if desc.Platform.Variant != "" {
+				pi.platform += "/" + desc.Platform.Variant
+			}
+		}
+		imgs = append(imgs, pi)
+	}
+	return imgs, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestParseTrivy(t *testing.T) {
+	out := `{"Results": [{"Target": "ko-app/app", "Vulnerabilities": [
+		{"VulnerabilityID": "CVE-2021-1", "PkgName": "golang.org/x/text", "InstalledVersion": "v0.3.5", "FixedVersion": "0.3.7", "Severity": "HIGH"}
+	]}, {"Target": "debian", "Vulnerabilities": null}]}`
+	got, err := parseTrivy([]byte(out))
+	if err != nil {
+		t.Fatalf("parseTrivy() = %v", err)
+	}
+	want := []vulnerability{{
+		ID:           "CVE-2021-1",
+		Package:      "golang.org/x/text",
+		Version:      "v0.3.5",
+		FixedVersion: "0.3.7",
+		Severity:     "HIGH",
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseTrivy() (-want +got) = %v", diff)
+	}
+}
+
+func TestParseGrype(t *testing.T) {
+	out := `{"matches": [{
+		"vulnerability": {"id": "CVE-2021-2", "severity": "Critical", "fix": {"versions": ["1.1.1l"]}},
+		"artifact": {"name": "openssl", "version": "1.1.1k"}
+	}]}`
+	got, err := parseGrype([]byte(out))
+	if err != nil {
+		t.Fatalf("parseGrype() = %v", err)
+	}
+	want := []vulnerability{{
+		ID:           "CVE-2021-2",
+		Package:      "openssl",
+		Version:      "1.1.1k",
+		FixedVersion: "1.1.1l",
+		Severity:     "CRITICAL",
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseGrype() (-want +got) = %v", diff)
+	}
+}
+
+func TestScanningPublisher(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	vulns := []vulnerability{
+		{ID: "CVE-2021-1", Severity: "MEDIUM"},
+		{ID: "CVE-2021-2", Severity: "CRITICAL"},
+	}
+	workDir, err := ioutil.TempDir("", "ko-work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	var scanned string
+	scan := func(_ context.Context, path string) ([]vulnerability, error) {
+		scanned = path
+		idx, err := layout.ImageIndexFromPath(path)
+		if err != nil {
+			t.Fatalf("ImageIndexFromPath() = %v", err)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			t.Fatalf("IndexManifest() = %v", err)
+		}
+		want, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(im.Manifests) != 1 || im.Manifests[0].Digest != want {
+			t.Errorf("scanned layout lists %v, want only %v", im.Manifests, want)
+		}
+		return vulns, nil
+	}
+	inner := nopPublisher{repoName: "ko.local", namer: options.MakeNamer(&options.PublishOptions{})}
+
+	for _, tc := range []struct {
+		threshold string
+		want      []vulnerability
+	}{{
+		threshold: "CRITICAL",
+		want:      vulns[1:],
+	}, {
+		threshold: "LOW",
+		want:      []vulnerability{vulns[1], vulns[0]},
+	}} {
+		t.Run(tc.threshold, func(t *testing.T) {
+			p := &scanningPublisher{inner: inner, scan: scan, threshold: tc.threshold, workDir: workDir}
+			defer p.Close()
+			_, err := p.Publish(context.Background(), img, "github.com/foo/bar")
+			var ve *vulnerabilityError
+			if !errors.As(err, &ve) {
+				t.Fatalf("Publish() = %v, want vulnerabilityError", err)
+			}
+			if diff := cmp.Diff(tc.want, ve.Found); diff != "" {
+				t.Errorf("Publish() found (-want +got) = %v", diff)
+			}
+			if !errors.Is(err, errVulnerable) {
+				t.Errorf("errors.Is(%v, errVulnerable) = false", err)
+			}
+			if filepath.Dir(scanned) != workDir {
+				t.Errorf("scanned %s, want it in %s", scanned, workDir)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+			if _, err := os.Stat(scanned); !os.IsNotExist(err) {
+				t.Errorf("Close() left %s behind", scanned)
+			}
+		})
+	}
+
+	p := &scanningPublisher{inner: inner, scan: func(context.Context, string) ([]vulnerability, error) {
+		return vulns[:1], nil
+	}, threshold: "HIGH"}
+	defer p.Close()
+	if _, err := p.Publish(context.Background(), img, "github.com/foo/bar"); err != nil {
+		t.Errorf("Publish() = %v", err)
+	}
+}
+
+func TestNewScanningPublisher(t *testing.T) {
+	inner := nopPublisher{}
+	for _, po := range []*options.PublishOptions{
+		{Scan: "clair"},
+		{Scan: "trivy", ScanSeverity: "severe"},
+	} {
+		if _, err := newScanningPublisher(inner, po); err == nil {
+			t.Errorf("newScanningPublisher(%+v) = nil, want error", po)
+		}
+	}
+	if p, err := newScanningPublisher(inner, &options.PublishOptions{}); err != nil {
+		t.Errorf("newScanningPublisher() = %v", err)
+	} else if _, ok := p.(*scanningPublisher); ok {
+		t.Error("newScanningPublisher() scans without --scan")
+	}
+}
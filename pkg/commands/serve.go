@@ -0,0 +1,218 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// addServe augments our CLI surface with serve.
+func addServe(topLevel *cobra.Command) {
+	po := &options.PublishOptions{}
+	bo := &options.BuildOptions{}
+	co := &options.CacheOptions{}
+	var addr, debugAddr string
+
+	serve := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an HTTP API to publish import paths and resolve yaml.",
+		Long: `This sub-command serves ko's publish and resolve functionality over HTTP, so that CI systems and internal platforms can call a long-running ko that has already loaded the module and shares its publish cache, instead of starting a new process for every request.
+
+POST /v1/publish with {"importPaths": [...]} publishes the import paths and responds with {"images": {"<import path>": "<reference>"}}.
+
+POST /v1/resolve with a yaml body (and an optional "selector" query parameter) responds with the resolved yaml.
+
+GET /metrics responds with Prometheus metrics of builds, the build cache and publishes. With --debug-addr, profiles of ko itself are served under /debug/pprof/ on that address, and not on the API's.
+
+Every request builds its import paths afresh; concurrent requests for the same import path are not coalesced. Request bodies are limited to 10MiB. There is no gRPC API.
+
+With --cache-backend, the metadata of base images (and, for a directory, their layers) persists in a volume or a registry repository, so that a restarted server doesn't fetch them all again.`,
+		Example: `
+  # Serve on :8080, and publish an import path.
+  ko serve --addr=:8080 &
+  curl -d '{"importPaths": ["./cmd/app"]}' localhost:8080/v1/publish
+
+  # Resolve yaml.
+  curl --data-binary @config/deployment.yaml localhost:8080/v1/resolve`,
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			ctx := createCancellableContext()
+			if err := useCacheBackend(co.Backend); err != nil {
+				log.Fatal(err)
+			}
+			if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			builder, err := makeUncachedBuilder(ctx, bo)
+			if err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			po.WorkDir = bo.WorkDir
+			publisher, err := makePublisher(po)
+			if err != nil {
+				log.Fatalf("error creating publisher: %v", err)
+			}
+			defer publisher.Close()
+
+			srv := &http.Server{Addr: addr, Handler: newServer(ctx, builder, publisher)}
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			if debugAddr != "" {
+				defer serveProfiles(debugAddr).Close()
+			}
+			log.Printf("Serving on %s", addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		},
+	}
+	options.AddPublishArg(serve, po)
+	options.AddBuildOptions(serve, bo)
+	options.AddCacheArg(serve, co)
+	serve.Flags().StringVar(&addr, "addr", ":8080",
+		"Address to serve the API on.")
+	scopeFlag(serve, "addr")
+	serve.Flags().StringVar(&debugAddr, "debug-addr", "",
+		"Address to serve profiles of ko on, under /debug/pprof/. Profiles aren't served unless this is set.")
+	topLevel.AddCommand(serve)
+}
+
+// server handles the requests of ko serve.
+type server struct {
+	// ctx bounds the work of every request, so that one request going
+	// away doesn't cancel work that another one shares.
+	ctx       context.Context
+	builder   build.Interface
+	publisher publish.Interface
+}
+
+func newServer(ctx context.Context, builder build.Interface, publisher publish.Interface) http.Handler {
+	s := &server{ctx: ctx, builder: builder, publisher: publisher}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/publish", s.publish)
+	mux.HandleFunc("/v1/resolve", s.resolve)
+	mux.Handle("/metrics", koMetrics.registry)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// maxRequestBytes bounds the body of a request, so that a client can't make
+// ko serve hold an arbitrary amount of yaml in memory.
+const maxRequestBytes = 10 << 20
+
+type publishRequest struct {
+	ImportPaths []string `json:"importPaths"`
+}
+
+type publishResponse struct {
+	Images map[string]string `json:"images"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// requestBuilder returns a builder for a single request, which shares the
+// builds of import paths within the request.
+func (s *server) requestBuilder() (*build.Caching, error) {
+	c, err := build.NewCaching(s.builder)
+	if err != nil {
+		return nil, err
+	}
+	koMetrics.meterCache(c)
+	return c, nil
+}
+
+func (s *server) publish(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	var req publishRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("parsing request: %v", err)})
+		return
+	}
+	builder, err := s.requestBuilder()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	imgs, err := publishImages(s.ctx, req.ImportPaths, s.publisher, builder)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	resp := publishResponse{Images: make(map[string]string, len(imgs))}
+	for importpath, ref := range imgs {
+		resp.Images[importpath] = ref.String()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) resolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	var selector labels.Selector
+	if q := r.URL.Query().Get("selector"); q != "" {
+		var err error
+		if selector, err = labels.Parse(q); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("unable to parse selector: %v", err)})
+			return
+		}
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	builder, err := s.requestBuilder()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	resolved, err := resolveBytes(s.ctx, b, builder, s.publisher, selector, nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(resolved)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
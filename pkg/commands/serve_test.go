@@ -0,0 +1,135 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestServer(t *testing.T) {
+	base := mustRepository("gcr.io/serve")
+	srv := httptest.NewServer(newServer(context.Background(), testBuilder, kotesting.NewFixedPublish(base, testHashes)))
+	defer srv.Close()
+
+	t.Run("publish", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/publish", "application/json",
+			strings.NewReader(`{"importPaths": ["`+fooRef+`", "`+barRef+`"]}`))
+		if err != nil {
+			t.Fatalf("Post() = %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Post() = %s", resp.Status)
+		}
+		var got publishResponse
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("Decode() = %v", err)
+		}
+		want := publishResponse{Images: map[string]string{
+			build.StrictScheme + fooRef: kotesting.ComputeDigest(base, fooRef, fooHash),
+			build.StrictScheme + barRef: kotesting.ComputeDigest(base, barRef, barHash),
+		}}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("publish (-want +got) = %s", diff)
+		}
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/resolve", "application/yaml",
+			strings.NewReader("image: "+build.StrictScheme+fooRef+"\n"))
+		if err != nil {
+			t.Fatalf("Post() = %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Post() = %s", resp.Status)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("ReadAll() = %v", err)
+		}
+		want := "image: " + kotesting.ComputeDigest(base, fooRef, fooHash) + "\n"
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("resolve (-want +got) = %s", diff)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/metrics")
+		if err != nil {
+			t.Fatalf("Get() = %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Get() = %s", resp.Status)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("ReadAll() = %v", err)
+		}
+		if want := `ko_build_cache_lookups_total{result="miss"}`; !strings.Contains(string(got), want) {
+			t.Errorf("/metrics = %s, wanted it to contain %s", got, want)
+		}
+	})
+
+	t.Run("no profiles", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/debug/pprof/")
+		if err != nil {
+			t.Fatalf("Get() = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Get(/debug/pprof/) = %s, want %d", resp.Status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("request too large", func(t *testing.T) {
+		body := "image: " + build.StrictScheme + fooRef + "\n# " + strings.Repeat("x", maxRequestBytes) + "\n"
+		resp, err := http.Post(srv.URL+"/v1/resolve", "application/yaml", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Post() = %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Post() = %s, want %d", resp.Status, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/v1/publish", "application/json",
+			strings.NewReader(`{"importPaths": ["github.com/awesomesauce/missing"]}`))
+		if err != nil {
+			t.Fatalf("Post() = %v", err)
+		}
+		defer resp.Body.Close()
+		var got errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("Decode() = %v", err)
+		}
+		if resp.StatusCode == http.StatusOK || got.Error == "" {
+			t.Errorf("Post() = %s %+v, wanted an error", resp.Status, got)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// resolveState is what a resolve produced: the reference each import path
+// was published as, and the import paths referenced by each input file.
+type resolveState struct {
+	Images map[string]string   `json:"images"`
+	Files  map[string][]string `json:"files"`
+}
+
+// stateRecorder collects a resolveState while resolving.
+type stateRecorder struct {
+	m     sync.Mutex
+	state resolveState
+}
+
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{
+		state: resolveState{
+			Images: make(map[string]string),
+			Files:  make(map[string][]string),
+		},
+	}
+}
+
+func (r *stateRecorder) recordFile(f string, importpaths []string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.state.Files[f] = importpaths
+}
+
+func (r *stateRecorder) recordImage(importpath string, ref name.Reference) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.state.Images[importpath] = ref.String()
+}
+
+// Publisher wraps inner so that every published reference is recorded.
+func (r *stateRecorder) Publisher(inner publish.Interface) publish.Interface {
+	return &recordingPublisher{inner: inner, r: r}
+}
+
+// State returns a copy of the recorded state.
+func (r *stateRecorder) State() *resolveState {
+	r.m.Lock()
+	defer r.m.Unlock()
+	s := &resolveState{
+		Images: make(map[string]string, len(r.state.Images)),
+		Files:  make(map[string][]string, len(r.state.Files)),
+	}
+	for k, v := range r.state.Images {
+		s.Images[k] = v
+	}
+	for k, v := range r.state.Files {
+		s.Files[k] = append([]string(nil), v...)
+	}
+	return s
+}
+
+type recordingPublisher struct {
+	inner publish.Interface
+	r     *stateRecorder
+}
+
+// Publish implements publish.Interface
+func (p *recordingPublisher) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	ref, err := p.inner.Publish(ctx, br, s)
+	if err != nil {
+		return nil, err
+	}
+	p.r.recordImage(s, ref)
+	return ref, nil
+}
+
+// Close implements publish.Interface
+func (p *recordingPublisher) Close() error {
+	return p.inner.Close()
+}
+
+// readState reads a resolveState from path. A missing file is an empty state.
+func readState(path string) (*resolveState, error) {
+	s := &resolveState{
+		Images: make(map[string]string),
+		Files:  make(map[string][]string),
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func writeState(path string, s *resolveState) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// stateDiff describes how two resolveStates differ.
+type stateDiff struct {
+	Changed   []string
+	Unchanged []string
+	Added     []string
+	Removed   []string
+	// Affected are the files that reference a changed or added import path.
+	Affected []string
+}
+
+func diffStates(prev, cur *resolveState) *stateDiff {
+	d := &stateDiff{}
+	changed := make(map[string]bool)
+	for ip, ref := range cur.Images {
+		old, ok := prev.Images[ip]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, ip)
+			changed[ip] = true
+		case old != ref:
+			d.Changed = append(d.Changed, ip)
+			changed[ip] = true
+		default:
+			d.Unchanged = append(d.Unchanged, ip)
+		}
+	}
+	for ip := range prev.Images {
+		if _, ok := cur.Images[ip]; !ok {
+			d.Removed = append(d.Removed, ip)
+		}
+	}
+	for f, ips := range cur.Files {
+		// A file that wasn't part of the previous resolve is affected too.
+		_, seen := prev.Files[f]
+		affected := !seen
+		for _, ip := range ips {
+			if changed[ip] {
+				affected = true
+				break
+			}
+		}
+		if affected {
+			d.Affected = append(d.Affected, f)
+		}
+	}
+	sort.Strings(d.Changed)
+	sort.Strings(d.Unchanged)
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Affected)
+	return d
+}
+
+// Empty returns true if nothing changed.
+func (d *stateDiff) Empty() bool {
+	return len(d.Changed)+len(d.Added)+len(d.Removed)+len(d.Affected) == 0
+}
+
+func (d *stateDiff) Write(w io.Writer) {
+	for _, ip := range d.Changed {
+		fmt.Fprintf(w, "changed    %s\n", ip)
+	}
+	for _, ip := range d.Added {
+		fmt.Fprintf(w, "added      %s\n", ip)
+	}
+	for _, ip := range d.Removed {
+		fmt.Fprintf(w, "removed    %s\n", ip)
+	}
+	for _, ip := range d.Unchanged {
+		fmt.Fprintf(w, "unchanged  %s\n", ip)
+	}
+	for _, f := range d.Affected {
+		fmt.Fprintf(w, "affected   %s\n", f)
+	}
+	if d.Empty() {
+		fmt.Fprintln(w, "no changes")
+	}
+}
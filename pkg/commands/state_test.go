@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffStates(t *testing.T) {
+	prev := &resolveState{
+		Images: map[string]string{
+			"ko://foo": "gcr.io/x/foo@sha256:1",
+			"ko://bar": "gcr.io/x/bar@sha256:2",
+			"ko://baz": "gcr.io/x/baz@sha256:3",
+		},
+		Files: map[string][]string{
+			"a.yaml": {"ko://foo"},
+			"b.yaml": {"ko://bar"},
+			"c.yaml": {"ko://baz"},
+		},
+	}
+	cur := &resolveState{
+		Images: map[string]string{
+			"ko://foo": "gcr.io/x/foo@sha256:changed",
+			"ko://bar": "gcr.io/x/bar@sha256:2",
+			"ko://qux": "gcr.io/x/qux@sha256:4",
+		},
+		Files: map[string][]string{
+			"a.yaml": {"ko://foo"},
+			"b.yaml": {"ko://bar"},
+			"d.yaml": {"ko://bar", "ko://qux"},
+		},
+	}
+
+	want := &stateDiff{
+		Changed:   []string{"ko://foo"},
+		Unchanged: []string{"ko://bar"},
+		Added:     []string{"ko://qux"},
+		Removed:   []string{"ko://baz"},
+		Affected:  []string{"a.yaml", "d.yaml"},
+	}
+	got := diffStates(prev, cur)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diffStates() (-want +got) = %v", diff)
+	}
+
+	if d := diffStates(cur, cur); !d.Empty() {
+		t.Errorf("diffStates(cur, cur) = %v, want empty", d)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "state.json")
+
+	// A missing file should be an empty state.
+	empty, err := readState(path)
+	if err != nil {
+		t.Fatalf("readState() = %v", err)
+	}
+	if len(empty.Images) != 0 || len(empty.Files) != 0 {
+		t.Errorf("readState() = %v, want empty", empty)
+	}
+
+	want := &resolveState{
+		Images: map[string]string{"ko://foo": "gcr.io/x/foo@sha256:1"},
+		Files:  map[string][]string{"a.yaml": {"ko://foo"}},
+	}
+	if err := writeState(path, want); err != nil {
+		t.Fatalf("writeState() = %v", err)
+	}
+	got, err := readState(path)
+	if err != nil {
+		t.Fatalf("readState() = %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readState() (-want +got) = %v", diff)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/ko/pkg/internal/humanize"
+)
+
+// writeSummary writes a table of the images in m, so that a run that built
+// many of them ends with something scannable.
+func writeSummary(w io.Writer, m *imageManifest) error {
+	if len(m.Images) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMPORT PATH\tDIGEST\tTAGS\tPLATFORMS\tSIZE\tBUILD\tPUSH\tCACHE HITS")
+	for _, rec := range m.Images {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n",
+			rec.ImportPath, shortDigest(rec.Digest), orDash(strings.Join(rec.Tags, ",")),
+			orDash(strings.Join(rec.Platforms, ",")), humanize.Bytes(rec.Size),
+			seconds(rec.BuildSeconds), seconds(rec.PushSeconds), rec.CacheHits)
+	}
+	return tw.Flush()
+}
+
+// seconds formats s seconds as a duration, or "-" if no time was recorded.
+func seconds(s float64) string {
+	if s == 0 {
+		return "-"
+	}
+	return time.Duration(s * float64(time.Second)).Round(100 * time.Millisecond).String()
+}
+
+// orDash returns s, or "-" if it's empty, so that columns aren't blank.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteSummary(t *testing.T) {
+	m := &imageManifest{Images: []imageRecord{{
+		ImportPath:   "ko://github.com/google/ko/cmd/a",
+		Digest:       "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Tags:         []string{"latest", "v1"},
+		Platforms:    []string{"linux/amd64", "linux/arm64"},
+		Size:         3 << 20,
+		BuildSeconds: 12.34,
+		PushSeconds:  1.26,
+		CacheHits:    2,
+	}, {
+		ImportPath: "ko://github.com/google/ko/cmd/b",
+		Digest:     "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210",
+		Size:       512,
+	}}}
+
+	var buf bytes.Buffer
+	if err := writeSummary(&buf, m); err != nil {
+		t.Fatalf("writeSummary() = %v", err)
+	}
+	want := `IMPORT PATH                      DIGEST               TAGS       PLATFORMS                SIZE     BUILD  PUSH  CACHE HITS
+ko://github.com/google/ko/cmd/a  sha256:0123456789ab  latest,v1  linux/amd64,linux/arm64  3.0 MiB  12.3s  1.3s  2
+ko://github.com/google/ko/cmd/b  sha256:fedcba987654  -          -                        512 B    -      -     0
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writeSummary() (-want +got): %s", diff)
+	}
+
+	// Nothing is printed when nothing was built.
+	buf.Reset()
+	if err := writeSummary(&buf, &imageManifest{}); err != nil {
+		t.Fatalf("writeSummary() = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("writeSummary() = %q, want nothing", buf.String())
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+// writeTektonResults writes the published reference of each import path in
+// to.Results to its result file. The keys of images are qualified import
+// paths, as returned by qualifyImportPath, and its values are references.
+func writeTektonResults(to *options.TektonOptions, images map[string]string) error {
+	for importpath, path := range to.Results {
+		qualified, err := qualifyImportPath(importpath)
+		if err != nil {
+			return fmt.Errorf("error qualifying %q: %v", importpath, err)
+		}
+		ref, ok := images[qualified]
+		if !ok {
+			ref, ok = images[strings.TrimPrefix(qualified, build.StrictScheme)]
+		}
+		if !ok {
+			return fmt.Errorf("%s was not published, so it has no Tekton result", importpath)
+		}
+		// Tekton trims results, so there is no need for a newline.
+		if err := ioutil.WriteFile(path, []byte(ref), 0644); err != nil {
+			return fmt.Errorf("error writing Tekton result for %s: %v", importpath, err)
+		}
+	}
+	return nil
+}
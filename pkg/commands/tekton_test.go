@@ -0,0 +1,63 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+)
+
+func TestWriteTektonResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-tekton")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	images := map[string]string{
+		build.StrictScheme + fooRef: "gcr.io/tekton/foo@" + fooHash.String(),
+		barRef:                      "gcr.io/tekton/bar@" + barHash.String(),
+	}
+	fooResult, barResult := filepath.Join(dir, "foo"), filepath.Join(dir, "bar")
+	if err := writeTektonResults(&options.TektonOptions{Results: map[string]string{
+		fooRef:                      fooResult,
+		build.StrictScheme + barRef: barResult,
+	}}, images); err != nil {
+		t.Fatalf("writeTektonResults() = %v", err)
+	}
+	for path, want := range map[string]string{
+		fooResult: images[build.StrictScheme+fooRef],
+		barResult: images[barRef],
+	} {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() = %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("result %s = %q, wanted %q", filepath.Base(path), got, want)
+		}
+	}
+
+	if err := writeTektonResults(&options.TektonOptions{Results: map[string]string{
+		"github.com/awesomesauce/missing": filepath.Join(dir, "missing"),
+	}}, images); err == nil {
+		t.Error("writeTektonResults() = nil, wanted an error for an unpublished import path")
+	}
+}
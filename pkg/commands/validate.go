@@ -0,0 +1,119 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// validateMode returns an error if mode is not a supported --validate value.
+func validateMode(mode string) error {
+	switch mode {
+	case "", "builtin", "cluster":
+		return nil
+	default:
+		return fmt.Errorf("unknown --validate mode %q, expected builtin or cluster", mode)
+	}
+}
+
+// validateDocuments validates the yaml stream b using the given mode. In
+// "cluster" mode, kubectlFlags are passed through to kubectl.
+func validateDocuments(ctx context.Context, mode string, b []byte, kubectlFlags []string) error {
+	switch mode {
+	case "":
+		return nil
+	case "builtin":
+		return validateBuiltin(bytes.NewReader(b))
+	case "cluster":
+		return validateCluster(ctx, bytes.NewReader(b), kubectlFlags)
+	default:
+		return validateMode(mode)
+	}
+}
+
+// validateBuiltin strictly decodes each document in r as one of the
+// Kubernetes types known to client-go, reporting unknown and duplicate
+// fields. Documents of kinds we don't know about (e.g. custom resources)
+// are skipped.
+func validateBuiltin(r io.Reader) error {
+	decoder := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme,
+		json.SerializerOptions{Yaml: true, Strict: true})
+
+	var problems []string
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		_, gvk, err := decoder.Decode(doc, nil, nil)
+		if err == nil || runtime.IsNotRegisteredError(err) || runtime.IsMissingKind(err) {
+			continue
+		}
+		name := fmt.Sprintf("document %d", i)
+		if gvk != nil && gvk.Kind != "" {
+			name = fmt.Sprintf("%s (%s)", name, gvk.Kind)
+		}
+		// Strict decoding errors include the entire document, which is noisy.
+		msg := err.Error()
+		if runtime.IsStrictDecodingError(err) {
+			msg = strings.SplitN(msg, "\n", 2)[0]
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", name, msg))
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("validation failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// validateCluster has kubectl validate r against the OpenAPI schema of the
+// target cluster, without creating anything.
+func validateCluster(ctx context.Context, r io.Reader, kubectlFlags []string) error {
+	if !isKubectlAvailable() {
+		return fmt.Errorf("kubectl must be installed to use --validate=cluster")
+	}
+	argv := []string{"create", "--dry-run=client", "--validate=true", "-o", "name", "-f", "-"}
+	argv = append(argv, kubectlFlags...)
+	cmd := exec.CommandContext(ctx, "kubectl", argv...)
+	cmd.Env = os.Environ()
+	cmd.Stdin = r
+	cmd.Stdout = ioutil.Discard
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("validation failed: %v\n%s", err, stderr.String())
+	}
+	return nil
+}
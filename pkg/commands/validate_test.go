@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBuiltin(t *testing.T) {
+	for _, c := range []struct {
+		desc    string
+		input   string
+		wantErr string
+	}{{
+		desc: "valid",
+		input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: foo
+        image: gcr.io/foo/bar@sha256:deadbeef
+`,
+	}, {
+		desc: "unknown kinds are skipped",
+		input: `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: foo
+spec:
+  whatever: true
+---
+`,
+	}, {
+		desc: "typo",
+		input: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ok
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+  - name: foo
+    imgae: gcr.io/foo/bar@sha256:deadbeef
+`,
+		wantErr: "document 1 (Pod)",
+	}} {
+		t.Run(c.desc, func(t *testing.T) {
+			err := validateBuiltin(strings.NewReader(c.input))
+			if c.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateBuiltin() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("validateBuiltin() = %v, want error containing %q", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	for _, mode := range []string{"", "builtin", "cluster"} {
+		if err := validateMode(mode); err != nil {
+			t.Errorf("validateMode(%q) = %v", mode, err)
+		}
+	}
+	if err := validateMode("strict"); err == nil {
+		t.Error("validateMode(strict) = nil, want error")
+	}
+}
@@ -15,29 +15,135 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
+	"os"
+	"runtime"
 	"runtime/debug"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-// Version is provided by govvv at compile-time
-var Version string
+var (
+	// Version is provided by govvv at compile-time
+	Version string
+	// Commit is the git commit ko was built from, provided at compile-time.
+	Commit string
+	// BuildDate is when ko was built, provided at compile-time.
+	BuildDate string
+)
+
+// featureFlags maps the features that ko version reports to the flag that
+// selects each of them, so that a feature is only reported while its flag
+// is registered.
+var featureFlags = []struct {
+	feature, flag string
+}{
+	{"multi-platform", "platform"},
+	{"publish:registry", "push"},
+	// kind.local is handled by the same publish options as --local.
+	{"publish:daemon", "local"},
+	{"publish:kind", "local"},
+	{"publish:tarball", "tarball"},
+	{"publish:oci-layout", "oci-layout-path"},
+	{"publish:bucket", "bucket"},
+}
+
+// features returns the features of this build of ko, the commands below root
+// (e.g. "auth token") and the names of their flags, so that tooling can
+// detect them without parsing --help.
+func features(root *cobra.Command) (feats, commands, flags []string) {
+	seen := map[string]bool{}
+	var walk func(*cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		if cmd != root && !cmd.Hidden {
+			commands = append(commands, strings.TrimPrefix(cmd.CommandPath(), root.Name()+" "))
+		}
+		cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+			if !f.Hidden && !seen[f.Name] {
+				seen[f.Name] = true
+				flags = append(flags, f.Name)
+			}
+		})
+		for _, c := range cmd.Commands() {
+			walk(c)
+		}
+	}
+	walk(root)
+	sort.Strings(commands)
+	sort.Strings(flags)
+
+	for _, ff := range featureFlags {
+		if seen[ff.flag] {
+			feats = append(feats, ff.feature)
+		}
+	}
+	return feats, commands, flags
+}
+
+// versionInfo is what "ko version --json" prints.
+type versionInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildDate string   `json:"buildDate,omitempty"`
+	GoVersion string   `json:"goVersion"`
+	Platform  string   `json:"platform"`
+	Features  []string `json:"features"`
+	Commands  []string `json:"commands"`
+	Flags     []string `json:"flags"`
+}
 
 // addVersion augments our CLI surface with version.
 func addVersion(topLevel *cobra.Command) {
-	topLevel.AddCommand(&cobra.Command{
+	var asJSON bool
+	cmd := &cobra.Command{
 		Use:   "version",
 		Short: `Print ko version.`,
+		Args:  cobra.NoArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			v := version()
-			if v == "" {
+			feats, commands, flags := features(cmd.Root())
+			info := versionInfo{
+				Version:   version(),
+				Commit:    Commit,
+				BuildDate: BuildDate,
+				GoVersion: runtime.Version(),
+				Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+				Features:  feats,
+				Commands:  commands,
+				Flags:     flags,
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(info); err != nil {
+					log.Fatalf("error encoding version: %v", err)
+				}
+				return
+			}
+
+			if info.Version == "" {
 				fmt.Println("could not determine build information")
 			} else {
-				fmt.Println(v)
+				fmt.Println(info.Version)
+			}
+			if info.Commit != "" {
+				fmt.Printf("commit:     %s\n", info.Commit)
 			}
+			if info.BuildDate != "" {
+				fmt.Printf("built:      %s\n", info.BuildDate)
+			}
+			fmt.Printf("go:         %s\n", info.GoVersion)
+			fmt.Printf("platform:   %s\n", info.Platform)
+			fmt.Printf("features:   %s\n", strings.Join(info.Features, ", "))
 		},
-	})
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print version information as JSON.")
+	topLevel.AddCommand(cmd)
 }
 
 func version() string {
@@ -0,0 +1,52 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestFeatures(t *testing.T) {
+	root := &cobra.Command{Use: "ko"}
+	AddKubeCommands(root)
+	feats, commands, flags := features(root)
+
+	// Every feature's flag is registered, so none of them silently
+	// disappears when a flag is renamed.
+	if len(feats) != len(featureFlags) {
+		t.Errorf("features() = %v, want all of %v", feats, featureFlags)
+	}
+	for _, want := range []string{"apply", "auth token", "publish", "serve", "version"} {
+		if !contains(commands, want) {
+			t.Errorf("features() commands = %v, want %q in them", commands, want)
+		}
+	}
+	for _, want := range []string{"platform", "skip-unchanged", "username"} {
+		if !contains(flags, want) {
+			t.Errorf("features() flags = %v, want %q in them", flags, want)
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
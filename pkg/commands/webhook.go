@@ -0,0 +1,252 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/commands/options"
+	"github.com/google/ko/pkg/publish"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// addWebhook augments our CLI surface with webhook.
+func addWebhook(topLevel *cobra.Command) {
+	po := &options.PublishOptions{}
+	bo := &options.BuildOptions{}
+	co := &options.CacheOptions{}
+	var addr, certFile, keyFile string
+
+	webhook := &cobra.Command{
+		Use:   "webhook",
+		Short: "Serve a mutating admission webhook that resolves ko:// references.",
+		Long: `This sub-command serves a Kubernetes mutating admission webhook that replaces every ko:// reference in admitted objects with the digest of the built and published image, so that unresolved yaml can be applied directly to a development cluster.
+
+The webhook serves HTTPS on /mutate (and Prometheus metrics on /metrics), and must be run somewhere the cluster's API server can reach, with a MutatingWebhookConfiguration that points at it. Each import path is built once; restart the webhook to pick up source changes.`,
+		Example: `
+  # Serve the webhook on :8443.
+  ko webhook --tls-cert-file=tls.crt --tls-key-file=tls.key`,
+		Args: cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			ctx := createCancellableContext()
+			if err := useCacheBackend(co.Backend); err != nil {
+				log.Fatal(err)
+			}
+			if certFile == "" || keyFile == "" {
+				log.Fatal("--tls-cert-file and --tls-key-file are required")
+			}
+			if err := lookupUnchanged(bo, po); err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			builder, err := makeBuilder(ctx, bo)
+			if err != nil {
+				log.Fatalf("error creating builder: %v", err)
+			}
+			po.WorkDir = bo.WorkDir
+			publisher, err := makePublisher(po)
+			if err != nil {
+				log.Fatalf("error creating publisher: %v", err)
+			}
+			defer publisher.Close()
+
+			mux := http.NewServeMux()
+			mux.Handle("/mutate", &admissionHandler{ctx: ctx, builder: builder, publisher: publisher})
+			mux.Handle("/metrics", koMetrics.registry)
+			srv := &http.Server{Addr: addr, Handler: mux}
+			go func() {
+				<-ctx.Done()
+				srv.Close()
+			}()
+			log.Printf("Serving admission webhook on %s", addr)
+			if err := srv.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		},
+	}
+	options.AddPublishArg(webhook, po)
+	options.AddBuildOptions(webhook, bo)
+	options.AddCacheArg(webhook, co)
+	webhook.Flags().StringVar(&addr, "addr", ":8443",
+		"Address to serve the webhook on.")
+	scopeFlag(webhook, "addr")
+	webhook.Flags().StringVar(&certFile, "tls-cert-file", "",
+		"File containing the TLS certificate to serve with.")
+	webhook.Flags().StringVar(&keyFile, "tls-key-file", "",
+		"File containing the private key of --tls-cert-file.")
+	topLevel.AddCommand(webhook)
+}
+
+// admissionReview is the subset of admission.k8s.io/v1 (and v1beta1)
+// AdmissionReview that the webhook needs.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Kind       string             `json:"kind,omitempty"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID    string          `json:"uid"`
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+type admissionResponse struct {
+	UID       string           `json:"uid"`
+	Allowed   bool             `json:"allowed"`
+	Result    *admissionStatus `json:"status,omitempty"`
+	PatchType string           `json:"patchType,omitempty"`
+	Patch     []byte           `json:"patch,omitempty"`
+}
+
+type admissionStatus struct {
+	Message string `json:"message,omitempty"`
+}
+
+// jsonPatchOp is a single RFC 6902 operation.
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// admissionHandler serves AdmissionReviews, resolving the ko:// references
+// of each admitted object.
+type admissionHandler struct {
+	// ctx bounds builds, rather than the context of the request that asked
+	// for them: builds are shared, and an admission request that times out
+	// mustn't leave its cancellation behind for the requests after it.
+	ctx       context.Context
+	builder   build.Interface
+	publisher publish.Interface
+}
+
+func (h *admissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var review admissionReview
+	if err := json.Unmarshal(b, &review); err != nil || review.Request == nil {
+		http.Error(w, "expected an AdmissionReview request", http.StatusBadRequest)
+		return
+	}
+
+	resp := &admissionResponse{UID: review.Request.UID, Allowed: true}
+	patch, err := h.mutate(h.ctx, review.Request.Object)
+	if err != nil {
+		log.Printf("error resolving %s: %v", review.Request.UID, err)
+		resp.Allowed = false
+		resp.Result = &admissionStatus{Message: err.Error()}
+	} else if len(patch) != 0 {
+		if resp.Patch, err = json.Marshal(patch); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.PatchType = "JSONPatch"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(admissionReview{
+		APIVersion: review.APIVersion,
+		Kind:       review.Kind,
+		Response:   resp,
+	}); err != nil {
+		log.Printf("error writing admission response: %v", err)
+	}
+}
+
+// mutate builds and publishes every ko:// reference in the JSON object, and
+// returns a patch that replaces them with the published references.
+func (h *admissionHandler) mutate(ctx context.Context, object json.RawMessage) ([]jsonPatchOp, error) {
+	if len(object) == 0 {
+		return nil, nil
+	}
+	var obj interface{}
+	if err := json.Unmarshal(object, &obj); err != nil {
+		return nil, fmt.Errorf("parsing object: %v", err)
+	}
+
+	// Find the pointer to every reference.
+	refs := make(map[string][]string)
+	walkJSON(obj, "", func(pointer, s string) {
+		s = expandAlias(s)
+		if strings.HasPrefix(s, build.StrictScheme) {
+			refs[s] = append(refs[s], pointer)
+		}
+	})
+
+	var m sync.Mutex
+	var patch []jsonPatchOp
+	var g errgroup.Group
+	for ref, pointers := range refs {
+		ref, pointers := ref, pointers
+		g.Go(func() error {
+			if err := h.builder.IsSupportedReference(ref); err != nil {
+				return fmt.Errorf("%s is not a valid import path: %v", ref, err)
+			}
+			img, err := h.builder.Build(ctx, ref)
+			if err != nil {
+				return fmt.Errorf("building %s: %v", ref, err)
+			}
+			digest, err := h.publisher.Publish(ctx, img, ref)
+			if err != nil {
+				return fmt.Errorf("publishing %s: %v", ref, err)
+			}
+			m.Lock()
+			defer m.Unlock()
+			for _, pointer := range pointers {
+				patch = append(patch, jsonPatchOp{Op: "replace", Path: pointer, Value: digest.String()})
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	sort.Slice(patch, func(i, j int) bool { return patch[i].Path < patch[j].Path })
+	return patch, nil
+}
+
+// walkJSON calls fn with the JSON pointer and value of every string in v.
+func walkJSON(v interface{}, pointer string, fn func(pointer, s string)) {
+	switch v := v.(type) {
+	case string:
+		fn(pointer, v)
+	case []interface{}:
+		for i, e := range v {
+			walkJSON(e, fmt.Sprintf("%s/%d", pointer, i), fn)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			k = strings.NewReplacer("~", "~0", "/", "~1").Replace(k)
+			walkJSON(e, pointer+"/"+k, fn)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/ko/pkg/build"
+	kotesting "github.com/google/ko/pkg/internal/testing"
+)
+
+func TestAdmissionHandler(t *testing.T) {
+	base := mustRepository("gcr.io/admission")
+	h := &admissionHandler{
+		ctx:       context.Background(),
+		builder:   testBuilder,
+		publisher: kotesting.NewFixedPublish(base, testHashes),
+	}
+
+	review := func(object string) *admissionReview {
+		t.Helper()
+		b, err := json.Marshal(admissionReview{
+			APIVersion: "admission.k8s.io/v1",
+			Kind:       "AdmissionReview",
+			Request:    &admissionRequest{UID: "1234", Object: json.RawMessage(object)},
+		})
+		if err != nil {
+			t.Fatalf("json.Marshal() = %v", err)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(b)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ServeHTTP() = %d %s", rec.Code, rec.Body)
+		}
+		var got admissionReview
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("json.Unmarshal() = %v", err)
+		}
+		if got.Response == nil || got.Response.UID != "1234" {
+			t.Fatalf("ServeHTTP() = %+v, wanted a response for 1234", got)
+		}
+		return &got
+	}
+
+	t.Run("resolves references", func(t *testing.T) {
+		got := review(`{"spec":{"containers":[
+			{"image":"` + build.StrictScheme + fooRef + `"},
+			{"image":"nginx"},
+			{"image":"` + build.StrictScheme + barRef + `","env":[{"name":"a/b","value":"` + build.StrictScheme + fooRef + `"}]}]}}`)
+		if !got.Response.Allowed || got.Response.PatchType != "JSONPatch" {
+			t.Fatalf("ServeHTTP() = %+v, wanted an allowed patch", got.Response)
+		}
+		var patch []jsonPatchOp
+		if err := json.Unmarshal(got.Response.Patch, &patch); err != nil {
+			t.Fatalf("json.Unmarshal() = %v", err)
+		}
+		want := []jsonPatchOp{{
+			Op:    "replace",
+			Path:  "/spec/containers/0/image",
+			Value: kotesting.ComputeDigest(base, fooRef, fooHash),
+		}, {
+			Op:    "replace",
+			Path:  "/spec/containers/2/env/0/value",
+			Value: kotesting.ComputeDigest(base, fooRef, fooHash),
+		}, {
+			Op:    "replace",
+			Path:  "/spec/containers/2/image",
+			Value: kotesting.ComputeDigest(base, barRef, barHash),
+		}}
+		if diff := cmp.Diff(want, patch); diff != "" {
+			t.Errorf("patch (-want +got) = %s", diff)
+		}
+	})
+
+	t.Run("nothing to resolve", func(t *testing.T) {
+		got := review(`{"spec":{"containers":[{"image":"nginx"}]}}`)
+		if !got.Response.Allowed || got.Response.Patch != nil {
+			t.Errorf("ServeHTTP() = %+v, wanted allowed without a patch", got.Response)
+		}
+	})
+
+	t.Run("unknown import path", func(t *testing.T) {
+		got := review(`{"image":"` + build.StrictScheme + `github.com/awesomesauce/missing"}`)
+		if got.Response.Allowed || got.Response.Result == nil {
+			t.Errorf("ServeHTTP() = %+v, wanted denied", got.Response)
+		}
+	})
+}
+
+// contextBuild fails builds whose context is done, like go build would.
+type contextBuild struct {
+	build.Interface
+}
+
+func (c contextBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Interface.Build(ctx, s)
+}
+
+func TestAdmissionHandlerCancelledRequest(t *testing.T) {
+	builder, err := build.NewCaching(contextBuild{testBuilder})
+	if err != nil {
+		t.Fatalf("NewCaching() = %v", err)
+	}
+	h := &admissionHandler{
+		ctx:       context.Background(),
+		builder:   builder,
+		publisher: kotesting.NewFixedPublish(mustRepository("gcr.io/admission"), testHashes),
+	}
+	b, err := json.Marshal(admissionReview{
+		APIVersion: "admission.k8s.io/v1",
+		Kind:       "AdmissionReview",
+		Request:    &admissionRequest{UID: "1234", Object: json.RawMessage(`{"image":"` + build.StrictScheme + fooRef + `"}`)},
+	})
+	if err != nil {
+		t.Fatalf("json.Marshal() = %v", err)
+	}
+
+	// The API server gives up on the first request.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(b)).WithContext(cancelled))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(b)))
+	var got admissionReview
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	if got.Response == nil || !got.Response.Allowed {
+		t.Errorf("ServeHTTP() = %+v after a cancelled request, wanted allowed", got.Response)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package debounce batches bursts of changes, so that saving ten files at once
+// rebuilds once rather than ten times.
+package debounce
+
+import (
+	"sync"
+	"time"
+)
+
+// Batcher collects keys until none have been added for a quiet period, or
+// until a maximum delay has passed since the first, and then flushes them
+// all at once.
+type Batcher struct {
+	quiet    time.Duration
+	maxDelay time.Duration
+	flush    func([]string)
+
+	mu    sync.Mutex
+	keys  []string
+	seen  map[string]bool
+	first time.Time
+	timer *time.Timer
+	// gen tells the current timer apart from those that fired already.
+	gen     int
+	stopped bool
+
+	// flushing makes flushes run one at a time, in order.
+	flushing sync.Mutex
+}
+
+// New returns a Batcher that calls flush with the keys added since the last
+// flush, each once and in the order they were first added. If quiet is zero,
+// every Add flushes right away. If maxDelay is zero, a steady stream of keys
+// postpones the flush indefinitely.
+func New(quiet, maxDelay time.Duration, flush func(keys []string)) *Batcher {
+	return &Batcher{
+		quiet:    quiet,
+		maxDelay: maxDelay,
+		flush:    flush,
+		seen:     map[string]bool{},
+	}
+}
+
+// Add adds keys to the next flush, and postpones it until the quiet period has
+// passed again.
+func (b *Batcher) Add(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	if b.quiet <= 0 {
+		b.flushing.Lock()
+		defer b.flushing.Unlock()
+		b.flush(dedupe(keys))
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stopped {
+		return
+	}
+	for _, k := range keys {
+		if !b.seen[k] {
+			b.seen[k] = true
+			b.keys = append(b.keys, k)
+		}
+	}
+	now := time.Now()
+	if b.timer == nil {
+		b.first = now
+		b.gen++
+		gen := b.gen
+		b.timer = time.AfterFunc(b.quiet, func() { b.fire(gen) })
+		return
+	}
+	wait := b.quiet
+	if b.maxDelay > 0 {
+		if left := b.first.Add(b.maxDelay).Sub(now); left < wait {
+			wait = left
+		}
+	}
+	b.timer.Reset(wait)
+}
+
+// fire flushes the keys collected so far.
+func (b *Batcher) fire(gen int) {
+	b.flushing.Lock()
+	defer b.flushing.Unlock()
+
+	b.mu.Lock()
+	if gen != b.gen || b.timer == nil {
+		// A timer that was reset as it fired.
+		b.mu.Unlock()
+		return
+	}
+	keys := b.keys
+	b.keys, b.seen, b.timer = nil, map[string]bool{}, nil
+	stopped := b.stopped
+	b.mu.Unlock()
+
+	if len(keys) != 0 && !stopped {
+		b.flush(keys)
+	}
+}
+
+// Stop drops any keys that haven't been flushed, and ignores those added from
+// now on.
+func (b *Batcher) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stopped = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
+
+func dedupe(keys []string) []string {
+	seen := make(map[string]bool, len(keys))
+	out := keys[:0:0]
+	for _, k := range keys {
+		if !seen[k] {
+			seen[k] = true
+			out = append(out, k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debounce
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type flushes struct {
+	mu  sync.Mutex
+	got [][]string
+	ch  chan struct{}
+}
+
+func newFlushes() *flushes {
+	return &flushes{ch: make(chan struct{}, 10)}
+}
+
+func (f *flushes) flush(keys []string) {
+	f.mu.Lock()
+	f.got = append(f.got, keys)
+	f.mu.Unlock()
+	f.ch <- struct{}{}
+}
+
+func (f *flushes) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a flush")
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	f := newFlushes()
+	b := New(50*time.Millisecond, 0, f.flush)
+	defer b.Stop()
+	b.Add("a", "b")
+	b.Add("a")
+	b.Add("c")
+	f.wait(t)
+	b.Add("d")
+	f.wait(t)
+
+	want := [][]string{{"a", "b", "c"}, {"d"}}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if diff := cmp.Diff(want, f.got); diff != "" {
+		t.Errorf("flushes (-want +got) = %v", diff)
+	}
+}
+
+func TestBatcherMaxDelay(t *testing.T) {
+	f := newFlushes()
+	b := New(time.Hour, 50*time.Millisecond, f.flush)
+	defer b.Stop()
+	// Keep adding more often than the quiet period, which the maximum
+	// delay cuts short.
+	done := time.After(5 * time.Second)
+	for {
+		b.Add("a")
+		select {
+		case <-f.ch:
+			return
+		case <-done:
+			t.Fatal("timed out waiting for a flush")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestBatcherNoQuiet(t *testing.T) {
+	var got [][]string
+	b := New(0, 0, func(keys []string) { got = append(got, keys) })
+	b.Add("a", "b", "a")
+	b.Add("c")
+	if diff := cmp.Diff([][]string{{"a", "b"}, {"c"}}, got); diff != "" {
+		t.Errorf("flushes (-want +got) = %v", diff)
+	}
+}
+
+func TestBatcherStop(t *testing.T) {
+	f := newFlushes()
+	b := New(10*time.Millisecond, 0, f.flush)
+	b.Add("a")
+	b.Stop()
+	b.Add("b")
+	select {
+	case <-f.ch:
+		t.Errorf("flushed %v after Stop()", f.got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ignore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	m, err := Parse("/src", strings.NewReader(`
+# Editor swap files.
+*.swp
+.*.sw?
+
+coverage/
+/testdata/huge
+kodata/**/*.tmp
+!keep.swp
+\#literal
+`))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+
+	for _, tc := range []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "/src/main.go", want: false},
+		{path: "/src/cmd/app/main.go.swp", want: true},
+		{path: "/src/cmd/app/.main.go.swx", want: true},
+		{path: "/src/keep.swp", want: false},
+		{path: "/src/coverage", isDir: true, want: true},
+		{path: "/src/coverage", want: false},
+		{path: "/src/cmd/coverage/out.html", want: true},
+		{path: "/src/testdata/huge/blob", want: true},
+		{path: "/src/cmd/testdata/huge", want: false},
+		{path: "/src/cmd/kodata/a/b/c.tmp", want: false},
+		{path: "/src/kodata/c.tmp", want: true},
+		{path: "/src/kodata/a/b/c.tmp", want: true},
+		{path: "/src/#literal", want: true},
+		{path: "/elsewhere/main.go.swp", want: false},
+		{path: "/src", isDir: true, want: false},
+	} {
+		if got := m.Match(tc.path, tc.isDir); got != tc.want {
+			t.Errorf("Match(%q, %v) = %v, wanted %v", tc.path, tc.isDir, got, tc.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-ignore")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if m.Match(filepath.Join(dir, "foo.swp"), false) {
+		t.Error("Match() = true without a .koignore, wanted false")
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, Filename), []byte("*.swp\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	m, err = Load(dir)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if !m.Match(filepath.Join(dir, "foo.swp"), false) {
+		t.Error("Match() = false, wanted true")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("ko_builds_total", "Builds.", "result")
+	h := r.NewHistogram("ko_build_duration_seconds", "Build durations.", []float64{1, 10})
+
+	c.Inc("success")
+	c.Inc("success")
+	c.Add(1, `err"or`)
+	h.Observe(0.5)
+	h.Observe(5)
+	h.Observe(50)
+
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	want := `# HELP ko_builds_total Builds.
+# TYPE ko_builds_total counter
+ko_builds_total{result="err\"or"} 1
+ko_builds_total{result="success"} 2
+# HELP ko_build_duration_seconds Build durations.
+# TYPE ko_build_duration_seconds histogram
+ko_build_duration_seconds_bucket{le="1"} 1
+ko_build_duration_seconds_bucket{le="10"} 2
+ko_build_duration_seconds_bucket{le="+Inf"} 3
+ko_build_duration_seconds_sum 55.5
+ko_build_duration_seconds_count 3
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Write() (-want +got) = %s", diff)
+	}
+}
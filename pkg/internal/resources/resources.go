@@ -0,0 +1,105 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package resources sizes ko's concurrency from the resources of the machine
+// it runs on.
+package resources
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// BuildMemory is roughly how much memory a single "go build" needs.
+const BuildMemory = 1 << 30
+
+// Memory reports the total and available memory of the machine in bytes,
+// from /proc/meminfo. On other systems, ok is false.
+func Memory() (total, available uint64, ok bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+	return parseMeminfo(f)
+}
+
+func parseMeminfo(r io.Reader) (total, available uint64, ok bool) {
+	var haveTotal, haveAvailable bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// e.g. "MemAvailable:   12345678 kB"
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, haveTotal = kb*1024, true
+		case "MemAvailable:":
+			available, haveAvailable = kb*1024, true
+		}
+	}
+	return total, available, haveTotal && haveAvailable
+}
+
+// Jobs returns how many jobs needing perJob bytes of memory each can run at
+// once: one per CPU, but no more than fit in the available memory, and at
+// least one.
+func Jobs(perJob uint64) int {
+	_, available, ok := Memory()
+	return jobs(runtime.GOMAXPROCS(0), available, ok, perJob)
+}
+
+func jobs(cpus int, available uint64, ok bool, perJob uint64) int {
+	n := cpus
+	if ok && perJob > 0 {
+		if byMemory := int(available / perJob); byMemory < n {
+			n = byMemory
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// PushJobs returns how many layers to upload at once: one per CPU, but at
+// least four, since uploads wait on the network rather than the CPU.
+func PushJobs() int {
+	if n := runtime.GOMAXPROCS(0); n > 4 {
+		return n
+	}
+	return 4
+}
+
+// LowMemory reports whether the machine is about to start swapping, that is
+// whether less than a tenth of its memory is available. If that can't be
+// determined, it reports false.
+func LowMemory() bool {
+	total, available, ok := Memory()
+	return ok && lowMemory(total, available)
+}
+
+func lowMemory(total, available uint64) bool {
+	return available < total/10
+}
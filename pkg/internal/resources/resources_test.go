@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMeminfo(t *testing.T) {
+	total, available, ok := parseMeminfo(strings.NewReader(`MemTotal:       16384000 kB
+MemFree:         1024000 kB
+MemAvailable:    8192000 kB
+Buffers:          512000 kB
+`))
+	if !ok {
+		t.Fatal("parseMeminfo() = !ok, wanted ok")
+	}
+	if want := uint64(16384000 * 1024); total != want {
+		t.Errorf("total = %d, wanted %d", total, want)
+	}
+	if want := uint64(8192000 * 1024); available != want {
+		t.Errorf("available = %d, wanted %d", available, want)
+	}
+
+	if _, _, ok := parseMeminfo(strings.NewReader("MemTotal: 1 kB\n")); ok {
+		t.Error("parseMeminfo() = ok without MemAvailable, wanted !ok")
+	}
+}
+
+func TestJobs(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		cpus      int
+		available uint64
+		ok        bool
+		want      int
+	}{
+		{name: "cpu bound", cpus: 4, available: 64 * BuildMemory, ok: true, want: 4},
+		{name: "memory bound", cpus: 64, available: 8 * BuildMemory, ok: true, want: 8},
+		{name: "at least one", cpus: 8, available: BuildMemory / 2, ok: true, want: 1},
+		{name: "unknown memory", cpus: 8, want: 8},
+	} {
+		if got := jobs(tc.cpus, tc.available, tc.ok, BuildMemory); got != tc.want {
+			t.Errorf("%s: jobs() = %d, wanted %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLowMemory(t *testing.T) {
+	if lowMemory(100, 50) {
+		t.Error("lowMemory(100, 50) = true, wanted false")
+	}
+	if !lowMemory(100, 5) {
+		t.Error("lowMemory(100, 5) = false, wanted true")
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package trace records spans of ko's work and exports them to an
+// OpenTelemetry collector using OTLP over HTTP (with JSON encoding). Tracing
+// is enabled by the standard OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) environment variable, and costs
+// nothing otherwise.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchSize is how many finished spans are buffered before exporting them.
+const batchSize = 256
+
+// Span is an operation being traced. A nil *Span is valid, and does nothing.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+
+	m     sync.Mutex
+	attrs map[string]string
+}
+
+type spanKey struct{}
+
+var (
+	m        sync.Mutex
+	exporter *otlpExporter
+	root     *Span
+)
+
+// Init enables tracing if an OTLP endpoint is configured in the environment,
+// and starts a root span with the given name that Shutdown ends. If the
+// TRACEPARENT environment variable holds a W3C trace context (as set by some
+// CI systems), the root span joins that trace.
+func Init(name string) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "ko"
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	exporter = &otlpExporter{
+		endpoint: endpoint,
+		headers:  parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		service:  service,
+		client:   http.DefaultClient,
+	}
+	root = newSpan(name, parseTraceParent(os.Getenv("TRACEPARENT")))
+}
+
+// Shutdown ends the root span and exports any spans that haven't been yet.
+func Shutdown(ctx context.Context) error {
+	m.Lock()
+	e, r := exporter, root
+	exporter, root = nil, nil
+	m.Unlock()
+	if e == nil {
+		return nil
+	}
+	e.finish(r, nil)
+	return e.flush(ctx)
+}
+
+// Start starts a span with the given name as a child of the span in ctx (or
+// of the root span), and returns a context holding it.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	m.Lock()
+	e, r := exporter, root
+	m.Unlock()
+	if e == nil {
+		return ctx, nil
+	}
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok {
+		parent = r
+	}
+	s := newSpan(name, parent)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttribute records a key/value pair on the span, e.g. the import path
+// being built.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.attrs[key] = value
+}
+
+// End finishes the span, marking it as failed if err is non-nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	m.Lock()
+	e := exporter
+	m.Unlock()
+	if e != nil {
+		e.finish(s, err)
+	}
+}
+
+func newSpan(name string, parent *Span) *Span {
+	s := &Span{
+		name:  name,
+		start: time.Now(),
+		attrs: map[string]string{},
+	}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// parseTraceParent returns a span standing in for the remote parent in a W3C
+// traceparent header, i.e. 00-<trace id>-<span id>-<flags>, or nil.
+func parseTraceParent(tp string) *Span {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return nil
+	}
+	s := &Span{}
+	if _, err := hex.Decode(s.traceID[:], []byte(parts[1])); err != nil {
+		return nil
+	}
+	if _, err := hex.Decode(s.spanID[:], []byte(parts[2])); err != nil {
+		return nil
+	}
+	return s
+}
+
+// parseHeaders parses OTEL_EXPORTER_OTLP_HEADERS, i.e. k1=v1,k2=v2.
+func parseHeaders(s string) map[string]string {
+	headers := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return headers
+}
+
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+
+	m       sync.Mutex
+	pending []otlpSpan
+}
+
+func (e *otlpExporter) finish(s *Span, err error) {
+	s.m.Lock()
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              1, // SPAN_KIND_INTERNAL
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	for k, v := range s.attrs {
+		span.Attributes = append(span.Attributes, stringAttribute(k, v))
+	}
+	s.m.Unlock()
+	if s.parentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		span.Status = &otlpStatus{Code: 2, Message: err.Error()} // STATUS_CODE_ERROR
+	}
+
+	e.m.Lock()
+	e.pending = append(e.pending, span)
+	full := len(e.pending) >= batchSize
+	e.m.Unlock()
+	if full {
+		go func() {
+			if err := e.flush(context.Background()); err != nil {
+				log.Printf("error exporting spans: %v", err)
+			}
+		}()
+	}
+}
+
+func (e *otlpExporter) flush(ctx context.Context) error {
+	e.m.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.m.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{stringAttribute("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/google/ko"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("exporting spans to %s: %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// The following mirror the JSON encoding of OTLP's ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func stringAttribute(k, v string) otlpAttribute {
+	return otlpAttribute{Key: k, Value: otlpValue{StringValue: v}}
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var got otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("path = %s, wanted /v1/traces", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer token" {
+			t.Errorf("Authorization = %q, wanted %q", got, "Bearer token")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Decode() = %v", err)
+		}
+	}))
+	defer server.Close()
+
+	for k, v := range map[string]string{
+		"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL,
+		"OTEL_EXPORTER_OTLP_HEADERS":  "Authorization=Bearer token",
+		"TRACEPARENT":                 "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	Init("ko resolve")
+	ctx, build := Start(context.Background(), "go build")
+	build.SetAttribute("ko.importpath", "github.com/google/ko")
+	_, layers := Start(ctx, "build layers")
+	layers.End(errors.New("boom"))
+	build.End(nil)
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got %v, wanted one batch of spans", got)
+	}
+	spans := map[string]otlpSpan{}
+	for _, s := range got.ResourceSpans[0].ScopeSpans[0].Spans {
+		if s.TraceID != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("span %s has trace id %s, wanted the one from TRACEPARENT", s.Name, s.TraceID)
+		}
+		spans[s.Name] = s
+	}
+	if got, want := spans["ko resolve"].ParentSpanID, "b7ad6b7169203331"; got != want {
+		t.Errorf("root parent = %s, wanted %s", got, want)
+	}
+	if got, want := spans["go build"].ParentSpanID, spans["ko resolve"].SpanID; got != want {
+		t.Errorf("go build parent = %s, wanted %s", got, want)
+	}
+	if got, want := spans["build layers"].ParentSpanID, spans["go build"].SpanID; got != want {
+		t.Errorf("build layers parent = %s, wanted %s", got, want)
+	}
+	if s := spans["build layers"].Status; s == nil || s.Message != "boom" {
+		t.Errorf("build layers status = %v, wanted an error", s)
+	}
+	if attrs := spans["go build"].Attributes; len(attrs) != 1 || attrs[0].Value.StringValue != "github.com/google/ko" {
+		t.Errorf("go build attributes = %v", attrs)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	Init("ko")
+	ctx := context.Background()
+	if got, span := Start(ctx, "noop"); got != ctx || span != nil {
+		t.Errorf("Start() = %v, %v, wanted no span without an endpoint", got, span)
+	}
+	if err := Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+}
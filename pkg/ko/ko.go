@@ -0,0 +1,186 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ko
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+// DefaultBaseImage is the base image that Build uses unless told otherwise.
+const DefaultBaseImage = "gcr.io/distroless/static:nonroot"
+
+// BuildOptions configure Build. The zero value builds on DefaultBaseImage
+// for the platform of the base image.
+type BuildOptions struct {
+	// BaseImage is the image to put the binary on top of.
+	BaseImage string
+
+	// Platform selects which platforms of a multi-platform base image to
+	// build for, e.g. linux/arm64, linux/amd64,linux/arm64 or all.
+	Platform string
+
+	// Keychain authenticates pulls of the base image, defaulting to
+	// authn.DefaultKeychain.
+	Keychain authn.Keychain
+
+	// CreationTime is the creation time of the image, defaulting to the
+	// epoch (rather than the time of the base image) for reproducibility.
+	CreationTime v1.Time
+
+	// DisableOptimizations builds the binary for debugging.
+	DisableOptimizations bool
+
+	// Labels are added to the config of the image.
+	Labels map[string]string
+
+	// Config configures how the binary is built, e.g. its flags and ldflags.
+	// Its ID is ignored.
+	Config build.Config
+
+	// Hooks are called as the build starts and ends.
+	Hooks build.Hooks
+}
+
+// Build builds the main package with the given import path (as understood
+// by the go command in the current directory) into an image, or into an
+// index of images if more than one platform is selected.
+func Build(ctx context.Context, importpath string, opts BuildOptions) (build.Result, error) {
+	baseImage := opts.BaseImage
+	if baseImage == "" {
+		baseImage = DefaultBaseImage
+	}
+	baseRef, err := name.ParseReference(baseImage)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base image %q: %w", baseImage, err)
+	}
+	keychain := opts.Keychain
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+
+	creationTime := opts.CreationTime
+	if creationTime.IsZero() {
+		creationTime = v1.Time{Time: time.Unix(0, 0)}
+	}
+
+	importpath = strings.TrimPrefix(importpath, build.StrictScheme)
+	config := opts.Config
+	config.ID = importpath
+	bo := []build.Option{
+		build.WithBaseImages(func(ctx context.Context, _ string) (build.Result, error) {
+			return build.FetchBase(baseRef, opts.Platform,
+				remote.WithAuthFromKeychain(keychain),
+				remote.WithUserAgent("ko"),
+				remote.WithContext(ctx))
+		}),
+		build.WithPlatforms(opts.Platform),
+		build.WithCreationTime(creationTime),
+		build.WithConfigs([]build.Config{config}),
+		build.WithHooks(opts.Hooks),
+	}
+	if opts.DisableOptimizations {
+		bo = append(bo, build.WithDisabledOptimizations())
+	}
+	if len(opts.Labels) != 0 {
+		bo = append(bo, build.WithLabels(opts.Labels))
+	}
+	b, err := build.NewGo(ctx, bo...)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := build.StrictScheme + importpath
+	if err := b.IsSupportedReference(ref); err != nil {
+		return nil, fmt.Errorf("importpath %q is not supported: %w", importpath, err)
+	}
+	return b.Build(ctx, ref)
+}
+
+// PublishOptions configure Publish.
+type PublishOptions struct {
+	// DockerRepo is the repository to publish under, like KO_DOCKER_REPO.
+	DockerRepo string
+
+	// Tags are the tags to publish the image with, defaulting to latest.
+	Tags []string
+
+	// Namer names the repository of the image from DockerRepo and the
+	// import path, defaulting to appending the import path to DockerRepo.
+	Namer publish.Namer
+
+	// Keychain authenticates pushes, defaulting to authn.DefaultKeychain.
+	Keychain authn.Keychain
+
+	// Insecure allows pushing to a registry without TLS.
+	Insecure bool
+
+	// Local loads the image into the local docker daemon (as ko.local)
+	// instead of pushing it to DockerRepo.
+	Local bool
+
+	// Hooks are called as the publish starts and ends.
+	Hooks publish.Hooks
+}
+
+// Publish publishes the result of building importpath, returning a reference
+// to it by digest.
+func Publish(ctx context.Context, result build.Result, importpath string, opts PublishOptions) (name.Reference, error) {
+	namer := opts.Namer
+	if namer == nil {
+		namer = func(base, importpath string) string {
+			return path.Join(base, importpath)
+		}
+	}
+	tags := opts.Tags
+	if len(tags) == 0 {
+		tags = []string{"latest"}
+	}
+
+	var p publish.Interface
+	if opts.Local {
+		p = publish.NewDaemon(namer, tags)
+	} else {
+		if opts.DockerRepo == "" {
+			return nil, fmt.Errorf("a DockerRepo is required to publish %s", importpath)
+		}
+		keychain := opts.Keychain
+		if keychain == nil {
+			keychain = authn.DefaultKeychain
+		}
+		var err error
+		p, err = publish.NewDefault(opts.DockerRepo,
+			publish.WithAuthFromKeychain(keychain),
+			publish.WithNamer(namer),
+			publish.WithTags(tags),
+			publish.Insecure(opts.Insecure))
+		if err != nil {
+			return nil, err
+		}
+	}
+	p = publish.NewHooked(p, opts.Hooks)
+	defer p.Close()
+	return p.Publish(ctx, result, strings.TrimPrefix(importpath, build.StrictScheme))
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ko
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/publish"
+)
+
+func TestBuildAndPublish(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	baseRef, err := name.ParseReference(fmt.Sprintf("%s/base:latest", u.Host))
+	if err != nil {
+		t.Fatalf("ParseReference() = %v", err)
+	}
+	if err := remote.Write(baseRef, base); err != nil {
+		t.Fatalf("remote.Write() = %v", err)
+	}
+
+	ctx := context.Background()
+	importpath := "github.com/google/ko/test"
+	var builds []build.BuildEnd
+	result, err := Build(ctx, importpath, BuildOptions{
+		BaseImage: baseRef.String(),
+		Labels:    map[string]string{"foo": "bar"},
+		Hooks: build.Hooks{
+			OnBuildEnd: func(_ context.Context, e build.BuildEnd) { builds = append(builds, e) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Build() = %v", err)
+	}
+	img, ok := result.(v1.Image)
+	if !ok {
+		t.Fatalf("Build() = %T, wanted an image", result)
+	}
+	cf, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile() = %v", err)
+	}
+	if got, want := cf.Config.Labels["foo"], "bar"; got != want {
+		t.Errorf("label foo = %q, wanted %q", got, want)
+	}
+	if want := time.Unix(0, 0); !cf.Created.Time.Equal(want) {
+		t.Errorf("created = %v, wanted %v", cf.Created.Time, want)
+	}
+
+	var publishes []publish.PublishEnd
+	ref, err := Publish(ctx, result, importpath, PublishOptions{
+		DockerRepo: u.Host + "/ko",
+		Hooks: publish.Hooks{
+			OnPublishEnd: func(_ context.Context, e publish.PublishEnd) { publishes = append(publishes, e) },
+		},
+	})
+	if err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+	if want := u.Host + "/ko/" + importpath + "@"; !strings.HasPrefix(ref.String(), want) {
+		t.Errorf("Publish() = %s, wanted prefix %s", ref, want)
+	}
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got := ref.Identifier(); got != want.String() {
+		t.Errorf("Publish() digest = %s, wanted %s", got, want)
+	}
+	if len(builds) != 1 || builds[0].Err != nil || builds[0].Digest != want.String() {
+		t.Errorf("OnBuildEnd got %v, wanted one build of %s", builds, want)
+	}
+	if len(publishes) != 1 || publishes[0].Err != nil || publishes[0].Reference.String() != ref.String() {
+		t.Errorf("OnPublishEnd got %v, wanted one publish of %s", publishes, ref)
+	}
+
+	if _, err := Build(ctx, "github.com/google/ko/pkg/ko", BuildOptions{BaseImage: baseRef.String()}); err == nil {
+		t.Error("Build() = nil, wanted an error for a package that isn't main")
+	}
+	if _, err := Publish(ctx, result, importpath, PublishOptions{}); err == nil {
+		t.Error("Publish() = nil, wanted an error without a DockerRepo")
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// ObjectStore writes objects to a bucket.
+type ObjectStore interface {
+	// Exists reports whether there is an object at key.
+	Exists(ctx context.Context, key string) (bool, error)
+	// Put writes the contents of r to key, served with contentType.
+	Put(ctx context.Context, key, contentType string, r io.Reader) error
+}
+
+// NewObjectStore returns the ObjectStore for a bucket URL: s3://bucket/prefix
+// (written with the aws CLI), gs://bucket/prefix (written with the gcloud
+// CLI), or file:///path for a directory, e.g. a mounted bucket.
+func NewObjectStore(bucketURL string) (ObjectStore, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "s3", "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("bucket URL %q has no bucket", bucketURL)
+		}
+		return &cliStore{scheme: u.Scheme, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+	case "file":
+		return &dirStore{dir: filepath.FromSlash(u.Path)}, nil
+	default:
+		return nil, fmt.Errorf("bucket URL %q must start with s3://, gs:// or file://", bucketURL)
+	}
+}
+
+// bucket publishes images to an ObjectStore, laid out like the registry API
+// (v2/<repository>/manifests/<reference> and v2/<repository>/blobs/<digest>),
+// so that serving the bucket over HTTP makes a read-only registry.
+type bucket struct {
+	store ObjectStore
+	base  string
+	namer Namer
+	tags  []string
+}
+
+// NewBucket returns a new publish.Interface that writes images to store,
+// named under base, the registry that serves the bucket.
+func NewBucket(store ObjectStore, base string, namer Namer, tags []string) Interface {
+	return &bucket{
+		store: store,
+		base:  base,
+		namer: namer,
+		tags:  tags,
+	}
+}
+
+// Publish implements publish.Interface
+func (b *bucket) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	s = strings.TrimPrefix(s, build.StrictScheme)
+	// https://github.com/google/go-containerregistry/issues/212
+	s = strings.ToLower(s)
+
+	repo, err := name.NewRepository(b.namer(b.base, s))
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Join("v2", repo.RepositoryStr())
+
+	// Static registries still need to answer the ping of clients.
+	if err := b.putIfMissing(ctx, "v2/index.html", "text/html", func() ([]byte, error) { return nil, nil }); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Publishing %v", repo)
+	if err := b.writeResult(ctx, prefix, br); err != nil {
+		return nil, err
+	}
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+	mt, err := br.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := br.RawManifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range b.tags {
+		if err := b.store.Put(ctx, path.Join(prefix, "manifests", tag), string(mt), bytes.NewReader(raw)); err != nil {
+			return nil, err
+		}
+	}
+
+	dig := repo.Digest(h.String())
+	log.Printf("Published %v", dig)
+	return &dig, nil
+}
+
+// writeResult writes the blobs and manifests of an image or index, and then
+// its own manifest, by digest, under prefix.
+func (b *bucket) writeResult(ctx context.Context, prefix string, br build.Result) error {
+	switch br := br.(type) {
+	case v1.ImageIndex:
+		im, err := br.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, desc := range im.Manifests {
+			var child build.Result
+			if desc.MediaType.IsIndex() {
+				child, err = br.ImageIndex(desc.Digest)
+			} else {
+				child, err = br.Image(desc.Digest)
+			}
+			if err != nil {
+				return err
+			}
+			if err := b.writeResult(ctx, prefix, child); err != nil {
+				return err
+			}
+		}
+	case v1.Image:
+		if err := b.writeBlobs(ctx, prefix, br); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unexpected result %T", br)
+	}
+
+	h, err := br.Digest()
+	if err != nil {
+		return err
+	}
+	mt, err := br.MediaType()
+	if err != nil {
+		return err
+	}
+	return b.putIfMissing(ctx, path.Join(prefix, "manifests", h.String()), string(mt), br.RawManifest)
+}
+
+// writeBlobs writes the config and layers of img that aren't there already.
+func (b *bucket) writeBlobs(ctx context.Context, prefix string, img v1.Image) error {
+	cn, err := img.ConfigName()
+	if err != nil {
+		return err
+	}
+	if err := b.putIfMissing(ctx, path.Join(prefix, "blobs", cn.String()), "application/octet-stream", img.RawConfigFile); err != nil {
+		return err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		h, err := l.Digest()
+		if err != nil {
+			return err
+		}
+		key := path.Join(prefix, "blobs", h.String())
+		if ok, err := b.store.Exists(ctx, key); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+		rc, err := l.Compressed()
+		if err != nil {
+			return err
+		}
+		err = b.store.Put(ctx, key, "application/octet-stream", rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putIfMissing writes what contents returns to key, unless something is
+// there already. Keys are content-addressed, so that is the same thing.
+func (b *bucket) putIfMissing(ctx context.Context, key, contentType string, contents func() ([]byte, error)) error {
+	if ok, err := b.store.Exists(ctx, key); err != nil || ok {
+		return err
+	}
+	c, err := contents()
+	if err != nil {
+		return err
+	}
+	return b.store.Put(ctx, key, contentType, bytes.NewReader(c))
+}
+
+// Close implements publish.Interface
+func (b *bucket) Close() error {
+	return nil
+}
+
+// cliStore writes to S3 with the aws CLI, or to GCS with the gcloud CLI, so
+// that they authenticate as they usually would.
+type cliStore struct {
+	scheme string
+	bucket string
+	prefix string
+}
+
+func (s *cliStore) url(key string) string {
+	return s.scheme + "://" + s.bucket + "/" + path.Join(s.prefix, key)
+}
+
+// Exists implements ObjectStore
+func (s *cliStore) Exists(ctx context.Context, key string) (bool, error) {
+	var cmd *exec.Cmd
+	if s.scheme == "s3" {
+		cmd = exec.CommandContext(ctx, "aws", "s3api", "head-object", "--bucket", s.bucket, "--key", path.Join(s.prefix, key))
+	} else {
+		cmd = exec.CommandContext(ctx, "gcloud", "storage", "objects", "describe", s.url(key))
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.ToLower(stderr.String())
+		if strings.Contains(msg, "404") || strings.Contains(msg, "not found") || strings.Contains(msg, "notfound") {
+			return false, nil
+		}
+		return false, fmt.Errorf("checking for %s: %v: %s", s.url(key), err, strings.TrimSpace(stderr.String()))
+	}
+	return true, nil
+}
+
+// Put implements ObjectStore
+func (s *cliStore) Put(ctx context.Context, key, contentType string, r io.Reader) error {
+	var cmd *exec.Cmd
+	if s.scheme == "s3" {
+		cmd = exec.CommandContext(ctx, "aws", "s3", "cp", "--content-type", contentType, "-", s.url(key))
+	} else {
+		cmd = exec.CommandContext(ctx, "gcloud", "storage", "cp", "--content-type="+contentType, "-", s.url(key))
+	}
+	var stderr bytes.Buffer
+	cmd.Stdin = r
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("writing %s: %v: %s", s.url(key), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// dirStore writes objects as files under dir. The content type is up to
+// whatever serves them.
+type dirStore struct {
+	dir string
+}
+
+// Exists implements ObjectStore
+func (s *dirStore) Exists(_ context.Context, key string) (bool, error) {
+	_, err := os.Stat(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put implements ObjectStore
+func (s *dirStore) Put(_ context.Context, key, _ string, r io.Reader) error {
+	p := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/ko/pkg/build"
+)
+
+// staticRegistry serves dir like a web server in front of a bucket would,
+// with manifests served as their media type.
+func staticRegistry(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(r.URL.Path)))
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			var m struct {
+				MediaType string `json:"mediaType"`
+			}
+			if err := json.Unmarshal(b, &m); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", m.MediaType)
+			w.Write(b)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+func TestBucket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-bucket")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(staticRegistry(dir))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	store, err := NewObjectStore("file://" + filepath.ToSlash(dir))
+	if err != nil {
+		t.Fatalf("NewObjectStore() = %v", err)
+	}
+	repoName := u.Host + "/ko"
+	pub := NewBucket(store, repoName, identity, []string{"latest"})
+
+	importpath := "github.com/Google/ko/cmd/ko"
+	for _, br := range []build.Result{img, idx} {
+		ref, err := pub.Publish(context.Background(), br, build.StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		want, err := br.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		if got, want := ref.String(), repoName+"/"+strings.ToLower(importpath)+"@"+want.String(); got != want {
+			t.Errorf("Publish() = %v, wanted %v", got, want)
+		}
+
+		// What was written can be pulled, by digest and by tag.
+		tag, err := name.NewTag(ref.Context().String() + ":latest")
+		if err != nil {
+			t.Fatalf("NewTag() = %v", err)
+		}
+		for _, r := range []name.Reference{ref, tag} {
+			desc, err := remote.Get(r)
+			if err != nil {
+				t.Fatalf("remote.Get(%v) = %v", r, err)
+			}
+			if desc.Digest != want {
+				t.Errorf("remote.Get(%v) = %v, wanted %v", r, desc.Digest, want)
+			}
+		}
+		if desc, _ := remote.Get(ref); desc.MediaType.IsIndex() {
+			pulled, err := remote.Index(ref)
+			if err != nil {
+				t.Fatalf("remote.Index() = %v", err)
+			}
+			im, err := pulled.IndexManifest()
+			if err != nil {
+				t.Fatalf("IndexManifest() = %v", err)
+			}
+			for _, child := range im.Manifests {
+				ci, err := pulled.Image(child.Digest)
+				if err != nil {
+					t.Fatalf("Image(%v) = %v", child.Digest, err)
+				}
+				if _, err := ci.ConfigFile(); err != nil {
+					t.Errorf("ConfigFile() = %v", err)
+				}
+			}
+		} else {
+			pulled, err := remote.Image(ref)
+			if err != nil {
+				t.Fatalf("remote.Image() = %v", err)
+			}
+			layers, err := pulled.Layers()
+			if err != nil {
+				t.Fatalf("Layers() = %v", err)
+			}
+			for _, l := range layers {
+				rc, err := l.Compressed()
+				if err != nil {
+					t.Fatalf("Compressed() = %v", err)
+				}
+				rc.Close()
+			}
+		}
+	}
+}
+
+func TestNewObjectStore(t *testing.T) {
+	for _, c := range []struct {
+		url     string
+		want    ObjectStore
+		wantErr bool
+	}{
+		{url: "s3://bucket/some/prefix/", want: &cliStore{scheme: "s3", bucket: "bucket", prefix: "some/prefix"}},
+		{url: "gs://bucket", want: &cliStore{scheme: "gs", bucket: "bucket"}},
+		{url: "file:///mnt/bucket", want: &dirStore{dir: filepath.FromSlash("/mnt/bucket")}},
+		{url: "gs:///prefix", wantErr: true},
+		{url: "azure://bucket", wantErr: true},
+	} {
+		got, err := NewObjectStore(c.url)
+		if (err != nil) != c.wantErr {
+			t.Errorf("NewObjectStore(%q) = %v, wanted error %t", c.url, err, c.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(c.want, got, cmp.AllowUnexported(cliStore{}, dirStore{})); diff != "" {
+			t.Errorf("NewObjectStore(%q) (-want +got): %s", c.url, diff)
+		}
+	}
+}
+
+func TestCLIStore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake aws is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "ko-aws")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A fake aws that records what it was asked to do, and has no objects.
+	script := `#!/bin/sh
+echo "$@" >> "` + dir + `/args"
+if [ "$1" = s3api ]; then
+  echo "An error occurred (404) when calling the HeadObject operation: Not Found" >&2
+  exit 254
+fi
+cat > "` + dir + `/stdin"
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "aws"), []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() = %v", err)
+	}
+	defer func(path string) { os.Setenv("PATH", path) }(os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	store, err := NewObjectStore("s3://bucket/prefix")
+	if err != nil {
+		t.Fatalf("NewObjectStore() = %v", err)
+	}
+	ctx := context.Background()
+	if ok, err := store.Exists(ctx, "v2/ko/blobs/sha256:abc"); err != nil || ok {
+		t.Errorf("Exists() = %t, %v; wanted false, nil", ok, err)
+	}
+	if err := store.Put(ctx, "v2/ko/manifests/latest", "application/json", strings.NewReader("{}")); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	want := `s3api head-object --bucket bucket --key prefix/v2/ko/blobs/sha256:abc
+s3 cp --content-type application/json - s3://bucket/prefix/v2/ko/manifests/latest
+`
+	if diff := cmp.Diff(want, string(args)); diff != "" {
+		t.Errorf("aws args (-want +got): %s", diff)
+	}
+	if stdin, err := ioutil.ReadFile(filepath.Join(dir, "stdin")); err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	} else if string(stdin) != "{}" {
+		t.Errorf("aws s3 cp read %q, wanted %q", stdin, "{}")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+)
+
+// bundle writes images into an OCI image layout for transfer into an
+// air-gapped environment. Each image is annotated with the reference it
+// should be pushed to, and the references returned from Publish point at the
+// target repository, so that resolved yaml can be applied once the bundle has
+// been pushed.
+//
+// Since every image carries all of its base image's layers, the layout is
+// self-contained and nothing needs to be pulled from the original registries.
+type bundle struct {
+	p     layout.Path
+	base  string
+	namer Namer
+	tags  []string
+
+	// Appending to a layout rewrites index.json, so serialize writes.
+	m sync.Mutex
+}
+
+// NewBundle returns a new publish.Interface that writes images into an OCI
+// image layout at path, naming them as if they were published under base.
+func NewBundle(path, base string, namer Namer, tags []string) (Interface, error) {
+	p, err := layout.FromPath(path)
+	if err != nil {
+		p, err = layout.Write(path, empty.Index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &bundle{
+		p:     p,
+		base:  base,
+		namer: namer,
+		tags:  tags,
+	}, nil
+}
+
+func (b *bundle) writeResult(br build.Result, annotations map[string]string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	mt, err := br.MediaType()
+	if err != nil {
+		return err
+	}
+
+	switch mt {
+	case types.OCIImageIndex, types.DockerManifestList:
+		idx, ok := br.(v1.ImageIndex)
+		if !ok {
+			return fmt.Errorf("failed to interpret result as index: %v", br)
+		}
+		return b.p.AppendIndex(idx, layout.WithAnnotations(annotations))
+	case types.OCIManifestSchema1, types.DockerManifestSchema2:
+		img, ok := br.(v1.Image)
+		if !ok {
+			return fmt.Errorf("failed to interpret result as image: %v", br)
+		}
+		return b.p.AppendImage(img, layout.WithAnnotations(annotations))
+	default:
+		return fmt.Errorf("result image media type: %s", mt)
+	}
+}
+
+// Publish implements publish.Interface
+func (b *bundle) Publish(_ context.Context, br build.Result, s string) (name.Reference, error) {
+	s = strings.TrimPrefix(s, build.StrictScheme)
+	// https://github.com/google/go-containerregistry/issues/212
+	s = strings.ToLower(s)
+
+	repo := b.namer(b.base, s)
+
+	tag := "latest"
+	if len(b.tags) > 0 {
+		tag = b.tags[0]
+	}
+	annotations := map[string]string{
+		// This is how an OCI layout records the name of an image.
+		"org.opencontainers.image.ref.name": fmt.Sprintf("%s:%s", repo, tag),
+	}
+
+	log.Printf("Bundling %v", s)
+	if err := b.writeResult(br, annotations); err != nil {
+		return nil, err
+	}
+	log.Printf("Bundled %v", s)
+
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+
+	ref := fmt.Sprintf("%s@%s", repo, h)
+	if len(b.tags) == 1 && b.tags[0] != defaultTags[0] {
+		// If a single tag is explicitly set (not latest), then this
+		// is probably a release, so include the tag in the reference.
+		ref = fmt.Sprintf("%s:%s@%s", repo, b.tags[0], h)
+	}
+	dig, err := name.NewDigest(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &dig, nil
+}
+
+// Close implements publish.Interface
+func (b *bundle) Close() error {
+	return nil
+}
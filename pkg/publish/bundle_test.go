@@ -0,0 +1,79 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestBundle(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	idx, err := random.Index(1024, 1, 2)
+	if err != nil {
+		t.Fatalf("random.Index() = %v", err)
+	}
+	base := "registry.airgap.example/team"
+	importpath := "github.com/Google/go-containerregistry/cmd/crane"
+
+	tmp, err := ioutil.TempDir("", "ko")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	bp, err := NewBundle(tmp, base, md5Hash, []string{"latest"})
+	if err != nil {
+		t.Fatalf("NewBundle() = %v", err)
+	}
+	wantPrefix := md5Hash(base, strings.ToLower(importpath)) + "@"
+	if d, err := bp.Publish(context.Background(), img, importpath); err != nil {
+		t.Errorf("Publish() = %v", err)
+	} else if !strings.HasPrefix(d.String(), wantPrefix) {
+		t.Errorf("Publish() = %v, wanted prefix %v", d, wantPrefix)
+	}
+	if d, err := bp.Publish(context.Background(), idx, importpath); err != nil {
+		t.Errorf("Publish() = %v", err)
+	} else if !strings.HasPrefix(d.String(), wantPrefix) {
+		t.Errorf("Publish() = %v, wanted prefix %v", d, wantPrefix)
+	}
+
+	ii, err := layout.ImageIndexFromPath(tmp)
+	if err != nil {
+		t.Fatalf("ImageIndexFromPath() = %v", err)
+	}
+	im, err := ii.IndexManifest()
+	if err != nil {
+		t.Fatalf("IndexManifest() = %v", err)
+	}
+	if got, want := len(im.Manifests), 2; got != want {
+		t.Fatalf("len(Manifests) = %d, want %d", got, want)
+	}
+	for _, desc := range im.Manifests {
+		want := md5Hash(base, strings.ToLower(importpath)) + ":latest"
+		if got := desc.Annotations["org.opencontainers.image.ref.name"]; got != want {
+			t.Errorf("ref.name = %q, want %q", got, want)
+		}
+	}
+}
@@ -38,6 +38,19 @@ type demon struct {
 	tags  []string
 }
 
+// localPlatform is the platform of the images that are loaded into a daemon
+// or kind: $GOOS/$GOARCH, defaulting to linux/amd64.
+func localPlatform() v1.Platform {
+	p := v1.Platform{OS: os.Getenv("GOOS"), Architecture: os.Getenv("GOARCH")}
+	if p.OS == "" {
+		p.OS = "linux"
+	}
+	if p.Architecture == "" {
+		p.Architecture = "amd64"
+	}
+	return p
+}
+
 // NewDaemon returns a new publish.Interface that publishes images to a container daemon.
 func NewDaemon(namer Namer, tags []string) Interface {
 	return &demon{namer, tags}
@@ -49,44 +62,10 @@ func (d *demon) Publish(_ context.Context, br build.Result, s string) (name.Refe
 	// https://github.com/google/go-containerregistry/issues/212
 	s = strings.ToLower(s)
 
-	// There's no way to write an index to a kind, so attempt to downcast it to an image.
-	var img v1.Image
-	switch i := br.(type) {
-	case v1.Image:
-		img = i
-	case v1.ImageIndex:
-		im, err := i.IndexManifest()
-		if err != nil {
-			return nil, err
-		}
-		goos, goarch := os.Getenv("GOOS"), os.Getenv("GOARCH")
-		if goos == "" {
-			goos = "linux"
-		}
-		if goarch == "" {
-			goarch = "amd64"
-		}
-		for _, manifest := range im.Manifests {
-			if manifest.Platform == nil {
-				continue
-			}
-			if manifest.Platform.OS != goos {
-				continue
-			}
-			if manifest.Platform.Architecture != goarch {
-				continue
-			}
-			img, err = i.Image(manifest.Digest)
-			if err != nil {
-				return nil, err
-			}
-			break
-		}
-		if img == nil {
-			return nil, fmt.Errorf("failed to find %s/%s image in index for image: %v", goos, goarch, s)
-		}
-	default:
-		return nil, fmt.Errorf("failed to interpret %s result as image: %v", s, br)
+	// There's no way to write an index to a daemon, so pick the image for the local platform.
+	img, err := build.ImageFor(br, localPlatform())
+	if err != nil {
+		return nil, fmt.Errorf("failed to interpret %s result as image: %v", s, err)
 	}
 
 	h, err := img.Digest()
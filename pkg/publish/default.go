@@ -28,6 +28,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/google/ko/pkg/build"
+	"github.com/google/ko/pkg/internal/trace"
 )
 
 // defalt is intentionally misspelled to avoid keyword collision (and drive Jon nuts).
@@ -39,6 +40,8 @@ type defalt struct {
 	namer     Namer
 	tags      []string
 	insecure  bool
+	jobs      int
+	hooks     []Hooks
 }
 
 // Option is a functional option for NewDefault.
@@ -52,6 +55,8 @@ type defaultOpener struct {
 	namer     Namer
 	tags      []string
 	insecure  bool
+	jobs      int
+	hooks     []Hooks
 }
 
 // Namer is a function from a supported import path to the portion of the resulting
@@ -69,7 +74,7 @@ func identity(base, in string) string { return path.Join(base, in) }
 var defaultTags = []string{"latest"}
 
 func (do *defaultOpener) Open() (Interface, error) {
-	return &defalt{
+	return NewHooked(&defalt{
 		base:      do.base,
 		t:         do.t,
 		userAgent: do.userAgent,
@@ -77,7 +82,9 @@ func (do *defaultOpener) Open() (Interface, error) {
 		namer:     do.namer,
 		tags:      do.tags,
 		insecure:  do.insecure,
-	}, nil
+		jobs:      do.jobs,
+		hooks:     do.hooks,
+	}, do.hooks...), nil
 }
 
 // NewDefault returns a new publish.Interface that publishes references under the provided base
@@ -101,7 +108,7 @@ func NewDefault(base string, options ...Option) (Interface, error) {
 	return do.Open()
 }
 
-func pushResult(tag name.Tag, br build.Result, opt []remote.Option) error {
+func pushResult(tag name.Reference, br build.Result, opt []remote.Option) error {
 	mt, err := br.MediaType()
 	if err != nil {
 		return err
@@ -127,16 +134,51 @@ func pushResult(tag name.Tag, br build.Result, opt []remote.Option) error {
 
 // Publish implements publish.Interface
 func (d *defalt) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	progress, err := newUploadProgress(ctx, d.hooks, s, br)
+	if err != nil {
+		return nil, err
+	}
 	s = strings.TrimPrefix(s, build.StrictScheme)
 	// https://github.com/google/go-containerregistry/issues/212
 	s = strings.ToLower(s)
 
-	ro := []remote.Option{remote.WithAuth(d.auth), remote.WithTransport(d.t), remote.WithContext(ctx), remote.WithUserAgent(d.userAgent)}
+	t := d.t
+	if progress != nil {
+		t = &progressTransport{inner: t, add: progress.add}
+	}
+	ro := []remote.Option{remote.WithAuth(d.auth), remote.WithTransport(t), remote.WithContext(ctx), remote.WithUserAgent(d.userAgent)}
+	if d.jobs > 0 {
+		ro = append(ro, remote.WithJobs(d.jobs))
+	}
 	no := []name.Option{}
 	if d.insecure {
 		no = append(no, name.Insecure)
 	}
 
+	if len(d.tags) == 0 {
+		// Without tags, push by digest alone, and don't leave any
+		// tags of our own behind either.
+		h, err := br.Digest()
+		if err != nil {
+			return nil, err
+		}
+		dig, err := name.NewDigest(fmt.Sprintf("%s@%s", d.namer(d.base, s), h), no...)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Publishing %v", dig)
+		_, span := trace.Start(ctx, "push")
+		span.SetAttribute("ko.reference", dig.String())
+		err = pushResult(dig, br, ro)
+		span.End(err)
+		if err != nil {
+			return nil, checkDenied(err)
+		}
+		progress.done()
+		log.Printf("Published %v", dig)
+		return &dig, nil
+	}
+
 	for i, tagName := range d.tags {
 		tag, err := name.NewTag(fmt.Sprintf("%s:%s", d.namer(d.base, s), tagName), no...)
 		if err != nil {
@@ -145,17 +187,33 @@ func (d *defalt) Publish(ctx context.Context, br build.Result, s string) (name.R
 
 		if i == 0 {
 			log.Printf("Publishing %v", tag)
-			if err := pushResult(tag, br, ro); err != nil {
-				return nil, err
+			_, span := trace.Start(ctx, "push")
+			span.SetAttribute("ko.reference", tag.String())
+			err := pushResult(tag, br, ro)
+			span.End(err)
+			if err != nil {
+				return nil, checkDenied(err)
 			}
+			progress.done()
 		} else {
 			log.Printf("Tagging %v", tag)
 			if err := remote.Tag(tag, br, ro...); err != nil {
-				return nil, err
+				return nil, checkDenied(err)
 			}
 		}
 	}
 
+	// Record the inputs of the build, so that it can be skipped next time.
+	if inputs, ok := build.InputsDigest(br); ok {
+		tag, err := name.NewTag(fmt.Sprintf("%s:%s", d.namer(d.base, s), InputsTag(inputs)), no...)
+		if err != nil {
+			return nil, err
+		}
+		if err := remote.Tag(tag, br, ro...); err != nil {
+			return nil, checkDenied(err)
+		}
+	}
+
 	h, err := br.Digest()
 	if err != nil {
 		return nil, err
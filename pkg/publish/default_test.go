@@ -155,6 +155,45 @@ func TestDefaultWithTags(t *testing.T) {
 	}
 }
 
+func TestDefaultDigestOnly(t *testing.T) {
+	for _, br := range []build.Result{img, idx} {
+		importpath := "github.com/google/ko/cmd/ko"
+
+		server := httptest.NewServer(registry.New())
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+		}
+		repoName := fmt.Sprintf("%s/blah", u.Host)
+
+		def, err := NewDefault(repoName, WithTags(nil))
+		if err != nil {
+			t.Fatalf("NewDefault() = %v", err)
+		}
+		ref, err := def.Publish(context.Background(), br, build.StrictScheme+importpath)
+		if err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+		if _, ok := ref.(*name.Digest); !ok {
+			t.Errorf("Publish() = %v, wanted a digest", ref)
+		}
+
+		want, err := br.Digest()
+		if err != nil {
+			t.Fatalf("Digest() = %v", err)
+		}
+		if got, err := crane.Digest(ref.String()); err != nil {
+			t.Fatalf("crane.Digest(%v) = %v", ref, err)
+		} else if got != want.String() {
+			t.Errorf("crane.Digest(%v) = %v, wanted %v", ref, got, want)
+		}
+		if _, err := crane.Digest(ref.Context().Tag("latest").String()); err == nil {
+			t.Error("crane.Digest(:latest) = nil, wanted no latest tag")
+		}
+	}
+}
+
 func TestDefaultWithReleaseTag(t *testing.T) {
 	img, err := random.Image(1024, 1)
 	if err != nil {
@@ -0,0 +1,67 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ErrPushDenied means that the registry refused to let the current
+// credentials push an image.
+var ErrPushDenied = errors.New("push denied")
+
+// pushDeniedError wraps the error from a registry that refused a push, so
+// that errors.Is matches ErrPushDenied while errors.As still finds the
+// *transport.Error.
+type pushDeniedError struct {
+	err error
+}
+
+// Error implements error
+func (e *pushDeniedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPushDenied, e.err)
+}
+
+// Is reports whether target is ErrPushDenied.
+func (e *pushDeniedError) Is(target error) bool {
+	return target == ErrPushDenied
+}
+
+// Unwrap returns the underlying error.
+func (e *pushDeniedError) Unwrap() error {
+	return e.err
+}
+
+// checkDenied wraps err in a pushDeniedError if the registry refused the
+// push because of the credentials used.
+func checkDenied(err error) error {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return err
+	}
+	if terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden {
+		return &pushDeniedError{err: err}
+	}
+	for _, d := range terr.Errors {
+		if d.Code == transport.UnauthorizedErrorCode || d.Code == transport.DeniedErrorCode {
+			return &pushDeniedError{err: err}
+		}
+	}
+	return err
+}
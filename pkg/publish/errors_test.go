@@ -0,0 +1,68 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/ko/pkg/build"
+)
+
+func TestPushDenied(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":[{"code":"DENIED","message":"requested access to the resource is denied"}]}`))
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+
+	def, err := NewDefault(u.Host + "/blah")
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	_, err = def.Publish(context.Background(), img, build.StrictScheme+"github.com/google/ko")
+	if !errors.Is(err, ErrPushDenied) {
+		t.Errorf("Publish() = %v, wanted %v", err, ErrPushDenied)
+	}
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		t.Errorf("Publish() = %v, wanted a *transport.Error", err)
+	}
+}
+
+func TestCheckDeniedOtherErrors(t *testing.T) {
+	for _, err := range []error{
+		errors.New("connection refused"),
+		&transport.Error{StatusCode: http.StatusInternalServerError},
+	} {
+		if got := checkDenied(err); errors.Is(got, ErrPushDenied) {
+			t.Errorf("checkDenied(%v) = %v, wanted it not to be %v", err, got, ErrPushDenied)
+		}
+	}
+}
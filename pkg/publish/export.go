@@ -0,0 +1,334 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	tarfile "archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+type export struct {
+	dir   string
+	base  string
+	namer Namer
+}
+
+// NewExport returns a new publish.Interface that flattens images into
+// directories under dir (like crane export, but unpacked), named by namer.
+// The images of an index each go in a subdirectory named after their
+// platform, e.g. linux_arm64. References are named under base, but nothing
+// is pushed there.
+func NewExport(dir, base string, namer Namer) Interface {
+	return &export{
+		dir:   dir,
+		base:  base,
+		namer: namer,
+	}
+}
+
+// Publish implements publish.Interface.
+func (e *export) Publish(_ context.Context, br build.Result, s string) (name.Reference, error) {
+	s = strings.ToLower(strings.TrimPrefix(s, build.StrictScheme))
+	dir := filepath.FromSlash(e.namer(filepath.ToSlash(e.dir), s))
+
+	switch br := br.(type) {
+	case v1.ImageIndex:
+		im, err := br.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, desc := range im.Manifests {
+			if desc.Platform == nil {
+				continue
+			}
+			img, err := br.Image(desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			p := desc.Platform
+			sub := strings.Join([]string{p.OS, p.Architecture, p.Variant}, "_")
+			if err := exportImage(img, filepath.Join(dir, strings.TrimSuffix(sub, "_")), s); err != nil {
+				return nil, err
+			}
+		}
+	case v1.Image:
+		if err := exportImage(br, dir, s); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("result of type %T is not an image or index", br)
+	}
+
+	h, err := br.Digest()
+	if err != nil {
+		return nil, err
+	}
+	dig, err := name.NewDigest(fmt.Sprintf("%s@%s", e.namer(e.base, s), h))
+	if err != nil {
+		return nil, err
+	}
+	return &dig, nil
+}
+
+// Close implements publish.Interface.
+func (e *export) Close() error {
+	return nil
+}
+
+func exportImage(img v1.Image, dir, s string) error {
+	if artifact, err := build.IsArtifact(img); err != nil {
+		return err
+	} else if artifact {
+		return fmt.Errorf("%s is an artifact, which has no filesystem to export", s)
+	}
+	// Files of earlier exports could be mistaken for the image's, so only
+	// export to somewhere new.
+	if !emptyDir(dir) {
+		return fmt.Errorf("can't export %s to %s, which isn't empty", s, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	log.Printf("Exporting %v to %v", s, dir)
+	layers, err := img.Layers()
+	if err != nil {
+		return err
+	}
+	// Layers are applied in order, rather than flattened first, so that
+	// each is written through the symlinks of those below it, like at
+	// runtime.
+	dirs := map[string]*tarfile.Header{}
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return err
+		}
+		err = extract(tarfile.NewReader(rc), dir, dirs)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", s, err)
+		}
+	}
+
+	// Directories are only given their mode and time at the end, so that
+	// read-only ones can still be written to, deepest first.
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.Count(names[i], "/") > strings.Count(names[j], "/")
+	})
+	for _, name := range names {
+		hdr := dirs[name]
+		target, err := resolveInRoot(dir, name, true)
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(target, hdr.FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	log.Printf("Exported %v to %v", s, dir)
+	return nil
+}
+
+// emptyDir returns whether dir is empty or doesn't exist.
+func emptyDir(dir string) bool {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return true
+	} else if err != nil {
+		return false
+	}
+	defer f.Close()
+	_, err = f.Readdirnames(1)
+	return err == io.EOF
+}
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// extract writes the files of the layer tr over root, as if root were /,
+// and adds its directories to dirs. Ownership isn't kept, and devices are
+// skipped, so that it works unprivileged.
+func extract(tr *tarfile.Reader, root string, dirs map[string]*tarfile.Header) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := path.Clean("/" + hdr.Name)
+		target, err := resolveInRoot(root, name, false)
+		if err != nil {
+			return err
+		}
+		if target == root {
+			continue
+		}
+
+		if base := path.Base(name); base == opaqueWhiteout {
+			// Hide everything below that came from lower layers.
+			parent, err := resolveInRoot(root, path.Dir(name), true)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(parent)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			children, err := f.Readdirnames(-1)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			for _, child := range children {
+				if err := os.RemoveAll(filepath.Join(parent, child)); err != nil {
+					return err
+				}
+			}
+			continue
+		} else if strings.HasPrefix(base, whiteoutPrefix) {
+			hidden := path.Join(path.Dir(name), strings.TrimPrefix(base, whiteoutPrefix))
+			target, err := resolveInRoot(root, hidden, false)
+			if err != nil {
+				return err
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			delete(dirs, hidden)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tarfile.TypeDir {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			delete(dirs, name)
+		}
+
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tarfile.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs[name] = hdr
+		case tarfile.TypeReg, tarfile.TypeRegA:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tarfile.TypeSymlink:
+			// Symlinks are written as-is: they only mean anything
+			// inside the image.
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tarfile.TypeLink:
+			old, err := resolveInRoot(root, hdr.Linkname, true)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(old, target); err != nil {
+				return err
+			}
+		default:
+			log.Printf("Skipping %s, which can't be exported (type %q)", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// maxSymlinks bounds how many symlinks resolveInRoot follows, like ELOOP.
+const maxSymlinks = 255
+
+// resolveInRoot returns where name is under root, following the symlinks
+// of its parent directories (and of name itself, if follow) as if root were
+// /, so that a symlink in the image can't lead outside of root.
+func resolveInRoot(root, name string, follow bool) (string, error) {
+	resolved := "/"
+	rest := strings.Split(path.Clean("/"+filepath.ToSlash(name)), "/")
+	for links := 0; len(rest) != 0; {
+		part := rest[0]
+		rest = rest[1:]
+		if part == "" {
+			continue
+		}
+		next := path.Join(resolved, part)
+		if len(rest) == 0 && !follow {
+			resolved = next
+			break
+		}
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// Whatever doesn't exist yet will be created as a
+			// directory, rather than followed.
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", name)
+		}
+		dest, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(dest) {
+			dest = path.Join(resolved, dest)
+		}
+		rest = append(strings.Split(path.Clean(dest), "/"), rest...)
+		resolved = "/"
+	}
+	return filepath.Join(root, filepath.FromSlash(resolved)), nil
+}
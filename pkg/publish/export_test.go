@@ -0,0 +1,142 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	tarfile "archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/ko/pkg/build"
+)
+
+// tarLayer returns a layer of the given entries, whose files contain their
+// names.
+func tarLayer(t *testing.T, hdrs ...*tarfile.Header) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tarfile.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tarfile.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("WriteHeader() = %v", err)
+		}
+		if hdr.Typeflag == tarfile.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("LayerFromOpener() = %v", err)
+	}
+	return layer
+}
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ko-export")
+	if err != nil {
+		t.Fatalf("TempDir() = %v", err)
+	}
+	defer func() {
+		// Read-only directories can't be emptied.
+		filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && fi.IsDir() {
+				os.Chmod(p, 0755)
+			}
+			return nil
+		})
+		os.RemoveAll(dir)
+	}()
+
+	img, err := mutate.AppendLayers(empty.Image,
+		tarLayer(t,
+			&tarfile.Header{Name: "usr/lib/", Typeflag: tarfile.TypeDir, Mode: 0755},
+			&tarfile.Header{Name: "lib", Typeflag: tarfile.TypeSymlink, Linkname: "/usr/lib"},
+			&tarfile.Header{Name: "escape", Typeflag: tarfile.TypeSymlink, Linkname: "../../../.."},
+			&tarfile.Header{Name: "ko-app/", Typeflag: tarfile.TypeDir, Mode: 0555},
+			&tarfile.Header{Name: "ko-app/app", Typeflag: tarfile.TypeReg, Mode: 0755},
+			&tarfile.Header{Name: "ko-app/alias", Typeflag: tarfile.TypeLink, Linkname: "ko-app/app"},
+			&tarfile.Header{Name: "tmp/gone", Typeflag: tarfile.TypeReg, Mode: 0644},
+		),
+		tarLayer(t,
+			&tarfile.Header{Name: "tmp/.wh.gone", Typeflag: tarfile.TypeReg, Mode: 0644},
+			&tarfile.Header{Name: "lib/libko.so", Typeflag: tarfile.TypeReg, Mode: 0644},
+			&tarfile.Header{Name: "escape/evil", Typeflag: tarfile.TypeReg, Mode: 0644},
+		),
+	)
+	if err != nil {
+		t.Fatalf("AppendLayers() = %v", err)
+	}
+	p := v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: v1.Descriptor{Platform: &p},
+	})
+
+	importpath := "github.com/Google/ko/cmd/app"
+	ep := NewExport(dir, "example.com/blah", md5Hash)
+	if d, err := ep.Publish(context.Background(), idx, build.StrictScheme+importpath); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	} else if want := md5Hash("example.com/blah", strings.ToLower(importpath)); !strings.HasPrefix(d.String(), want) {
+		t.Errorf("Publish() = %v, wanted prefix %v", d, want)
+	}
+
+	root := filepath.Join(md5Hash(dir, strings.ToLower(importpath)), "linux_arm_v7")
+	for name, want := range map[string]string{
+		"ko-app/app":       "ko-app/app",
+		"ko-app/alias":     "ko-app/app",
+		"usr/lib/libko.so": "lib/libko.so",
+		// Symlinks are followed as if root were /.
+		"evil": "escape/evil",
+	} {
+		got, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("ReadFile(%s) = %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("ReadFile(%s) = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(root, "tmp", "gone")); !os.IsNotExist(err) {
+		t.Errorf("Lstat(tmp/gone) = %v, want it whited out", err)
+	}
+	if dest, err := os.Readlink(filepath.Join(root, "lib")); err != nil || dest != "/usr/lib" {
+		t.Errorf("Readlink(lib) = %q, %v, want /usr/lib", dest, err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "ko-app")); err != nil || fi.Mode().Perm() != 0555 {
+		t.Errorf("Stat(ko-app) = %v, %v, want mode 0555", fi, err)
+	}
+
+	// Exporting over an earlier export could mix the two up.
+	if _, err := ep.Publish(context.Background(), idx, build.StrictScheme+importpath); err == nil {
+		t.Error("Publish() = nil, wanted an error for a directory that isn't empty")
+	}
+}
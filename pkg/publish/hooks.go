@@ -0,0 +1,105 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/ko/pkg/build"
+)
+
+// Hooks are called as publishes start and end, so that tools embedding ko can
+// report progress or collect metrics. Any hook may be nil. Publishes run
+// concurrently, so hooks must be safe to call concurrently.
+type Hooks struct {
+	OnPublishStart func(context.Context, PublishStart)
+	OnPublishEnd   func(context.Context, PublishEnd)
+	// OnPublishProgress is called as the default publisher uploads blobs.
+	// Other publishers don't call it.
+	OnPublishProgress func(context.Context, PublishProgress)
+}
+
+// PublishProgress describes how much of an image has been uploaded. Blobs
+// that the registry already has aren't uploaded, so Complete jumps to Total
+// once the push is done.
+type PublishProgress struct {
+	ImportPath string
+	Complete   int64
+	Total      int64
+}
+
+// PublishStart describes a publish that is starting.
+type PublishStart struct {
+	ImportPath string
+	// Digest is the digest of the build.Result being published.
+	Digest  string
+	Started time.Time
+}
+
+// PublishEnd describes a publish that has finished. Reference is set if Err
+// is nil.
+type PublishEnd struct {
+	PublishStart
+	Reference name.Reference
+	Seconds   float64
+	Err       error
+}
+
+// NewHooked returns a publish.Interface that calls the given hooks around
+// each call to the given publisher's Publish.
+func NewHooked(inner Interface, hooks ...Hooks) Interface {
+	if len(hooks) == 0 {
+		return inner
+	}
+	return &hooked{inner: inner, hooks: hooks}
+}
+
+type hooked struct {
+	inner Interface
+	hooks []Hooks
+}
+
+// Publish implements publish.Interface
+func (h *hooked) Publish(ctx context.Context, br build.Result, s string) (name.Reference, error) {
+	start := PublishStart{ImportPath: s, Started: time.Now()}
+	if d, err := br.Digest(); err == nil {
+		start.Digest = d.String()
+	}
+	for _, hook := range h.hooks {
+		if hook.OnPublishStart != nil {
+			hook.OnPublishStart(ctx, start)
+		}
+	}
+	ref, err := h.inner.Publish(ctx, br, s)
+	end := PublishEnd{
+		PublishStart: start,
+		Reference:    ref,
+		Seconds:      time.Since(start.Started).Seconds(),
+		Err:          err,
+	}
+	for _, hook := range h.hooks {
+		if hook.OnPublishEnd != nil {
+			hook.OnPublishEnd(ctx, end)
+		}
+	}
+	return ref, err
+}
+
+// Close implements publish.Interface
+func (h *hooked) Close() error {
+	return h.inner.Close()
+}
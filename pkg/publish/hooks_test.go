@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/ko/pkg/build"
+)
+
+type failpublish struct{ err error }
+
+func (f failpublish) Publish(context.Context, build.Result, string) (name.Reference, error) {
+	return nil, f.err
+}
+
+func (f failpublish) Close() error {
+	return nil
+}
+
+func TestHooked(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("random.Image() = %v", err)
+	}
+	h, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+
+	boom := errors.New("boom")
+	var starts []PublishStart
+	var ends []PublishEnd
+	pub := NewHooked(failpublish{boom}, Hooks{
+		OnPublishStart: func(_ context.Context, e PublishStart) { starts = append(starts, e) },
+	}, Hooks{
+		OnPublishEnd: func(_ context.Context, e PublishEnd) { ends = append(ends, e) },
+	})
+
+	if _, err := pub.Publish(context.Background(), img, "github.com/foo/bar"); !errors.Is(err, boom) {
+		t.Errorf("Publish() = %v, wanted %v", err, boom)
+	}
+	if len(starts) != 1 || starts[0].ImportPath != "github.com/foo/bar" || starts[0].Digest != h.String() {
+		t.Errorf("OnPublishStart got %v", starts)
+	}
+	if len(ends) != 1 || !errors.Is(ends[0].Err, boom) || ends[0].Digest != h.String() {
+		t.Errorf("OnPublishEnd got %v", ends)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/google/ko/pkg/build"
+)
+
+// InputsTag is the tag that the default publisher gives an image built from
+// inputs with the given digest (see build.WithLookup), alongside its other
+// tags.
+func InputsTag(inputs string) string {
+	return "ko-inputs-" + inputs
+}
+
+// NewLookup returns a build.Lookup that finds images that the default
+// publisher, configured with the same base repository and options, already
+// published from the same inputs.
+func NewLookup(base string, options ...Option) (build.Lookup, error) {
+	do := &defaultOpener{
+		base:      base,
+		t:         http.DefaultTransport,
+		userAgent: "ko",
+		auth:      authn.Anonymous,
+		namer:     identity,
+	}
+	for _, option := range options {
+		if err := option(do); err != nil {
+			return nil, err
+		}
+	}
+	no := []name.Option{}
+	if do.insecure {
+		no = append(no, name.Insecure)
+	}
+
+	return func(ctx context.Context, importpath, inputs string) (build.Result, error) {
+		s := strings.ToLower(strings.TrimPrefix(importpath, build.StrictScheme))
+		tag, err := name.NewTag(fmt.Sprintf("%s:%s", do.namer(do.base, s), InputsTag(inputs)), no...)
+		if err != nil {
+			return nil, err
+		}
+		desc, err := remote.Get(tag, remote.WithAuth(do.auth), remote.WithTransport(do.t),
+			remote.WithContext(ctx), remote.WithUserAgent(do.userAgent))
+		if err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		switch desc.MediaType {
+		case types.OCIImageIndex, types.DockerManifestList:
+			return desc.ImageIndex()
+		default:
+			return desc.Image()
+		}
+	}, nil
+}
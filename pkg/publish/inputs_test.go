@@ -0,0 +1,92 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// inputsImage is an image that knows the digest of its inputs, like those
+// built with build.WithLookup.
+type inputsImage struct {
+	v1.Image
+	inputs string
+}
+
+func (i *inputsImage) InputsDigest() string {
+	return i.inputs
+}
+
+func TestLookup(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+	}
+	repoName := fmt.Sprintf("%s/blah", u.Host)
+	importpath := build.StrictScheme + "github.com/Google/go-containerregistry/cmd/crane"
+
+	lookup, err := NewLookup(repoName)
+	if err != nil {
+		t.Fatalf("NewLookup() = %v", err)
+	}
+	if res, err := lookup(context.Background(), importpath, "abc"); err != nil {
+		t.Fatalf("lookup() = %v", err)
+	} else if res != nil {
+		t.Fatalf("lookup() = %v, want nothing before publishing", res)
+	}
+
+	def, err := NewDefault(repoName)
+	if err != nil {
+		t.Fatalf("NewDefault() = %v", err)
+	}
+	if _, err := def.Publish(context.Background(), &inputsImage{Image: img, inputs: "abc"}, importpath); err != nil {
+		t.Fatalf("Publish() = %v", err)
+	}
+
+	res, err := lookup(context.Background(), importpath, "abc")
+	if err != nil {
+		t.Fatalf("lookup() = %v", err)
+	}
+	if res == nil {
+		t.Fatal("lookup() = nil, want the published image")
+	}
+	got, err := res.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatalf("Digest() = %v", err)
+	}
+	if got != want {
+		t.Errorf("lookup() = %v, want %v", got, want)
+	}
+
+	if res, err := lookup(context.Background(), importpath, "def"); err != nil {
+		t.Fatalf("lookup() = %v", err)
+	} else if res != nil {
+		t.Errorf("lookup() = %v, want nothing for other inputs", res)
+	}
+}
@@ -86,7 +86,26 @@ func WithNamer(n Namer) Option {
 	}
 }
 
-// WithTags is a functional option for overriding the image tags
+// WithJobs is a functional option for overriding how many layers of an image
+// are uploaded at once.
+func WithJobs(jobs int) Option {
+	return func(i *defaultOpener) error {
+		i.jobs = jobs
+		return nil
+	}
+}
+
+// WithHooks is a functional option for calling hooks as each publish starts
+// and ends. It may be given more than once.
+func WithHooks(hooks Hooks) Option {
+	return func(i *defaultOpener) error {
+		i.hooks = append(i.hooks, hooks)
+		return nil
+	}
+}
+
+// WithTags is a functional option for overriding the image tags. With no
+// tags, images are pushed by digest alone.
 func WithTags(tags []string) Option {
 	return func(i *defaultOpener) error {
 		i.tags = tags
@@ -0,0 +1,163 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+// uploadProgress calls the OnPublishProgress hooks as the blobs of an image
+// are uploaded.
+type uploadProgress struct {
+	ctx   context.Context
+	hooks []Hooks
+
+	mu       sync.Mutex
+	progress PublishProgress
+}
+
+// newUploadProgress returns nil unless one of hooks wants to know how the
+// push of br goes.
+func newUploadProgress(ctx context.Context, hooks []Hooks, ip string, br build.Result) (*uploadProgress, error) {
+	var want []Hooks
+	for _, h := range hooks {
+		if h.OnPublishProgress != nil {
+			want = append(want, h)
+		}
+	}
+	if len(want) == 0 {
+		return nil, nil
+	}
+	total, err := uploadSize(br, map[v1.Hash]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return &uploadProgress{
+		ctx:      ctx,
+		hooks:    want,
+		progress: PublishProgress{ImportPath: ip, Total: total},
+	}, nil
+}
+
+// add records that n more bytes were uploaded.
+func (p *uploadProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Complete += n
+	if p.progress.Complete > p.progress.Total {
+		// Retried uploads are counted twice.
+		p.progress.Complete = p.progress.Total
+	}
+	p.report()
+}
+
+// done records that every blob is in the registry, uploaded or not.
+func (p *uploadProgress) done() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.progress.Complete = p.progress.Total
+	p.report()
+}
+
+func (p *uploadProgress) report() {
+	for _, h := range p.hooks {
+		h.OnPublishProgress(p.ctx, p.progress)
+	}
+}
+
+// uploadSize is how many bytes pushing br uploads at most: the size of each
+// of the blobs of its images, other than those in seen.
+func uploadSize(br build.Result, seen map[v1.Hash]bool) (int64, error) {
+	if idx, ok := br.(v1.ImageIndex); ok {
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return 0, err
+		}
+		var total int64
+		for _, desc := range im.Manifests {
+			var child build.Result
+			if desc.MediaType.IsIndex() {
+				child, err = idx.ImageIndex(desc.Digest)
+			} else {
+				child, err = idx.Image(desc.Digest)
+			}
+			if err != nil {
+				return 0, err
+			}
+			size, err := uploadSize(child, seen)
+			if err != nil {
+				return 0, err
+			}
+			total += size
+		}
+		return total, nil
+	}
+	img, ok := br.(v1.Image)
+	if !ok {
+		return 0, nil
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, desc := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+		if !seen[desc.Digest] {
+			seen[desc.Digest] = true
+			total += desc.Size
+		}
+	}
+	return total, nil
+}
+
+// progressTransport counts the bytes of the blobs that are uploaded through
+// it.
+type progressTransport struct {
+	inner http.RoundTripper
+	add   func(int64)
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *progressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || (req.Method != http.MethodPut && req.Method != http.MethodPatch) || !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		return t.inner.RoundTrip(req)
+	}
+	counted := *req
+	counted.Body = &countingReader{ReadCloser: req.Body, add: t.add}
+	return t.inner.RoundTrip(&counted)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	add func(int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/ko/pkg/build"
+)
+
+func TestPublishProgress(t *testing.T) {
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest() = %v", err)
+	}
+	want := m.Config.Size
+	for _, l := range m.Layers {
+		want += l.Size
+	}
+
+	for _, br := range []build.Result{img, idx} {
+		server := httptest.NewServer(registry.New())
+		defer server.Close()
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			t.Fatalf("url.Parse(%v) = %v", server.URL, err)
+		}
+
+		var mu sync.Mutex
+		var events []PublishProgress
+		def, err := NewDefault(u.Host+"/blah", WithHooks(Hooks{
+			OnPublishProgress: func(_ context.Context, p PublishProgress) {
+				mu.Lock()
+				defer mu.Unlock()
+				events = append(events, p)
+			},
+		}))
+		if err != nil {
+			t.Fatalf("NewDefault() = %v", err)
+		}
+		if _, err := def.Publish(context.Background(), br, build.StrictScheme+"github.com/google/ko"); err != nil {
+			t.Fatalf("Publish() = %v", err)
+		}
+
+		// The registry is empty, so blobs are uploaded (and counted) before
+		// the push is done.
+		if len(events) < 2 {
+			t.Fatalf("got %d progress events, want at least 2", len(events))
+		}
+		for i, e := range events {
+			if e.ImportPath != build.StrictScheme+"github.com/google/ko" {
+				t.Errorf("ImportPath = %q", e.ImportPath)
+			}
+			if i > 0 && e.Complete < events[i-1].Complete {
+				t.Errorf("Complete went from %d to %d", events[i-1].Complete, e.Complete)
+			}
+		}
+		last := events[len(events)-1]
+		if last.Complete != last.Total {
+			t.Errorf("last progress = %d/%d, want done", last.Complete, last.Total)
+		}
+		if _, ok := br.(v1.Image); ok && last.Total != want {
+			t.Errorf("Total = %d, want %d", last.Total, want)
+		}
+	}
+}
@@ -56,6 +56,11 @@ func (t *tar) Publish(_ context.Context, br build.Result, s string) (name.Refere
 	if !ok {
 		return nil, fmt.Errorf("failed to interpret %s result as image: %v", s, br)
 	}
+	if artifact, err := build.IsArtifact(img); err != nil {
+		return nil, err
+	} else if artifact {
+		return nil, fmt.Errorf("%s is an artifact, which can't be written to a tarball", s)
+	}
 
 	for _, tagName := range t.tags {
 		tag, err := name.ParseReference(fmt.Sprintf("%s:%s", t.namer(t.base, s), tagName))
@@ -0,0 +1,44 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"strings"
+
+	"github.com/google/ko/pkg/build"
+	"gopkg.in/yaml.v3"
+)
+
+// ExpandAliases rewrites references to aliases within the input yaml (e.g.
+// ko://controller) to references to the import paths they stand for (e.g.
+// ko://github.com/acme/platform/cmd/controller), so that they can be resolved
+// by ImageReferences. Aliases are matched case-insensitively.
+//
+// References to anything other than an alias are left untouched.
+func ExpandAliases(docs []*yaml.Node, aliases map[string]string) {
+	if len(aliases) == 0 {
+		return
+	}
+	for _, doc := range docs {
+		it := refsFromDoc(doc)
+
+		for node, ok := it(); ok; node, ok = it() {
+			alias := strings.TrimSpace(strings.TrimPrefix(node.Value, build.StrictScheme))
+			if ip, ok := aliases[strings.ToLower(alias)]; ok {
+				node.Value = build.StrictScheme + ip
+			}
+		}
+	}
+}
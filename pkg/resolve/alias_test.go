@@ -0,0 +1,71 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+func TestExpandAliases(t *testing.T) {
+	input := `apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+  - name: controller
+    image: ko://Controller
+  - name: other
+    image: ko://github.com/foo/other
+  - name: sidecar
+    image: controller
+`
+	want := `apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+    - name: controller
+      image: ko://github.com/acme/platform/cmd/controller
+    - name: other
+      image: ko://github.com/foo/other
+    - name: sidecar
+      image: controller
+`
+
+	var doc yaml.Node
+	if err := yaml.NewDecoder(strings.NewReader(input)).Decode(&doc); err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	ExpandAliases([]*yaml.Node{&doc}, map[string]string{
+		"controller": "github.com/acme/platform/cmd/controller",
+	})
+
+	var buf bytes.Buffer
+	e := yaml.NewEncoder(&buf)
+	e.SetIndent(2)
+	if err := e.Encode(&doc); err != nil {
+		t.Fatalf("Encode() = %v", err)
+	}
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("ExpandAliases() (-want +got) = %s", diff)
+	}
+}
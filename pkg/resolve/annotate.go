@@ -0,0 +1,132 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/ko/pkg/build"
+	"gopkg.in/yaml.v3"
+)
+
+// PodTemplate is a Pod, or the pod template of a workload, whose containers
+// reference import paths.
+type PodTemplate struct {
+	// ImportPaths are the references of the containers (and init
+	// containers) of the pod, in order, as they appear in the yaml.
+	ImportPaths []string
+
+	// obj is the mapping that holds the pod's metadata and spec.
+	obj *yaml.Node
+}
+
+// PodTemplates returns the pod templates within docs whose containers
+// reference import paths. It must be called before ImageReferences, which
+// replaces those references.
+func PodTemplates(docs []*yaml.Node) []*PodTemplate {
+	var templates []*PodTemplate
+	for _, doc := range docs {
+		walkMappings(doc, func(obj *yaml.Node) {
+			spec := mapValue(obj, "spec")
+			if spec == nil || spec.Kind != yaml.MappingNode {
+				return
+			}
+			var ips []string
+			for _, key := range []string{"initContainers", "containers"} {
+				containers := mapValue(spec, key)
+				if containers == nil || containers.Kind != yaml.SequenceNode {
+					continue
+				}
+				for _, c := range containers.Content {
+					image := mapValue(c, "image")
+					if image == nil || image.Kind != yaml.ScalarNode {
+						continue
+					}
+					if ref := strings.TrimSpace(image.Value); strings.HasPrefix(ref, build.StrictScheme) {
+						ips = append(ips, ref)
+					}
+				}
+			}
+			if len(ips) != 0 {
+				templates = append(templates, &PodTemplate{ImportPaths: ips, obj: obj})
+			}
+		})
+	}
+	return templates
+}
+
+// Annotate sets the given annotations in the pod's metadata, creating the
+// metadata and annotations if necessary.
+func (p *PodTemplate) Annotate(annotations map[string]string) {
+	if len(annotations) == 0 {
+		return
+	}
+	metadata := ensureMapping(p.obj, "metadata")
+	anns := ensureMapping(metadata, "annotations")
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := mapValue(anns, k); v != nil {
+			v.Kind, v.Tag, v.Value, v.Content = yaml.ScalarNode, "!!str", annotations[k], nil
+			continue
+		}
+		anns.Content = append(anns.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: annotations[k]},
+		)
+	}
+}
+
+// walkMappings calls f for every mapping node within n.
+func walkMappings(n *yaml.Node, f func(*yaml.Node)) {
+	if n.Kind == yaml.MappingNode {
+		f(n)
+	}
+	for _, c := range n.Content {
+		walkMappings(c, f)
+	}
+}
+
+// mapValue returns the value of key in the mapping m, or nil.
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// ensureMapping returns the mapping value of key in the mapping m, adding
+// (or replacing a null value with) an empty mapping if necessary.
+func ensureMapping(m *yaml.Node, key string) *yaml.Node {
+	if v := mapValue(m, key); v != nil {
+		if v.Kind != yaml.MappingNode {
+			v.Kind, v.Tag, v.Value, v.Content = yaml.MappingNode, "!!map", "", nil
+		}
+		return v
+	}
+	v := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v
+}
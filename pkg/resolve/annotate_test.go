@@ -0,0 +1,174 @@
+// Copyright 2021 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolve
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPodTemplates(t *testing.T) {
+	input := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    metadata:
+      labels:
+        app: foo
+    spec:
+      initContainers:
+      - name: init
+        image: ko://github.com/foo/init
+      containers:
+      - name: foo
+        image: ko://github.com/foo/foo
+      - name: sidecar
+        image: gcr.io/foo/sidecar
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: bar
+spec:
+  containers:
+  - name: bar
+    image: ko://github.com/foo/bar
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: baz
+spec:
+  containers:
+  - name: baz
+    image: gcr.io/foo/baz
+`
+	want := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    metadata:
+      labels:
+        app: foo
+      annotations:
+        ko.build/import-path: github.com/foo/init,github.com/foo/foo
+    spec:
+      initContainers:
+        - name: init
+          image: ko://github.com/foo/init
+      containers:
+        - name: foo
+          image: ko://github.com/foo/foo
+        - name: sidecar
+          image: gcr.io/foo/sidecar
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: bar
+  annotations:
+    ko.build/import-path: github.com/foo/bar
+spec:
+  containers:
+    - name: bar
+      image: ko://github.com/foo/bar
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: baz
+spec:
+  containers:
+    - name: baz
+      image: gcr.io/foo/baz
+`
+
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewBufferString(input))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			break
+		}
+		docs = append(docs, &doc)
+	}
+
+	templates := PodTemplates(docs)
+	var got [][]string
+	for _, tmpl := range templates {
+		got = append(got, tmpl.ImportPaths)
+		tmpl.Annotate(map[string]string{
+			"ko.build/import-path": strings.ReplaceAll(strings.Join(tmpl.ImportPaths, ","), "ko://", ""),
+		})
+	}
+	if diff := cmp.Diff([][]string{
+		{"ko://github.com/foo/init", "ko://github.com/foo/foo"},
+		{"ko://github.com/foo/bar"},
+	}, got); diff != "" {
+		t.Errorf("PodTemplates() (-want +got) = %s", diff)
+	}
+
+	var buf bytes.Buffer
+	e := yaml.NewEncoder(&buf)
+	e.SetIndent(2)
+	for _, doc := range docs {
+		if err := e.Encode(doc); err != nil {
+			t.Fatalf("Encode() = %v", err)
+		}
+	}
+	e.Close()
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Annotate() (-want +got) = %s", diff)
+	}
+}
+
+func TestAnnotateCreatesMetadata(t *testing.T) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(`spec:
+  containers:
+  - image: ko://github.com/foo/bar
+`), &doc); err != nil {
+		t.Fatalf("Unmarshal() = %v", err)
+	}
+	templates := PodTemplates([]*yaml.Node{&doc})
+	if len(templates) != 1 {
+		t.Fatalf("PodTemplates() = %d templates, want 1", len(templates))
+	}
+	templates[0].Annotate(map[string]string{"b": "2", "a": "1"})
+
+	var got struct {
+		Metadata struct {
+			Annotations yaml.Node
+		}
+	}
+	if err := doc.Decode(&got); err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	var keys []string
+	for i := 0; i < len(got.Metadata.Annotations.Content); i += 2 {
+		keys = append(keys, got.Metadata.Annotations.Content[i].Value)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, keys); diff != "" {
+		t.Errorf("annotation keys (-want +got) = %s", diff)
+	}
+}
@@ -16,7 +16,9 @@ package resolve
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -27,6 +29,56 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// ImportPathError is returned by ImageReferences to attribute a failure to
+// the import path that caused it.
+type ImportPathError struct {
+	ImportPath string
+	Err        error
+}
+
+// Error implements error
+func (e *ImportPathError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ImportPathError) Unwrap() error {
+	return e.Err
+}
+
+// Errors is returned by ImageReferences when more than one import path fails,
+// ordered by import path.
+type Errors []*ImportPathError
+
+// Error implements error
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Is reports whether any of the errors is target.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target.
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // ImageReferences resolves supported references to images within the input yaml
 // to published image digests.
 //
@@ -42,7 +94,10 @@ func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Inter
 			ref := strings.TrimSpace(node.Value)
 
 			if err := builder.IsSupportedReference(ref); err != nil {
-				return fmt.Errorf("found strict reference but %s is not a valid import path: %v", ref, err)
+				return &ImportPathError{
+					ImportPath: ref,
+					Err:        fmt.Errorf("found strict reference but %s is not a valid import path: %w", ref, err),
+				}
 			}
 
 			refs[ref] = append(refs[ref], node)
@@ -50,25 +105,39 @@ func ImageReferences(ctx context.Context, docs []*yaml.Node, builder build.Inter
 	}
 
 	// Next, perform parallel builds for each of the supported references.
+	// Every build runs to completion, so that all of the failures can be
+	// reported at once.
 	var sm sync.Map
 	var errg errgroup.Group
+	var mu sync.Mutex
+	var errs Errors
+	fail := func(err *ImportPathError) error {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+		return err
+	}
 	for ref := range refs {
 		ref := ref
 		errg.Go(func() error {
 			img, err := builder.Build(ctx, ref)
 			if err != nil {
-				return err
+				return fail(&ImportPathError{ImportPath: ref, Err: err})
 			}
 			digest, err := publisher.Publish(ctx, img, ref)
 			if err != nil {
-				return err
+				return fail(&ImportPathError{ImportPath: ref, Err: err})
 			}
 			sm.Store(ref, digest.String())
 			return nil
 		})
 	}
 	if err := errg.Wait(); err != nil {
-		return err
+		if len(errs) == 1 {
+			return errs[0]
+		}
+		sort.Slice(errs, func(i, j int) bool { return errs[i].ImportPath < errs[j].ImportPath })
+		return errs
 	}
 
 	// Walk the tags and update them with their digest.
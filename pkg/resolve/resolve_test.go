@@ -17,6 +17,9 @@ package resolve
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -292,6 +295,50 @@ func TestIsSupportedReferenceError(t *testing.T) {
 	}
 }
 
+func TestErrors(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	encoder := yaml.NewEncoder(buf)
+	refs := []string{build.StrictScheme + fooRef, build.StrictScheme + barRef, build.StrictScheme + bazRef}
+	if err := encoder.Encode(refs); err != nil {
+		t.Fatalf("Encode(%v) = %v", refs, err)
+	}
+	base := mustRepository("gcr.io/multi-pass")
+	doc := strToYAML(t, buf.String())
+
+	// Only foo builds, so bar and baz both fail.
+	builder := &failingBuild{Interface: testBuilder, fail: map[string]bool{barRef: true, bazRef: true}}
+	err := ImageReferences(context.Background(), []*yaml.Node{doc}, builder, kotesting.NewFixedPublish(base, testHashes))
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("ImageReferences() = %v, want Errors", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.ImportPath)
+	}
+	want := []string{build.StrictScheme + barRef, build.StrictScheme + bazRef}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Errors (-want +got) = %v", diff)
+	}
+	var ipe *ImportPathError
+	if !errors.As(err, &ipe) || ipe.ImportPath != want[0] {
+		t.Errorf("errors.As() = %v, want the first failure", ipe)
+	}
+}
+
+// failingBuild fails to build the import paths in fail.
+type failingBuild struct {
+	build.Interface
+	fail map[string]bool
+}
+
+func (f *failingBuild) Build(ctx context.Context, s string) (build.Result, error) {
+	if ip := strings.TrimPrefix(s, build.StrictScheme); f.fail[ip] {
+		return nil, fmt.Errorf("failed to build %s", ip)
+	}
+	return f.Interface.Build(ctx, s)
+}
+
 func mustRandom() build.Result {
 	img, err := random.Index(1024, 5, 1)
 	if err != nil {